@@ -18,7 +18,7 @@ func TestCredential(t *testing.T) {
 	}
 	testEgiIssuer := "https://aai.egi.eu/auth/realms/egi"
 
-	token := credentials.CreateRS256PolicyToken("testIssuer", testEgiIssuer, "subject", "policy", time.Minute, session.AWSSessionTags{})
+	token := credentials.CreateRS256PolicyToken("testIssuer", testEgiIssuer, "subject", "policy", time.Minute, session.AWSSessionTags{}, session.AWSSessionPolicies{})
 	ac, err := credentials.NewAWSCredentials(token, time.Second, keyStorage)
 	if err != nil {
 		t.Errorf("Oops got error %s when creating %s", err, ac)
@@ -37,6 +37,8 @@ type SessionClaims struct {
 	//The issuer of the initial OIDC refresh token
 	IIssuer string `json:"initial_issuer"`
 	IDPClaims
+	//Optional session policies that limit the permissions of the role
+	SessionPolicies session.AWSSessionPolicies `json:"session_policies,omitzero"`
 
 	AccessKeyID string `json:"access_key_id"`
 }
@@ -58,7 +60,7 @@ func (s *SessionClaims) GetAccessKeyId() string {
 	return s.AccessKeyID
 }
 
-func CreateRS256PolicyToken(issuer, iIssuer, subject, roleARN string, expiry time.Duration, tags session.AWSSessionTags) *jwt.Token {
+func CreateRS256PolicyToken(issuer, iIssuer, subject, roleARN string, expiry time.Duration, tags session.AWSSessionTags, sessionPolicies session.AWSSessionPolicies) *jwt.Token {
 	claims := &SessionClaims{
 		roleARN,
 		iIssuer,
@@ -73,6 +75,7 @@ func CreateRS256PolicyToken(issuer, iIssuer, subject, roleARN string, expiry tim
 				ID:        uuid.New().String(),
 			},
 		},
+		sessionPolicies,
 		"",
 	}
 
@@ -95,6 +98,17 @@ func ExtractOIDCTokenClaims(token string, oidcKeyFunc jwt.Keyfunc) (*SessionClai
 	return ExtractTokenClaims(token, oidcKeyFunc)
 }
 
+// ExtractOIDCTokenMapClaims extracts all claims of a security token, including the ones that are
+// specific to the OIDC provider like amr or groups
+func ExtractOIDCTokenMapClaims(token string, oidcKeyFunc jwt.Keyfunc) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.NewParser().ParseWithClaims(token, claims, oidcKeyFunc)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
 // ExtractTokenClaims extracts JWT claims using a key functions
 func ExtractTokenClaims(token string, keyFunc jwt.Keyfunc, options ...jwt.ParserOption) (*SessionClaims, error) {
 	if token == "" {
@@ -14,15 +14,17 @@ func _() {
 	_ = x[ErrAWSAccessDenied-3]
 	_ = x[ErrInvalidAccessKeyId-4]
 	_ = x[ErrAuthorizationHeaderMalformed-5]
+	_ = x[ErrMalformedPOSTRequest-6]
 }
 
-const _AWSErrorCode_name = "AWSNoneAWSInternalErrorAWSInvalidSignatureAWSAccessDeniedInvalidAccessKeyIdAuthorizationHeaderMalformed"
+const _AWSErrorCode_name = "AWSNoneAWSInternalErrorAWSInvalidSignatureAWSAccessDeniedInvalidAccessKeyIdAuthorizationHeaderMalformedMalformedPOSTRequest"
 
-var _AWSErrorCode_index = [...]uint8{0, 7, 23, 42, 57, 75, 103}
+var _AWSErrorCode_index = [...]uint8{0, 7, 23, 42, 57, 75, 103, 123}
 
 func (i AWSErrorCode) String() string {
-	if i < 0 || i >= AWSErrorCode(len(_AWSErrorCode_index)-1) {
+	idx := int(i) - 0
+	if i < 0 || idx >= len(_AWSErrorCode_index)-1 {
 		return "AWSErrorCode(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _AWSErrorCode_name[_AWSErrorCode_index[i]:_AWSErrorCode_index[i+1]]
+	return _AWSErrorCode_name[_AWSErrorCode_index[idx]:_AWSErrorCode_index[idx+1]]
 }
@@ -2,14 +2,36 @@ package actionnames
 
 // S3 IAM actions
 const (
-	IAMActionS3PutObject            = "s3:PutObject"
-	IAMActionS3GetObject            = "s3:GetObject"
-	IAMActionS3ListBucket           = "s3:ListBucket"
-	IAMActionS3AbortMultipartUpload = "s3:AbortMultipartUpload"
-	IAMActionS3ListAllMyBuckets     = "s3:ListAllMyBuckets"
+	IAMActionS3PutObject                  = "s3:PutObject"
+	IAMActionS3GetObject                  = "s3:GetObject"
+	IAMActionS3ListBucket                 = "s3:ListBucket"
+	IAMActionS3AbortMultipartUpload       = "s3:AbortMultipartUpload"
+	IAMActionS3ListAllMyBuckets           = "s3:ListAllMyBuckets"
+	IAMActionS3DeleteObject               = "s3:DeleteObject"
+	IAMActionS3ListBucketVersions         = "s3:ListBucketVersions"
+	IAMActionS3GetObjectVersion           = "s3:GetObjectVersion"
+	IAMActionS3DeleteObjectVersion        = "s3:DeleteObjectVersion"
+	IAMActionS3GetBucketVersioning        = "s3:GetBucketVersioning"
+	IAMActionS3PutBucketVersioning        = "s3:PutBucketVersioning"
+	IAMActionS3ListBucketMultipartUploads = "s3:ListBucketMultipartUploads"
+	IAMActionS3ListMultipartUploadParts   = "s3:ListMultipartUploadParts"
+	IAMActionS3GetObjectTagging           = "s3:GetObjectTagging"
+	IAMActionS3PutObjectTagging           = "s3:PutObjectTagging"
+	IAMActionS3DeleteObjectTagging        = "s3:DeleteObjectTagging"
+	IAMActionS3GetObjectVersionTagging    = "s3:GetObjectVersionTagging"
+	IAMActionS3PutObjectVersionTagging    = "s3:PutObjectVersionTagging"
+	IAMActionS3DeleteObjectVersionTagging = "s3:DeleteObjectVersionTagging"
+	IAMActionS3GetBucketLocation          = "s3:GetBucketLocation"
 )
 
 // S3 Condition keys
 const (
-	IAMConditionS3Prefix = "s3:prefix"
+	IAMConditionS3Prefix       = "s3:prefix"
+	IAMConditionS3Delimiter    = "s3:delimiter"
+	IAMConditionS3MaxKeys      = "s3:max-keys"
+	IAMConditionS3SignatureAge = "s3:signatureAge"
+
+	// Prefixes of condition keys that are followed by a tag key e.g. s3:ExistingObjectTag/classification
+	IAMConditionS3RequestObjectTagPrefix  = "s3:RequestObjectTag/"
+	IAMConditionS3ExistingObjectTagPrefix = "s3:ExistingObjectTag/"
 )
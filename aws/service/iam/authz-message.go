@@ -0,0 +1,79 @@
+package iam
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// The details of an authorization decision. Like the encoded message of AWS it can be returned to users who got
+// Access Denied but only an administrator can decode it as it reveals the policies.
+type AuthorizationMessage struct {
+	RequestId string              `json:"request_id"`
+	RoleArn   string              `json:"role_arn"`
+	Allowed   bool                `json:"allowed"`
+	Decisions []ActionExplanation `json:"decisions"`
+}
+
+// Messages are encrypted with a key derived from the private key of the proxy so that no extra secret is needed.
+func getAuthorizationMessageCipher(key *rsa.PrivateKey) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, errors.New("no key to encode authorization messages")
+	}
+	derivedKey := sha256.Sum256(append([]byte("fakes3pp-authz-message:"), x509.MarshalPKCS1PrivateKey(key)...))
+	block, err := aes.NewCipher(derivedKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encode an authorization message such that it can be safely put in a response header
+func EncodeAuthorizationMessage(key *rsa.PrivateKey, msg AuthorizationMessage) (string, error) {
+	aead, err := getAuthorizationMessageCipher(key)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := json.Marshal(msg)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plaintext, nil)), nil
+}
+
+// Decode an authorization message that was encoded with the same key
+func DecodeAuthorizationMessage(key *rsa.PrivateKey, encodedMsg string) (*AuthorizationMessage, error) {
+	aead, err := getAuthorizationMessageCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(encodedMsg)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("encoded authorization message is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("could not decrypt authorization message, was it encoded by another proxy?")
+	}
+	var msg AuthorizationMessage
+	err = json.Unmarshal(plaintext, &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
@@ -0,0 +1,47 @@
+package iam
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+)
+
+func TestAuthorizationMessageRoundTrip(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := AuthorizationMessage{
+		RequestId: "d5a0f5f6-0a3c-4b6e-9a51-8b8cf0d4d8e2",
+		RoleArn:   testRoleSimulation,
+		Decisions: []ActionExplanation{{
+			Action:           actionnames.IAMActionS3GetObject,
+			Resource:         testBucketARN + "/key",
+			EvaluationResult: EvaluationResult{Reason: reasonExplicitDeny, Statement: &MatchedStatement{Index: 2, Sid: "NoSecrets"}},
+		}},
+	}
+	encoded, err := EncodeAuthorizationMessage(key, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeAuthorizationMessage(key, encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.RoleArn != msg.RoleArn || len(decoded.Decisions) != 1 || decoded.Decisions[0].Statement.Sid != "NoSecrets" {
+		t.Errorf("Decoded message %+v does not match %+v", decoded, msg)
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeAuthorizationMessage(otherKey, encoded); err == nil {
+		t.Error("Message must not be decodable with another key")
+	}
+	if _, err := DecodeAuthorizationMessage(key, encoded[:10]); err == nil {
+		t.Error("Truncated message must not be decodable")
+	}
+}
@@ -1,8 +1,14 @@
 package interfaces
 
-import "github.com/VITObelgium/fakes3pp/aws/service/iam"
+import (
+	"github.com/VITObelgium/fakes3pp/aws/credentials"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+)
 
 type PolicyRetriever interface {
 	//Takes S3ApiAction and whether it is a presigned request
 	GetPolicy(arn string, data *iam.PolicySessionData) (string, error)
+
+	//Get the evaluator that combines the policies of the role of a session with its session policies
+	GetSessionPolicyEvaluator(claims *credentials.SessionClaims, data *iam.PolicySessionData) (*iam.PolicyEvaluator, error)
 }
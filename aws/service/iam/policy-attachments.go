@@ -0,0 +1,186 @@
+package iam
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/credentials"
+	"sigs.k8s.io/yaml"
+)
+
+// The managed policies that are attached to roles, keyed by role ARN
+type RolePolicyAttachments map[string][]string
+
+// Read a YAML file that maps role ARNs to lists of managed policy ARNs
+func ReadRolePolicyAttachments(filename string) (RolePolicyAttachments, error) {
+	buf, err := os.ReadFile(filename) // #nosec G304 -- platform provided files
+	if err != nil {
+		return nil, err
+	}
+	var attachments RolePolicyAttachments
+	err = yaml.Unmarshal(buf, &attachments)
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// The managed policy that is the permission boundary of a role, keyed by role ARN
+type RolePermissionBoundaries map[string]string
+
+// Read a YAML file that maps role ARNs to the managed policy ARN of their permission boundary
+func ReadRolePermissionBoundaries(filename string) (RolePermissionBoundaries, error) {
+	buf, err := os.ReadFile(filename) // #nosec G304 -- platform provided files
+	if err != nil {
+		return nil, err
+	}
+	var boundaries RolePermissionBoundaries
+	err = yaml.Unmarshal(buf, &boundaries)
+	if err != nil {
+		return nil, err
+	}
+	return boundaries, nil
+}
+
+// Managed policies have ARNs like arn:aws:iam::000000000000:policy/ReadOnly whereas roles have :role/
+func IsManagedPolicyArn(arn string) bool {
+	return strings.HasPrefix(arn, "arn:aws:iam::") && strings.Contains(arn, ":policy/")
+}
+
+// Attach managed policies to roles. The policies are stored like the role policies with their ARN as identifier.
+func (m *PolicyManager) AttachPolicies(attachments RolePolicyAttachments) error {
+	for roleArn, policyArns := range attachments {
+		for _, policyArn := range policyArns {
+			if err := m.checkManagedPolicy(policyArn); err != nil {
+				return fmt.Errorf("invalid policy attached to role %s: %w", roleArn, err)
+			}
+		}
+		m.attachments[roleArn] = policyArns
+	}
+	return nil
+}
+
+// Check that a policy that is referenced by the configuration is a managed policy that exists
+func (m *PolicyManager) checkManagedPolicy(policyArn string) error {
+	if !IsManagedPolicyArn(policyArn) {
+		return fmt.Errorf("%s is not a managed policy ARN", policyArn)
+	}
+	if !m.DoesPolicyExist(policyArn) {
+		return fmt.Errorf("policy %s does not exist", policyArn)
+	}
+	return nil
+}
+
+// Set permission boundaries of roles. A session of a role only gets what both its role and its boundary allow.
+func (m *PolicyManager) SetPermissionBoundaries(boundaries RolePermissionBoundaries) error {
+	for roleArn, policyArn := range boundaries {
+		if err := m.checkManagedPolicy(policyArn); err != nil {
+			return fmt.Errorf("invalid permission boundary of role %s: %w", roleArn, err)
+		}
+		m.boundaries[roleArn] = policyArn
+	}
+	return nil
+}
+
+// Set the guardrail policy which limits the permissions of every role like a service control policy. It must allow
+// what it does not forbid, a guardrail without Allow statements denies everything.
+func (m *PolicyManager) SetGuardrailPolicy(policyArn string) error {
+	if err := m.checkManagedPolicy(policyArn); err != nil {
+		return fmt.Errorf("invalid guardrail policy: %w", err)
+	}
+	m.guardrailPolicyArn = policyArn
+	return nil
+}
+
+// Intersect an evaluator with a managed policy that limits its permissions
+func (m *PolicyManager) limitByPolicy(pe *PolicyEvaluator, policyArn string, data *PolicySessionData) (*PolicyEvaluator, error) {
+	policy, err := m.GetPolicy(policyArn, data)
+	if err != nil {
+		return nil, err
+	}
+	limitPe, err := NewPolicyEvaluatorFromStr(policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", policyArn, err)
+	}
+	return pe.Intersect(limitPe), nil
+}
+
+// A role exists if it has its own policy or managed policies attached to it
+func (m *PolicyManager) DoesRoleExist(roleArn string) bool {
+	return len(m.attachments[roleArn]) > 0 || m.DoesPolicyExist(roleArn)
+}
+
+// Get the policies of a role: its own policy if it exists followed by the attached managed policies
+func (m *PolicyManager) GetRolePolicies(roleArn string, data *PolicySessionData) ([]string, error) {
+	var policies []string
+	attachedPolicyArns := m.attachments[roleArn]
+	if len(attachedPolicyArns) == 0 || m.DoesPolicyExist(roleArn) {
+		policy, err := m.GetPolicy(roleArn, data)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	for _, policyArn := range attachedPolicyArns {
+		policy, err := m.GetPolicy(policyArn, data)
+		if err != nil {
+			return nil, fmt.Errorf("could not get policy %s attached to role %s: %w", policyArn, roleArn, err)
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+// Set the bucket policies that grant access alongside the policies of roles
+func (m *PolicyManager) SetBucketPolicies(bucketPolicies BucketPolicyRetriever) {
+	m.bucketPolicies = bucketPolicies
+}
+
+// Get the evaluator for the permissions of a session. These are the permissions of its role and the bucket policies
+// limited to what the guardrail policy, the permission boundary of the role and the session policies allow if they
+// are set.
+func (m *PolicyManager) GetSessionPolicyEvaluator(claims *credentials.SessionClaims, data *PolicySessionData) (*PolicyEvaluator, error) {
+	rolePolicies, err := m.GetRolePolicies(claims.RoleARN, data)
+	if err != nil {
+		return nil, err
+	}
+	pe, err := NewPolicyEvaluatorFromStrs(rolePolicies...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy for role %s: %w", claims.RoleARN, err)
+	}
+	if m.bucketPolicies != nil {
+		pe = pe.WithBucketPolicies(m.bucketPolicies)
+	}
+	if m.guardrailPolicyArn != "" {
+		pe, err = m.limitByPolicy(pe, m.guardrailPolicyArn, data)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply guardrail policy: %w", err)
+		}
+	}
+	if boundaryArn, ok := m.boundaries[claims.RoleARN]; ok {
+		pe, err = m.limitByPolicy(pe, boundaryArn, data)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply permission boundary of role %s: %w", claims.RoleARN, err)
+		}
+	}
+	if claims.SessionPolicies.IsEmpty() {
+		return pe, nil
+	}
+	var sessionPolicies []string
+	if claims.SessionPolicies.Policy != "" {
+		sessionPolicies = append(sessionPolicies, claims.SessionPolicies.Policy)
+	}
+	for _, policyArn := range claims.SessionPolicies.PolicyArns {
+		policy, err := m.GetPolicy(policyArn, data)
+		if err != nil {
+			return nil, fmt.Errorf("could not get session policy %s: %w", policyArn, err)
+		}
+		sessionPolicies = append(sessionPolicies, policy)
+	}
+	sessionPe, err := NewPolicyEvaluatorFromStrs(sessionPolicies...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid session policy: %w", err)
+	}
+	return pe.Intersect(sessionPe), nil
+}
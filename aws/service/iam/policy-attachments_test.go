@@ -0,0 +1,179 @@
+package iam
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/VITObelgium/fakes3pp/aws/credentials"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/aws/service/sts/session"
+)
+
+const testRoleWithPolicy = "arn:aws:iam::000000000000:role/WithPolicy"
+const testRoleOnlyAttachments = "arn:aws:iam::000000000000:role/OnlyAttachments"
+const testManagedPolicyRead = "arn:aws:iam::000000000000:policy/Read"
+const testManagedPolicyWrite = "arn:aws:iam::000000000000:policy/Write"
+const testManagedPolicyDenyPrivate = "arn:aws:iam::000000000000:policy/DenyPrivate"
+
+func testPolicyForAction(effect, action, resource string) string {
+	return fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [{"Effect": "%s", "Action": "%s", "Resource": "%s"}]
+	}`, effect, action, resource)
+}
+
+func newTestPolicyManagerWithAttachments(t testing.TB) *PolicyManager {
+	pm := NewTestPolicyManager(map[string]string{
+		testRoleWithPolicy:           testPolicyForAction("Allow", actionnames.IAMActionS3ListBucket, testBucketARN),
+		testManagedPolicyRead:        testPolicyForAction("Allow", actionnames.IAMActionS3GetObject, testBucketARN+"/*"),
+		testManagedPolicyWrite:       testPolicyForAction("Allow", actionnames.IAMActionS3PutObject, testBucketARN+"/*"),
+		testManagedPolicyDenyPrivate: testPolicyForAction("Deny", "s3:*", testBucketARN+"/private/*"),
+	})
+	err := pm.AttachPolicies(RolePolicyAttachments{
+		testRoleWithPolicy:      {testManagedPolicyRead, testManagedPolicyWrite},
+		testRoleOnlyAttachments: {testManagedPolicyRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pm
+}
+
+func TestAttachPoliciesRefusesUnknownPolicies(t *testing.T) {
+	pm := newTestPolicyManagerWithAttachments(t)
+	err := pm.AttachPolicies(RolePolicyAttachments{testRoleWithPolicy: {"arn:aws:iam::000000000000:policy/Unknown"}})
+	if err == nil {
+		t.Error("Attaching a policy that does not exist must fail")
+	}
+	err = pm.AttachPolicies(RolePolicyAttachments{testRoleOnlyAttachments: {testRoleWithPolicy}})
+	if err == nil {
+		t.Error("Attaching a role must fail")
+	}
+}
+
+func TestDoesRoleExist(t *testing.T) {
+	pm := newTestPolicyManagerWithAttachments(t)
+	for _, roleArn := range []string{testRoleWithPolicy, testRoleOnlyAttachments} {
+		if !pm.DoesRoleExist(roleArn) {
+			t.Errorf("Role %s must exist", roleArn)
+		}
+	}
+	if pm.DoesRoleExist("arn:aws:iam::000000000000:role/Unknown") {
+		t.Error("Unknown role must not exist")
+	}
+}
+
+func TestSessionPolicyEvaluator(t *testing.T) {
+	pm := newTestPolicyManagerWithAttachments(t)
+	readOnlyInline := testPolicyForAction("Allow", "s3:Get*", "*")
+
+	var testCases = []struct {
+		Description     string
+		RoleArn         string
+		SessionPolicies session.AWSSessionPolicies
+		Action          string
+		Resource        string
+		Expected        bool
+	}{
+		{"Own role policy", testRoleWithPolicy, session.AWSSessionPolicies{}, actionnames.IAMActionS3ListBucket, testBucketARN, true},
+		{"Attached policy", testRoleWithPolicy, session.AWSSessionPolicies{}, actionnames.IAMActionS3PutObject, testBucketARN + "/key", true},
+		{"Only attached policies", testRoleOnlyAttachments, session.AWSSessionPolicies{}, actionnames.IAMActionS3GetObject, testBucketARN + "/key", true},
+		{"Not in any role policy", testRoleOnlyAttachments, session.AWSSessionPolicies{}, actionnames.IAMActionS3PutObject, testBucketARN + "/key", false},
+		{"Allowed by role and inline session policy", testRoleWithPolicy, session.AWSSessionPolicies{Policy: readOnlyInline}, actionnames.IAMActionS3GetObject, testBucketARN + "/key", true},
+		{"Allowed by role but not by inline session policy", testRoleWithPolicy, session.AWSSessionPolicies{Policy: readOnlyInline}, actionnames.IAMActionS3PutObject, testBucketARN + "/key", false},
+		{"Allowed by session policy but not by role", testRoleOnlyAttachments, session.AWSSessionPolicies{PolicyArns: []string{testManagedPolicyWrite}}, actionnames.IAMActionS3PutObject, testBucketARN + "/key", false},
+		{"Allowed by one of the session policies", testRoleWithPolicy, session.AWSSessionPolicies{Policy: readOnlyInline, PolicyArns: []string{testManagedPolicyWrite}}, actionnames.IAMActionS3PutObject, testBucketARN + "/key", true},
+		{"Denied by session policy", testRoleWithPolicy, session.AWSSessionPolicies{Policy: readOnlyInline, PolicyArns: []string{testManagedPolicyDenyPrivate}}, actionnames.IAMActionS3GetObject, testBucketARN + "/private/key", false},
+	}
+	for _, tc := range testCases {
+		claims := &credentials.SessionClaims{RoleARN: tc.RoleArn, SessionPolicies: tc.SessionPolicies}
+		data := GetPolicySessionDataFromClaims(claims)
+		pe, err := pm.GetSessionPolicyEvaluator(claims, data)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+			continue
+		}
+		isAllowed, _, err := pe.Evaluate(NewIamAction(tc.Action, tc.Resource, data))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+		}
+		if isAllowed != tc.Expected {
+			t.Errorf("%s: expected allowed=%t, got %t", tc.Description, tc.Expected, isAllowed)
+		}
+	}
+}
+
+func TestIntersectedEvaluatorCouldAllowAny(t *testing.T) {
+	pm := newTestPolicyManagerWithAttachments(t)
+	claims := &credentials.SessionClaims{
+		RoleARN:         testRoleWithPolicy,
+		SessionPolicies: session.AWSSessionPolicies{Policy: testPolicyForAction("Allow", "s3:*", "arn:aws:s3:::otherbucket/*")},
+	}
+	data := GetPolicySessionDataFromClaims(claims)
+	pe, err := pm.GetSessionPolicyEvaluator(claims, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pe.CouldAllowAny([]string{actionnames.IAMActionS3GetObject}, testBucketARN+"/*", data) {
+		t.Error("Session policy does not allow anything in the bucket of the role")
+	}
+}
+
+func TestGuardrailAndPermissionBoundaries(t *testing.T) {
+	const testGuardrail = "arn:aws:iam::000000000000:policy/Guardrail"
+	const testBoundaryReadOnly = "arn:aws:iam::000000000000:policy/BoundaryReadOnly"
+	pm := NewTestPolicyManager(map[string]string{
+		testRoleWithPolicy: testPolicyForAction("Allow", "s3:*", "*"),
+		testGuardrail: fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [
+				{"Effect": "Allow", "Action": "*", "Resource": "*"},
+				{"Effect": "Deny", "Action": "s3:DeleteObject", "Resource": "%s/archive/*"}
+			]
+		}`, testBucketARN),
+		testBoundaryReadOnly: testPolicyForAction("Allow", "s3:Get*", "*"),
+	})
+	if err := pm.SetGuardrailPolicy(testRoleWithPolicy); err == nil {
+		t.Error("A role must not be accepted as guardrail policy")
+	}
+	if err := pm.SetPermissionBoundaries(RolePermissionBoundaries{testRoleWithPolicy: "arn:aws:iam::000000000000:policy/Unknown"}); err == nil {
+		t.Error("A permission boundary that does not exist must be refused")
+	}
+	if err := pm.SetGuardrailPolicy(testGuardrail); err != nil {
+		t.Fatal(err)
+	}
+
+	var testCases = []struct {
+		Description string
+		Boundaries  RolePermissionBoundaries
+		Action      string
+		Resource    string
+		Expected    bool
+	}{
+		{"Allowed by role and guardrail", nil, actionnames.IAMActionS3PutObject, testBucketARN + "/key", true},
+		{"Denied by guardrail", nil, actionnames.IAMActionS3DeleteObject, testBucketARN + "/archive/key", false},
+		{"Outside of denied part of guardrail", nil, actionnames.IAMActionS3DeleteObject, testBucketARN + "/key", true},
+		{"Allowed by role and boundary", RolePermissionBoundaries{testRoleWithPolicy: testBoundaryReadOnly}, actionnames.IAMActionS3GetObject, testBucketARN + "/key", true},
+		{"Not allowed by boundary", RolePermissionBoundaries{testRoleWithPolicy: testBoundaryReadOnly}, actionnames.IAMActionS3PutObject, testBucketARN + "/key", false},
+	}
+	for _, tc := range testCases {
+		pm.boundaries = RolePermissionBoundaries{}
+		if err := pm.SetPermissionBoundaries(tc.Boundaries); err != nil {
+			t.Fatal(err)
+		}
+		claims := &credentials.SessionClaims{RoleARN: testRoleWithPolicy}
+		data := GetPolicySessionDataFromClaims(claims)
+		pe, err := pm.GetSessionPolicyEvaluator(claims, data)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+			continue
+		}
+		isAllowed, _, err := pe.Evaluate(NewIamAction(tc.Action, tc.Resource, data))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+		}
+		if isAllowed != tc.Expected {
+			t.Errorf("%s: expected allowed=%t, got %t", tc.Description, tc.Expected, isAllowed)
+		}
+	}
+}
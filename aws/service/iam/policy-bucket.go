@@ -0,0 +1,264 @@
+package iam
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/VITObelgium/fakes3pp/utils"
+	"github.com/fsnotify/fsnotify"
+	"github.com/micahhausler/aws-iam-policy/policy"
+)
+
+const s3ArnPrefix = "arn:aws:s3:::"
+const bucketPolicySuffix = ".json"
+
+// The ARN of the role of the session
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_condition-keys.html#condition-keys-principalarn
+const conditionKeyPrincipalArn = "aws:PrincipalArn"
+
+type BucketPolicyRetriever interface {
+	//Get the policy of a bucket or nil if the bucket has no policy
+	GetBucketPolicy(bucket string) *policy.Policy
+}
+
+// Get the bucket of an S3 resource like arn:aws:s3:::bucket or arn:aws:s3:::bucket/key. Resources with a wildcard
+// in the bucket name do not belong to a single bucket.
+func getBucketFromResource(resource string) (string, bool) {
+	bucketAndKey, isS3 := strings.CutPrefix(resource, s3ArnPrefix)
+	if !isS3 {
+		return "", false
+	}
+	bucket, _, _ := strings.Cut(bucketAndKey, "/")
+	if bucket == "" || strings.ContainsAny(bucket, "*?") {
+		return "", false
+	}
+	return bucket, true
+}
+
+// Whether the session is in the Principal element of a statement. Statements without Principal are part of an
+// identity policy and apply to the session they are attached to. "*" matches any session, AWS matches the ARN of
+// the role and Federated the issuer of the OIDC token or <issuer>:<subject> for a single user of that issuer.
+func isPrincipalInScope(statement policy.Statement, context map[string]*policy.ConditionValue) bool {
+	p := statement.Principal
+	if p == nil {
+		return true
+	}
+	if slices.Contains(p.Kinds(), policy.PrincipalKindAll) {
+		return true
+	}
+	contextValue := func(key string) string {
+		if values := conditionValueStrings(context[key]); len(values) == 1 {
+			return values[0]
+		}
+		return ""
+	}
+	if p.AWS() != nil {
+		roleArn := contextValue(conditionKeyPrincipalArn)
+		for _, principal := range p.AWS().Values() {
+			if principal == policy.PrincipalAll || (roleArn != "" && principal == roleArn) {
+				return true
+			}
+		}
+	}
+	if p.Federated() != nil {
+		issuer := contextValue("claims:iss")
+		subject := contextValue("claims:sub")
+		for _, principal := range p.Federated().Values() {
+			if issuer != "" && (principal == issuer || (subject != "" && principal == issuer+":"+subject)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Parse the policy of a bucket. Its statements must have a Principal and can only grant access to the bucket itself.
+func parseBucketPolicy(bucket, policyContent string) (*policy.Policy, error) {
+	p, err := decodePolicy(policyContent)
+	if err != nil {
+		return nil, err
+	}
+	err = validatePolicy(p, func(s policy.Statement) error {
+		return validateBucketStatementPrincipalAndResource(bucket, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func validateBucketStatementPrincipalAndResource(bucket string, s policy.Statement) error {
+	if s.NotPrincipal != nil {
+		return errors.New("NotPrincipal is not supported")
+	}
+	if s.Principal == nil {
+		return errors.New("bucket policy statements must have a Principal")
+	}
+	for _, kind := range s.Principal.Kinds() {
+		switch kind {
+		case policy.PrincipalKindAll:
+			if p, _ := s.Principal.MarshalJSON(); string(p) != `"*"` {
+				return fmt.Errorf("unsupported Principal %s", p)
+			}
+		case policy.PrincipalKindAWS, policy.PrincipalKindFederated:
+		default:
+			return fmt.Errorf("unsupported Principal type %s", kind)
+		}
+	}
+	if err := validateStatementHasResource(s); err != nil {
+		return err
+	}
+	if s.Resource != nil {
+		bucketArn := s3ArnPrefix + bucket
+		for _, resource := range s.Resource.Values() {
+			if resource != bucketArn && !strings.HasPrefix(resource, bucketArn+"/") {
+				return fmt.Errorf("resource %s is not part of bucket %s", resource, bucket)
+			}
+		}
+	}
+	return nil
+}
+
+// Bucket policies stored as <bucket>.json files in a directory. Changes to the files and new files are picked up
+// while running.
+type LocalBucketPolicies struct {
+	path string
+
+	policies map[string]*policy.Policy
+	mux      *sync.RWMutex
+
+	//To monitor file system changes
+	watcher *fsnotify.Watcher
+	//To monitor files that are added to the directory
+	dirWatcher *fsnotify.Watcher
+}
+
+func NewLocalBucketPolicies(path string) (*LocalBucketPolicies, error) {
+	b := &LocalBucketPolicies{
+		path:     path,
+		policies: map[string]*policy.Policy{},
+		mux:      &sync.RWMutex{},
+	}
+	var fileUpdated fileCallback = func(fileName string) {
+		err := b.loadFile(fileName)
+		if err != nil {
+			//Keep the previous version rather than dropping the statements that deny access
+			slog.Error("Could not reload bucket policy, keeping previous version", "filename", fileName, "error", err)
+		}
+	}
+	var fileDeleted fileCallback = func(fileName string) {
+		bucket := b.getBucket(fileName)
+		slog.Info("Remove bucket policy", "bucket", bucket)
+		b.mux.Lock()
+		defer b.mux.Unlock()
+		delete(b.policies, bucket)
+	}
+	b.watcher = createFileWatcherAndStartWatching(fileUpdated, fileDeleted)
+
+	matches, err := filepath.Glob(filepath.Join(path, "*"+bucketPolicySuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range matches {
+		err = b.loadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		startWatching(b.watcher, fileName) // For reloading
+	}
+	err = b.watchForNewFiles()
+	if err != nil {
+		return nil, fmt.Errorf("could not watch bucket policy path %s: %w", path, err)
+	}
+	return b, nil
+}
+
+// Load the policies of buckets that get a policy file while running
+func (b *LocalBucketPolicies) watchForNewFiles() error {
+	dirWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	err = dirWatcher.Add(b.path)
+	if err != nil {
+		_ = dirWatcher.Close()
+		return err
+	}
+	b.dirWatcher = dirWatcher
+	go func() {
+		for {
+			select {
+			case event, ok := <-dirWatcher.Events:
+				if !ok {
+					return
+				}
+				if event.Has(fsnotify.Create) && strings.HasSuffix(event.Name, bucketPolicySuffix) {
+					slog.Debug("Bucket policy file created", "event", event)
+					//Watch before loading such that content that is still being written gets reloaded
+					startWatching(b.watcher, event.Name)
+					err := b.loadFile(event.Name)
+					if err != nil {
+						slog.Error("Could not load new bucket policy", "filename", event.Name, "error", err)
+					}
+				}
+			case err, ok := <-dirWatcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("error with bucket policy directory watcher", "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *LocalBucketPolicies) getBucket(fileName string) string {
+	return strings.TrimSuffix(filepath.Base(fileName), bucketPolicySuffix)
+}
+
+func (b *LocalBucketPolicies) loadFile(fileName string) error {
+	bucket := b.getBucket(fileName)
+	c, err := utils.ReadFileFull(fileName)
+	if err != nil {
+		return err
+	}
+	p, err := parseBucketPolicy(bucket, string(c))
+	if err != nil {
+		return fmt.Errorf("invalid policy for bucket %s: %w", bucket, err)
+	}
+	slog.Info("Load bucket policy", "bucket", bucket)
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.policies[bucket] = p
+	return nil
+}
+
+func (b *LocalBucketPolicies) GetBucketPolicy(bucket string) *policy.Policy {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return b.policies[bucket]
+}
+
+// Because other packages will have to create test coverage for bucket policies
+type TestBucketPolicies map[string]*policy.Policy
+
+func NewTestBucketPolicies(policies map[string]string) (TestBucketPolicies, error) {
+	b := TestBucketPolicies{}
+	for bucket, policyContent := range policies {
+		p, err := parseBucketPolicy(bucket, policyContent)
+		if err != nil {
+			return nil, err
+		}
+		b[bucket] = p
+	}
+	return b, nil
+}
+
+func (b TestBucketPolicies) GetBucketPolicy(bucket string) *policy.Policy {
+	return b[bucket]
+}
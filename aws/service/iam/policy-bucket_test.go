@@ -0,0 +1,234 @@
+package iam
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/VITObelgium/fakes3pp/aws/credentials"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/aws/service/sts/session"
+)
+
+const testBucketPolicyIssuer = "https://idp.example.com/realms/test"
+
+func testBucketPolicy(effect, principal, action, resource string) string {
+	return fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [{"Effect": "%s", "Principal": %s, "Action": "%s", "Resource": "%s"}]
+	}`, effect, principal, action, resource)
+}
+
+func TestGetBucketFromResource(t *testing.T) {
+	var testCases = []struct {
+		Resource       string
+		ExpectedBucket string
+		ExpectedOk     bool
+	}{
+		{"arn:aws:s3:::bucket1", "bucket1", true},
+		{"arn:aws:s3:::bucket1/key/with/slashes", "bucket1", true},
+		{"arn:aws:s3:::bucket1/*", "bucket1", true},
+		{"arn:aws:s3:::bucket*/key", "", false},
+		{"arn:aws:s3:::", "", false},
+		{"*", "", false},
+	}
+	for _, tc := range testCases {
+		bucket, ok := getBucketFromResource(tc.Resource)
+		if bucket != tc.ExpectedBucket || ok != tc.ExpectedOk {
+			t.Errorf("%s: expected %q/%t, got %q/%t", tc.Resource, tc.ExpectedBucket, tc.ExpectedOk, bucket, ok)
+		}
+	}
+}
+
+func TestParseBucketPolicy(t *testing.T) {
+	var testCases = []struct {
+		Description   string
+		Policy        string
+		ExpectedValid bool
+	}{
+		{"Role principal", testBucketPolicy("Allow", `{"AWS": "arn:aws:iam::000000000000:role/Reader"}`, "s3:GetObject", testBucketARN+"/*"), true},
+		{"Federated principal", testBucketPolicy("Allow", `{"Federated": "https://idp.example.com"}`, "s3:GetObject", testBucketARN+"/*"), true},
+		{"Everyone", testBucketPolicy("Deny", `"*"`, "s3:DeleteObject", testBucketARN+"/*"), true},
+		{"Without Principal", testPolicyForAction("Allow", "s3:GetObject", testBucketARN+"/*"), false},
+		{"Service principal", testBucketPolicy("Allow", `{"Service": "s3.amazonaws.com"}`, "s3:GetObject", testBucketARN+"/*"), false},
+		{"Resource in other bucket", testBucketPolicy("Allow", `"*"`, "s3:GetObject", "arn:aws:s3:::otherbucket/*"), false},
+		{"Resource with bucket as prefix", testBucketPolicy("Allow", `"*"`, "s3:GetObject", testBucketARN+"-other/*"), false},
+		{"NotPrincipal", fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [{"Effect": "Deny", "NotPrincipal": {"AWS": "arn:aws:iam::000000000000:role/Admin"}, "Action": "s3:*", "Resource": "%s/*"}]
+		}`, testBucketARN), false},
+	}
+	for _, tc := range testCases {
+		_, err := parseBucketPolicy(testBucketName, tc.Policy)
+		if (err == nil) != tc.ExpectedValid {
+			t.Errorf("%s: expected valid=%t, got error %v", tc.Description, tc.ExpectedValid, err)
+		}
+	}
+}
+
+func TestIdentityPolicyWithPrincipalIsRefused(t *testing.T) {
+	_, err := NewPolicyEvaluatorFromStr(testBucketPolicy("Allow", `"*"`, "s3:GetObject", testBucketARN+"/*"))
+	if err == nil {
+		t.Error("Principal must be refused in identity policies")
+	}
+}
+
+func TestBucketPoliciesAreEvaluatedAlongsideRolePolicies(t *testing.T) {
+	pm := newTestPolicyManagerWithAttachments(t)
+	objectArn := testBucketARN + "/key"
+	bucketPolicies, err := NewTestBucketPolicies(map[string]string{
+		testBucketName: fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [
+				{"Effect": "Allow", "Principal": {"AWS": "%s"}, "Action": "s3:PutObject", "Resource": "%s/*"},
+				{"Effect": "Allow", "Principal": {"Federated": "%s"}, "Action": "s3:GetObjectTagging", "Resource": "%s/*"},
+				{"Effect": "Allow", "Principal": {"Federated": "%s:alice"}, "Action": "s3:DeleteObject", "Resource": "%s/*"},
+				{"Effect": "Deny", "Principal": "*", "Action": "s3:GetObject", "Resource": "%s/private/*"}
+			]
+		}`, testRoleOnlyAttachments, testBucketARN, testBucketPolicyIssuer, testBucketARN, testBucketPolicyIssuer, testBucketARN, testBucketARN),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm.SetBucketPolicies(bucketPolicies)
+
+	var testCases = []struct {
+		Description     string
+		RoleArn         string
+		Subject         string
+		SessionPolicies session.AWSSessionPolicies
+		Action          string
+		Resource        string
+		Expected        bool
+	}{
+		{"Allowed by role policy", testRoleOnlyAttachments, "bob", session.AWSSessionPolicies{}, actionnames.IAMActionS3GetObject, objectArn, true},
+		{"Allowed by bucket policy for role", testRoleOnlyAttachments, "bob", session.AWSSessionPolicies{}, actionnames.IAMActionS3PutObject, objectArn, true},
+		{"Bucket policy for other role", testRoleWithPolicy, "bob", session.AWSSessionPolicies{}, actionnames.IAMActionS3DeleteObject, objectArn, false},
+		{"Allowed by bucket policy for issuer", testRoleWithPolicy, "bob", session.AWSSessionPolicies{}, actionnames.IAMActionS3GetObjectTagging, objectArn, true},
+		{"Allowed by bucket policy for subject", testRoleWithPolicy, "alice", session.AWSSessionPolicies{}, actionnames.IAMActionS3DeleteObject, objectArn, true},
+		{"Bucket policy only applies to its bucket", testRoleOnlyAttachments, "bob", session.AWSSessionPolicies{}, actionnames.IAMActionS3PutObject, "arn:aws:s3:::otherbucket/key", false},
+		{"Explicit deny in bucket policy wins", testRoleOnlyAttachments, "bob", session.AWSSessionPolicies{}, actionnames.IAMActionS3GetObject, testBucketARN + "/private/key", false},
+		{"Session policy limits bucket policy", testRoleOnlyAttachments, "bob", session.AWSSessionPolicies{Policy: testPolicyForAction("Allow", "s3:Get*", "*")}, actionnames.IAMActionS3PutObject, objectArn, false},
+	}
+	for _, tc := range testCases {
+		claims := &credentials.SessionClaims{
+			RoleARN:         tc.RoleArn,
+			IIssuer:         testBucketPolicyIssuer,
+			IDPClaims:       *credentials.NewIDPClaims("", tc.Subject, 0, session.AWSSessionTags{}),
+			SessionPolicies: tc.SessionPolicies,
+		}
+		data := GetPolicySessionDataFromClaims(claims)
+		pe, err := pm.GetSessionPolicyEvaluator(claims, data)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+			continue
+		}
+		isAllowed, _, err := pe.Evaluate(NewIamAction(tc.Action, tc.Resource, data))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+		}
+		if isAllowed != tc.Expected {
+			t.Errorf("%s: expected allowed=%t, got %t", tc.Description, tc.Expected, isAllowed)
+		}
+	}
+}
+
+func TestCouldAllowAnyWithBucketPolicy(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(testPolicyForAction("Allow", "s3:GetObject", "arn:aws:s3:::otherbucket/*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucketPolicies, err := NewTestBucketPolicies(map[string]string{
+		testBucketName: testBucketPolicy("Allow", fmt.Sprintf(`{"AWS": "%s"}`, testRoleOnlyAttachments), "s3:GetObject", testBucketARN+"/*"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe = pe.WithBucketPolicies(bucketPolicies)
+	getObject := []string{actionnames.IAMActionS3GetObject}
+
+	if !pe.CouldAllowAny(getObject, testBucketARN+"/*", &PolicySessionData{RoleArn: testRoleOnlyAttachments}) {
+		t.Error("Bucket policy grants access to the role")
+	}
+	if pe.CouldAllowAny(getObject, testBucketARN+"/*", &PolicySessionData{RoleArn: testRoleWithPolicy}) {
+		t.Error("Bucket policy does not grant access to other roles")
+	}
+}
+
+func TestLocalBucketPolicies(t *testing.T) {
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, testBucketName+bucketPolicySuffix)
+	writePolicy := func(principal string) {
+		err := os.WriteFile(policyFile, []byte(testBucketPolicy("Allow", principal, "s3:GetObject", testBucketARN+"/*")), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePolicy(`"*"`)
+
+	bucketPolicies, err := NewLocalBucketPolicies(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucketPolicies.GetBucketPolicy(testBucketName) == nil {
+		t.Fatal("Bucket policy must be loaded at startup")
+	}
+	if bucketPolicies.GetBucketPolicy("otherbucket") != nil {
+		t.Error("Bucket without file must not have a policy")
+	}
+
+	writePolicy(fmt.Sprintf(`{"AWS": "%s"}`, testRoleWithPolicy))
+	var policyIsReloaded predicateFunction = func() bool {
+		p := bucketPolicies.GetBucketPolicy(testBucketName)
+		return p != nil && p.Statements.Values()[0].Principal.AWS() != nil
+	}
+	if !isTrueWithinDueTime(policyIsReloaded) {
+		t.Error("Updated bucket policy was not reloaded")
+	}
+
+	err = os.Remove(policyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policyIsRemoved predicateFunction = func() bool {
+		return bucketPolicies.GetBucketPolicy(testBucketName) == nil
+	}
+	if !isTrueWithinDueTime(policyIsRemoved) {
+		t.Error("Deleted bucket policy was not removed")
+	}
+}
+
+func TestLocalBucketPoliciesLoadsNewFiles(t *testing.T) {
+	dir := t.TempDir()
+	bucketPolicies, err := NewLocalBucketPolicies(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bucketPolicies.GetBucketPolicy(testBucketName) != nil {
+		t.Fatal("Bucket without file must not have a policy")
+	}
+
+	err = os.WriteFile(filepath.Join(dir, testBucketName+bucketPolicySuffix), []byte(testBucketPolicy("Allow", `"*"`, "s3:GetObject", testBucketARN+"/*")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var policyIsLoaded predicateFunction = func() bool {
+		return bucketPolicies.GetBucketPolicy(testBucketName) != nil
+	}
+	if !isTrueWithinDueTime(policyIsLoaded) {
+		t.Error("Policy of bucket that was added while running was not loaded")
+	}
+}
+
+func TestLocalBucketPoliciesRefusesInvalidPolicy(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, testBucketName+bucketPolicySuffix), []byte(testPolicyForAction("Allow", "s3:GetObject", "*")), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewLocalBucketPolicies(dir)
+	if err == nil {
+		t.Error("Bucket policy without Principal must be refused")
+	}
+}
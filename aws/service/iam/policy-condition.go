@@ -0,0 +1,347 @@
+package iam
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micahhausler/aws-iam-policy/policy"
+)
+
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_elements_condition_operators.html
+const ifExistsSuffix = "IfExists"
+const operatorNull = "Null"
+
+// Set qualifiers compare multi-valued context keys
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_condition-single-vs-multi-valued-context-keys.html
+const (
+	forAnyValuePrefix  = "ForAnyValue:"
+	forAllValuesPrefix = "ForAllValues:"
+)
+
+// Multi-valued context keys can only be compared with a set qualifier
+var errNonSingularContextValue = errors.New("non-singular value requires ForAnyValue or ForAllValues")
+
+// A condition operator compares the values of a condition key in a policy with the value in the request context
+type conditionOperator struct {
+	// Whether a value of the request context matches a value of the policy
+	matches func(policyValue policyValue, contextValue string) (bool, error)
+	// Negated operators (e.g. StringNotEquals) are met if no value matches and also if the key is missing
+	negated bool
+}
+
+var conditionOperators = map[string]conditionOperator{
+	"StringEquals":              {matches: stringEquals},
+	"StringNotEquals":           {matches: stringEquals, negated: true},
+	"StringEqualsIgnoreCase":    {matches: stringEqualsIgnoreCase},
+	"StringNotEqualsIgnoreCase": {matches: stringEqualsIgnoreCase, negated: true},
+	"StringLike":                {matches: stringLike},
+	"StringNotLike":             {matches: stringLike, negated: true},
+	"ArnEquals":                 {matches: arnLike},
+	"ArnNotEquals":              {matches: arnLike, negated: true},
+	"ArnLike":                   {matches: arnLike},
+	"ArnNotLike":                {matches: arnLike, negated: true},
+	"Bool":                      {matches: boolEquals},
+	"NumericEquals":             {matches: numericCompare(func(p, c float64) bool { return c == p })},
+	"NumericNotEquals":          {matches: numericCompare(func(p, c float64) bool { return c == p }), negated: true},
+	"NumericLessThan":           {matches: numericCompare(func(p, c float64) bool { return c < p })},
+	"NumericLessThanEquals":     {matches: numericCompare(func(p, c float64) bool { return c <= p })},
+	"NumericGreaterThan":        {matches: numericCompare(func(p, c float64) bool { return c > p })},
+	"NumericGreaterThanEquals":  {matches: numericCompare(func(p, c float64) bool { return c >= p })},
+	"DateEquals":                {matches: dateCompare(func(p, c time.Time) bool { return c.Equal(p) })},
+	"DateNotEquals":             {matches: dateCompare(func(p, c time.Time) bool { return c.Equal(p) }), negated: true},
+	"DateLessThan":              {matches: dateCompare(func(p, c time.Time) bool { return c.Before(p) })},
+	"DateLessThanEquals":        {matches: dateCompare(func(p, c time.Time) bool { return !c.After(p) })},
+	"DateGreaterThan":           {matches: dateCompare(func(p, c time.Time) bool { return c.After(p) })},
+	"DateGreaterThanEquals":     {matches: dateCompare(func(p, c time.Time) bool { return !c.Before(p) })},
+	"IpAddress":                 {matches: ipAddressInRange},
+	"NotIpAddress":              {matches: ipAddressInRange, negated: true},
+}
+
+// The formats of dates in conditions. Besides these ISO 8601 variants a date can be given in epoch seconds.
+var iamDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func parseIAMDate(value string) (time.Time, error) {
+	for _, layout := range iamDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if epoch, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(epoch, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
+
+// Build a matcher that compares the context value with the policy value as numbers. A context value
+// that is not a number does not match.
+func numericCompare(compare func(policyValue, contextValue float64) bool) func(policyValue, string) (bool, error) {
+	return func(policyValue policyValue, contextValue string) (bool, error) {
+		p, err := strconv.ParseFloat(policyValue.String(), 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid number %q in policy", policyValue)
+		}
+		c, err := strconv.ParseFloat(contextValue, 64)
+		if err != nil {
+			return false, nil
+		}
+		return compare(p, c), nil
+	}
+}
+
+// Build a matcher that compares the context value with the policy value as dates. A context value
+// that is not a date does not match.
+func dateCompare(compare func(policyValue, contextValue time.Time) bool) func(policyValue, string) (bool, error) {
+	return func(policyValue policyValue, contextValue string) (bool, error) {
+		p, err := parseIAMDate(policyValue.String())
+		if err != nil {
+			return false, fmt.Errorf("%w in policy", err)
+		}
+		c, err := parseIAMDate(contextValue)
+		if err != nil {
+			return false, nil
+		}
+		return compare(p, c), nil
+	}
+}
+
+// The policy value is a CIDR block (e.g. 203.0.113.0/24 or 2001:db8::/32) or a single address. A context value
+// that is not an IP address does not match.
+func ipAddressInRange(policyValue policyValue, contextValue string) (bool, error) {
+	prefix, err := netip.ParsePrefix(policyValue.String())
+	if err != nil {
+		addr, addrErr := netip.ParseAddr(policyValue.String())
+		if addrErr != nil {
+			return false, fmt.Errorf("invalid IP address or CIDR block %q in policy", policyValue)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	addr, err := netip.ParseAddr(contextValue)
+	if err != nil {
+		return false, nil
+	}
+	return prefix.Masked().Contains(addr.Unmap()), nil
+}
+
+func stringEquals(policyValue policyValue, contextValue string) (bool, error) {
+	return policyValue.String() == contextValue, nil
+}
+
+func stringEqualsIgnoreCase(policyValue policyValue, contextValue string) (bool, error) {
+	return strings.EqualFold(policyValue.String(), contextValue), nil
+}
+
+func stringLike(policyValue policyValue, contextValue string) (bool, error) {
+	return policyValue.like(contextValue), nil
+}
+
+// ArnEquals and ArnLike behave the same: every colon separated part of the ARN is matched on its own and
+// can contain wildcards. A context value that is not an ARN does not match.
+func arnLike(policyValue policyValue, contextValue string) (bool, error) {
+	policyParts := policyValue.splitN(":", 6)
+	contextParts := strings.SplitN(contextValue, ":", 6)
+	if len(policyParts) != 6 || len(contextParts) != 6 {
+		return false, nil
+	}
+	for i := range policyParts {
+		if !policyParts[i].like(contextParts[i]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func boolEquals(policyValue policyValue, contextValue string) (bool, error) {
+	policyBool, err := strconv.ParseBool(strings.ToLower(policyValue.String()))
+	if err != nil {
+		return false, fmt.Errorf("invalid boolean %q in policy", policyValue)
+	}
+	contextBool, err := strconv.ParseBool(strings.ToLower(contextValue))
+	if err != nil {
+		//A context value that is not a boolean does not match
+		return false, nil
+	}
+	return policyBool == contextBool, nil
+}
+
+// The values of a condition as strings. A policy can hold booleans and numbers which AWS compares
+// by their string representation.
+func conditionValueStrings(value *policy.ConditionValue) []string {
+	strValues, boolValues, numValues := value.Values()
+	result := make([]string, 0, len(strValues)+len(boolValues)+len(numValues))
+	result = append(result, strValues...)
+	for _, b := range boolValues {
+		result = append(result, strconv.FormatBool(b))
+	}
+	for _, n := range numValues {
+		result = append(result, strconv.FormatFloat(n, 'f', -1, 64))
+	}
+	return result
+}
+
+// Whether a single value of the request context meets the operator for any of the policy values
+func (op conditionOperator) isMetForValue(policyValues []policyValue, contextValue string) (bool, error) {
+	for _, policyValue := range policyValues {
+		if policyValue.unresolved {
+			continue
+		}
+		matches, err := op.matches(policyValue, contextValue)
+		if err != nil {
+			return false, err
+		}
+		if matches {
+			return !op.negated, nil
+		}
+	}
+	return op.negated, nil
+}
+
+// Whether a single condition key of a condition block is met
+func (op conditionOperator) isMetForKey(conditionKey string, policyValues []policyValue, contextValue *policy.ConditionValue, ifExists bool) (bool, error) {
+	if contextValue == nil {
+		slog.Debug("condition key was not set in request context", "conditionKey", conditionKey)
+		//https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_elements_condition_operators.html#Conditions_IfExists
+		return ifExists || op.negated, nil
+	}
+	if !contextValue.IsSingular() {
+		return false, fmt.Errorf("%w: %s got %v", errNonSingularContextValue, conditionKey, conditionValueStrings(contextValue))
+	}
+	contextStrValues := conditionValueStrings(contextValue)
+	if len(contextStrValues) == 0 {
+		return ifExists || op.negated, nil
+	}
+	return op.isMetForValue(policyValues, contextStrValues[0])
+}
+
+// ForAnyValue is met if at least one value of the request context meets the operator. A missing key or a key
+// without values does not meet it, unless IfExists is used.
+func (op conditionOperator) isMetForAnyValue(policyValues []policyValue, contextValue *policy.ConditionValue, ifExists bool) (bool, error) {
+	var contextStrValues []string
+	if contextValue != nil {
+		contextStrValues = conditionValueStrings(contextValue)
+	}
+	if len(contextStrValues) == 0 {
+		return ifExists, nil
+	}
+	for _, value := range contextStrValues {
+		isMet, err := op.isMetForValue(policyValues, value)
+		if err != nil || isMet {
+			return isMet, err
+		}
+	}
+	return false, nil
+}
+
+// ForAllValues is met if every value of the request context meets the operator. This includes a missing key or
+// a key without values.
+func (op conditionOperator) isMetForAllValues(policyValues []policyValue, contextValue *policy.ConditionValue) (bool, error) {
+	if contextValue == nil {
+		return true, nil
+	}
+	for _, value := range conditionValueStrings(contextValue) {
+		isMet, err := op.isMetForValue(policyValues, value)
+		if err != nil || !isMet {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// The Null operator checks whether a key is absent ("true") or present ("false") in the request context
+func isConditionMetForNull(conditionDetails map[string]*policy.ConditionValue, context map[string]*policy.ConditionValue) (bool, error) {
+	for conditionKey, policyValues := range conditionDetails {
+		values := conditionValueStrings(policyValues)
+		if len(values) != 1 {
+			return false, fmt.Errorf("Null condition for %s must have a single value got %v", conditionKey, values)
+		}
+		mustBeAbsent, err := strconv.ParseBool(strings.ToLower(values[0]))
+		if err != nil {
+			return false, fmt.Errorf("invalid boolean %q in Null condition for %s", values[0], conditionKey)
+		}
+		contextValue, exists := context[conditionKey]
+		isAbsent := !exists || contextValue == nil || len(conditionValueStrings(contextValue)) == 0
+		if isAbsent != mustBeAbsent {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// A condition operator as it is written in a policy e.g. ForAnyValue:StringLikeIfExists
+type qualifiedConditionOperator struct {
+	op           conditionOperator
+	null         bool
+	ifExists     bool
+	forAnyValue  bool
+	forAllValues bool
+}
+
+func parseConditionOperator(conditionOperator string) (qualifiedConditionOperator, error) {
+	var q qualifiedConditionOperator
+	operatorName, forAnyValue := strings.CutPrefix(conditionOperator, forAnyValuePrefix)
+	q.forAnyValue = forAnyValue
+	if !forAnyValue {
+		operatorName, q.forAllValues = strings.CutPrefix(operatorName, forAllValuesPrefix)
+	}
+	if operatorName == operatorNull {
+		if q.forAnyValue || q.forAllValues {
+			return q, fmt.Errorf("unsupported condition: '%s'", conditionOperator)
+		}
+		q.null = true
+		return q, nil
+	}
+	operatorName, q.ifExists = strings.CutSuffix(operatorName, ifExistsSuffix)
+	op, ok := conditionOperators[operatorName]
+	if !ok {
+		return q, fmt.Errorf("unsupported condition: '%s'", conditionOperator)
+	}
+	q.op = op
+	return q, nil
+}
+
+// See whether the condition defined by the conditionOperator and conditionDetails is met
+// for the given context. All keys of the condition block must be met. If variablesEnabled the
+// policy variables in the policy values are replaced by their value in the context.
+func isConditionMetForOperator(conditionOperator string, conditionDetails map[string]*policy.ConditionValue, context map[string]*policy.ConditionValue, variablesEnabled bool) (bool, error) {
+	q, err := parseConditionOperator(conditionOperator)
+	if err != nil {
+		return false, err
+	}
+	if q.null {
+		return isConditionMetForNull(conditionDetails, context)
+	}
+	for conditionKey, conditionValue := range conditionDetails {
+		policyValues := resolvePolicyValues(conditionValueStrings(conditionValue), variablesEnabled, context)
+		if q.op.negated && slices.ContainsFunc(policyValues, func(v policyValue) bool { return v.unresolved }) {
+			//A variable without value matches nothing, that must not make a negated operator match everything
+			return false, nil
+		}
+		var isMet bool
+		var err error
+		switch {
+		case q.forAnyValue:
+			isMet, err = q.op.isMetForAnyValue(policyValues, context[conditionKey], q.ifExists)
+		case q.forAllValues:
+			isMet, err = q.op.isMetForAllValues(policyValues, context[conditionKey])
+		default:
+			isMet, err = q.op.isMetForKey(conditionKey, policyValues, context[conditionKey], q.ifExists)
+		}
+		if err != nil {
+			return false, fmt.Errorf("operator %s encountered %w", conditionOperator, err)
+		}
+		if !isMet {
+			return false, nil
+		}
+	}
+	return true, nil
+}
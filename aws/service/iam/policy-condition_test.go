@@ -0,0 +1,292 @@
+package iam
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/micahhausler/aws-iam-policy/policy"
+)
+
+func strCtx(values ...string) *policy.ConditionValue {
+	return policy.NewConditionValueString(true, values...)
+}
+
+// Expected outcomes follow the AWS reference on condition operators
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_elements_condition_operators.html
+func TestIsConditionMetForOperator(t *testing.T) {
+	var testCases = []struct {
+		Description string
+		Operator    string
+		Condition   string
+		Context     map[string]*policy.ConditionValue
+		ExpectedMet bool
+		ExpectError bool
+	}{
+		{"StringEquals with equal value", "StringEquals", `{"aws:PrincipalTag/team": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("a")}, true, false},
+		{"StringEquals is case sensitive", "StringEquals", `{"aws:PrincipalTag/team": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("A")}, false, false},
+		{"StringEquals does not expand wildcards", "StringEquals", `{"aws:PrincipalTag/team": "*"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("a")}, false, false},
+		{"StringEquals matches any of the policy values", "StringEquals", `{"aws:PrincipalTag/team": ["a", "b"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("b")}, true, false},
+		{"StringEquals with missing key", "StringEquals", `{"aws:PrincipalTag/team": "a"}`, map[string]*policy.ConditionValue{}, false, false},
+		{"StringEquals requires all keys", "StringEquals", `{"aws:PrincipalTag/team": "a", "aws:RequestedRegion": "eu-nl"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("a"), "aws:RequestedRegion": strCtx("waw3-1")}, false, false},
+		{"StringNotEquals with other value", "StringNotEquals", `{"aws:PrincipalTag/team": ["a", "b"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("c")}, true, false},
+		{"StringNotEquals with one of the values", "StringNotEquals", `{"aws:PrincipalTag/team": ["a", "b"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("b")}, false, false},
+		{"StringNotEquals with missing key", "StringNotEquals", `{"aws:PrincipalTag/team": "a"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"StringNotEquals requires all keys", "StringNotEquals", `{"aws:PrincipalTag/team": "a", "aws:RequestedRegion": "eu-nl"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("b"), "aws:RequestedRegion": strCtx("eu-nl")}, false, false},
+		{"StringEqualsIgnoreCase", "StringEqualsIgnoreCase", `{"aws:PrincipalTag/team": "Alpha"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("aLPHA")}, true, false},
+		{"StringNotEqualsIgnoreCase with same value in other case", "StringNotEqualsIgnoreCase", `{"aws:PrincipalTag/team": "Alpha"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("ALPHA")}, false, false},
+		{"StringNotEqualsIgnoreCase with missing key", "StringNotEqualsIgnoreCase", `{"aws:PrincipalTag/team": "Alpha"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"StringLike must match the whole value", "StringLike", `{"s3:prefix": "home/"}`, map[string]*policy.ConditionValue{"s3:prefix": strCtx("home/alice/")}, false, false},
+		{"StringLike with wildcard", "StringLike", `{"s3:prefix": "home/*"}`, map[string]*policy.ConditionValue{"s3:prefix": strCtx("home/alice/")}, true, false},
+		{"StringLike with single character wildcard", "StringLike", `{"s3:prefix": "home/?"}`, map[string]*policy.ConditionValue{"s3:prefix": strCtx("home/ab")}, false, false},
+		{"StringNotLike with missing key", "StringNotLike", `{"s3:prefix": "home/*"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"ArnEquals with equal ARN", "ArnEquals", `{"aws:SourceArn": "arn:aws:iam::000000000000:role/a"}`, map[string]*policy.ConditionValue{"aws:SourceArn": strCtx("arn:aws:iam::000000000000:role/a")}, true, false},
+		{"ArnEquals allows wildcards per part", "ArnEquals", `{"aws:SourceArn": "arn:aws:iam::*:role/*"}`, map[string]*policy.ConditionValue{"aws:SourceArn": strCtx("arn:aws:iam::000000000000:role/a")}, true, false},
+		{"ArnLike wildcard does not span parts", "ArnLike", `{"aws:SourceArn": "arn:aws:iam::*"}`, map[string]*policy.ConditionValue{"aws:SourceArn": strCtx("arn:aws:iam::000000000000:role/a")}, false, false},
+		{"ArnLike with value that is not an ARN", "ArnLike", `{"aws:SourceArn": "*"}`, map[string]*policy.ConditionValue{"aws:SourceArn": strCtx("not-an-arn")}, false, false},
+		{"ArnLike keeps colons in the resource part", "ArnLike", `{"aws:SourceArn": "arn:aws:s3:::bucket/a:*"}`, map[string]*policy.ConditionValue{"aws:SourceArn": strCtx("arn:aws:s3:::bucket/a:b")}, true, false},
+		{"ArnNotLike with other ARN", "ArnNotLike", `{"aws:SourceArn": "arn:aws:iam::*:role/a"}`, map[string]*policy.ConditionValue{"aws:SourceArn": strCtx("arn:aws:iam::000000000000:role/b")}, true, false},
+		{"ArnNotLike with missing key", "ArnNotLike", `{"aws:SourceArn": "arn:aws:iam::*:role/a"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"Bool with JSON boolean in policy", "Bool", `{"aws:SecureTransport": true}`, map[string]*policy.ConditionValue{"aws:SecureTransport": strCtx("true")}, true, false},
+		{"Bool with string in policy", "Bool", `{"aws:SecureTransport": "false"}`, map[string]*policy.ConditionValue{"aws:SecureTransport": policy.NewConditionValueBool(true, false)}, true, false},
+		{"Bool with other value", "Bool", `{"aws:SecureTransport": "true"}`, map[string]*policy.ConditionValue{"aws:SecureTransport": strCtx("false")}, false, false},
+		{"Bool with missing key", "Bool", `{"aws:SecureTransport": "false"}`, map[string]*policy.ConditionValue{}, false, false},
+		{"Bool with invalid policy value", "Bool", `{"aws:SecureTransport": "yes please"}`, map[string]*policy.ConditionValue{"aws:SecureTransport": strCtx("true")}, false, true},
+		{"Null true with missing key", "Null", `{"aws:PrincipalTag/team": "true"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"Null true with present key", "Null", `{"aws:PrincipalTag/team": true}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("a")}, false, false},
+		{"Null false with present key", "Null", `{"aws:PrincipalTag/team": "false"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("")}, true, false},
+		{"Null false with missing key", "Null", `{"aws:PrincipalTag/team": false}`, map[string]*policy.ConditionValue{}, false, false},
+		{"StringEqualsIfExists with missing key", "StringEqualsIfExists", `{"aws:PrincipalTag/team": "a"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"StringEqualsIfExists with other value", "StringEqualsIfExists", `{"aws:PrincipalTag/team": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": strCtx("b")}, false, false},
+		{"StringNotLikeIfExists with matching value", "StringNotLikeIfExists", `{"s3:prefix": "home/*"}`, map[string]*policy.ConditionValue{"s3:prefix": strCtx("home/a")}, false, false},
+		{"ArnLikeIfExists with missing key", "ArnLikeIfExists", `{"aws:SourceArn": "arn:aws:iam::*:role/a"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"BoolIfExists with missing key", "BoolIfExists", `{"aws:SecureTransport": "true"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"NumericEquals", "NumericEquals", `{"s3:max-keys": 10}`, map[string]*policy.ConditionValue{"s3:max-keys": strCtx("10")}, true, false},
+		{"NumericEquals with policy value as string", "NumericEquals", `{"s3:max-keys": "10"}`, map[string]*policy.ConditionValue{"s3:max-keys": strCtx("10.0")}, true, false},
+		{"NumericNotEquals with missing key", "NumericNotEquals", `{"s3:max-keys": 10}`, map[string]*policy.ConditionValue{}, true, false},
+		{"NumericLessThan with lower value", "NumericLessThan", `{"s3:max-keys": 10}`, map[string]*policy.ConditionValue{"s3:max-keys": strCtx("9")}, true, false},
+		{"NumericLessThan with equal value", "NumericLessThan", `{"s3:max-keys": 10}`, map[string]*policy.ConditionValue{"s3:max-keys": strCtx("10")}, false, false},
+		{"NumericLessThanEquals with equal value", "NumericLessThanEquals", `{"s3:max-keys": 10}`, map[string]*policy.ConditionValue{"s3:max-keys": strCtx("10")}, true, false},
+		{"NumericLessThanEquals with missing key", "NumericLessThanEquals", `{"s3:max-keys": 10}`, map[string]*policy.ConditionValue{}, false, false},
+		{"NumericLessThanEqualsIfExists with missing key", "NumericLessThanEqualsIfExists", `{"s3:max-keys": 10}`, map[string]*policy.ConditionValue{}, true, false},
+		{"NumericGreaterThan with higher value", "NumericGreaterThan", `{"s3:signatureAge": 600000}`, map[string]*policy.ConditionValue{"s3:signatureAge": strCtx("600001")}, true, false},
+		{"NumericGreaterThanEquals with lower value", "NumericGreaterThanEquals", `{"s3:signatureAge": 600000}`, map[string]*policy.ConditionValue{"s3:signatureAge": strCtx("1")}, false, false},
+		{"Numeric operator with context value that is not a number", "NumericLessThan", `{"s3:max-keys": 10}`, map[string]*policy.ConditionValue{"s3:max-keys": strCtx("ten")}, false, false},
+		{"Numeric operator with policy value that is not a number", "NumericLessThan", `{"s3:max-keys": "ten"}`, map[string]*policy.ConditionValue{"s3:max-keys": strCtx("1")}, false, true},
+		{"DateEquals with other notation of the same time", "DateEquals", `{"aws:CurrentTime": "2024-06-30T14:00:00Z"}`, map[string]*policy.ConditionValue{"aws:CurrentTime": strCtx("2024-06-30T16:00:00+02:00")}, true, false},
+		{"DateNotEquals with missing key", "DateNotEquals", `{"aws:CurrentTime": "2024-06-30T14:00:00Z"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"DateLessThan with earlier time", "DateLessThan", `{"aws:CurrentTime": "2024-07-01"}`, map[string]*policy.ConditionValue{"aws:CurrentTime": strCtx("2024-06-30T23:59:59Z")}, true, false},
+		{"DateLessThan with equal time", "DateLessThan", `{"aws:CurrentTime": "2024-07-01T00:00:00Z"}`, map[string]*policy.ConditionValue{"aws:CurrentTime": strCtx("2024-07-01T00:00:00Z")}, false, false},
+		{"DateLessThanEquals with equal time", "DateLessThanEquals", `{"aws:CurrentTime": "2024-07-01T00:00Z"}`, map[string]*policy.ConditionValue{"aws:CurrentTime": strCtx("2024-07-01T00:00:00Z")}, true, false},
+		{"DateGreaterThan with epoch in context", "DateGreaterThan", `{"aws:EpochTime": "2024-07-01T00:00:00Z"}`, map[string]*policy.ConditionValue{"aws:EpochTime": strCtx("1719792001")}, true, false},
+		{"DateGreaterThanEquals with epoch in policy", "DateGreaterThanEquals", `{"aws:CurrentTime": 1719792000}`, map[string]*policy.ConditionValue{"aws:CurrentTime": strCtx("2024-06-30T23:59:59Z")}, false, false},
+		{"DateGreaterThan with missing key", "DateGreaterThan", `{"aws:TokenIssueTime": "2024-07-01T00:00:00Z"}`, map[string]*policy.ConditionValue{}, false, false},
+		{"Date operator with policy value that is not a date", "DateLessThan", `{"aws:CurrentTime": "tomorrow"}`, map[string]*policy.ConditionValue{"aws:CurrentTime": strCtx("2024-06-30T23:59:59Z")}, false, true},
+		{"IpAddress in CIDR block", "IpAddress", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("192.0.2.17")}, true, false},
+		{"IpAddress outside CIDR block", "IpAddress", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("198.51.100.17")}, false, false},
+		{"IpAddress with any of the CIDR blocks", "IpAddress", `{"aws:SourceIp": ["198.51.100.0/24", "2001:db8::/32"]}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("2001:db8::1")}, true, false},
+		{"IpAddress with single address", "IpAddress", `{"aws:SourceIp": "192.0.2.17"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("192.0.2.17")}, true, false},
+		{"IpAddress with host bits in CIDR block", "IpAddress", `{"aws:SourceIp": "192.0.2.1/24"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("192.0.2.200")}, true, false},
+		{"IpAddress with IPv4 mapped IPv6 address", "IpAddress", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("::ffff:192.0.2.17")}, true, false},
+		{"IpAddress with missing key", "IpAddress", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{}, false, false},
+		{"IpAddress with context value that is not an address", "IpAddress", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("localhost")}, false, false},
+		{"IpAddress with invalid CIDR block in policy", "IpAddress", `{"aws:SourceIp": "192.0.2.0/33"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("192.0.2.17")}, false, true},
+		{"NotIpAddress outside CIDR block", "NotIpAddress", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("198.51.100.17")}, true, false},
+		{"NotIpAddress in CIDR block", "NotIpAddress", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("192.0.2.17")}, false, false},
+		{"NotIpAddress with missing key", "NotIpAddress", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"IpAddressIfExists with missing key", "IpAddressIfExists", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"Multi-valued key without set qualifier", "StringEquals", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a", "b")}, false, true},
+		{"ForAnyValue:StringEquals with one matching value", "ForAnyValue:StringEquals", `{"aws:PrincipalTag/groups": ["b", "c"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a", "b")}, true, false},
+		{"ForAnyValue:StringEquals without matching value", "ForAnyValue:StringEquals", `{"aws:PrincipalTag/groups": ["c", "d"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a", "b")}, false, false},
+		{"ForAnyValue:StringEquals with single value", "ForAnyValue:StringEquals", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a")}, true, false},
+		{"ForAnyValue:StringEquals with missing key", "ForAnyValue:StringEquals", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{}, false, false},
+		{"ForAnyValue:StringEquals with empty key", "ForAnyValue:StringEquals", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx()}, false, false},
+		{"ForAnyValue:StringEqualsIfExists with missing key", "ForAnyValue:StringEqualsIfExists", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"ForAnyValue:StringNotEquals with one other value", "ForAnyValue:StringNotEquals", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a", "b")}, true, false},
+		{"ForAnyValue:StringNotEquals with only listed values", "ForAnyValue:StringNotEquals", `{"aws:PrincipalTag/groups": ["a", "b"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a", "b")}, false, false},
+		{"ForAnyValue:StringNotEquals with missing key", "ForAnyValue:StringNotEquals", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{}, false, false},
+		{"ForAnyValue:StringLike", "ForAnyValue:StringLike", `{"aws:PrincipalTag/groups": "team-*"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("staff", "team-x")}, true, false},
+		{"ForAnyValue:NumericLessThan", "ForAnyValue:NumericLessThan", `{"aws:PrincipalTag/levels": 3}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/levels": strCtx("5", "2")}, true, false},
+		{"ForAnyValue:IpAddress", "ForAnyValue:IpAddress", `{"aws:SourceIp": "192.0.2.0/24"}`, map[string]*policy.ConditionValue{"aws:SourceIp": strCtx("198.51.100.1", "192.0.2.1")}, true, false},
+		{"ForAllValues:StringEquals with only listed values", "ForAllValues:StringEquals", `{"aws:PrincipalTag/groups": ["a", "b", "c"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a", "b")}, true, false},
+		{"ForAllValues:StringEquals with one other value", "ForAllValues:StringEquals", `{"aws:PrincipalTag/groups": ["a", "c"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a", "b")}, false, false},
+		{"ForAllValues:StringEquals with missing key", "ForAllValues:StringEquals", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{}, true, false},
+		{"ForAllValues:StringEquals with empty key", "ForAllValues:StringEquals", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx()}, true, false},
+		{"ForAllValues:StringNotEquals without listed values", "ForAllValues:StringNotEquals", `{"aws:PrincipalTag/groups": ["c", "d"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a", "b")}, true, false},
+		{"ForAllValues:StringNotEquals with one listed value", "ForAllValues:StringNotEquals", `{"aws:PrincipalTag/groups": ["b", "d"]}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a", "b")}, false, false},
+		{"ForAllValues:StringLike", "ForAllValues:StringLike", `{"aws:PrincipalTag/groups": "team-*"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("team-y", "team-x")}, true, false},
+		{"ForAllValues with invalid policy value", "ForAllValues:NumericEquals", `{"aws:PrincipalTag/levels": "one"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/levels": strCtx("1")}, false, true},
+		{"Set qualifiers cannot be combined", "ForAnyValue:ForAllValues:StringEquals", `{"aws:PrincipalTag/groups": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/groups": strCtx("a")}, false, true},
+		{"Null has no set qualifier", "ForAnyValue:Null", `{"aws:PrincipalTag/groups": "true"}`, map[string]*policy.ConditionValue{}, false, true},
+		{"Null has no IfExists variant", "NullIfExists", `{"aws:PrincipalTag/team": "true"}`, map[string]*policy.ConditionValue{}, false, true},
+		{"Unknown operator", "StringSortOfLike", `{"aws:PrincipalTag/team": "a"}`, map[string]*policy.ConditionValue{}, false, true},
+		{"Multi valued context key", "StringEquals", `{"aws:PrincipalTag/team": "a"}`, map[string]*policy.ConditionValue{"aws:PrincipalTag/team": policy.NewConditionValueString(false, "a", "b")}, false, true},
+	}
+	for _, tc := range testCases {
+		conditionDetails := map[string]*policy.ConditionValue{}
+		if err := json.Unmarshal([]byte(tc.Condition), &conditionDetails); err != nil {
+			t.Fatalf("%s: invalid test condition: %s", tc.Description, err)
+		}
+		isMet, err := isConditionMetForOperator(tc.Operator, conditionDetails, tc.Context, true)
+		if tc.ExpectError {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.Description)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+			continue
+		}
+		if isMet != tc.ExpectedMet {
+			t.Errorf("%s: expected met=%t, got %t", tc.Description, tc.ExpectedMet, isMet)
+		}
+	}
+}
+
+func TestPolicyWithStringEqualsCondition(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/*",
+			"Condition": {"StringEquals": {"aws:PrincipalTag/department": "test"}}
+		}]
+	}`, actionnames.IAMActionS3GetObject, testBucketARN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for session, expected := range map[*PolicySessionData]bool{testSessionDataTestDepartment: true, testSessionDataQaDeparment: false} {
+		isAllowed, _, err := pe.Evaluate(NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/key", session))
+		if err != nil {
+			t.Errorf("Unexpected error %s", err)
+		}
+		if isAllowed != expected {
+			t.Errorf("Expected allowed=%t for tags %v", expected, session.Tags.PrincipalTags)
+		}
+	}
+}
+
+func TestPolicyWithForAnyValueOnMultiValuedPrincipalTag(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/*",
+			"Condition": {"ForAnyValue:StringEquals": {"aws:PrincipalTag/groups": ["climate", "water"]}}
+		}]
+	}`, actionnames.IAMActionS3GetObject, testBucketARN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testCases = []struct {
+		Groups   []string
+		Expected bool
+	}{
+		{[]string{"staff", "water"}, true},
+		{[]string{"climate"}, true},
+		{[]string{"staff", "land"}, false},
+		{nil, false},
+	}
+	for _, tc := range testCases {
+		session := &PolicySessionData{}
+		if tc.Groups != nil {
+			session.Tags.PrincipalTags = map[string][]string{"groups": tc.Groups}
+		}
+		isAllowed, _, err := pe.Evaluate(NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/key", session))
+		if err != nil {
+			t.Errorf("Unexpected error %s", err)
+		}
+		if isAllowed != tc.Expected {
+			t.Errorf("Expected allowed=%t for groups %v", tc.Expected, tc.Groups)
+		}
+	}
+}
+
+func TestTimeConditionKeys(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	session := &PolicySessionData{TokenIssueTime: now.Add(-90 * time.Minute)}
+	context := map[string]*policy.ConditionValue{}
+
+	addTimeConditionKeys(context, session, now)
+
+	expected := map[string]string{
+		"aws:CurrentTime":    "2024-07-01T12:00:00Z",
+		"aws:EpochTime":      "1719835200",
+		"aws:TokenIssueTime": "2024-07-01T10:30:00Z",
+		ConditionKeyTokenAge: "5400",
+	}
+	for key, expectedValue := range expected {
+		value, ok := context[key]
+		if !ok {
+			t.Errorf("%s is missing", key)
+			continue
+		}
+		if got := conditionValueStrings(value); len(got) != 1 || got[0] != expectedValue {
+			t.Errorf("%s: expected %s, got %v", key, expectedValue, got)
+		}
+	}
+
+	//A session without issue time only gets the current time
+	context = map[string]*policy.ConditionValue{}
+	addTimeConditionKeys(context, &PolicySessionData{}, now)
+	if _, ok := context["aws:TokenIssueTime"]; ok || len(context) != 2 {
+		t.Errorf("Expected only the current time keys, got %v", context)
+	}
+}
+
+func TestPolicyLimitingSessionAge(t *testing.T) {
+	now := time.Now().UTC()
+	var testCases = []struct {
+		Description string
+		Condition   string
+	}{
+		{"Date operator on aws:TokenIssueTime", fmt.Sprintf(`{"DateGreaterThan": {"aws:TokenIssueTime": "%s"}}`, now.Add(-time.Hour).Format(time.RFC3339))},
+		{"Numeric operator on fakes3pp:TokenAge", fmt.Sprintf(`{"NumericLessThan": {"%s": "3600"}}`, ConditionKeyTokenAge)},
+	}
+	for _, tc := range testCases {
+		pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [{"Effect": "Allow", "Action": "%s", "Resource": "%s/*", "Condition": %s}]
+		}`, actionnames.IAMActionS3GetObject, testBucketARN, tc.Condition))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, issuedAgo := range []time.Duration{time.Minute, 2 * time.Hour} {
+			session := &PolicySessionData{TokenIssueTime: now.Add(-issuedAgo)}
+			isAllowed, _, err := pe.Evaluate(NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/key", session))
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", tc.Description, err)
+			}
+			if expected := issuedAgo < time.Hour; isAllowed != expected {
+				t.Errorf("%s: session issued %s ago expected allowed=%t, got %t", tc.Description, issuedAgo, expected, isAllowed)
+			}
+		}
+	}
+}
+
+func TestPolicyWithTimeWindow(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/*",
+			"Condition": {
+				"DateGreaterThan": {"aws:CurrentTime": "2000-01-01T00:00:00Z"},
+				"DateLessThan": {"aws:CurrentTime": "%s"}
+			}
+		}]
+	}`, actionnames.IAMActionS3GetObject, testBucketARN, time.Now().Add(time.Hour).UTC().Format(time.RFC3339)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	isAllowed, _, err := pe.Evaluate(NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/key", nil))
+	if err != nil || !isAllowed {
+		t.Errorf("Expected access within the time window, got allowed=%t error=%v", isAllowed, err)
+	}
+}
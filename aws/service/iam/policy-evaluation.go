@@ -5,14 +5,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log/slog"
-	"regexp"
+	"maps"
+	"slices"
 	"strings"
 
 	"github.com/micahhausler/aws-iam-policy/policy"
 )
 
-func parsePolicy(policyContent string) (*policy.Policy, error) {
+func decodePolicy(policyContent string) (*policy.Policy, error) {
 	var p policy.Policy
 	decoder := json.NewDecoder(bytes.NewReader([]byte(policyContent)))
 	decoder.DisallowUnknownFields()
@@ -23,23 +23,189 @@ func parsePolicy(policyContent string) (*policy.Policy, error) {
 	return &p, nil
 }
 
+func parsePolicy(policyContent string) (*policy.Policy, error) {
+	p, err := decodePolicy(policyContent)
+	if err != nil {
+		return nil, err
+	}
+	err = validatePolicy(p, validateIdentityStatementPrincipalAndResource)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Refuse policies with elements that the evaluator does not take into account. Ignoring them would make the
+// policy grant or deny something else than what its author intended. The Principal and Resource elements depend on
+// the type of policy so they are checked by validatePrincipalAndResource.
+func validatePolicy(p *policy.Policy, validatePrincipalAndResource func(policy.Statement) error) error {
+	if p.Statements == nil {
+		return errors.New("policy has no Statement element")
+	}
+	variablesEnabled := p.Version == policyVersionWithVariables
+	for i, s := range p.Statements.Values() {
+		statementId := s.Sid
+		if statementId == "" {
+			statementId = fmt.Sprintf("#%d", i)
+		}
+		err := validateStatement(s, variablesEnabled)
+		if err == nil {
+			err = validatePrincipalAndResource(s)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid statement %s: %w", statementId, err)
+		}
+	}
+	return nil
+}
+
+func validateIdentityStatementPrincipalAndResource(s policy.Statement) error {
+	if s.Principal != nil || s.NotPrincipal != nil {
+		return errors.New("Principal and NotPrincipal are not supported in identity policies")
+	}
+	return validateStatementHasResource(s)
+}
+
+func validateStatementHasResource(s policy.Statement) error {
+	if (s.Resource == nil) == (s.NotResource == nil) {
+		return errors.New("exactly one of Resource and NotResource must be set")
+	}
+	return nil
+}
+
+func validateStatement(s policy.Statement, variablesEnabled bool) error {
+	if s.Effect != policy.EffectAllow && s.Effect != policy.EffectDeny {
+		return fmt.Errorf("unsupported Effect %q", s.Effect)
+	}
+	if (s.Action == nil) == (s.NotAction == nil) {
+		return errors.New("exactly one of Action and NotAction must be set")
+	}
+	for conditionOperator, conditionDetails := range s.Condition {
+		if _, err := parseConditionOperator(conditionOperator); err != nil {
+			return err
+		}
+		if !variablesEnabled {
+			continue
+		}
+		for _, conditionValue := range conditionDetails {
+			strValues, _, _ := conditionValue.Values()
+			for _, value := range strValues {
+				if err := validatePolicyVariables(value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if variablesEnabled {
+		for _, resources := range []*policy.StringOrSlice{s.Resource, s.NotResource} {
+			if resources == nil {
+				continue
+			}
+			for _, resource := range resources.Values() {
+				if err := validatePolicyVariables(resource); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
 type PolicyEvaluator struct {
-	p *policy.Policy
+	//An action is allowed if each group has a policy that allows it and no policy denies it. The policies of a role
+	//form one group and session policies another so a session only gets what both of them allow.
+	groups [][]*policy.Policy
+	//Optional bucket policies, the policy of the bucket of a resource grants access like the policies of the role
+	bucketPolicies BucketPolicyRetriever
 }
 
 func NewPolicyEvaluator(pol *policy.Policy) *PolicyEvaluator {
 	pe := PolicyEvaluator{
-		p: pol,
+		groups: [][]*policy.Policy{{pol}},
 	}
 	return &pe
 }
 
 func NewPolicyEvaluatorFromStr(policyContent string) (*PolicyEvaluator, error) {
-	p, err := parsePolicy(policyContent)
-	if err != nil {
-		return nil, err
+	return NewPolicyEvaluatorFromStrs(policyContent)
+}
+
+// Create an evaluator that allows what any of the policies allows (e.g. the policies attached to a role)
+func NewPolicyEvaluatorFromStrs(policyContents ...string) (*PolicyEvaluator, error) {
+	if len(policyContents) < 1 {
+		return nil, errors.New("policy evaluator must have at least 1 policy")
+	}
+	policies := make([]*policy.Policy, len(policyContents))
+	for i, policyContent := range policyContents {
+		p, err := parsePolicy(policyContent)
+		if err != nil {
+			return nil, err
+		}
+		policies[i] = p
 	}
-	return NewPolicyEvaluator(p), nil
+	return &PolicyEvaluator{groups: [][]*policy.Policy{policies}}, nil
+}
+
+// Get an evaluator that only allows what both evaluators allow (e.g. the policies of a role and session policies)
+func (e *PolicyEvaluator) Intersect(other *PolicyEvaluator) *PolicyEvaluator {
+	return &PolicyEvaluator{groups: slices.Concat(e.groups, other.groups), bucketPolicies: e.bucketPolicies}
+}
+
+// Get an evaluator that also takes the bucket policies into account
+func (e *PolicyEvaluator) WithBucketPolicies(bucketPolicies BucketPolicyRetriever) *PolicyEvaluator {
+	return &PolicyEvaluator{groups: e.groups, bucketPolicies: bucketPolicies}
+}
+
+// Get the policy of the bucket of a resource or nil if there is none
+func (e *PolicyEvaluator) getBucketPolicy(resource string) *policy.Policy {
+	if e.bucketPolicies == nil {
+		return nil
+	}
+	bucket, ok := getBucketFromResource(resource)
+	if !ok {
+		return nil
+	}
+	return e.bucketPolicies.GetBucketPolicy(bucket)
+}
+
+// Get the policies that are evaluated for a resource. The bucket policy is added to the first group which holds the
+// policies of the role so that either of them can grant access.
+func (e *PolicyEvaluator) getPolicyGroups(resource string) [][]*policy.Policy {
+	bucketPolicy := e.getBucketPolicy(resource)
+	if bucketPolicy == nil || len(e.groups) == 0 {
+		return e.groups
+	}
+	groups := slices.Clone(e.groups)
+	groups[0] = append(slices.Clip(groups[0]), bucketPolicy)
+	return groups
+}
+
+// Whether a condition key starting with prefix is used in one of the policies that are evaluated for the actions
+func (e *PolicyEvaluator) UsesConditionKeyPrefix(prefix string, actions []IAMAction) bool {
+	prefix = strings.ToLower(prefix)
+	for _, action := range actions {
+		for _, group := range e.getPolicyGroups(action.Resource) {
+			for _, pol := range group {
+				if policyUsesConditionKeyPrefix(pol, prefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func policyUsesConditionKeyPrefix(pol *policy.Policy, lowerCasePrefix string) bool {
+	for _, s := range pol.Statements.Values() {
+		for _, conditionDetails := range s.Condition {
+			for conditionKey := range conditionDetails {
+				if strings.HasPrefix(strings.ToLower(conditionKey), lowerCasePrefix) {
+					return true
+				}
+			}
+		}
+	}
+	return false
 }
 
 type evalReason string
@@ -52,78 +218,57 @@ const reasonErrorEncountered evalReason = "Error was encountered"
 // Allow wildcards like * and ? but escape other special characters
 // https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_elements_condition_operators.html
 func iamStringLike(patternString, literalStrin string) bool {
-	saferPattern := regexp.QuoteMeta(patternString)
-	pattern := strings.NewReplacer("\\*", ".*", "\\?", ".").Replace(saferPattern)
-	match, err := regexp.MatchString(pattern, literalStrin)
-	if err != nil {
-		slog.Error("Error for checking match", "pattern_string", patternString, "pattern", pattern, "literal", literalStrin, "error", err)
-	}
-	return match
+	return newPolicyPattern(patternString).like(literalStrin)
 }
 
 // The statement resource can have wildcards like * and ?
 // so use the StringLike to check it
-func doesResourceMatch(statementResource, resource string) bool {
-	return iamStringLike(statementResource, resource)
+func doesResourceMatch(statementResource policyValue, resource string) bool {
+	return statementResource.like(resource)
 }
 
-// To check whether all the values in the passed context are singular depending on the
-// condition operator this might be necessary
-func areAllConditionValuesSingular(context map[string]*policy.ConditionValue) bool {
-	for _, value := range context {
-		if !value.IsSingular() {
-			return false
-		}
-	}
-	return true
+func doesActionMatch(statementAction, action string) bool {
+	return statementAction == action || iamStringLike(statementAction, action)
 }
 
-// Evaluate what a StringLike operation does
-func evalStringLike(conditionDetails map[string]*policy.ConditionValue, context map[string]*policy.ConditionValue) (bool, error) {
-	if !areAllConditionValuesSingular(context) {
-		return false, fmt.Errorf("non-singular value got %v", context)
-	}
-	for sConditionKey, sConditionValue := range conditionDetails {
-		contextValue, exists := context[sConditionKey]
-		if !exists {
-			slog.Debug("condition key was not set in request context", "operation", "StringLike", "conditionKey", sConditionKey)
-			return false, nil
+// Whether an action is in scope of a statement. With NotAction every action that is not listed is in scope.
+func isActionInScope(statement policy.Statement, action string) bool {
+	if statement.NotAction != nil {
+		for _, statementAction := range statement.NotAction.Values() {
+			if doesActionMatch(statementAction, action) {
+				return false
+			}
 		}
-		if !isConditionMetForStringLike(sConditionValue, contextValue) {
-			return false, nil
+		return true
+	}
+	if statement.Action == nil {
+		return false
+	}
+	for _, statementAction := range statement.Action.Values() {
+		if doesActionMatch(statementAction, action) {
+			return true
 		}
 	}
-	return true, nil
+	return false
 }
 
-// See whether the condition defined by the conditionOperator and conditionDetails is met
-// for the given context
-func isConditionMetForOperator(conditionOperator string, conditionDetails map[string]*policy.ConditionValue, context map[string]*policy.ConditionValue) (bool, error) {
-	switch conditionOperator {
-	case "StringLike":
-		result, err := evalStringLike(conditionDetails, context)
-		if err != nil {
-			return false, fmt.Errorf("operator StringLike encountered %s", err)
-		}
-		return result, err
-	case "StringNotLike":
-		result, err := evalStringLike(conditionDetails, context)
-		if err != nil {
-			return false, fmt.Errorf("operator StringLike encountered %s", err)
+// Whether a resource is in scope of a statement. With NotResource every resource that is not listed is in scope.
+// If variablesEnabled the policy variables in the statement are replaced by their value in the context.
+func isResourceInScope(statement policy.Statement, resource string, context map[string]*policy.ConditionValue, variablesEnabled bool) bool {
+	if statement.NotResource != nil {
+		for _, statementResource := range resolvePolicyValues(statement.NotResource.Values(), variablesEnabled, context) {
+			//A NotResource with a variable that has no value does not apply to any resource
+			if statementResource.unresolved || doesResourceMatch(statementResource, resource) {
+				return false
+			}
 		}
-		// https://stackoverflow.com/a/71531863/2653523
-		return !result, err
-	default:
-		return false, fmt.Errorf("unsupported condition: '%s'", conditionOperator)
+		return true
 	}
-}
-
-func isConditionMetForStringLike(statementValues, context *policy.ConditionValue) bool {
-	ctxStrValues, _, _ := context.Values()
-	ctxStrValue := ctxStrValues[0]
-	strValues, _, _ := statementValues.Values()
-	for _, sValue := range strValues {
-		if iamStringLike(sValue, ctxStrValue) {
+	if statement.Resource == nil {
+		return false
+	}
+	for _, statementResource := range resolvePolicyValues(statement.Resource.Values(), variablesEnabled, context) {
+		if doesResourceMatch(statementResource, resource) {
 			return true
 		}
 	}
@@ -131,63 +276,158 @@ func isConditionMetForStringLike(statementValues, context *policy.ConditionValue
 }
 
 // Check whether a policy Statement is relevent for a certain IAM action
-func isRelevantFor(statement policy.Statement, a IAMAction) (bool, error) {
-	actionInScope := false
-	for _, statementAction := range statement.Action.Values() {
-		if statementAction == a.Action || iamStringLike(statementAction, a.Action) {
-			actionInScope = true
-		}
+// If the statement applies to the action but one of its conditions is not met that condition is returned.
+func isRelevantFor(statement policy.Statement, a IAMAction, variablesEnabled bool) (bool, *FailedCondition, error) {
+	if !isPrincipalInScope(statement, a.Context) {
+		return false, nil, nil
 	}
-	if !actionInScope {
-		return false, nil
+	if !isActionInScope(statement, a.Action) {
+		return false, nil, nil
 	}
-
-	resourceInScope := false
-	for _, statementResource := range statement.Resource.Values() {
-		if doesResourceMatch(statementResource, a.Resource) {
-			resourceInScope = true
-		}
-	}
-	if !resourceInScope {
-		return false, nil
+	if !isResourceInScope(statement, a.Resource, a.Context, variablesEnabled) {
+		return false, nil, nil
 	}
 
 	for conditionOperator, conditionDetails := range statement.Condition {
-		isMet, err := isConditionMetForOperator(conditionOperator, conditionDetails, a.Context)
+		isMet, err := isConditionMetForOperator(conditionOperator, conditionDetails, a.Context, variablesEnabled)
 		if err != nil {
-			return false, err
+			return false, nil, err
 		}
 		if !isMet {
 			//Unmet condition so we are not relevant
-			return false, nil
+			return false, &FailedCondition{
+				Operator: conditionOperator,
+				Key:      getFailedConditionKey(conditionOperator, conditionDetails, a.Context, variablesEnabled),
+			}, nil
 		}
 	}
 
-	return true, nil
+	return true, nil, nil
+}
+
+// Get the key of an unmet condition. A condition with multiple keys is only met if it is met for each of them.
+func getFailedConditionKey(conditionOperator string, conditionDetails, context map[string]*policy.ConditionValue, variablesEnabled bool) string {
+	for _, conditionKey := range slices.Sorted(maps.Keys(conditionDetails)) {
+		singleKey := map[string]*policy.ConditionValue{conditionKey: conditionDetails[conditionKey]}
+		isMet, err := isConditionMetForOperator(conditionOperator, singleKey, context, variablesEnabled)
+		if err == nil && !isMet {
+			return conditionKey
+		}
+	}
+	return ""
+}
+
+// Whether the policy variables like ${aws:PrincipalTag/team} are replaced in the policy
+func variablesEnabled(pol *policy.Policy) bool {
+	return pol.Version == policyVersionWithVariables
 }
 
 func (e *PolicyEvaluator) Evaluate(a IAMAction) (isAllowed bool, reason evalReason, err error) {
-	isAllowed = false
-	reason = reasonNoStatementAllowingAction
-	pol := e.p
-	for _, s := range pol.Statements.Values() {
+	result, err := e.Explain(a)
+	return result.Allowed, result.Reason, err
+}
+
+// The statement of a policy that decided an evaluation, identified by its position in the policy and its Sid
+type MatchedStatement struct {
+	Index int    `json:"index"`
+	Sid   string `json:"sid,omitempty"`
+}
+
+// A condition of a statement that applies to an action but is not met
+type FailedCondition struct {
+	Statement MatchedStatement `json:"statement"`
+	Operator  string           `json:"operator"`
+	Key       string           `json:"key,omitempty"`
+}
+
+// The outcome of evaluating an IAM action together with the statement that decided it
+type EvaluationResult struct {
+	Allowed bool       `json:"allowed"`
+	Reason  evalReason `json:"reason"`
+	//The statement that denied the action or the first statement that allowed it, nil if no statement applies
+	Statement *MatchedStatement `json:"statement,omitempty"`
+	//For denied actions the Allow statements that would have applied if their conditions were met
+	FailedConditions []FailedCondition `json:"failed_conditions,omitempty"`
+}
+
+// Evaluate an IAM action like Evaluate but also tell which statement decided it
+func (e *PolicyEvaluator) Explain(a IAMAction) (result EvaluationResult, err error) {
+	result = EvaluationResult{Allowed: true, Reason: reasonActionIsAllowed}
+	var failedConditions []FailedCondition
+	for _, group := range e.getPolicyGroups(a.Resource) {
+		var allowingStatement *MatchedStatement
+		for _, pol := range group {
+			policyResult, err := evaluatePolicy(pol, a)
+			if err != nil || policyResult.Reason == reasonExplicitDeny {
+				return policyResult, err
+			}
+			failedConditions = append(failedConditions, policyResult.FailedConditions...)
+			if policyResult.Allowed && allowingStatement == nil {
+				allowingStatement = policyResult.Statement
+			}
+		}
+		if allowingStatement == nil {
+			//Keep evaluating as an explicit deny in another group takes precedence
+			result = EvaluationResult{Allowed: false, Reason: reasonNoStatementAllowingAction}
+		} else if result.Allowed && result.Statement == nil {
+			result.Statement = allowingStatement
+		}
+	}
+	if !result.Allowed {
+		result.FailedConditions = failedConditions
+	}
+	return
+}
+
+// The decision for an IAM action with the statement that decided it
+type ActionExplanation struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+	EvaluationResult
+}
+
+// Explain the evaluation of multiple IAM actions which must all be allowed. Evaluation stops at the first action that
+// is denied so that is the last explanation.
+func (e *PolicyEvaluator) ExplainAll(actions []IAMAction) (isAllowed bool, explanations []ActionExplanation, err error) {
+	if len(actions) < 1 {
+		return false, nil, errors.New("ExplainAll must have at least 1 iamAction")
+	}
+	for _, action := range actions {
+		result, err := e.Explain(action)
+		explanations = append(explanations, ActionExplanation{Action: action.Action, Resource: action.Resource, EvaluationResult: result})
+		if err != nil || !result.Allowed {
+			return false, explanations, err
+		}
+	}
+	return true, explanations, nil
+}
+
+func evaluatePolicy(pol *policy.Policy, a IAMAction) (result EvaluationResult, err error) {
+	result = EvaluationResult{Allowed: false, Reason: reasonNoStatementAllowingAction}
+	variablesEnabled := variablesEnabled(pol)
+	for i, s := range pol.Statements.Values() {
 		switch s.Effect {
 		case policy.EffectAllow:
-			relevant, err := isRelevantFor(s, a)
+			relevant, failedCondition, err := isRelevantFor(s, a, variablesEnabled)
 			if err != nil {
-				return false, reasonNoStatementAllowingAction, err
+				return EvaluationResult{Allowed: false, Reason: reasonNoStatementAllowingAction}, err
 			}
-			if relevant {
-				isAllowed = true
-				reason = reasonActionIsAllowed
+			if failedCondition != nil {
+				failedCondition.Statement = MatchedStatement{Index: i, Sid: s.Sid}
+				result.FailedConditions = append(result.FailedConditions, *failedCondition)
+			}
+			if relevant && !result.Allowed {
+				result.Allowed = true
+				result.Reason = reasonActionIsAllowed
+				result.Statement = &MatchedStatement{Index: i, Sid: s.Sid}
 			}
 		case policy.EffectDeny:
-			relevant, err := isRelevantFor(s, a)
+			relevant, _, err := isRelevantFor(s, a, variablesEnabled)
 			if err != nil {
-				return false, reasonErrorEncountered, err
+				return EvaluationResult{Allowed: false, Reason: reasonErrorEncountered}, err
 			}
 			if relevant {
-				return false, reasonExplicitDeny, err
+				return EvaluationResult{Allowed: false, Reason: reasonExplicitDeny, Statement: &MatchedStatement{Index: i, Sid: s.Sid}}, err
 			}
 		}
 	}
@@ -207,3 +447,190 @@ func (e *PolicyEvaluator) EvaluateAll(actions []IAMAction) (isAllowed bool, reas
 	}
 	return
 }
+
+// Whether a wildcard pattern can match at least one of the strings matched by another wildcard pattern.
+// Both patterns can have the wildcards * and ? as in iamStringLike. This walks both patterns at the same
+// time keeping track of the positions that are reachable in each of them.
+func wildcardPatternsOverlap(patternA, patternB policyValue) bool {
+	if patternA.unresolved || patternB.unresolved {
+		return false
+	}
+	a, b := patternA.patternChars(), patternB.patternChars()
+	isStar := func(p patternChar) bool { return p.wildcard && p.c == '*' }
+	type position struct{ i, j int }
+	visited := map[position]bool{}
+	toVisit := []position{{0, 0}}
+	for len(toVisit) > 0 {
+		p := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		if visited[p] {
+			continue
+		}
+		visited[p] = true
+		if p.i == len(a) && p.j == len(b) {
+			return true
+		}
+		//A * can match the empty string
+		if p.i < len(a) && isStar(a[p.i]) {
+			toVisit = append(toVisit, position{p.i + 1, p.j})
+		}
+		if p.j < len(b) && isStar(b[p.j]) {
+			toVisit = append(toVisit, position{p.i, p.j + 1})
+		}
+		if p.i == len(a) || p.j == len(b) {
+			continue
+		}
+		//Both patterns consume the same character where a * stays in place to match more characters
+		if !a[p.i].wildcard && !b[p.j].wildcard && a[p.i].c != b[p.j].c {
+			continue
+		}
+		next := position{p.i + 1, p.j + 1}
+		if isStar(a[p.i]) {
+			next.i = p.i
+		}
+		if isStar(b[p.j]) {
+			next.j = p.j
+		}
+		if next != p {
+			toVisit = append(toVisit, next)
+		}
+	}
+	return false
+}
+
+// Whether a wildcard pattern matches every string matched by another wildcard pattern. This only recognizes
+// the common cases of equal patterns and a pattern that ends in * and has no other wildcards.
+func wildcardPatternCovers(pattern, other policyValue) bool {
+	if pattern.unresolved || other.unresolved {
+		return false
+	}
+	a, b := pattern.patternChars(), other.patternChars()
+	if slices.Equal(a, b) {
+		return true
+	}
+	if len(a) == 0 || a[len(a)-1] != (patternChar{c: '*', wildcard: true}) {
+		return false
+	}
+	prefix := a[:len(a)-1]
+	if slices.ContainsFunc(prefix, func(p patternChar) bool { return p.wildcard }) {
+		return false
+	}
+	return len(b) >= len(prefix) && slices.Equal(b[:len(prefix)], prefix)
+}
+
+// Whether some Allow statement could grant one of the actions on a resource matched by resourcePattern
+// which can have wildcards. Conditions and Deny statements are not taken into account since there is no
+// concrete request to evaluate them for. This tells whether a policy has any business with a resource
+// e.g. to decide whether to show it in a listing. Policy variables are replaced with the values of the session.
+func (e *PolicyEvaluator) CouldAllowAny(actions []string, resourcePattern string, session *PolicySessionData) bool {
+	context := map[string]*policy.ConditionValue{}
+	addGenericSessionContextKeys(context, session)
+	for _, group := range e.getPolicyGroups(resourcePattern) {
+		couldGroupAllow := false
+		for _, pol := range group {
+			if couldPolicyAllowAny(pol, actions, resourcePattern, context) {
+				couldGroupAllow = true
+				break
+			}
+		}
+		if !couldGroupAllow {
+			return false
+		}
+	}
+	return true
+}
+
+func couldPolicyAllowAny(pol *policy.Policy, actions []string, resourcePattern string, context map[string]*policy.ConditionValue) bool {
+	variablesEnabled := variablesEnabled(pol)
+	for _, s := range pol.Statements.Values() {
+		if s.Effect != policy.EffectAllow || !isPrincipalInScope(s, context) {
+			continue
+		}
+		actionInScope := false
+		for _, action := range actions {
+			if isActionInScope(s, action) {
+				actionInScope = true
+			}
+		}
+		if !actionInScope {
+			continue
+		}
+		if s.NotResource != nil {
+			//Some resource matched by the pattern is in scope unless a NotResource covers all of them
+			covered := false
+			for _, statementResource := range resolvePolicyValues(s.NotResource.Values(), variablesEnabled, context) {
+				//A NotResource with a variable that has no value does not apply to any resource
+				if statementResource.unresolved || wildcardPatternCovers(statementResource, newPolicyPattern(resourcePattern)) {
+					covered = true
+				}
+			}
+			if !covered {
+				return true
+			}
+			continue
+		}
+		if s.Resource == nil {
+			continue
+		}
+		for _, statementResource := range resolvePolicyValues(s.Resource.Values(), variablesEnabled, context) {
+			if wildcardPatternsOverlap(statementResource, newPolicyPattern(resourcePattern)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether an explicit Deny prevents each of the actions on every resource matched by resourcePattern. Like
+// CouldAllowAny this is meant for deciding whether to show a resource. Only Deny statements without conditions
+// are taken into account since there is no concrete request to evaluate conditions for.
+func (e *PolicyEvaluator) DeniesAll(actions []string, resourcePattern string, session *PolicySessionData) bool {
+	context := map[string]*policy.ConditionValue{}
+	addGenericSessionContextKeys(context, session)
+	for _, action := range actions {
+		denied := false
+		for _, group := range e.getPolicyGroups(resourcePattern) {
+			for _, pol := range group {
+				if doesPolicyDenyAll(pol, action, resourcePattern, context) {
+					denied = true
+				}
+			}
+		}
+		if !denied {
+			return false
+		}
+	}
+	return len(actions) > 0
+}
+
+func doesPolicyDenyAll(pol *policy.Policy, action string, resourcePattern string, context map[string]*policy.ConditionValue) bool {
+	variablesEnabled := variablesEnabled(pol)
+	for _, s := range pol.Statements.Values() {
+		if s.Effect != policy.EffectDeny || len(s.Condition) > 0 || !isPrincipalInScope(s, context) || !isActionInScope(s, action) {
+			continue
+		}
+		if s.NotResource != nil {
+			//Every resource matched by the pattern is in scope unless a NotResource could exclude some of them
+			excluded := false
+			for _, statementResource := range resolvePolicyValues(s.NotResource.Values(), variablesEnabled, context) {
+				//A NotResource with a variable that has no value does not apply to any resource
+				if statementResource.unresolved || wildcardPatternsOverlap(statementResource, newPolicyPattern(resourcePattern)) {
+					excluded = true
+				}
+			}
+			if !excluded {
+				return true
+			}
+			continue
+		}
+		if s.Resource == nil {
+			continue
+		}
+		for _, statementResource := range resolvePolicyValues(s.Resource.Values(), variablesEnabled, context) {
+			if wildcardPatternCovers(statementResource, newPolicyPattern(resourcePattern)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -469,3 +469,338 @@ func TestPolicyEvaluations(t *testing.T) {
 	}
 
 }
+
+func TestWildcardPatternsOverlap(t *testing.T) {
+	var testCases = []struct {
+		a, b     string
+		expected bool
+	}{
+		{"arn:aws:s3:::bucket1", "arn:aws:s3:::bucket1", true},
+		{"arn:aws:s3:::bucket1", "arn:aws:s3:::bucket2", false},
+		{"arn:aws:s3:::bucket1/*", "arn:aws:s3:::bucket1/*", true},
+		{"arn:aws:s3:::bucket1/okprefix/*", "arn:aws:s3:::bucket1/*", true},
+		{"arn:aws:s3:::bucket1*", "arn:aws:s3:::bucket1/*", true},
+		{"arn:aws:s3:::bucket?", "arn:aws:s3:::bucket1", true},
+		{"arn:aws:s3:::bucket?", "arn:aws:s3:::bucket12", false},
+		{"arn:aws:s3:::*/public/*", "arn:aws:s3:::bucket1/*", true},
+		{"arn:aws:s3:::*/public/*", "arn:aws:s3:::bucket1", false},
+		{"*", "arn:aws:s3:::bucket1", true},
+		{"arn:aws:s3:::bucket2/*", "arn:aws:s3:::bucket1/*", false},
+		{"arn:aws:s3:::bucket1", "arn:aws:s3:::bucket1/*", false},
+	}
+	for _, tc := range testCases {
+		for _, patterns := range [][2]string{{tc.a, tc.b}, {tc.b, tc.a}} {
+			if got := wildcardPatternsOverlap(newPolicyPattern(patterns[0]), newPolicyPattern(patterns[1])); got != tc.expected {
+				t.Errorf("%s and %s: expected overlap %t, got %t", patterns[0], patterns[1], tc.expected, got)
+			}
+		}
+	}
+}
+
+func TestCouldAllowAny(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(testPolScen1AllowPutWithinPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectActions := []string{actionnames.IAMActionS3GetObject, actionnames.IAMActionS3PutObject}
+	if !pe.CouldAllowAny(objectActions, fmt.Sprintf("%s/*", testBucketARN), nil) {
+		t.Error("Policy allows writing objects in the bucket")
+	}
+	if pe.CouldAllowAny(objectActions, "arn:aws:s3:::otherbucket/*", nil) {
+		t.Error("Policy does not allow anything in another bucket")
+	}
+	if pe.CouldAllowAny([]string{actionnames.IAMActionS3ListBucket}, testBucketARN, nil) {
+		t.Error("Policy does not allow listing the bucket")
+	}
+}
+
+var testPolDenyEverythingExceptReads = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "s3:*",
+			"Resource": "*"
+		},
+		{
+			"Effect": "Deny",
+			"NotAction": ["%s", "%s"],
+			"Resource": "*"
+		}
+	]
+}`, actionnames.IAMActionS3GetObject, actionnames.IAMActionS3ListBucket)
+
+var testPolAllowEverywhereExceptPrivate = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"NotResource": "%s/private/*"
+		}
+	]
+}`, actionnames.IAMActionS3GetObject, testBucketARN)
+
+var testPolAllowAllExceptDeleteOnlyInPrefix = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"NotAction": "%s",
+			"Resource": "%s/%s*"
+		}
+	]
+}`, actionnames.IAMActionS3DeleteObject, testBucketARN, testAllowedPrefix)
+
+var testPolDenyOutsidePrefix = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "s3:*",
+			"Resource": "*"
+		},
+		{
+			"Effect": "Deny",
+			"Action": "%s",
+			"NotResource": "%s/%s*"
+		}
+	]
+}`, actionnames.IAMActionS3PutObject, testBucketARN, testAllowedPrefix)
+
+func TestNotActionAndNotResource(t *testing.T) {
+	var testCases = []struct {
+		Description     string
+		PolicyString    string
+		Action          string
+		Resource        string
+		ShouldBeAllowed bool
+		ExpectedReason  evalReason
+	}{
+		{"Deny with NotAction does not deny the listed actions", testPolDenyEverythingExceptReads, actionnames.IAMActionS3GetObject, testBucketARN + "/key", true, reasonActionIsAllowed},
+		{"Deny with NotAction denies other actions", testPolDenyEverythingExceptReads, actionnames.IAMActionS3PutObject, testBucketARN + "/key", false, reasonExplicitDeny},
+		{"Allow with NotResource allows other resources", testPolAllowEverywhereExceptPrivate, actionnames.IAMActionS3GetObject, testBucketARN + "/public/key", true, reasonActionIsAllowed},
+		{"Allow with NotResource does not allow the listed resources", testPolAllowEverywhereExceptPrivate, actionnames.IAMActionS3GetObject, testBucketARN + "/private/key", false, reasonNoStatementAllowingAction},
+		{"Allow with NotResource only allows the listed action", testPolAllowEverywhereExceptPrivate, actionnames.IAMActionS3PutObject, testBucketARN + "/public/key", false, reasonNoStatementAllowingAction},
+		{"Allow with NotAction allows other actions", testPolAllowAllExceptDeleteOnlyInPrefix, actionnames.IAMActionS3PutObject, fmt.Sprintf("%s/%skey", testBucketARN, testAllowedPrefix), true, reasonActionIsAllowed},
+		{"Allow with NotAction does not allow the listed actions", testPolAllowAllExceptDeleteOnlyInPrefix, actionnames.IAMActionS3DeleteObject, fmt.Sprintf("%s/%skey", testBucketARN, testAllowedPrefix), false, reasonNoStatementAllowingAction},
+		{"Allow with NotAction only allows the listed resources", testPolAllowAllExceptDeleteOnlyInPrefix, actionnames.IAMActionS3PutObject, testBucketARN + "/otherkey", false, reasonNoStatementAllowingAction},
+		{"Deny with NotResource denies other resources", testPolDenyOutsidePrefix, actionnames.IAMActionS3PutObject, testBucketARN + "/otherkey", false, reasonExplicitDeny},
+		{"Deny with NotResource does not deny the listed resources", testPolDenyOutsidePrefix, actionnames.IAMActionS3PutObject, fmt.Sprintf("%s/%skey", testBucketARN, testAllowedPrefix), true, reasonActionIsAllowed},
+	}
+	for _, tc := range testCases {
+		pe, err := NewPolicyEvaluatorFromStr(tc.PolicyString)
+		if err != nil {
+			t.Fatalf("%s: Could not create PolicyEvaluator: %s", tc.Description, err)
+		}
+		allowed, reason, err := pe.Evaluate(NewIamAction(tc.Action, tc.Resource, nil))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+		}
+		if allowed != tc.ShouldBeAllowed {
+			t.Errorf("%s: Expected '%t' got '%t'", tc.Description, tc.ShouldBeAllowed, allowed)
+		}
+		if reason != tc.ExpectedReason {
+			t.Errorf("%s: Expected '%s' got '%s'", tc.Description, tc.ExpectedReason, reason)
+		}
+	}
+}
+
+func TestPoliciesWithUnsupportedElementsAreRefused(t *testing.T) {
+	var testCases = []struct {
+		Description string
+		Statement   string
+	}{
+		{"Unknown element", `{"Effect": "Allow", "Action": "s3:*", "Resource": "*", "Sources": "*"}`},
+		{"Principal", `{"Effect": "Allow", "Principal": "*", "Action": "s3:*", "Resource": "*"}`},
+		{"NotPrincipal", `{"Effect": "Deny", "NotPrincipal": {"AWS": "arn:aws:iam::000000000000:root"}, "Action": "s3:*", "Resource": "*"}`},
+		{"Invalid effect", `{"Effect": "Audit", "Action": "s3:*", "Resource": "*"}`},
+		{"Missing action", `{"Effect": "Allow", "Resource": "*"}`},
+		{"Both Action and NotAction", `{"Effect": "Allow", "Action": "s3:*", "NotAction": "s3:DeleteObject", "Resource": "*"}`},
+		{"Missing resource", `{"Effect": "Allow", "Action": "s3:*"}`},
+		{"Both Resource and NotResource", `{"Effect": "Allow", "Action": "s3:*", "Resource": "*", "NotResource": "arn:aws:s3:::private/*"}`},
+		{"Unsupported condition operator", `{"Effect": "Allow", "Action": "s3:*", "Resource": "*", "Condition": {"BinaryEquals": {"aws:SourceIp": "AA=="}}}`},
+	}
+	for _, tc := range testCases {
+		policyString := fmt.Sprintf(`{"Version": "2012-10-17", "Statement": [%s]}`, tc.Statement)
+		if _, err := NewPolicyEvaluatorFromStr(policyString); err == nil {
+			t.Errorf("%s: expected policy to be refused", tc.Description)
+		}
+	}
+
+	if _, err := NewPolicyEvaluatorFromStr(`{"Version": "2012-10-17"}`); err == nil {
+		t.Error("Expected policy without statements to be refused")
+	}
+}
+
+func TestCouldAllowAnyWithNotActionAndNotResource(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(testPolAllowEverywhereExceptPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	getObject := []string{actionnames.IAMActionS3GetObject}
+	if !pe.CouldAllowAny(getObject, fmt.Sprintf("%s/*", testBucketARN), nil) {
+		t.Error("Policy allows reading objects outside of the private prefix")
+	}
+	if pe.CouldAllowAny(getObject, fmt.Sprintf("%s/private/*", testBucketARN), nil) {
+		t.Error("Policy does not allow reading objects under the private prefix")
+	}
+	if pe.CouldAllowAny(getObject, fmt.Sprintf("%s/private/home/*", testBucketARN), nil) {
+		t.Error("Policy does not allow reading objects under the private prefix")
+	}
+
+	pe, err = NewPolicyEvaluatorFromStr(testPolAllowAllExceptDeleteOnlyInPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pe.CouldAllowAny(getObject, fmt.Sprintf("%s/*", testBucketARN), nil) {
+		t.Error("Policy allows reading objects in the prefix")
+	}
+	if pe.CouldAllowAny([]string{actionnames.IAMActionS3DeleteObject}, fmt.Sprintf("%s/*", testBucketARN), nil) {
+		t.Error("Policy does not allow deleting objects")
+	}
+}
+
+func TestDeniesAll(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{"Effect": "Allow", "Action": "*", "Resource": "*"},
+		{"Effect": "Deny", "Action": "%s", "Resource": "%s/secret/*"},
+		{"Effect": "Deny", "Action": "%s", "NotResource": "%s/*"},
+		{"Effect": "Deny", "Action": "%s", "Resource": "*", "Condition": {"StringEquals": {"aws:SourceIp": "10.0.0.1"}}}
+	]
+}`, actionnames.IAMActionS3GetObject, testBucketARN, actionnames.IAMActionS3GetObject, testBucketARN, actionnames.IAMActionS3GetObject))
+	if err != nil {
+		t.Fatal(err)
+	}
+	getObject := []string{actionnames.IAMActionS3GetObject}
+	var testCases = []struct {
+		ResourcePattern string
+		Expected        bool
+	}{
+		{testBucketARN + "/secret/*", true},
+		{testBucketARN + "/secret/sub/*", true},
+		{testBucketARN + "/secret*", false},
+		{testBucketARN + "/*", false},
+		{"arn:aws:s3:::otherbucket/*", true}, //Denied by NotResource
+		{"arn:aws:s3:::*", false},
+	}
+	for _, tc := range testCases {
+		if got := pe.DeniesAll(getObject, tc.ResourcePattern, nil); got != tc.Expected {
+			t.Errorf("DeniesAll(%s): expected %t, got %t", tc.ResourcePattern, tc.Expected, got)
+		}
+	}
+	if pe.DeniesAll([]string{actionnames.IAMActionS3PutObject}, testBucketARN+"/secret/*", nil) {
+		t.Error("Only reading secrets is denied")
+	}
+}
+
+func TestExplainAll(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Sid": "ListOwnPrefix", "Effect": "Allow", "Action": "%s", "Resource": "%s", "Condition": {"StringLike": {"s3:prefix": "%s*", "s3:delimiter": "/"}}},
+			{"Sid": "ReadOwnPrefix", "Effect": "Allow", "Action": "%s", "Resource": "%s*"}
+		]
+	}`, actionnames.IAMActionS3ListBucket, testBucketARN, testAllowedPrefix, actionnames.IAMActionS3GetObject, allowedWriteARNStart))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listAction := func(prefix string) IAMAction {
+		return NewIamAction(actionnames.IAMActionS3ListBucket, testBucketARN, nil).AddContext(map[string]*policy.ConditionValue{
+			"s3:prefix":    policy.NewConditionValueString(true, prefix),
+			"s3:delimiter": policy.NewConditionValueString(true, "/"),
+		})
+	}
+	getAction := NewIamAction(actionnames.IAMActionS3GetObject, allowedWriteARNStart+"key", nil)
+
+	isAllowed, explanations, err := pe.ExplainAll([]IAMAction{getAction, listAction(testAllowedPrefix)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isAllowed || len(explanations) != 2 {
+		t.Fatalf("Expected 2 allowed actions, got %t %+v", isAllowed, explanations)
+	}
+	if explanations[0].Statement == nil || explanations[0].Statement.Sid != "ReadOwnPrefix" || explanations[0].Statement.Index != 1 {
+		t.Errorf("Expected GetObject to be allowed by statement 1, got %+v", explanations[0].Statement)
+	}
+	if explanations[1].Statement == nil || explanations[1].Statement.Sid != "ListOwnPrefix" {
+		t.Errorf("Expected ListBucket to be allowed by ListOwnPrefix, got %+v", explanations[1].Statement)
+	}
+
+	isAllowed, explanations, err = pe.ExplainAll([]IAMAction{listAction(testNotAllowedPrefix), getAction})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isAllowed || len(explanations) != 1 {
+		t.Fatalf("Expected evaluation to stop at the denied action, got %t %+v", isAllowed, explanations)
+	}
+	denied := explanations[0]
+	if denied.Action != actionnames.IAMActionS3ListBucket || denied.Statement != nil || denied.Reason != reasonNoStatementAllowingAction {
+		t.Errorf("Unexpected explanation of denied action %+v", denied)
+	}
+	expectedFailure := FailedCondition{Statement: MatchedStatement{Index: 0, Sid: "ListOwnPrefix"}, Operator: "StringLike", Key: "s3:prefix"}
+	if len(denied.FailedConditions) != 1 || denied.FailedConditions[0] != expectedFailure {
+		t.Errorf("Expected failed condition %+v, got %+v", expectedFailure, denied.FailedConditions)
+	}
+
+	if _, _, err := pe.ExplainAll(nil); err == nil {
+		t.Error("ExplainAll without actions must fail")
+	}
+}
+
+func TestIamStringLikeMatchesWholeString(t *testing.T) {
+	var testCases = []struct {
+		pattern  string
+		value    string
+		expected bool
+	}{
+		{actionnames.IAMActionS3GetObject, actionnames.IAMActionS3GetObjectTagging, false},
+		{"arn:aws:s3:::bucket1", "arn:aws:s3:::bucket10", false},
+		{"arn:aws:s3:::bucket1", "arn:aws:s3:::other-bucket1", false},
+		{"arn:aws:s3:::bucket1/*", "arn:aws:s3:::bucket1/a\nb", true},
+		{"s3:Get*", actionnames.IAMActionS3GetObjectTagging, true},
+	}
+	for _, tc := range testCases {
+		if got := iamStringLike(tc.pattern, tc.value); got != tc.expected {
+			t.Errorf("iamStringLike(%q, %q): expected %t, got %t", tc.pattern, tc.value, tc.expected, got)
+		}
+	}
+}
+
+// Resources and actions of statements must match the whole resource and action of a request, not just a prefix
+func TestResourceAndActionDoNotMatchByPrefix(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Allow", "Action": "%s", "Resource": "%s"},
+			{"Effect": "Allow", "Action": "%s", "Resource": "%s/home"}
+		]
+	}`, actionnames.IAMActionS3ListBucket, testBucketARN, actionnames.IAMActionS3GetObject, testBucketARN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testCases = []struct {
+		Description string
+		Action      IAMAction
+		Expected    bool
+	}{
+		{"Bucket itself", NewIamAction(actionnames.IAMActionS3ListBucket, testBucketARN, nil), true},
+		{"Bucket with name that starts with bucket", NewIamAction(actionnames.IAMActionS3ListBucket, testBucketARN+"-other", nil), false},
+		{"Object itself", NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/home", nil), true},
+		{"Object under key", NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/home/secret", nil), false},
+		{"Object in bucket with name that starts with bucket", NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"-other/home", nil), false},
+		{"Action that starts with action", NewIamAction(actionnames.IAMActionS3GetObjectTagging, testBucketARN+"/home", nil), false},
+		{"Action that starts with action on bucket", NewIamAction(actionnames.IAMActionS3ListBucketVersions, testBucketARN, nil), false},
+	}
+	for _, tc := range testCases {
+		isAllowed, _, err := pe.Evaluate(tc.Action)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+		}
+		if isAllowed != tc.Expected {
+			t.Errorf("%s: expected allowed=%t, got %t", tc.Description, tc.Expected, isAllowed)
+		}
+	}
+}
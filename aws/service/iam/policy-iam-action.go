@@ -2,10 +2,16 @@ package iam
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/micahhausler/aws-iam-policy/policy"
 )
 
+// The number of seconds since the credentials of the session were issued. This key is specific to the proxy,
+// policies that must also work with AWS compare aws:TokenIssueTime with a Date operator instead.
+const ConditionKeyTokenAge = "fakes3pp:TokenAge"
+
 type IAMAction struct {
 	Action   string                            `json:"action"`
 	Resource string                            `json:"resource"`
@@ -35,7 +41,9 @@ func (a IAMAction) AddContext(context map[string]*policy.ConditionValue) IAMActi
 func addGenericSessionContextKeys(context map[string]*policy.ConditionValue, session *PolicySessionData) {
 	addAwsPrincipalTagConditionKeys(context, session)
 	addAwsRequestedRegionConditionKey(context, session)
+	addAwsSourceIpConditionKey(context, session)
 	addGenericTokenClaims(context, session)
+	addTimeConditionKeys(context, session, time.Now().UTC())
 }
 
 // Add aws:PrincipalTag/tag-key keys that are added to nearly all requests that contain information about the current session
@@ -60,6 +68,17 @@ func addAwsRequestedRegionConditionKey(context map[string]*policy.ConditionValue
 	}
 }
 
+// Add aws:SourceIp key with the address of the client that sent the request
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_condition-keys.html#condition-keys-sourceip
+func addAwsSourceIpConditionKey(context map[string]*policy.ConditionValue, session *PolicySessionData) {
+	if session == nil {
+		return
+	}
+	if session.SourceIp != "" {
+		context["aws:SourceIp"] = policy.NewConditionValueString(true, session.SourceIp)
+	}
+}
+
 // Add generic session claims
 func addGenericTokenClaims(context map[string]*policy.ConditionValue, session *PolicySessionData) {
 	if session == nil {
@@ -67,8 +86,27 @@ func addGenericTokenClaims(context map[string]*policy.ConditionValue, session *P
 	}
 	if session.Claims.Subject != "" {
 		context["claims:sub"] = policy.NewConditionValueString(true, session.Claims.Subject)
+		//Sessions are not tied to IAM users so the subject is the closest thing to a user name
+		context["aws:username"] = policy.NewConditionValueString(true, session.Claims.Subject)
 	}
 	if session.Claims.Issuer != "" {
 		context["claims:iss"] = policy.NewConditionValueString(true, session.Claims.Issuer)
 	}
+	if session.RoleArn != "" {
+		context[conditionKeyPrincipalArn] = policy.NewConditionValueString(true, session.RoleArn)
+	}
+}
+
+// Add the keys about the time of the request and the age of the session
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_condition-keys.html#condition-keys-currenttime
+func addTimeConditionKeys(context map[string]*policy.ConditionValue, session *PolicySessionData, now time.Time) {
+	context["aws:CurrentTime"] = policy.NewConditionValueString(true, now.Format(time.RFC3339))
+	context["aws:EpochTime"] = policy.NewConditionValueString(true, strconv.FormatInt(now.Unix(), 10))
+	if session == nil || session.TokenIssueTime.IsZero() {
+		return
+	}
+	context["aws:TokenIssueTime"] = policy.NewConditionValueString(true, session.TokenIssueTime.UTC().Format(time.RFC3339))
+	//The proxy has no multi-factor authentication so instead of aws:MultiFactorAuthAge it exposes the age of the session
+	tokenAge := max(int64(now.Sub(session.TokenIssueTime)/time.Second), 0)
+	context[ConditionKeyTokenAge] = policy.NewConditionValueString(true, strconv.FormatInt(tokenAge, 10))
 }
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/VITObelgium/fakes3pp/aws/credentials"
 	"github.com/VITObelgium/fakes3pp/aws/service/sts/session"
@@ -166,6 +167,15 @@ type PolicyManager struct {
 	templates map[string]*template.Template
 	//Mutex for local template access
 	tMux *sync.RWMutex
+	//Managed policies attached to roles, these are set at startup
+	attachments RolePolicyAttachments
+	//Managed policies that limit the permissions of roles, these are set at startup
+	boundaries         RolePermissionBoundaries
+	guardrailPolicyArn string
+	//Policies that control which web identities can assume roles, these are set at startup
+	trustPolicies RoleTrustPolicies
+	//Optional bucket policies that are evaluated alongside the policies of a session
+	bucketPolicies BucketPolicyRetriever
 }
 
 // Check if a policy manager can get a policy corresponding to an ARN
@@ -238,8 +248,12 @@ type PolicySessionClaims struct {
 // thus is available to be used in policies.
 type PolicySessionData struct {
 	Claims          PolicySessionClaims
+	RoleArn         string
 	Tags            session.AWSSessionTags
 	RequestedRegion string
+	TokenIssueTime  time.Time
+	//The IP address of the client
+	SourceIp string
 }
 
 func GetPolicySessionDataFromClaims(claims *credentials.SessionClaims) *PolicySessionData {
@@ -247,12 +261,18 @@ func GetPolicySessionDataFromClaims(claims *credentials.SessionClaims) *PolicySe
 	if issuer == "" {
 		issuer = claims.Issuer
 	}
+	var tokenIssueTime time.Time
+	if claims.IssuedAt != nil {
+		tokenIssueTime = claims.IssuedAt.Time
+	}
 	return &PolicySessionData{
 		Claims: PolicySessionClaims{
 			Subject: claims.Subject,
 			Issuer:  issuer,
 		},
-		Tags: claims.Tags,
+		RoleArn:        claims.RoleARN,
+		Tags:           claims.Tags,
+		TokenIssueTime: tokenIssueTime,
 	}
 }
 
@@ -282,9 +302,12 @@ func (m *PolicyManager) deletePolicyCacheEntry(arn string) {
 
 func NewPolicyManager(r PolicyRetriever) *PolicyManager {
 	pm := &PolicyManager{
-		retriever: r,
-		templates: map[string]*template.Template{},
-		tMux:      &sync.RWMutex{},
+		retriever:     r,
+		templates:     map[string]*template.Template{},
+		tMux:          &sync.RWMutex{},
+		attachments:   RolePolicyAttachments{},
+		boundaries:    RolePermissionBoundaries{},
+		trustPolicies: RoleTrustPolicies{},
 	}
 	r.registerPolicyManager(pm)
 	return pm
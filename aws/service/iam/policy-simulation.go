@@ -0,0 +1,142 @@
+package iam
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/credentials"
+	"github.com/VITObelgium/fakes3pp/aws/service/sts/session"
+	"github.com/micahhausler/aws-iam-policy/policy"
+)
+
+// A request of a session of a role to simulate, e.g. to find out why a user got Access Denied
+type SimulationRequest struct {
+	RoleArn         string                     `json:"role_arn"`
+	Issuer          string                     `json:"issuer,omitempty"`
+	Subject         string                     `json:"subject,omitempty"`
+	Tags            session.AWSSessionTags     `json:"tags"`
+	SessionPolicies session.AWSSessionPolicies `json:"session_policies"`
+	Action          string                     `json:"action"`
+	Resource        string                     `json:"resource"`
+	//Context keys that are specific for the action like s3:prefix. Keys with multiple values are multi-valued.
+	Context         map[string][]string `json:"context,omitempty"`
+	SourceIp        string              `json:"source_ip,omitempty"`
+	RequestedRegion string              `json:"requested_region,omitempty"`
+}
+
+// The decisions of the IAM policy simulator of AWS
+const (
+	DecisionAllowed      = "allowed"
+	DecisionExplicitDeny = "explicitDeny"
+	DecisionImplicitDeny = "implicitDeny"
+)
+
+type SimulationResult struct {
+	Decision string     `json:"decision"`
+	Reason   evalReason `json:"reason"`
+	//The statement that denied the action or the first statement that allowed it
+	Statement *MatchedStatement `json:"statement,omitempty"`
+	//For denied actions the Allow statements that would have applied if their conditions were met
+	FailedConditions []FailedCondition `json:"failed_conditions,omitempty"`
+}
+
+// Simulate a request with the same policies as the proxy would use for it: the role policies rendered for the
+// session, the bucket policies and the policies that limit them.
+func (m *PolicyManager) Simulate(req SimulationRequest) (*SimulationResult, error) {
+	if req.RoleArn == "" || req.Action == "" || req.Resource == "" {
+		return nil, errors.New("role_arn, action and resource are mandatory")
+	}
+	if !m.DoesRoleExist(req.RoleArn) {
+		return nil, fmt.Errorf("role %s does not exist", req.RoleArn)
+	}
+	claims := &credentials.SessionClaims{
+		RoleARN:         req.RoleArn,
+		IIssuer:         req.Issuer,
+		IDPClaims:       *credentials.NewIDPClaims("", req.Subject, 0, req.Tags),
+		SessionPolicies: req.SessionPolicies,
+	}
+	data := GetPolicySessionDataFromClaims(claims)
+	data.SourceIp = req.SourceIp
+	data.RequestedRegion = req.RequestedRegion
+
+	pe, err := m.GetSessionPolicyEvaluator(claims, data)
+	if err != nil {
+		return nil, err
+	}
+	context := map[string]*policy.ConditionValue{}
+	for key, values := range req.Context {
+		context[key] = policy.NewConditionValueString(len(values) == 1, values...)
+	}
+	evaluation, err := pe.Explain(NewIamAction(req.Action, req.Resource, data).AddContext(context))
+	if err != nil {
+		return nil, err
+	}
+	result := &SimulationResult{
+		Decision:         DecisionImplicitDeny,
+		Reason:           evaluation.Reason,
+		Statement:        evaluation.Statement,
+		FailedConditions: evaluation.FailedConditions,
+	}
+	if evaluation.Allowed {
+		result.Decision = DecisionAllowed
+	} else if evaluation.Reason == reasonExplicitDeny {
+		result.Decision = DecisionExplicitDeny
+	}
+	return result, nil
+}
+
+// The maximum size of the body of a request to the policy simulator handler
+const maxSimulationRequestBytes = 1 << 20
+
+// An HTTP handler that simulates the SimulationRequest in the body of POST requests. It reveals the policies so it
+// only serves requests with the admin token as bearer token.
+func NewPolicySimulatorHandler(m *PolicyManager, adminToken string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearerToken(r, adminToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "a valid admin token is required", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		var req SimulationRequest
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSimulationRequestBytes)).Decode(&req)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("simulation request exceeds %d bytes", maxSimulationRequestBytes), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("invalid simulation request: %s", err), http.StatusBadRequest)
+			return
+		}
+		result, err := m.Simulate(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(result)
+		if err != nil {
+			slog.Error("Could not write simulation result", "error", err)
+		}
+	})
+}
+
+// Whether the request is authorized with the given bearer token, an empty token never matches.
+func hasBearerToken(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	providedToken, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(providedToken), []byte(token)) == 1
+}
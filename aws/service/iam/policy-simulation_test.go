@@ -0,0 +1,174 @@
+package iam
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/aws/service/sts/session"
+)
+
+const testRoleSimulation = "arn:aws:iam::000000000000:role/Simulation"
+
+func newTestPolicyManagerForSimulation() *PolicyManager {
+	return NewTestPolicyManager(map[string]string{
+		testRoleSimulation: fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [
+				{"Sid": "ReadTeamFolder", "Effect": "Allow", "Action": "%s", "Resource": "%s/{{ index .Tags.PrincipalTags "team" 0 }}/*"},
+				{"Sid": "ListTeamFolder", "Effect": "Allow", "Action": "%s", "Resource": "%s", "Condition": {"StringLike": {"s3:prefix": "{{ index .Tags.PrincipalTags "team" 0 }}/*"}}},
+				{"Sid": "NoSecrets", "Effect": "Deny", "Action": "s3:*", "Resource": "%s/*/secrets/*"}
+			]
+		}`, actionnames.IAMActionS3GetObject, testBucketARN, actionnames.IAMActionS3ListBucket, testBucketARN, testBucketARN),
+	})
+}
+
+func TestSimulate(t *testing.T) {
+	pm := newTestPolicyManagerForSimulation()
+	climateTags := session.AWSSessionTags{PrincipalTags: map[string][]string{"team": {"climate"}}}
+
+	var testCases = []struct {
+		Description      string
+		Request          SimulationRequest
+		ExpectedDecision string
+		ExpectedSid      string
+	}{
+		{
+			"Allowed by statement",
+			SimulationRequest{RoleArn: testRoleSimulation, Tags: climateTags, Action: actionnames.IAMActionS3GetObject, Resource: testBucketARN + "/climate/data.csv"},
+			DecisionAllowed,
+			"ReadTeamFolder",
+		},
+		{
+			"Explicitly denied",
+			SimulationRequest{RoleArn: testRoleSimulation, Tags: climateTags, Action: actionnames.IAMActionS3GetObject, Resource: testBucketARN + "/climate/secrets/key"},
+			DecisionExplicitDeny,
+			"NoSecrets",
+		},
+		{
+			"Other team",
+			SimulationRequest{RoleArn: testRoleSimulation, Tags: climateTags, Action: actionnames.IAMActionS3GetObject, Resource: testBucketARN + "/water/data.csv"},
+			DecisionImplicitDeny,
+			"",
+		},
+		{
+			"Condition with context key",
+			SimulationRequest{RoleArn: testRoleSimulation, Tags: climateTags, Action: actionnames.IAMActionS3ListBucket, Resource: testBucketARN, Context: map[string][]string{"s3:prefix": {"climate/2024/"}}},
+			DecisionAllowed,
+			"ListTeamFolder",
+		},
+		{
+			"Limited by session policy",
+			SimulationRequest{
+				RoleArn:         testRoleSimulation,
+				Tags:            climateTags,
+				SessionPolicies: session.AWSSessionPolicies{Policy: testPolicyForAction("Allow", actionnames.IAMActionS3ListBucket, "*")},
+				Action:          actionnames.IAMActionS3GetObject,
+				Resource:        testBucketARN + "/climate/data.csv",
+			},
+			DecisionImplicitDeny,
+			"",
+		},
+	}
+	for _, tc := range testCases {
+		result, err := pm.Simulate(tc.Request)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+			continue
+		}
+		if result.Decision != tc.ExpectedDecision {
+			t.Errorf("%s: expected decision %s, got %s (%s)", tc.Description, tc.ExpectedDecision, result.Decision, result.Reason)
+		}
+		sid := ""
+		if result.Statement != nil {
+			sid = result.Statement.Sid
+		}
+		if sid != tc.ExpectedSid {
+			t.Errorf("%s: expected statement %q, got %q", tc.Description, tc.ExpectedSid, sid)
+		}
+	}
+}
+
+func TestSimulateUnknownRole(t *testing.T) {
+	pm := newTestPolicyManagerForSimulation()
+	_, err := pm.Simulate(SimulationRequest{RoleArn: "arn:aws:iam::000000000000:role/Unknown", Action: actionnames.IAMActionS3GetObject, Resource: testBucketARN + "/key"})
+	if err == nil {
+		t.Error("Simulating an unknown role must fail")
+	}
+}
+
+const testSimulatorToken = "simulator-admin-token"
+
+func newTestSimulatorRequest(method, token string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, "/simulate", body)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestPolicySimulatorHandler(t *testing.T) {
+	handler := NewPolicySimulatorHandler(newTestPolicyManagerForSimulation(), testSimulatorToken)
+	body := fmt.Sprintf(`{
+		"role_arn": "%s",
+		"tags": {"principal_tags": {"team": ["climate"]}},
+		"action": "%s",
+		"resource": "%s/climate/data.csv"
+	}`, testRoleSimulation, actionnames.IAMActionS3GetObject, testBucketARN)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newTestSimulatorRequest(http.MethodPost, testSimulatorToken, strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var result SimulationResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Decision != DecisionAllowed || result.Statement == nil || result.Statement.Sid != "ReadTeamFolder" {
+		t.Errorf("Unexpected simulation result %+v", result)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, newTestSimulatorRequest(http.MethodGet, testSimulatorToken, nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", rr.Code)
+	}
+}
+
+func TestPolicySimulatorHandlerRequiresAdminToken(t *testing.T) {
+	body := fmt.Sprintf(`{"role_arn": "%s", "action": "%s", "resource": "%s/key"}`, testRoleSimulation, actionnames.IAMActionS3GetObject, testBucketARN)
+	var testCases = []struct {
+		Description     string
+		ConfiguredToken string
+		ProvidedToken   string
+	}{
+		{"No token", testSimulatorToken, ""},
+		{"Wrong token", testSimulatorToken, "not-the-admin-token"},
+		{"No token configured", "", ""},
+	}
+	for _, tc := range testCases {
+		handler := NewPolicySimulatorHandler(newTestPolicyManagerForSimulation(), tc.ConfiguredToken)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, newTestSimulatorRequest(http.MethodPost, tc.ProvidedToken, strings.NewReader(body)))
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected status 401, got %d: %s", tc.Description, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestPolicySimulatorHandlerLimitsRequestSize(t *testing.T) {
+	handler := NewPolicySimulatorHandler(newTestPolicyManagerForSimulation(), testSimulatorToken)
+	body := fmt.Sprintf(`{"role_arn": "%s", "resource": "%s"}`, testRoleSimulation, strings.Repeat("a", maxSimulationRequestBytes))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, newTestSimulatorRequest(http.MethodPost, testSimulatorToken, strings.NewReader(body)))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,142 @@
+package iam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/micahhausler/aws-iam-policy/policy"
+	"sigs.k8s.io/yaml"
+)
+
+// The action that the trust policy of a role must allow for a web identity to assume the role
+const ActionAssumeRoleWithWebIdentity = "sts:AssumeRoleWithWebIdentity"
+
+// The trust policies of roles, keyed by role ARN
+type RoleTrustPolicies map[string]*policy.Policy
+
+// Read a YAML file that maps role ARNs to their trust policy document
+func ReadRoleTrustPolicies(filename string) (RoleTrustPolicies, error) {
+	buf, err := os.ReadFile(filename) // #nosec G304 -- platform provided files
+	if err != nil {
+		return nil, err
+	}
+	var documents map[string]json.RawMessage
+	err = yaml.Unmarshal(buf, &documents)
+	if err != nil {
+		return nil, err
+	}
+	trustPolicies := RoleTrustPolicies{}
+	for roleArn, document := range documents {
+		p, err := parseTrustPolicy(roleArn, string(document))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trust policy for role %s: %w", roleArn, err)
+		}
+		trustPolicies[roleArn] = p
+	}
+	return trustPolicies, nil
+}
+
+// Parse the trust policy of a role. Like in AWS its statements have a Principal but no Resource since they apply to
+// the role itself, so the role is filled in as Resource to evaluate it like any other policy.
+func parseTrustPolicy(roleArn, policyContent string) (*policy.Policy, error) {
+	p, err := decodePolicy(policyContent)
+	if err != nil {
+		return nil, err
+	}
+	err = validatePolicy(p, validateTrustStatementPrincipalAndResource)
+	if err != nil {
+		return nil, err
+	}
+	statements := p.Statements.Values()
+	for i := range statements {
+		statements[i].Resource = policy.NewStringOrSlice(true, roleArn)
+	}
+	p.Statements = policy.NewStatementOrSlice(statements...)
+	return p, nil
+}
+
+func validateTrustStatementPrincipalAndResource(s policy.Statement) error {
+	if s.Resource != nil || s.NotResource != nil {
+		return errors.New("Resource and NotResource are not supported in trust policies")
+	}
+	if s.NotPrincipal != nil {
+		return errors.New("NotPrincipal is not supported")
+	}
+	if s.Principal == nil {
+		return errors.New("trust policy statements must have a Principal")
+	}
+	for _, kind := range s.Principal.Kinds() {
+		switch kind {
+		case policy.PrincipalKindAll:
+			if p, _ := s.Principal.MarshalJSON(); string(p) != `"*"` {
+				return fmt.Errorf("unsupported Principal %s", p)
+			}
+		case policy.PrincipalKindFederated:
+		default:
+			return fmt.Errorf("unsupported Principal type %s, only Federated principals can assume roles", kind)
+		}
+	}
+	return nil
+}
+
+// Set the trust policies of roles. Roles without trust policy can be assumed by any web identity that is trusted by
+// one of the configured OIDC providers.
+func (m *PolicyManager) SetTrustPolicies(trustPolicies RoleTrustPolicies) error {
+	for roleArn, p := range trustPolicies {
+		if !m.DoesRoleExist(roleArn) {
+			return fmt.Errorf("trust policy for role %s which does not exist", roleArn)
+		}
+		m.trustPolicies[roleArn] = p
+	}
+	return nil
+}
+
+// Whether a web identity with the given token claims may assume a role. If not the reason tells why.
+func (m *PolicyManager) IsRoleTrusted(roleArn string, webIdentityClaims map[string]any) (isTrusted bool, reason string, err error) {
+	p, ok := m.trustPolicies[roleArn]
+	if !ok {
+		return true, "", nil
+	}
+	a := IAMAction{
+		Action:   ActionAssumeRoleWithWebIdentity,
+		Resource: roleArn,
+		Context:  getWebIdentityContext(webIdentityClaims),
+	}
+	result, err := evaluatePolicy(p, a)
+	if errors.Is(err, errNonSingularContextValue) {
+		//A claim with multiple values that the trust policy compares as single value is not trusted
+		return false, err.Error(), nil
+	}
+	return result.Allowed, string(result.Reason), err
+}
+
+// The claims of a web identity token as claims:<name> condition keys. Lists of strings like aud, amr or groups are
+// multi-valued keys unless they have a single value, claims that are objects are left out.
+func getWebIdentityContext(webIdentityClaims map[string]any) map[string]*policy.ConditionValue {
+	context := map[string]*policy.ConditionValue{}
+	for name, value := range webIdentityClaims {
+		key := "claims:" + name
+		switch v := value.(type) {
+		case string:
+			context[key] = policy.NewConditionValueString(true, v)
+		case bool:
+			context[key] = policy.NewConditionValueString(true, strconv.FormatBool(v))
+		case float64:
+			context[key] = policy.NewConditionValueString(true, strconv.FormatFloat(v, 'f', -1, 64))
+		case []any:
+			var values []string
+			for _, item := range v {
+				if s, isString := item.(string); isString {
+					values = append(values, s)
+				}
+			}
+			if len(values) == len(v) {
+				context[key] = policy.NewConditionValueString(len(values) == 1, values...)
+			}
+		}
+	}
+	return context
+}
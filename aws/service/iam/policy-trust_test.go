@@ -0,0 +1,136 @@
+package iam
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testTrustIssuer = "https://idp.example.com/realms/climate"
+
+func testTrustPolicy(principal, condition string) string {
+	return fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [{"Effect": "Allow", "Principal": %s, "Action": "%s", "Condition": %s}]
+	}`, principal, ActionAssumeRoleWithWebIdentity, condition)
+}
+
+func TestParseTrustPolicy(t *testing.T) {
+	var testCases = []struct {
+		Description   string
+		Policy        string
+		ExpectedValid bool
+	}{
+		{"Federated principal", testTrustPolicy(fmt.Sprintf(`{"Federated": "%s"}`, testTrustIssuer), "{}"), true},
+		{"Everyone", testTrustPolicy(`"*"`, `{"StringEquals": {"claims:aud": "fakes3pp"}}`), true},
+		{"AWS principal", testTrustPolicy(`{"AWS": "arn:aws:iam::000000000000:role/Other"}`, "{}"), false},
+		{"Without Principal", fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [{"Effect": "Allow", "Action": "%s"}]
+		}`, ActionAssumeRoleWithWebIdentity), false},
+		{"With Resource", fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [{"Effect": "Allow", "Principal": "*", "Action": "%s", "Resource": "*"}]
+		}`, ActionAssumeRoleWithWebIdentity), false},
+		{"Unsupported condition operator", testTrustPolicy(`"*"`, `{"StringMaybe": {"claims:aud": "fakes3pp"}}`), false},
+	}
+	for _, tc := range testCases {
+		_, err := parseTrustPolicy(testRoleWithPolicy, tc.Policy)
+		if (err == nil) != tc.ExpectedValid {
+			t.Errorf("%s: expected valid=%t, got error %v", tc.Description, tc.ExpectedValid, err)
+		}
+	}
+}
+
+func TestIsRoleTrusted(t *testing.T) {
+	pm := newTestPolicyManagerWithAttachments(t)
+	trustPolicy, err := parseTrustPolicy(testRoleWithPolicy, fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [
+			{
+				"Effect": "Allow",
+				"Principal": {"Federated": "%s"},
+				"Action": "%s",
+				"Condition": {
+					"StringEquals": {"claims:aud": "fakes3pp"},
+					"ForAnyValue:StringEquals": {"claims:groups": ["climate", "water"], "claims:amr": "mfa"}
+				}
+			},
+			{"Effect": "Deny", "Principal": "*", "Action": "%s", "Condition": {"StringEquals": {"claims:sub": "mallory"}}}
+		]
+	}`, testTrustIssuer, ActionAssumeRoleWithWebIdentity, ActionAssumeRoleWithWebIdentity))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pm.SetTrustPolicies(RoleTrustPolicies{testRoleWithPolicy: trustPolicy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.SetTrustPolicies(RoleTrustPolicies{"arn:aws:iam::000000000000:role/Unknown": trustPolicy}); err == nil {
+		t.Error("Trust policy for a role that does not exist must be refused")
+	}
+
+	trustedClaims := func(overrides map[string]any) map[string]any {
+		claims := map[string]any{
+			"iss":    testTrustIssuer,
+			"sub":    "alice",
+			"aud":    "fakes3pp",
+			"amr":    []any{"pwd", "mfa"},
+			"groups": []any{"climate"},
+			"exp":    float64(1700000000),
+		}
+		for name, value := range overrides {
+			claims[name] = value
+		}
+		return claims
+	}
+	var testCases = []struct {
+		Description string
+		RoleArn     string
+		Claims      map[string]any
+		Expected    bool
+	}{
+		{"Matching claims", testRoleWithPolicy, trustedClaims(nil), true},
+		{"Other issuer", testRoleWithPolicy, trustedClaims(map[string]any{"iss": "https://other.example.com"}), false},
+		{"Other audience", testRoleWithPolicy, trustedClaims(map[string]any{"aud": "other"}), false},
+		{"Audience as list with one value", testRoleWithPolicy, trustedClaims(map[string]any{"aud": []any{"fakes3pp"}}), true},
+		{"Audience as list with multiple values", testRoleWithPolicy, trustedClaims(map[string]any{"aud": []any{"fakes3pp", "other"}}), false},
+		{"Without second factor", testRoleWithPolicy, trustedClaims(map[string]any{"amr": []any{"pwd"}}), false},
+		{"Not in group", testRoleWithPolicy, trustedClaims(map[string]any{"groups": []any{"health"}}), false},
+		{"Without groups", testRoleWithPolicy, trustedClaims(map[string]any{"groups": nil}), false},
+		{"Explicitly denied subject", testRoleWithPolicy, trustedClaims(map[string]any{"sub": "mallory"}), false},
+		{"Role without trust policy", testRoleOnlyAttachments, map[string]any{"iss": "https://other.example.com"}, true},
+	}
+	for _, tc := range testCases {
+		isTrusted, reason, err := pm.IsRoleTrusted(tc.RoleArn, tc.Claims)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+		}
+		if isTrusted != tc.Expected {
+			t.Errorf("%s: expected trusted=%t, got %t (%s)", tc.Description, tc.Expected, isTrusted, reason)
+		}
+	}
+}
+
+func TestReadRoleTrustPolicies(t *testing.T) {
+	trustPoliciesFile := filepath.Join(t.TempDir(), "trust-policies.yaml")
+	err := os.WriteFile(trustPoliciesFile, []byte(fmt.Sprintf(`%s:
+  Version: "2012-10-17"
+  Statement:
+    - Effect: Allow
+      Principal:
+        Federated: %s
+      Action: %s
+`, testRoleWithPolicy, testTrustIssuer, ActionAssumeRoleWithWebIdentity)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustPolicies, err := ReadRoleTrustPolicies(trustPoliciesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := trustPolicies[testRoleWithPolicy]; !ok || len(trustPolicies) != 1 {
+		t.Errorf("Expected a trust policy for %s, got %v", testRoleWithPolicy, trustPolicies)
+	}
+}
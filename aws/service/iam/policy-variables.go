@@ -0,0 +1,224 @@
+package iam
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/micahhausler/aws-iam-policy/policy"
+)
+
+// Policy variables are only replaced in policies of this version, older versions use ${...} literally
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_policies_variables.html
+const policyVersionWithVariables = "2012-10-17"
+
+const policyVariableStart = "${"
+const policyVariableEnd = "}"
+
+// Special variables that stand for characters that otherwise have a special meaning
+var policyVariableCharacters = map[string]string{
+	"*": "*",
+	"?": "?",
+	"$": "$",
+}
+
+// A part of a policy value. Text of the policy itself can have the wildcards * and ? while text that comes from
+// a variable, including ${*} and ${?}, always matches literally.
+type policyValuePart struct {
+	text    string
+	literal bool
+}
+
+// A value of a policy element with its variables resolved. A value with a variable that has no value in the
+// request context is unresolved and matches nothing.
+type policyValue struct {
+	parts      []policyValuePart
+	unresolved bool
+}
+
+// A policy value without variables
+func newPolicyPattern(text string) policyValue {
+	var v policyValue
+	v.addPart(text, false)
+	return v
+}
+
+func (v *policyValue) addPart(text string, literal bool) {
+	if text != "" {
+		v.parts = append(v.parts, policyValuePart{text: text, literal: literal})
+	}
+}
+
+// The text of the value for operators without wildcards
+func (v policyValue) String() string {
+	var sb strings.Builder
+	for _, part := range v.parts {
+		sb.WriteString(part.text)
+	}
+	return sb.String()
+}
+
+// A regular expression that matches the whole string, the wildcards of the policy text also match newlines
+func (v policyValue) regexp() string {
+	var sb strings.Builder
+	sb.WriteString("(?s)^")
+	for _, part := range v.parts {
+		quoted := regexp.QuoteMeta(part.text)
+		if !part.literal {
+			quoted = strings.NewReplacer("\\*", ".*", "\\?", ".").Replace(quoted)
+		}
+		sb.WriteString(quoted)
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// Whether a string matches the value where * and ? of the policy text are wildcards
+func (v policyValue) like(s string) bool {
+	if v.unresolved {
+		return false
+	}
+	pattern := v.regexp()
+	match, err := regexp.MatchString(pattern, s)
+	if err != nil {
+		slog.Error("Error for checking match", "pattern", pattern, "literal", s, "error", err)
+	}
+	return match
+}
+
+// Split the value around sep like strings.SplitN
+func (v policyValue) splitN(sep string, n int) []policyValue {
+	result := []policyValue{{unresolved: v.unresolved}}
+	for _, part := range v.parts {
+		text := part.text
+		for len(result) < n {
+			before, after, found := strings.Cut(text, sep)
+			if !found {
+				break
+			}
+			result[len(result)-1].addPart(before, part.literal)
+			result = append(result, policyValue{unresolved: v.unresolved})
+			text = after
+		}
+		result[len(result)-1].addPart(text, part.literal)
+	}
+	return result
+}
+
+// A character of a pattern, wildcard is set for * and ? that are wildcards
+type patternChar struct {
+	c        byte
+	wildcard bool
+}
+
+func (v policyValue) patternChars() []patternChar {
+	var chars []patternChar
+	for _, part := range v.parts {
+		for i := 0; i < len(part.text); i++ {
+			c := part.text[i]
+			chars = append(chars, patternChar{c: c, wildcard: !part.literal && (c == '*' || c == '?')})
+		}
+	}
+	return chars
+}
+
+// A variable like ${aws:PrincipalTag/team} or ${aws:PrincipalTag/team, 'default'}
+type policyVariable struct {
+	key          string
+	defaultValue string
+	hasDefault   bool
+}
+
+func parsePolicyVariable(s string) (policyVariable, error) {
+	key, defaultValue, hasDefault := strings.Cut(s, ",")
+	v := policyVariable{key: strings.TrimSpace(key), hasDefault: hasDefault}
+	if v.key == "" {
+		return v, fmt.Errorf("empty policy variable ${%s}", s)
+	}
+	if hasDefault {
+		defaultValue = strings.TrimSpace(defaultValue)
+		if len(defaultValue) < 2 || !strings.HasPrefix(defaultValue, "'") || !strings.HasSuffix(defaultValue, "'") {
+			return v, fmt.Errorf("default value of policy variable ${%s} must be quoted with '", s)
+		}
+		v.defaultValue = defaultValue[1 : len(defaultValue)-1]
+	}
+	return v, nil
+}
+
+// Get the value of a variable from the request context. Multi-valued keys cannot be used as variable.
+func (v policyVariable) resolve(context map[string]*policy.ConditionValue) (string, bool) {
+	if c, ok := policyVariableCharacters[v.key]; ok {
+		return c, true
+	}
+	if contextValue, ok := context[v.key]; ok && contextValue != nil {
+		values := conditionValueStrings(contextValue)
+		if len(values) == 1 {
+			return values[0], true
+		}
+	}
+	return v.defaultValue, v.hasDefault
+}
+
+// Replace the policy variables in a Resource or condition value by their value in the request context. The values
+// of variables match literally so a principal tag of * does not match any resource. If a variable has no value
+// the result is unresolved.
+func resolvePolicyVariables(value string, context map[string]*policy.ConditionValue) policyValue {
+	var resolved policyValue
+	rest := value
+	for {
+		before, after, found := strings.Cut(rest, policyVariableStart)
+		resolved.addPart(before, false)
+		if !found {
+			return resolved
+		}
+		variableStr, remainder, found := strings.Cut(after, policyVariableEnd)
+		if !found {
+			//Policies are validated when they are loaded so this does not happen
+			return policyValue{unresolved: true}
+		}
+		variable, err := parsePolicyVariable(variableStr)
+		if err != nil {
+			return policyValue{unresolved: true}
+		}
+		variableValue, ok := variable.resolve(context)
+		if !ok {
+			return policyValue{unresolved: true}
+		}
+		resolved.addPart(variableValue, true)
+		rest = remainder
+	}
+}
+
+// Check that all policy variables in a value are well-formed
+func validatePolicyVariables(value string) error {
+	rest := value
+	for {
+		_, after, found := strings.Cut(rest, policyVariableStart)
+		if !found {
+			return nil
+		}
+		variableStr, remainder, found := strings.Cut(after, policyVariableEnd)
+		if !found {
+			return fmt.Errorf("unterminated policy variable in %q", value)
+		}
+		if _, err := parsePolicyVariable(variableStr); err != nil {
+			return err
+		}
+		rest = remainder
+	}
+}
+
+// Resolve the variables of the values of a policy element. Values that cannot be resolved are kept as unresolved
+// values such that they match nothing rather than being left out.
+func resolvePolicyValues(values []string, variablesEnabled bool, context map[string]*policy.ConditionValue) []policyValue {
+	resolvedValues := make([]policyValue, 0, len(values))
+	for _, value := range values {
+		if !variablesEnabled || !strings.Contains(value, policyVariableStart) {
+			resolvedValues = append(resolvedValues, newPolicyPattern(value))
+			continue
+		}
+		resolvedValues = append(resolvedValues, resolvePolicyVariables(value, context))
+	}
+	return resolvedValues
+}
@@ -0,0 +1,305 @@
+package iam
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/micahhausler/aws-iam-policy/policy"
+)
+
+func TestResolvePolicyVariables(t *testing.T) {
+	context := map[string]*policy.ConditionValue{
+		"aws:PrincipalTag/team":   policy.NewConditionValueString(true, "climate"),
+		"aws:PrincipalTag/groups": policy.NewConditionValueString(false, "a", "b"),
+		"aws:PrincipalTag/star":   policy.NewConditionValueString(true, "*"),
+		"claims:sub":              policy.NewConditionValueString(true, "alice"),
+	}
+	var testCases = []struct {
+		Description      string
+		Value            string
+		ExpectedResolved bool
+		ExpectedResult   string
+	}{
+		{"No variables", "home/team/*", true, "home/team/*"},
+		{"Single variable", "home/${aws:PrincipalTag/team}/*", true, "home/climate/*"},
+		{"Multiple variables", "${aws:PrincipalTag/team}/${claims:sub}", true, "climate/alice"},
+		{"Missing key", "home/${aws:PrincipalTag/unit}/*", false, ""},
+		{"Missing key with default", "home/${aws:PrincipalTag/unit, 'shared'}/*", true, "home/shared/*"},
+		{"Present key ignores default", "home/${aws:PrincipalTag/team, 'shared'}/*", true, "home/climate/*"},
+		{"Multi-valued key", "home/${aws:PrincipalTag/groups}/*", false, ""},
+		{"Dollar sign", "literal${$}", true, "literal$"},
+		{"Wildcard from context", "home/${aws:PrincipalTag/star}", true, "home/*"},
+	}
+	for _, tc := range testCases {
+		result := resolvePolicyVariables(tc.Value, context)
+		if result.unresolved == tc.ExpectedResolved {
+			t.Errorf("%s: expected resolved=%t, got %t", tc.Description, tc.ExpectedResolved, !result.unresolved)
+			continue
+		}
+		if result.String() != tc.ExpectedResult {
+			t.Errorf("%s: expected %q, got %q", tc.Description, tc.ExpectedResult, result.String())
+		}
+	}
+}
+
+func TestResolvedPolicyValuesMatchVariablesLiterally(t *testing.T) {
+	context := map[string]*policy.ConditionValue{
+		"aws:PrincipalTag/star":     policy.NewConditionValueString(true, "*"),
+		"aws:PrincipalTag/sentinel": policy.NewConditionValueString(true, "\uE02A"),
+	}
+	var testCases = []struct {
+		Description string
+		Value       string
+		Literal     string
+		Expected    bool
+	}{
+		{"Wildcard of the policy", "home/*", "home/climate", true},
+		{"Wildcard from context matches itself", "home/${aws:PrincipalTag/star}/*", "home/*/data.csv", true},
+		{"Wildcard from context matches nothing else", "home/${aws:PrincipalTag/star}/*", "home/climate/data.csv", false},
+		{"Literal asterisk", "home/${*}", "home/*", true},
+		{"Literal asterisk matches nothing else", "home/${*}", "home/climate", false},
+		{"Literal question mark", "what${?}", "what?", true},
+		{"Literal question mark matches nothing else", "what${?}", "whatx", false},
+		{"Private use characters are not special", "home/${aws:PrincipalTag/sentinel}", "home/*", false},
+		{"Private use characters match themselves", "home/${aws:PrincipalTag/sentinel}", "home/\uE02A", true},
+		{"Unresolved value matches nothing", "${aws:PrincipalTag/unit}*", "anything", false},
+	}
+	for _, tc := range testCases {
+		if got := resolvePolicyVariables(tc.Value, context).like(tc.Literal); got != tc.Expected {
+			t.Errorf("%s: expected match=%t, got %t", tc.Description, tc.Expected, got)
+		}
+	}
+}
+
+func TestValidatePolicyVariables(t *testing.T) {
+	var testCases = []struct {
+		Value         string
+		ExpectedValid bool
+	}{
+		{"home/${aws:PrincipalTag/team}/*", true},
+		{"home/${aws:PrincipalTag/team, 'shared'}/*", true},
+		{"${*}${$}", true},
+		{"home/${aws:PrincipalTag/team/*", false},
+		{"home/${}/*", false},
+		{"home/${aws:PrincipalTag/team, shared}/*", false},
+	}
+	for _, tc := range testCases {
+		err := validatePolicyVariables(tc.Value)
+		if (err == nil) != tc.ExpectedValid {
+			t.Errorf("%q: expected valid=%t, got error %v", tc.Value, tc.ExpectedValid, err)
+		}
+	}
+}
+
+var testPolicyHomeFolders = fmt.Sprintf(`{
+	"Version": "%%s",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/home/${aws:PrincipalTag/team}/*"
+		},
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s",
+			"Condition": {"StringLike": {"s3:prefix": "home/${claims:sub}/*"}}
+		}
+	]
+}`, actionnames.IAMActionS3GetObject, testBucketARN, actionnames.IAMActionS3ListBucket, testBucketARN)
+
+func TestPolicyVariablesInResourceAndCondition(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(testPolicyHomeFolders, policyVersionWithVariables))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &PolicySessionData{Claims: PolicySessionClaims{Subject: "alice"}}
+	session.Tags.PrincipalTags = map[string][]string{"team": {"climate"}}
+
+	var testCases = []struct {
+		Description string
+		Action      IAMAction
+		Expected    bool
+	}{
+		{
+			"Get in folder of own team",
+			NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/home/climate/data.csv", session),
+			true,
+		},
+		{
+			"Get in folder of other team",
+			NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/home/water/data.csv", session),
+			false,
+		},
+		{
+			"Get without team tag",
+			NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/home/climate/data.csv", &PolicySessionData{}),
+			false,
+		},
+		{
+			"List own home folder",
+			NewIamAction(actionnames.IAMActionS3ListBucket, testBucketARN, session).AddContext(
+				map[string]*policy.ConditionValue{"s3:prefix": policy.NewConditionValueString(true, "home/alice/")},
+			),
+			true,
+		},
+		{
+			"List home folder of other user",
+			NewIamAction(actionnames.IAMActionS3ListBucket, testBucketARN, session).AddContext(
+				map[string]*policy.ConditionValue{"s3:prefix": policy.NewConditionValueString(true, "home/bob/")},
+			),
+			false,
+		},
+	}
+	for _, tc := range testCases {
+		isAllowed, _, err := pe.Evaluate(tc.Action)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+		}
+		if isAllowed != tc.Expected {
+			t.Errorf("%s: expected allowed=%t, got %t", tc.Description, tc.Expected, isAllowed)
+		}
+	}
+}
+
+func TestSpecialCharacterVariablesMatchLiterally(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Allow", "Action": "%s", "Resource": "%s/${*}"},
+			{"Effect": "Allow", "Action": "%s", "Resource": "%s/what${?}"},
+			{"Effect": "Allow", "Action": "%s", "Resource": "%s", "Condition": {"StringLike": {"s3:prefix": "${*}"}}}
+		]
+	}`, actionnames.IAMActionS3GetObject, testBucketARN, actionnames.IAMActionS3GetObject, testBucketARN,
+		actionnames.IAMActionS3ListBucket, testBucketARN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listWithPrefix := func(prefix string) IAMAction {
+		return NewIamAction(actionnames.IAMActionS3ListBucket, testBucketARN, nil).AddContext(
+			map[string]*policy.ConditionValue{"s3:prefix": policy.NewConditionValueString(true, prefix)},
+		)
+	}
+
+	var testCases = []struct {
+		Description string
+		Action      IAMAction
+		Expected    bool
+	}{
+		{"Key that is a literal *", NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/*", nil), true},
+		{"Other key", NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/secret", nil), false},
+		{"Key with a literal ?", NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/what?", nil), true},
+		{"Key with another character", NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/whatx", nil), false},
+		{"Prefix that is a literal *", listWithPrefix("*"), true},
+		{"Other prefix", listWithPrefix("secret/"), false},
+	}
+	for _, tc := range testCases {
+		isAllowed, _, err := pe.Evaluate(tc.Action)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+		}
+		if isAllowed != tc.Expected {
+			t.Errorf("%s: expected allowed=%t, got %t", tc.Description, tc.Expected, isAllowed)
+		}
+	}
+}
+
+func TestPolicyVariablesAreLiteralInOldPolicyVersion(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(testPolicyHomeFolders, "2008-10-17"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &PolicySessionData{}
+	session.Tags.PrincipalTags = map[string][]string{"team": {"climate"}}
+
+	isAllowed, _, err := pe.Evaluate(NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/home/climate/data.csv", session))
+	if err != nil || isAllowed {
+		t.Errorf("Variable must not be replaced in old policy version, got allowed=%t, err=%v", isAllowed, err)
+	}
+	isAllowed, _, err = pe.Evaluate(NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/home/${aws:PrincipalTag/team}/data.csv", session))
+	if err != nil || !isAllowed {
+		t.Errorf("Variable must match literally in old policy version, got allowed=%t, err=%v", isAllowed, err)
+	}
+}
+
+func TestPolicyWithMalformedVariableIsRefused(t *testing.T) {
+	_, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/home/${aws:PrincipalTag/team/*"
+		}]
+	}`, actionnames.IAMActionS3GetObject, testBucketARN))
+	if err == nil {
+		t.Error("Policy with unterminated variable must be refused")
+	}
+}
+
+func TestCouldAllowAnyWithPolicyVariables(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(testPolicyHomeFolders, policyVersionWithVariables))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &PolicySessionData{}
+	session.Tags.PrincipalTags = map[string][]string{"team": {"climate"}}
+	getObject := []string{actionnames.IAMActionS3GetObject}
+
+	if !pe.CouldAllowAny(getObject, testBucketARN+"/*", session) {
+		t.Error("Team folder must be reachable in bucket")
+	}
+	if pe.CouldAllowAny(getObject, "arn:aws:s3:::otherbucket/*", session) {
+		t.Error("Other bucket must not be reachable")
+	}
+	if pe.CouldAllowAny(getObject, testBucketARN+"/*", &PolicySessionData{}) {
+		t.Error("Without team tag nothing must be reachable")
+	}
+}
+
+// A variable without value must never widen what a statement allows
+func TestUnresolvedPolicyVariablesDoNotAllow(t *testing.T) {
+	pe, err := NewPolicyEvaluatorFromStr(fmt.Sprintf(`{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Sid": "AllButOtherTeams", "Effect": "Allow", "Action": "%s", "NotResource": "%s/teams/${aws:PrincipalTag/team}/*"},
+			{"Sid": "NotOwnPrefix", "Effect": "Allow", "Action": "%s", "Resource": "%s", "Condition": {"StringNotEquals": {"s3:prefix": "${aws:PrincipalTag/team}"}}},
+			{"Sid": "NotLikeOwnPrefix", "Effect": "Allow", "Action": "%s", "Resource": "%s", "Condition": {"StringNotLike": {"s3:prefix": "${aws:PrincipalTag/team}/*"}}},
+			{"Sid": "NotOwnArn", "Effect": "Allow", "Action": "%s", "Resource": "%s/*", "Condition": {"ArnNotLike": {"aws:PrincipalArn": "arn:aws:iam::000000000000:role/${aws:PrincipalTag/team}"}}}
+		]
+	}`, actionnames.IAMActionS3GetObject, testBucketARN,
+		actionnames.IAMActionS3ListBucket, testBucketARN,
+		actionnames.IAMActionS3ListBucketVersions, testBucketARN,
+		actionnames.IAMActionS3PutObject, testBucketARN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := &PolicySessionData{RoleArn: "arn:aws:iam::000000000000:role/reader"}
+	listWithPrefix := func(action string) IAMAction {
+		return NewIamAction(action, testBucketARN, session).AddContext(
+			map[string]*policy.ConditionValue{"s3:prefix": policy.NewConditionValueString(true, "climate/")},
+		)
+	}
+
+	var testCases = []struct {
+		Description string
+		Action      IAMAction
+	}{
+		{"NotResource", NewIamAction(actionnames.IAMActionS3GetObject, testBucketARN+"/data.csv", session)},
+		{"StringNotEquals", listWithPrefix(actionnames.IAMActionS3ListBucket)},
+		{"StringNotLike", listWithPrefix(actionnames.IAMActionS3ListBucketVersions)},
+		{"ArnNotLike", NewIamAction(actionnames.IAMActionS3PutObject, testBucketARN+"/data.csv", session)},
+	}
+	for _, tc := range testCases {
+		isAllowed, _, err := pe.Evaluate(tc.Action)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+		}
+		if isAllowed {
+			t.Errorf("%s: a variable without value must not allow the action", tc.Description)
+		}
+	}
+	if pe.CouldAllowAny([]string{actionnames.IAMActionS3GetObject}, testBucketARN+"/*", session) {
+		t.Error("NotResource with a variable without value must not make any resource reachable")
+	}
+}
@@ -15,4 +15,19 @@ const (
 	CompleteMultipartUpload
 	AbortMultipartUpload
 	UploadPart
+	DeleteObject
+	DeleteObjects
+	CopyObject
+	UploadPartCopy
+	ListObjects
+	ListObjectVersions
+	GetBucketVersioning
+	PutBucketVersioning
+	ListMultipartUploads
+	ListParts
+	GetObjectTagging
+	PutObjectTagging
+	DeleteObjectTagging
+	GetBucketLocation
+	PostObject
 )
@@ -19,15 +19,31 @@ func _() {
 	_ = x[CompleteMultipartUpload-8]
 	_ = x[AbortMultipartUpload-9]
 	_ = x[UploadPart-10]
+	_ = x[DeleteObject-11]
+	_ = x[DeleteObjects-12]
+	_ = x[CopyObject-13]
+	_ = x[UploadPartCopy-14]
+	_ = x[ListObjects-15]
+	_ = x[ListObjectVersions-16]
+	_ = x[GetBucketVersioning-17]
+	_ = x[PutBucketVersioning-18]
+	_ = x[ListMultipartUploads-19]
+	_ = x[ListParts-20]
+	_ = x[GetObjectTagging-21]
+	_ = x[PutObjectTagging-22]
+	_ = x[DeleteObjectTagging-23]
+	_ = x[GetBucketLocation-24]
+	_ = x[PostObject-25]
 }
 
-const _S3Operation_name = "UnknownOperationListObjectsV2GetObjectListBucketsHeadBucketHeadObjectPutObjectCreateMultipartUploadCompleteMultipartUploadAbortMultipartUploadUploadPart"
+const _S3Operation_name = "UnknownOperationListObjectsV2GetObjectListBucketsHeadBucketHeadObjectPutObjectCreateMultipartUploadCompleteMultipartUploadAbortMultipartUploadUploadPartDeleteObjectDeleteObjectsCopyObjectUploadPartCopyListObjectsListObjectVersionsGetBucketVersioningPutBucketVersioningListMultipartUploadsListPartsGetObjectTaggingPutObjectTaggingDeleteObjectTaggingGetBucketLocationPostObject"
 
-var _S3Operation_index = [...]uint8{0, 16, 29, 38, 49, 59, 69, 78, 99, 122, 142, 152}
+var _S3Operation_index = [...]uint16{0, 16, 29, 38, 49, 59, 69, 78, 99, 122, 142, 152, 164, 177, 187, 201, 212, 230, 249, 268, 288, 297, 313, 329, 348, 365, 375}
 
 func (i S3Operation) String() string {
-	if i < 0 || i >= S3Operation(len(_S3Operation_index)-1) {
+	idx := int(i) - 0
+	if i < 0 || idx >= len(_S3Operation_index)-1 {
 		return "S3Operation(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _S3Operation_name[_S3Operation_index[i]:_S3Operation_index[i+1]]
+	return _S3Operation_name[_S3Operation_index[idx]:_S3Operation_index[idx+1]]
 }
@@ -0,0 +1,37 @@
+package s3
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/utils"
+)
+
+// The response header with the encoded authorization message of a denied request
+const HeaderAuthzMessage = "x-fakes3pp-authz-message"
+
+// Return why a request was denied in an encoded message that only an administrator with the private key of the
+// proxy can decode. Without key no message is returned.
+func setAuthorizationMessageHeader(ctx context.Context, w http.ResponseWriter, keyKeeper utils.PrivateKeyKeeper, roleArn string, explanations []iam.ActionExplanation) {
+	if keyKeeper == nil {
+		return
+	}
+	key, err := keyKeeper.GetPrivateKey()
+	if err != nil {
+		slog.WarnContext(ctx, "Could not get key to encode authorization message", "error", err)
+		return
+	}
+	encodedMsg, err := iam.EncodeAuthorizationMessage(key, iam.AuthorizationMessage{
+		RequestId: requestctx.GetRequestID(ctx),
+		RoleArn:   roleArn,
+		Decisions: explanations,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Could not encode authorization message", "error", err)
+		return
+	}
+	w.Header().Set(HeaderAuthzMessage, encodedMsg)
+}
@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/server"
+	"github.com/VITObelgium/fakes3pp/testutils"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Do a ListObjectsV2 request that is denied and return the authorization message header of the response
+func getAuthzMessageHeaderOfDeniedRequest(t *testing.T, s *S3Server) string {
+	cred := createTestCredentialsForPolicy(t, testPolicyNoPermissionsARN, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "eu-west-1", cred, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: &testBucketName})
+	var respErr *smithyhttp.ResponseError
+	if !errors.As(err, &respErr) {
+		t.Fatalf("Expected a denied request, got %v", err)
+	}
+	return respErr.Response.Header.Get(HeaderAuthzMessage)
+}
+
+func TestAuthzMessageHeaderOfDeniedRequest(t *testing.T) {
+	s, err := newS3Server(
+		fmt.Sprintf("%s/jwt_testing_rsa", testEtcPath),
+		testS3Port,
+		[]string{testS3Host},
+		fmt.Sprintf("%s/cert.pem", testEtcPath),
+		fmt.Sprintf("%s/key.pem", testEtcPath),
+		newTestPolicyManager(t, nil),
+		3600,
+		testStubJustProxy,
+		getDefaultTestBackendConfig(),
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		0,
+		nil,
+		true,
+	)
+	if err != nil {
+		t.Fatalf("Problem creating test S3 server: %s", err)
+	}
+	teardownSuite, srv, err := server.CreateAndStart(s, server.ServerOpts{})
+	if err != nil {
+		t.Fatalf("Could not spawn fake S3 server %s", err)
+	}
+	defer func() {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			panic(err)
+		}
+		teardownSuite.Wait()
+	}()
+
+	encodedMsg := getAuthzMessageHeaderOfDeniedRequest(t, s)
+	if encodedMsg == "" {
+		t.Fatal("Expected an authorization message for a denied request")
+	}
+	key, err := s.jwtKeyMaterial.GetPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := iam.DecodeAuthorizationMessage(key, encodedMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.RoleArn != testPolicyNoPermissionsARN || msg.RequestId == "" {
+		t.Errorf("Unexpected authorization message %+v", msg)
+	}
+	if len(msg.Decisions) != 1 || msg.Decisions[0].Action != actionnames.IAMActionS3ListBucket || msg.Decisions[0].Allowed {
+		t.Errorf("Expected a denied ListBucket decision, got %+v", msg.Decisions)
+	}
+}
+
+func TestNoAuthzMessageHeaderByDefault(t *testing.T) {
+	teardownSuite, s := setupSuiteProxyS3(t, testStubJustProxy, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	if encodedMsg := getAuthzMessageHeaderOfDeniedRequest(t, s); encodedMsg != "" {
+		t.Errorf("Expected no authorization message, got %s", encodedMsg)
+	}
+}
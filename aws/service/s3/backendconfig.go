@@ -3,6 +3,7 @@ package s3
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path"
 	"slices"
@@ -110,6 +111,8 @@ func (entry backendConfigFileEntry) getCredentials(relativepath string) (creds a
 type backendsConfigFile struct {
 	Backends []backendConfigFileEntry `yaml:"s3backends" json:"s3backends"`
 	Default  string                   `yaml:"default" json:"default"`
+	//When set ListBuckets is sent to every backend and the results are merged
+	AggregateListBuckets bool `yaml:"aggregateListBuckets,omitempty" json:"aggregateListBuckets,omitempty"`
 }
 
 // TODO: legacyBehavior
@@ -150,6 +153,7 @@ func getBackendsConfigFromBytes(inputBytes []byte, legacyBehavior bool, relative
 	}
 	result.defaultBackend = defaultBackend
 	result.invalidRegionToDefaultRegion = legacyBehavior
+	result.aggregateListBuckets = c.AggregateListBuckets
 
 	return &result, err
 }
@@ -212,6 +216,8 @@ type backendsConfig struct {
 	defaultBackend string
 
 	invalidRegionToDefaultRegion bool
+
+	aggregateListBuckets bool
 }
 
 var errInvalidBackendErr = errors.New("invalid BackendId")
@@ -278,3 +284,12 @@ func (cfg *backendsConfig) GetBackendEndpoint(backendId string) (interfaces.Endp
 func (cfg *backendsConfig) GetDefaultBackend() string {
 	return cfg.defaultBackend
 }
+
+func (cfg *backendsConfig) AggregatesListBuckets() bool {
+	return cfg.aggregateListBuckets
+}
+
+// Get the identifiers of all configured backends in alphabetical order
+func (cfg *backendsConfig) GetBackendIds() []string {
+	return slices.Sorted(maps.Keys(cfg.backends))
+}
@@ -33,6 +33,9 @@ func TestLoadingOfExampleConfig(t *testing.T) {
 	if cfg.defaultBackend != testDefaultBackendRegion {
 		t.Errorf("Incorrect default backend. Got %s, Expected %s", cfg.defaultBackend, testDefaultBackendRegion)
 	}
+	if cfg.AggregatesListBuckets() {
+		t.Error("ListBuckets aggregation should be disabled by default")
+	}
 	_, err = cfg.getBackendConfig(testDefaultBackendRegion)
 	if err != nil {
 		t.Error("Default backend config is not available")
@@ -76,6 +79,7 @@ s3backends:
       file: %s
     endpoint: https://obs.eu-nl.otc.t-systems.com
 default:  waw3-1
+aggregateListBuckets: true
 `, cfcCredFile, otcCredFile)
 
 	//Given that this config file is on the relative path
@@ -88,6 +92,9 @@ default:  waw3-1
 		t.Errorf("Could not load S3 backend config: %s", err)
 		t.FailNow()
 	}
+	if !cfg.AggregatesListBuckets() {
+		t.Error("ListBuckets aggregation should be enabled")
+	}
 	if cfg.defaultBackend != testDefaultBackendRegion {
 		t.Errorf("Incorrect default backend. Got %s, Expected %s", cfg.defaultBackend, testDefaultBackendRegion)
 	}
@@ -0,0 +1,93 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/service"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/utils"
+)
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetBucketLocation.html#API_GetBucketLocation_ResponseSyntax
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Region  string   `xml:",chardata"`
+}
+
+// The order in which backends are checked for a bucket. The backend targeted by the request comes first such
+// that a client which already uses the right region gets it confirmed. Next is the default backend and then all
+// others. Since backends are identified by region the answer can be used by a client to sign its requests
+// such that requestutils.GetRegionFromRequest routes them to the backend holding the bucket.
+func getBucketLocationProbeOrder(targetBackendId string, bm interfaces.BackendManager) []string {
+	backendIds := bm.GetBackendIds()
+	probeOrder := []string{}
+	for _, backendId := range []string{targetBackendId, bm.GetDefaultBackend()} {
+		if slices.Contains(backendIds, backendId) && !slices.Contains(probeOrder, backendId) {
+			probeOrder = append(probeOrder, backendId)
+		}
+	}
+	for _, backendId := range backendIds {
+		if !slices.Contains(probeOrder, backendId) {
+			probeOrder = append(probeOrder, backendId)
+		}
+	}
+	return probeOrder
+}
+
+// Whether a bucket exists on the backend. A bucket that the credentials of the proxy cannot access does exist.
+func (c backendClient) hasBucket(ctx context.Context, bucket string) (bool, error) {
+	resp, err := c.do(ctx, http.MethodHead, fmt.Sprintf("/%s", url.PathEscape(bucket)), url.Values{}, nil, nil, 0)
+	var backendErr *backendErrorResponse
+	if errors.As(err, &backendErr) {
+		switch backendErr.statusCode {
+		case http.StatusForbidden:
+			return true, nil
+		case http.StatusNotFound, http.StatusMovedPermanently:
+			return false, nil
+		}
+		return false, err
+	} else if err != nil {
+		return false, err
+	}
+	utils.Close(resp.Body, "HeadBucket response body", ctx)
+	return true, nil
+}
+
+// Answer a GetBucketLocation request with the region of the backend that holds the bucket.
+// This is not proxied since the backend only knows about its own region.
+func getBucketLocation(ctx context.Context, w http.ResponseWriter, r *http.Request, targetBackendId string,
+	backendManager interfaces.BackendManager, requester requesterFunc, corsHandler interfaces.CORSHandler) {
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" {
+		writeS3ErrorResponse(ctx, w, ErrS3NoSuchBucket, errors.New("no bucket in GetBucketLocation request"))
+		return
+	}
+	for _, backendId := range getBucketLocationProbeOrder(targetBackendId, backendManager) {
+		backend := backendClient{backendId: backendId, bm: backendManager, requester: requester}
+		exists, err := backend.hasBucket(ctx, bucket)
+		if err != nil {
+			slog.WarnContext(ctx, "Could not check whether backend holds bucket", "error", err, "backendId", backendId, "bucket", bucket)
+			continue
+		}
+		if exists {
+			requestctx.AddAccessLogInfo(r, "s3", slog.String("BucketLocation", backendId))
+			corsHandler.SetHeaders(w, bucket, backendId, backendManager)
+			result := locationConstraint{
+				XMLName: xml.Name{Space: s3XMLNamespace, Local: "LocationConstraint"},
+				Region:  backendId,
+			}
+			service.WriteSuccessResponseXML(ctx, w, service.EncodeResponse(ctx, result))
+			return
+		}
+	}
+	writeS3ErrorResponse(ctx, w, ErrS3NoSuchBucket, fmt.Errorf("bucket %s not found on any backend", bucket))
+}
@@ -0,0 +1,85 @@
+package s3
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/testutils"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// A fake backend requester for which buckets only exist on the backend with the given host
+func newFakeBucketHostRequester(bucketHost string) requesterFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodHead {
+			return fakeBackendResponse(http.StatusBadRequest, nil, nil), nil
+		}
+		if r.Host == bucketHost && r.URL.Path == "/"+testBucketName {
+			return fakeBackendResponse(http.StatusOK, nil, nil), nil
+		}
+		return fakeBackendResponse(http.StatusNotFound, nil, nil), nil
+	}
+}
+
+func runGetBucketLocation(t *testing.T, s *S3Server, bucket string) (*s3.GetBucketLocationOutput, error) {
+	cred := createTestCredentialsForPolicy(t, testPolicyAllowAllARN, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "waw3-1", cred, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: &bucket})
+}
+
+func TestGetBucketLocationReturnsRegionOfBackendHoldingBucket(t *testing.T) {
+	hb := handlerBuilder{proxyFunc: justProxy, requester: newFakeBucketHostRequester(testSourceBackendHost)}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When asking the location of a bucket that is on another backend than the one targeted by the request
+	output, err := runGetBucketLocation(t, s, testBucketName)
+
+	//Then the region of that backend is returned
+	if err != nil {
+		t.Fatalf("GetBucketLocation failed: %s", err)
+	}
+	if string(output.LocationConstraint) != "eu-nl" {
+		t.Errorf("Expected eu-nl, got %q", output.LocationConstraint)
+	}
+}
+
+func TestGetBucketLocationOfUnknownBucket(t *testing.T) {
+	hb := handlerBuilder{proxyFunc: justProxy, requester: newFakeBucketHostRequester(testSourceBackendHost)}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When asking the location of a bucket that no backend holds
+	_, err := runGetBucketLocation(t, s, "unknown-bucket")
+
+	//Then NoSuchBucket is returned
+	if err == nil || !strings.Contains(err.Error(), "NoSuchBucket") {
+		t.Errorf("Expected NoSuchBucket, got %v", err)
+	}
+}
+
+func TestGetBucketLocationProbeOrder(t *testing.T) {
+	bm := getDefaultTestBackendConfig()
+	var testCases = []struct {
+		targetBackendId string
+		expected        []string
+	}{
+		{"eu-nl", []string{"eu-nl", "waw3-1"}},
+		{"waw3-1", []string{"waw3-1", "eu-nl"}},
+		//A region without backend is skipped
+		{"us-east-1", []string{"waw3-1", "eu-nl"}},
+	}
+	for _, tc := range testCases {
+		got := getBucketLocationProbeOrder(tc.targetBackendId, bm)
+		if !slices.Equal(got, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.targetBackendId, tc.expected, got)
+		}
+	}
+}
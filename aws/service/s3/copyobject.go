@@ -0,0 +1,477 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/aws/service"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/constants"
+	"github.com/VITObelgium/fakes3pp/presign"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/usererror"
+	"github.com/VITObelgium/fakes3pp/utils"
+)
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObject.html
+// Objects up to 5 GB can be uploaded with a single PUT, larger objects require a multipart upload.
+var crossBackendCopyMaxSinglePutSize int64 = 5 * 1024 * 1024 * 1024
+
+// The smallest part size used for multipart uploads when copying across backends. It gets increased
+// when needed to stay within the 10000 parts an upload can have.
+var crossBackendCopyMinPartSize int64 = 64 * 1024 * 1024
+
+const maxMultipartUploadParts = 10000
+
+var errInvalidCopySourceRegion = errors.New("invalid copy source region")
+
+// Error bodies of backends are small XML documents, no need to read more
+const maxBackendErrorBodyBytes = 64 * 1024
+
+// The headers of an object that are copied along with the data when the metadata directive is COPY.
+// When the metadata directive is REPLACE they are taken from the request instead.
+var objectMetadataHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Type",
+	"Expires",
+}
+
+const amzMetaHeaderPrefix = "X-Amz-Meta-"
+
+// Headers of a CopyObject request that apply to the destination object regardless of the metadata directive
+var copyDestinationHeaders = []string{
+	"X-Amz-Acl",
+	"X-Amz-Server-Side-Encryption",
+	"X-Amz-Storage-Class",
+	"X-Amz-Website-Redirect-Location",
+}
+
+// The conditional headers of a CopyObject request and the equivalent headers of a GetObject request
+var copySourceConditionHeaders = map[string]string{
+	"X-Amz-Copy-Source-If-Match":            "If-Match",
+	"X-Amz-Copy-Source-If-None-Match":       "If-None-Match",
+	"X-Amz-Copy-Source-If-Modified-Since":   "If-Modified-Since",
+	"X-Amz-Copy-Source-If-Unmodified-Since": "If-Unmodified-Since",
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CopyObject.html#API_CopyObject_ResponseSyntax
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+type initiateMultipartUploadResult struct {
+	UploadId string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// A CompleteMultipartUpload can fail after the backend has sent a 200 OK in which case the
+// body is an Error document rather than a CompleteMultipartUploadResult.
+type completeMultipartUploadResult struct {
+	XMLName xml.Name
+	ETag    string `xml:"ETag"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// An error response of a backend that is passed on to the client as is
+type backendErrorResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func (e *backendErrorResponse) Error() string {
+	return fmt.Sprintf("backend responded with status %d: %s", e.statusCode, string(e.body))
+}
+
+// A client to perform requests against a single backend with the credentials of the proxy
+type backendClient struct {
+	backendId string
+	bm        interfaces.BackendManager
+	requester requesterFunc
+}
+
+// Perform a signed request against the backend. The path must already be escaped. If the backend does not
+// respond with a 2xx status code the response is consumed and returned as a *backendErrorResponse.
+func (c backendClient) do(ctx context.Context, method, escapedPath string, query url.Values, header http.Header, body io.Reader, contentLength int64) (*http.Response, error) {
+	endpoint, err := c.bm.GetBackendEndpoint(c.backendId)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := c.bm.GetBackendCredentials(c.backendId)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(fmt.Sprintf("%s%s", endpoint.GetBaseURI(), escapedPath))
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for headerName, headerValues := range header {
+		for _, headerValue := range headerValues {
+			req.Header.Add(headerName, headerValue)
+		}
+	}
+	if body == nil {
+		req.Header.Set(constants.AmzContentSHAKey, constants.EmptyStringSHA256)
+	} else {
+		req.ContentLength = contentLength
+		req.Header.Set(constants.AmzContentSHAKey, "UNSIGNED-PAYLOAD")
+	}
+	err = presign.SignWithCreds(ctx, req, creds, c.backendId)
+	if err != nil {
+		return nil, err
+	}
+	slog.DebugContext(ctx, "Going to perform backend request", "method", req.Method, "host", req.Host, "url", req.URL)
+	resp, err := c.requester(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer utils.Close(resp.Body, "backend error response body", ctx)
+		errBody, err := io.ReadAll(io.LimitReader(resp.Body, maxBackendErrorBodyBytes))
+		if err != nil {
+			return nil, err
+		}
+		return nil, &backendErrorResponse{statusCode: resp.StatusCode, header: resp.Header, body: errBody}
+	}
+	return resp, nil
+}
+
+// Path of an object in a path-style request. Every segment of the key gets escaped on its own
+// such that slashes remain.
+func makeS3ObjectPath(bucketName, objectKey string) string {
+	segments := strings.Split(objectKey, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return fmt.Sprintf("/%s/%s", url.PathEscape(bucketName), strings.Join(segments, "/"))
+}
+
+// The part size to use for a multipart upload of an object of the given size
+func getCrossBackendCopyPartSize(size int64) int64 {
+	partSize := (size + maxMultipartUploadParts - 1) / maxMultipartUploadParts
+	return max(partSize, crossBackendCopyMinPartSize)
+}
+
+// Get the headers of the object that gets written to the destination backend
+func getCrossBackendCopyDestinationHeaders(r *http.Request, sourceHeader http.Header) http.Header {
+	header := http.Header{}
+	metadataHeader := sourceHeader
+	if strings.EqualFold(r.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		metadataHeader = r.Header
+	}
+	for headerName, headerValues := range metadataHeader {
+		if strings.HasPrefix(headerName, amzMetaHeaderPrefix) {
+			header[headerName] = headerValues
+		}
+	}
+	for _, headerName := range objectMetadataHeaders {
+		if headerValue := metadataHeader.Get(headerName); headerValue != "" {
+			header.Set(headerName, headerValue)
+		}
+	}
+	for _, headerName := range copyDestinationHeaders {
+		if headerValue := r.Header.Get(headerName); headerValue != "" {
+			header.Set(headerName, headerValue)
+		}
+	}
+	if strings.EqualFold(r.Header.Get("X-Amz-Tagging-Directive"), "REPLACE") {
+		if headerValue := r.Header.Get(amzTaggingHeader); headerValue != "" {
+			header.Set(amzTaggingHeader, headerValue)
+		}
+	} else if sourceTags := getCopySourceTags(r); len(sourceTags) > 0 {
+		header.Set(amzTaggingHeader, encodeTaggingHeader(sourceTags))
+	}
+	return header
+}
+
+// Provides the parts of a multipart upload one by one, io.EOF signals that there are no more parts
+type nextPartFunc func() (part io.Reader, partLength int64, err error)
+
+// Upload an object in parts. The upload gets aborted if any of the parts fail.
+func (c backendClient) uploadParts(ctx context.Context, escapedPath string, header http.Header, nextPart nextPartFunc) (etag, versionId string, err error) {
+	createResp, err := c.do(ctx, http.MethodPost, escapedPath, url.Values{"uploads": {""}}, header, nil, 0)
+	if err != nil {
+		return "", "", err
+	}
+	initiateResult := initiateMultipartUploadResult{}
+	err = xml.NewDecoder(createResp.Body).Decode(&initiateResult)
+	utils.Close(createResp.Body, "CreateMultipartUpload response body", ctx)
+	if err != nil {
+		return "", "", fmt.Errorf("could not decode CreateMultipartUpload response: %w", err)
+	}
+	uploadId := initiateResult.UploadId
+	if uploadId == "" {
+		return "", "", errors.New("CreateMultipartUpload response did not contain an UploadId")
+	}
+	abort := func() {
+		abortResp, abortErr := c.do(ctx, http.MethodDelete, escapedPath, url.Values{"uploadId": {uploadId}}, nil, nil, 0)
+		if abortErr != nil {
+			slog.WarnContext(ctx, "Could not abort multipart upload", "error", abortErr, "uploadId", uploadId)
+			return
+		}
+		utils.Close(abortResp.Body, "AbortMultipartUpload response body", ctx)
+	}
+
+	parts := []completedPart{}
+	for partNumber := 1; ; partNumber++ {
+		part, partLength, err := nextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			abort()
+			return "", "", err
+		}
+		if partNumber > maxMultipartUploadParts {
+			abort()
+			return "", "", fmt.Errorf("object does not fit in %d parts", maxMultipartUploadParts)
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadId}}
+		partResp, err := c.do(ctx, http.MethodPut, escapedPath, query, nil, part, partLength)
+		if err != nil {
+			abort()
+			return "", "", err
+		}
+		utils.Close(partResp.Body, "UploadPart response body", ctx)
+		parts = append(parts, completedPart{PartNumber: partNumber, ETag: partResp.Header.Get("ETag")})
+	}
+
+	completeBody, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		abort()
+		return "", "", err
+	}
+	completeResp, err := c.do(ctx, http.MethodPost, escapedPath, url.Values{"uploadId": {uploadId}}, nil, bytes.NewReader(completeBody), int64(len(completeBody)))
+	if err != nil {
+		abort()
+		return "", "", err
+	}
+	defer utils.Close(completeResp.Body, "CompleteMultipartUpload response body", ctx)
+	completeResult := completeMultipartUploadResult{}
+	err = xml.NewDecoder(completeResp.Body).Decode(&completeResult)
+	if err != nil {
+		abort()
+		return "", "", fmt.Errorf("could not decode CompleteMultipartUpload response: %w", err)
+	}
+	if completeResult.XMLName.Local == "Error" {
+		abort()
+		return "", "", fmt.Errorf("CompleteMultipartUpload failed with %s: %s", completeResult.Code, completeResult.Message)
+	}
+	return completeResult.ETag, completeResp.Header.Get("X-Amz-Version-Id"), nil
+}
+
+// Upload an object of known size in parts
+func (c backendClient) putObjectInParts(ctx context.Context, escapedPath string, header http.Header, body io.Reader, size int64) (etag, versionId string, err error) {
+	partSize := getCrossBackendCopyPartSize(size)
+	offset := int64(0)
+	return c.uploadParts(ctx, escapedPath, header, func() (io.Reader, int64, error) {
+		if offset >= size {
+			return nil, 0, io.EOF
+		}
+		partLength := min(partSize, size-offset)
+		offset += partLength
+		return io.LimitReader(body, partLength), partLength, nil
+	})
+}
+
+// Write an object of known size to the backend using a single PUT if possible
+func (c backendClient) putObject(ctx context.Context, escapedPath string, header http.Header, body io.Reader, size int64) (etag, versionId string, err error) {
+	if size > crossBackendCopyMaxSinglePutSize {
+		return c.putObjectInParts(ctx, escapedPath, header, body, size)
+	}
+	if size == 0 {
+		// A zero-length body must still be sent as a PUT with content
+		body = http.NoBody
+	}
+	resp, err := c.do(ctx, http.MethodPut, escapedPath, url.Values{}, header, body, size)
+	if err != nil {
+		return "", "", err
+	}
+	defer utils.Close(resp.Body, "PutObject response body", ctx)
+	return resp.Header.Get("ETag"), resp.Header.Get("X-Amz-Version-Id"), nil
+}
+
+// Write an object of which the size is not known upfront. The body is read one part at a time such that
+// memory use stays bounded by the part size. An object that fits in a single part is written with a single PUT.
+func (c backendClient) putObjectOfUnknownSize(ctx context.Context, escapedPath string, header http.Header, body io.Reader, partSize int64) (etag, versionId string, err error) {
+	readPart := func() (*bytes.Buffer, error) {
+		part := &bytes.Buffer{}
+		_, err := io.CopyN(part, body, partSize)
+		if err == io.EOF {
+			err = nil
+		}
+		return part, err
+	}
+	firstPart, err := readPart()
+	if err != nil {
+		return "", "", err
+	}
+	if int64(firstPart.Len()) < partSize {
+		return c.putObject(ctx, escapedPath, header, firstPart, int64(firstPart.Len()))
+	}
+	next := firstPart
+	return c.uploadParts(ctx, escapedPath, header, func() (io.Reader, int64, error) {
+		if next == nil {
+			var err error
+			next, err = readPart()
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		part := next
+		next = nil
+		if part.Len() == 0 {
+			return nil, 0, io.EOF
+		}
+		return part, int64(part.Len()), nil
+	})
+}
+
+func writeBackendErrorResponse(ctx context.Context, w http.ResponseWriter, e *backendErrorResponse) {
+	for _, headerName := range []string{"Content-Type", "X-Amz-Request-Id", "X-Amz-Id-2"} {
+		if headerValue := e.header.Get(headerName); headerValue != "" {
+			w.Header().Set(headerName, headerValue)
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(e.body)))
+	w.WriteHeader(e.statusCode)
+	utils.WriteButLogOnError(ctx, w, e.body)
+}
+
+// Report a failure of a cross-backend copy. Errors of the backends are passed on such that
+// the client sees e.g. NoSuchKey for a missing source.
+func writeCrossBackendCopyError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	var backendErr *backendErrorResponse
+	switch {
+	case errors.Is(err, errInvalidBackendErr):
+		writeS3ErrorResponse(ctx, w, ErrS3InvalidRegion, err)
+	case errors.As(err, &backendErr) && backendErr.statusCode == http.StatusNotModified:
+		// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CopyObject.html
+		// A copy with unmet conditions fails with 412 Precondition Failed.
+		requestctx.SetUpstreamHTTPStatus(r, backendErr.statusCode)
+		writeS3ErrorResponse(ctx, w, ErrS3PreconditionFailed, err)
+	case errors.As(err, &backendErr):
+		requestctx.SetUpstreamHTTPStatus(r, backendErr.statusCode)
+		slog.InfoContext(ctx, "Backend refused cross-backend copy", "error", err)
+		writeBackendErrorResponse(ctx, w, backendErr)
+	default:
+		requestctx.SetUpstreamHTTPStatus(r, -1)
+		writeS3ErrorResponse(ctx, w, ErrS3UpstreamError, err)
+	}
+}
+
+// The source backend of a cross-backend copy is picked by the client with the CopySourceRegion header. It decides
+// where the source is read and in which region reading it is authorized so the header must be signed and must
+// name a configured backend. An empty id is returned when the header is absent.
+func getCopySourceBackendId(r *http.Request, backendManager interfaces.BackendManager) (string, error) {
+	sourceBackendId := r.Header.Get(constants.CopySourceRegion)
+	if sourceBackendId == "" {
+		return "", nil
+	}
+	signedHeaders, err := requestctx.GetSignedHeaders(r)
+	if err != nil || !slices.Contains(signedHeaders, constants.CopySourceRegion) {
+		return "", usererror.New(
+			fmt.Errorf("%w: %s is not signed", errInvalidCopySourceRegion, constants.CopySourceRegion),
+			fmt.Sprintf("The %s header must be signed", constants.CopySourceRegion),
+		)
+	}
+	if !slices.Contains(backendManager.GetBackendIds(), sourceBackendId) {
+		return "", usererror.New(
+			fmt.Errorf("%w: %s", errInvalidCopySourceRegion, sourceBackendId),
+			fmt.Sprintf("The copy source region %s is not supported", sourceBackendId),
+		)
+	}
+	return sourceBackendId, nil
+}
+
+// Copy an object that lives on another backend than the destination. Neither backend can do this
+// server-side so the object is streamed through the proxy: a GET on the source backend is fed into a
+// PUT (or a multipart upload for large objects) on the destination backend.
+func copyObjectAcrossBackends(ctx context.Context, w http.ResponseWriter, r *http.Request, sourceBackendId, targetBackendId string,
+	backendManager interfaces.BackendManager, requester requesterFunc, corsHandler interfaces.CORSHandler) {
+	sourceBucket, sourceKey, sourceVersionId, err := getS3CopySourceFromRequest(r)
+	if err != nil {
+		writeS3ErrorResponse(ctx, w, ErrS3InternalError, err)
+		return
+	}
+	requestctx.AddAccessLogInfo(r, "s3", slog.String("CopySourceRegion", sourceBackendId))
+
+	sourceQuery := url.Values{}
+	if sourceVersionId != "" {
+		sourceQuery.Set("versionId", sourceVersionId)
+	}
+	//Without an explicit Accept-Encoding the transport asks for gzip and transparently decompresses objects that are
+	//stored with Content-Encoding gzip, which would write a decompressed object with a gzip Content-Encoding.
+	sourceHeader := http.Header{"Accept-Encoding": {"identity"}}
+	for copyHeaderName, getHeaderName := range copySourceConditionHeaders {
+		if headerValue := r.Header.Get(copyHeaderName); headerValue != "" {
+			sourceHeader.Set(getHeaderName, headerValue)
+		}
+	}
+	source := backendClient{backendId: sourceBackendId, bm: backendManager, requester: requester}
+	sourceResp, err := source.do(ctx, http.MethodGet, makeS3ObjectPath(sourceBucket, sourceKey), sourceQuery, sourceHeader, nil, 0)
+	if err != nil {
+		writeCrossBackendCopyError(ctx, w, r, err)
+		return
+	}
+	defer utils.Close(sourceResp.Body, "cross-backend copy source body", ctx)
+	size := sourceResp.ContentLength
+	if size < 0 {
+		writeS3ErrorResponse(ctx, w, ErrS3UpstreamError, errors.New("source backend did not report the size of the object"))
+		return
+	}
+	requestctx.AddAccessLogInfo(r, "s3", slog.Int64("CopiedBytes", size))
+
+	destination := backendClient{backendId: targetBackendId, bm: backendManager, requester: requester}
+	destinationHeader := getCrossBackendCopyDestinationHeaders(r, sourceResp.Header)
+	etag, versionId, err := destination.putObject(ctx, r.URL.EscapedPath(), destinationHeader, sourceResp.Body, size)
+	if err != nil {
+		writeCrossBackendCopyError(ctx, w, r, err)
+		return
+	}
+	requestctx.SetUpstreamHTTPStatus(r, http.StatusOK)
+
+	corsHandler.SetHeaders(w, requestctx.GetAccessLogStringInfo(r, "s3", L_BUCKET), targetBackendId, backendManager)
+	if versionId != "" {
+		w.Header().Set("X-Amz-Version-Id", versionId)
+	}
+	if sourceVersionId != "" {
+		w.Header().Set("X-Amz-Copy-Source-Version-Id", sourceVersionId)
+	}
+	result := copyObjectResult{
+		XMLName:      xml.Name{Space: s3XMLNamespace, Local: "CopyObjectResult"},
+		ETag:         etag,
+		LastModified: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+	service.WriteSuccessResponseXML(ctx, w, service.EncodeResponse(ctx, result))
+}
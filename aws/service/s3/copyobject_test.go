@@ -0,0 +1,491 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/constants"
+	"github.com/VITObelgium/fakes3pp/testutils"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+var testPolicyAllowReadOnlyPublicWriteAllARN = "arn:aws:iam::000000000000:role/AllowReadOnlyPublicWriteAll"
+var testPolicyAllowReadOnlyPublicWriteAll = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/public/*"
+		},
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/*"
+		}
+	]
+}`, actionnames.IAMActionS3GetObject, testBucketARN, actionnames.IAMActionS3PutObject, testBucketARN)
+
+func runCopyObject(t *testing.T, s *S3Server, sourceKey, destinationKey string) error {
+	cred := createTestCredentialsForPolicy(t, testPolicyAllowReadOnlyPublicWriteAllARN, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "eu-west-1", cred, s)
+
+	copySource := fmt.Sprintf("%s/%s", testBucketName, sourceKey)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &testBucketName,
+		Key:        &destinationKey,
+		CopySource: &copySource,
+	})
+	return err
+}
+
+func TestCopyObjectRequiresReadAccessOnSource(t *testing.T) {
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowReadOnlyPublicWriteAllARN: testPolicyAllowReadOnlyPublicWriteAll})
+	teardownSuite, s := setupSuiteProxyS3(t, testStubJustProxy, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+	testRequests = nil
+
+	//When copying an object that cannot be read into a location that can be written
+	err := runCopyObject(t, s, "private/secret", "public/leaked")
+
+	//Then access is denied
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Expected AccessDenied, got %v", err)
+	}
+	//And the request does not go upstream
+	if popLastRequestByTestProxy() != nil {
+		t.Error("Denied copy should not have been proxied")
+	}
+}
+
+func TestCopyObjectAllowedWithReadAccessOnSource(t *testing.T) {
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowReadOnlyPublicWriteAllARN: testPolicyAllowReadOnlyPublicWriteAll})
+	teardownSuite, s := setupSuiteProxyS3(t, testStubJustProxy, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+	testRequests = nil
+
+	//When copying an object that can be read into a location that can be written
+	err := runCopyObject(t, s, "public/shared", "private/copy")
+
+	//Then the request is proxied
+	if err != nil && strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Copy should have been allowed, got %s", err)
+	}
+	if popLastRequestByTestProxy() == nil {
+		t.Error("Allowed copy should have been proxied")
+	}
+}
+
+// An in-memory fake of the backends of getDefaultTestBackendConfig. Objects are keyed by host and path.
+type fakeBackends struct {
+	objects       map[string][]byte
+	objectHeaders map[string]http.Header
+	objectTags    map[string]string
+	uploads       map[string]*bytes.Buffer
+	requests      []string
+}
+
+func newFakeBackends() *fakeBackends {
+	return &fakeBackends{
+		objects:       map[string][]byte{},
+		objectHeaders: map[string]http.Header{},
+		objectTags:    map[string]string{},
+		uploads:       map[string]*bytes.Buffer{},
+	}
+}
+
+func fakeBackendResponse(statusCode int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode:    statusCode,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+}
+
+func transparentlyDecompressedResponse(header http.Header, object []byte) (*http.Response, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(object))
+	if err != nil {
+		return nil, err
+	}
+	header.Del("Content-Encoding")
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          gz,
+		ContentLength: -1,
+		Uncompressed:  true,
+	}, nil
+}
+
+func (f *fakeBackends) requester(r *http.Request) (*http.Response, error) {
+	f.requests = append(f.requests, fmt.Sprintf("%s %s%s?%s", r.Method, r.Host, r.URL.EscapedPath(), r.URL.RawQuery))
+	objectId := r.Host + r.URL.EscapedPath()
+	query := r.URL.Query()
+	var body []byte
+	if r.Body != nil {
+		var err error
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case r.Method == http.MethodGet && query.Has("tagging"):
+		if _, exists := f.objects[objectId]; !exists {
+			return fakeBackendResponse(http.StatusNotFound, nil, []byte("<Error><Code>NoSuchKey</Code></Error>")), nil
+		}
+		body := "<Tagging><TagSet></TagSet></Tagging>"
+		if classification, tagged := f.objectTags[objectId]; tagged {
+			body = fmt.Sprintf("<Tagging><TagSet><Tag><Key>classification</Key><Value>%s</Value></Tag></TagSet></Tagging>", classification)
+		}
+		return fakeBackendResponse(http.StatusOK, nil, []byte(body)), nil
+	case r.Method == http.MethodGet:
+		object, exists := f.objects[objectId]
+		if !exists {
+			return fakeBackendResponse(http.StatusNotFound, nil, []byte("<Error><Code>NoSuchKey</Code></Error>")), nil
+		}
+		header := f.objectHeaders[objectId].Clone()
+		if r.Header.Get("Accept-Encoding") == "" && header.Get("Content-Encoding") == "gzip" {
+			//Like http.Transport which requests gzip and transparently decompresses when no encoding was asked for
+			return transparentlyDecompressedResponse(header, object)
+		}
+		return fakeBackendResponse(http.StatusOK, header, object), nil
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.uploads[objectId] = &bytes.Buffer{}
+		return fakeBackendResponse(http.StatusOK, nil, []byte("<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>")), nil
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		f.uploads[objectId].Write(body)
+		return fakeBackendResponse(http.StatusOK, http.Header{"Etag": {"part"}}, nil), nil
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.objects[objectId] = f.uploads[objectId].Bytes()
+		delete(f.uploads, objectId)
+		return fakeBackendResponse(http.StatusOK, nil, []byte("<CompleteMultipartUploadResult><ETag>multipart</ETag></CompleteMultipartUploadResult>")), nil
+	case r.Method == http.MethodPut:
+		f.objects[objectId] = body
+		f.objectHeaders[objectId] = http.Header{}
+		for _, headerName := range []string{"Content-Type", "Content-Encoding", "X-Amz-Tagging"} {
+			if headerValue := r.Header.Get(headerName); headerValue != "" {
+				f.objectHeaders[objectId].Set(headerName, headerValue)
+			}
+		}
+		return fakeBackendResponse(http.StatusOK, http.Header{"Etag": {"single"}}, nil), nil
+	}
+	return fakeBackendResponse(http.StatusNotImplemented, nil, nil), nil
+}
+
+const testSourceBackendHost = "obs.eu-nl.otc.t-systems.com"
+const testDestinationBackendHost = "s3.waw3-1.cloudferro.com"
+
+func runCopyObjectAcrossBackends(t *testing.T, s *S3Server, copySource, destinationKey string) (*s3.CopyObjectOutput, error) {
+	return runCopyObjectAcrossBackendsWithRole(t, s, testPolicyAllowAllARN, copySource, destinationKey)
+}
+
+func runCopyObjectAcrossBackendsWithRole(t *testing.T, s *S3Server, roleArn, copySource, destinationKey string) (*s3.CopyObjectOutput, error) {
+	return runCopyObjectWithSourceRegion(t, s, roleArn, copySource, destinationKey, smithyhttp.AddHeaderValue(constants.CopySourceRegion, "eu-nl"))
+}
+
+func runCopyObjectWithSourceRegion(t *testing.T, s *S3Server, roleArn, copySource, destinationKey string, addSourceRegion func(*smithymiddleware.Stack) error) (*s3.CopyObjectOutput, error) {
+	cred := createTestCredentialsForPolicy(t, roleArn, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "waw3-1", cred, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &testBucketName,
+		Key:        &destinationKey,
+		CopySource: &copySource,
+	}, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, addSourceRegion)
+	})
+}
+
+// Set a header after the request got signed such that it is not part of the signed headers
+func setHeaderAfterSigning(headerName, value string) func(*smithymiddleware.Stack) error {
+	return func(stack *smithymiddleware.Stack) error {
+		return stack.Finalize.Add(smithymiddleware.FinalizeMiddlewareFunc("SetHeaderAfterSigning", func(
+			ctx context.Context, in smithymiddleware.FinalizeInput, next smithymiddleware.FinalizeHandler,
+		) (smithymiddleware.FinalizeOutput, smithymiddleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				req.Header.Set(headerName, value)
+			}
+			return next.HandleFinalize(ctx, in)
+		}), smithymiddleware.After)
+	}
+}
+
+func TestCopyObjectAcrossBackendsStreamsObject(t *testing.T) {
+	backends := newFakeBackends()
+	sourceId := fmt.Sprintf("%s/%s/src/a%%20b", testSourceBackendHost, testBucketName)
+	backends.objects[sourceId] = []byte("the content")
+	backends.objectHeaders[sourceId] = http.Header{"Content-Type": {"text/plain"}}
+	hb := handlerBuilder{proxyFunc: justProxy, requester: backends.requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When copying an object from a backend in another region
+	output, err := runCopyObjectAcrossBackends(t, s, fmt.Sprintf("%s/src/a%%20b", testBucketName), "dst")
+
+	//Then the copy succeeds
+	if err != nil {
+		t.Fatalf("Copy across backends failed: %s", err)
+	}
+	if output.CopyObjectResult == nil || *output.CopyObjectResult.ETag != "single" {
+		t.Errorf("Unexpected CopyObjectResult %v", output.CopyObjectResult)
+	}
+	//And the object with its metadata was written to the destination backend
+	destinationId := fmt.Sprintf("%s/%s/dst", testDestinationBackendHost, testBucketName)
+	if string(backends.objects[destinationId]) != "the content" {
+		t.Errorf("Unexpected destination content %q, requests: %v", backends.objects[destinationId], backends.requests)
+	}
+	if backends.objectHeaders[destinationId].Get("Content-Type") != "text/plain" {
+		t.Errorf("Content-Type was not copied, got %v", backends.objectHeaders[destinationId])
+	}
+}
+
+func TestCopyObjectAcrossBackendsUsesMultipartForLargeObjects(t *testing.T) {
+	defer func(maxSinglePutSize, minPartSize int64) {
+		crossBackendCopyMaxSinglePutSize = maxSinglePutSize
+		crossBackendCopyMinPartSize = minPartSize
+	}(crossBackendCopyMaxSinglePutSize, crossBackendCopyMinPartSize)
+	crossBackendCopyMaxSinglePutSize = 4
+	crossBackendCopyMinPartSize = 3
+
+	backends := newFakeBackends()
+	backends.objects[fmt.Sprintf("%s/%s/src", testSourceBackendHost, testBucketName)] = []byte("0123456789")
+	hb := handlerBuilder{proxyFunc: justProxy, requester: backends.requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When copying an object that is too large for a single PUT
+	output, err := runCopyObjectAcrossBackends(t, s, fmt.Sprintf("%s/src", testBucketName), "dst")
+
+	//Then the copy succeeds using a multipart upload
+	if err != nil {
+		t.Fatalf("Copy across backends failed: %s", err)
+	}
+	if *output.CopyObjectResult.ETag != "multipart" {
+		t.Errorf("Expected ETag of multipart upload, got %s", *output.CopyObjectResult.ETag)
+	}
+	destinationId := fmt.Sprintf("%s/%s/dst", testDestinationBackendHost, testBucketName)
+	if string(backends.objects[destinationId]) != "0123456789" {
+		t.Errorf("Unexpected destination content %q, requests: %v", backends.objects[destinationId], backends.requests)
+	}
+	var parts int
+	for _, request := range backends.requests {
+		if strings.Contains(request, "partNumber=") {
+			parts++
+		}
+	}
+	if parts != 4 {
+		t.Errorf("Expected 4 parts, got %d: %v", parts, backends.requests)
+	}
+}
+
+func TestCopyObjectAcrossBackendsReturnsSourceErrors(t *testing.T) {
+	backends := newFakeBackends()
+	hb := handlerBuilder{proxyFunc: justProxy, requester: backends.requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When copying an object that does not exist on the source backend
+	_, err := runCopyObjectAcrossBackends(t, s, fmt.Sprintf("%s/missing", testBucketName), "dst")
+
+	//Then the error of the source backend is returned
+	if err == nil || !strings.Contains(err.Error(), "NoSuchKey") {
+		t.Errorf("Expected NoSuchKey, got %v", err)
+	}
+	//And nothing gets written to the destination backend
+	for _, request := range backends.requests {
+		if strings.Contains(request, testDestinationBackendHost) {
+			t.Errorf("Unexpected request to destination backend: %s", request)
+		}
+	}
+}
+
+var testPolicyAllowAllInWaw31ARN = "arn:aws:iam::000000000000:role/AllowAllInWaw31"
+var testPolicyAllowAllInWaw31 = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "*",
+			"Resource": "*",
+			"Condition": {"StringEquals": {"aws:RequestedRegion": "waw3-1"}}
+		}
+	]
+}`
+
+func TestCopyObjectAcrossBackendsEnforcesSourceRegion(t *testing.T) {
+	backends := newFakeBackends()
+	backends.objects[fmt.Sprintf("%s/%s/src", testSourceBackendHost, testBucketName)] = []byte("the content")
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowAllInWaw31ARN: testPolicyAllowAllInWaw31})
+	hb := handlerBuilder{proxyFunc: justProxy, requester: backends.requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When copying into an allowed region from a region that the policy does not allow
+	_, err := runCopyObjectAcrossBackendsWithRole(t, s, testPolicyAllowAllInWaw31ARN, fmt.Sprintf("%s/src", testBucketName), "dst")
+
+	//Then access is denied
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Expected AccessDenied, got %v", err)
+	}
+	//And the source object is not read nor is anything written to the destination backend
+	for _, request := range backends.requests {
+		if !strings.HasPrefix(request, fmt.Sprintf("GET %s/%s/src?tagging=", testSourceBackendHost, testBucketName)) {
+			t.Errorf("Denied copy should at most retrieve the tags of the source, got %s", request)
+		}
+	}
+}
+
+func TestCopyObjectAcrossBackendsKeepsGzipEncodedObjectsAsIs(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write([]byte("the content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	backends := newFakeBackends()
+	sourceId := fmt.Sprintf("%s/%s/src.gz", testSourceBackendHost, testBucketName)
+	backends.objects[sourceId] = compressed.Bytes()
+	backends.objectHeaders[sourceId] = http.Header{"Content-Type": {"text/plain"}, "Content-Encoding": {"gzip"}}
+	hb := handlerBuilder{proxyFunc: justProxy, requester: backends.requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When copying an object that is stored gzip encoded from a backend in another region
+	_, err = runCopyObjectAcrossBackends(t, s, fmt.Sprintf("%s/src.gz", testBucketName), "dst.gz")
+
+	//Then the copy succeeds
+	if err != nil {
+		t.Fatalf("Copy across backends failed: %s", err)
+	}
+	//And the compressed bytes are written to the destination backend with the same Content-Encoding
+	destinationId := fmt.Sprintf("%s/%s/dst.gz", testDestinationBackendHost, testBucketName)
+	if !bytes.Equal(backends.objects[destinationId], compressed.Bytes()) {
+		t.Errorf("Destination content differs from the compressed source %q, requests: %v", backends.objects[destinationId], backends.requests)
+	}
+	if backends.objectHeaders[destinationId].Get("Content-Encoding") != "gzip" {
+		t.Errorf("Content-Encoding was not copied, got %v", backends.objectHeaders[destinationId])
+	}
+}
+
+func TestCopyObjectRejectsInvalidSourceRegions(t *testing.T) {
+	backends := newFakeBackends()
+	backends.objects[fmt.Sprintf("%s/%s/src", testSourceBackendHost, testBucketName)] = []byte("the content")
+	hb := handlerBuilder{proxyFunc: justProxy, requester: backends.requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	var testCases = []struct {
+		Description     string
+		AddSourceRegion func(*smithymiddleware.Stack) error
+	}{
+		{"Unknown region", smithyhttp.AddHeaderValue(constants.CopySourceRegion, "us-east-1")},
+		{"Unsigned region", setHeaderAfterSigning(constants.CopySourceRegion, "eu-nl")},
+	}
+	for _, tc := range testCases {
+		backends.requests = nil
+		//When copying with a source region that cannot be trusted
+		_, err := runCopyObjectWithSourceRegion(t, s, testPolicyAllowAllARN, fmt.Sprintf("%s/src", testBucketName), "dst", tc.AddSourceRegion)
+
+		//Then the request is refused as invalid
+		if err == nil || !strings.Contains(err.Error(), "InvalidArgument") {
+			t.Errorf("%s: expected InvalidArgument, got %v", tc.Description, err)
+		}
+		//And no backend is contacted
+		if len(backends.requests) != 0 {
+			t.Errorf("%s: invalid copy should not reach the backends, got %v", tc.Description, backends.requests)
+		}
+	}
+}
+
+var testPolicyAllowAllButClassifiedCopiesARN = "arn:aws:iam::000000000000:role/AllowAllButClassifiedCopies"
+var testPolicyAllowAllButClassifiedCopies = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "*",
+			"Resource": "*"
+		},
+		{
+			"Effect": "Deny",
+			"Action": "%s",
+			"Resource": "*",
+			"Condition": {"StringEquals": {"%sclassification": "secret"}}
+		},
+		{
+			"Effect": "Deny",
+			"Action": "%s",
+			"Resource": "*",
+			"Condition": {"StringEquals": {"%sclassification": "confidential"}}
+		}
+	]
+}`, actionnames.IAMActionS3GetObject, actionnames.IAMConditionS3ExistingObjectTagPrefix,
+	actionnames.IAMActionS3PutObject, actionnames.IAMConditionS3RequestObjectTagPrefix)
+
+func TestCopyObjectAcrossBackendsCopiesTagsOfSource(t *testing.T) {
+	backends := newFakeBackends()
+	for _, classification := range []string{"public", "confidential", "secret"} {
+		sourceId := fmt.Sprintf("%s/%s/%s", testSourceBackendHost, testBucketName, classification)
+		backends.objects[sourceId] = []byte("the content")
+		backends.objectTags[sourceId] = classification
+	}
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowAllButClassifiedCopiesARN: testPolicyAllowAllButClassifiedCopies})
+	hb := handlerBuilder{proxyFunc: justProxy, requester: backends.requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	var testCases = []struct {
+		Classification string
+		ExpectAllowed  bool
+	}{
+		{"public", true},
+		{"confidential", false}, //Denied by a condition on the tags of the destination
+		{"secret", false},       //Denied by a condition on the tags of the source
+	}
+	for _, tc := range testCases {
+		//When copying a tagged object across backends with the default tagging directive
+		_, err := runCopyObjectAcrossBackendsWithRole(t, s, testPolicyAllowAllButClassifiedCopiesARN, fmt.Sprintf("%s/%s", testBucketName, tc.Classification), "dst-"+tc.Classification)
+
+		destinationId := fmt.Sprintf("%s/%s/dst-%s", testDestinationBackendHost, testBucketName, tc.Classification)
+		if tc.ExpectAllowed {
+			//Then the copy succeeds and the destination gets the tags of the source
+			if err != nil {
+				t.Errorf("%s: copy across backends failed: %s", tc.Classification, err)
+				continue
+			}
+			if tagging := backends.objectHeaders[destinationId].Get("X-Amz-Tagging"); tagging != "classification="+tc.Classification {
+				t.Errorf("%s: tags of the source were not copied, got %q", tc.Classification, tagging)
+			}
+		} else {
+			//Then the tags of the source are taken into account for authorization
+			if !isAccessDenied(err) {
+				t.Errorf("%s: expected AccessDenied, got %v", tc.Classification, err)
+			}
+			if _, written := backends.objects[destinationId]; written {
+				t.Errorf("%s: denied copy was written to the destination", tc.Classification)
+			}
+		}
+	}
+}
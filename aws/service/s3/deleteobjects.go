@@ -0,0 +1,299 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5" // #nosec G501 -- Content-MD5 is mandated by the S3 API for DeleteObjects
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/service"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/constants"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/usererror"
+)
+
+// The namespace used by S3 for its XML documents
+const s3XMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObjects.html
+// The request can contain a list of up to 1000 keys that you want to delete.
+const maxDeleteObjectsKeys = 1000
+
+// Keys are at most 1024 bytes so this leaves ample room for the XML structure
+const maxDeleteObjectsBodyBytes = 4 * 1024 * 1024
+
+// The requestctx data key under which keys that were denied during authorization are kept
+const deniedDeleteObjectsKey = "deniedDeleteObjects"
+
+var errMalformedXML = errors.New("malformed XML")
+var errPayloadHashMismatch = errors.New("payload does not match x-amz-content-sha256")
+
+type deleteObjectIdentifier struct {
+	Key              string `xml:"Key"`
+	VersionId        string `xml:"VersionId,omitempty"`
+	ETag             string `xml:"ETag,omitempty"`
+	LastModifiedTime string `xml:"LastModifiedTime,omitempty"`
+	Size             string `xml:"Size,omitempty"`
+}
+
+type deleteObjectsRequest struct {
+	XMLName xml.Name                 `xml:"Delete"`
+	Objects []deleteObjectIdentifier `xml:"Object"`
+	Quiet   bool                     `xml:"Quiet,omitempty"`
+}
+
+type deletedObject struct {
+	Key                   string `xml:"Key"`
+	VersionId             string `xml:"VersionId,omitempty"`
+	DeleteMarker          string `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionId string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+type deleteError struct {
+	Key       string `xml:"Key"`
+	VersionId string `xml:"VersionId,omitempty"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+	Errors  []deleteError   `xml:"Error"`
+}
+
+func newDeleteResult() deleteResult {
+	return deleteResult{
+		XMLName: xml.Name{Space: s3XMLNamespace, Local: "DeleteResult"},
+	}
+}
+
+func isHexSha256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// Read the full body of a request and put it back such that it can be read again later on.
+func readAndRestoreBody(r *http.Request, maxBytes int64) ([]byte, error) {
+	if r.Body == nil {
+		return []byte{}, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBytes {
+		return nil, usererror.New(
+			fmt.Errorf("%w: body exceeds %d bytes", errMalformedXML, maxBytes),
+			"The request body is too large",
+		)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// Read the body of a request that must be inspected during authorization. The body remains available on the request.
+// Because authorization depends on the body, the payload is checked against the signed payload hash.
+func readSignedRequestBody(r *http.Request, maxBytes int64) ([]byte, error) {
+	payloadHash := r.Header.Get(constants.AmzContentSHAKey)
+	if strings.HasPrefix(payloadHash, "STREAMING-") {
+		return nil, usererror.New(
+			fmt.Errorf("%w: streaming payload %s", errMalformedXML, payloadHash),
+			"Streaming payloads are not supported for this operation",
+		)
+	}
+	body, err := readAndRestoreBody(r, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if isHexSha256(payloadHash) && sha256Hex(body) != strings.ToLower(payloadHash) {
+		return nil, errPayloadHashMismatch
+	}
+	return body, nil
+}
+
+// Get the parsed body of a DeleteObjects request. The body remains available on the request.
+func getDeleteObjectsRequest(r *http.Request) (*deleteObjectsRequest, error) {
+	body, err := readSignedRequestBody(r, maxDeleteObjectsBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	deleteReq := &deleteObjectsRequest{}
+	err = xml.Unmarshal(body, deleteReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errMalformedXML, err)
+	}
+	if len(deleteReq.Objects) == 0 || len(deleteReq.Objects) > maxDeleteObjectsKeys {
+		return nil, fmt.Errorf("%w: got %d keys", errMalformedXML, len(deleteReq.Objects))
+	}
+	return deleteReq, nil
+}
+
+// Replace the body of a DeleteObjects request. Headers that are derived from the payload
+// are updated and client-side checksums are dropped since they no longer apply.
+func setDeleteObjectsRequestBody(r *http.Request, deleteReq *deleteObjectsRequest) error {
+	var buf bytes.Buffer
+	//The tag of XMLName has no namespace such that requests without one are accepted but backends can require it
+	err := xml.NewEncoder(&buf).EncodeElement(deleteReq, xml.StartElement{Name: xml.Name{Space: s3XMLNamespace, Local: "Delete"}})
+	if err != nil {
+		return err
+	}
+	body := buf.Bytes()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	if r.Header.Get("Content-Length") != "" {
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	md5Sum := md5.Sum(body) // #nosec G401 -- Content-MD5 is mandated by the S3 API for DeleteObjects
+	r.Header.Set("Content-Md5", base64.StdEncoding.EncodeToString(md5Sum[:]))
+	if isHexSha256(r.Header.Get(constants.AmzContentSHAKey)) {
+		r.Header.Set(constants.AmzContentSHAKey, sha256Hex(body))
+	}
+	for headerName := range r.Header {
+		if strings.HasPrefix(headerName, "X-Amz-Checksum-") || headerName == "X-Amz-Sdk-Checksum-Algorithm" {
+			r.Header.Del(headerName)
+		}
+	}
+	return nil
+}
+
+// Authorize every key of a DeleteObjects request on its own. Denied keys are removed from the request
+// that goes upstream and are returned such that they can be reported as per-key errors.
+func authorizeDeleteObjectsPerKey(ctx context.Context, pe *iam.PolicyEvaluator, iamActions []iam.IAMAction, r *http.Request) (denied []deleteError, allowed int, err error) {
+	deleteReq, err := getDeleteObjectsRequest(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(deleteReq.Objects) != len(iamActions) {
+		return nil, 0, fmt.Errorf("got %d IAM actions for %d keys", len(iamActions), len(deleteReq.Objects))
+	}
+	allowedObjects := []deleteObjectIdentifier{}
+	var deniedExplanations []iam.ActionExplanation
+	for i, object := range deleteReq.Objects {
+		result, err := pe.Explain(iamActions[i])
+		if err != nil {
+			return nil, 0, err
+		}
+		if result.Allowed {
+			allowedObjects = append(allowedObjects, object)
+		} else {
+			slog.DebugContext(ctx, "Denied deletion of key", "reason", result.Reason, "key", object.Key)
+			deniedExplanations = append(deniedExplanations, iam.ActionExplanation{
+				Action:           iamActions[i].Action,
+				Resource:         iamActions[i].Resource,
+				EvaluationResult: result,
+			})
+			s3Err := s3ErrCodes.ToS3Err(ErrS3AccessDenied)
+			denied = append(denied, deleteError{
+				Key:       object.Key,
+				VersionId: object.VersionId,
+				Code:      s3Err.Code,
+				Message:   s3Err.Description,
+			})
+		}
+	}
+	if len(deniedExplanations) > 0 {
+		requestctx.AddAccessLogInfo(r, "authz", slog.Any("decisions", deniedExplanations))
+	}
+	if len(denied) > 0 && len(allowedObjects) > 0 {
+		deleteReq.Objects = allowedObjects
+		err = setDeleteObjectsRequestBody(r, deleteReq)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return denied, len(allowedObjects), nil
+}
+
+// Authorize a DeleteObjects request. Unlike other operations a partial denial does not fail the request.
+// If no key is allowed the response is written directly as no upstream request is needed.
+func authorizeDeleteObjects(ctx context.Context, pe *iam.PolicyEvaluator, iamActions []iam.IAMAction, w http.ResponseWriter, r *http.Request) (allowed bool) {
+	denied, allowedKeys, err := authorizeDeleteObjectsPerKey(ctx, pe, iamActions, r)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not authorize keys of DeleteObjects", "error", err)
+		writeS3ErrorResponse(ctx, w, ErrS3InternalError, nil)
+		return false
+	}
+	requestctx.AddAccessLogInfo(r, "s3", slog.Int("DeniedKeys", len(denied)))
+	if allowedKeys == 0 {
+		result := newDeleteResult()
+		result.Errors = denied
+		service.WriteSuccessResponseXML(ctx, w, service.EncodeResponse(ctx, result))
+		return false
+	}
+	if len(denied) > 0 {
+		rCtx, ok := requestctx.FromContext(ctx)
+		if !ok {
+			slog.ErrorContext(ctx, "Could not keep track of denied keys without request context")
+			writeS3ErrorResponse(ctx, w, ErrS3InternalError, nil)
+			return false
+		}
+		rCtx.SetDataKey(deniedDeleteObjectsKey, denied)
+	}
+	return true
+}
+
+// Get the keys that were denied during authorization of a DeleteObjects request
+func getDeniedDeleteObjects(r *http.Request) []deleteError {
+	rCtx, ok := requestctx.FromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	v, err := rCtx.GetData(deniedDeleteObjectsKey)
+	if err != nil {
+		return nil
+	}
+	denied, ok := v.([]deleteError)
+	if !ok {
+		return nil
+	}
+	return denied
+}
+
+func mergeDeniedIntoDeleteResult(upstreamBody []byte, denied []deleteError) ([]byte, error) {
+	result := newDeleteResult()
+	err := xml.Unmarshal(upstreamBody, &result)
+	if err != nil {
+		return nil, err
+	}
+	result.Errors = append(result.Errors, denied...)
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	err = xml.NewEncoder(&buf).Encode(result)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Add the keys that were denied by the proxy to the upstream DeleteResult. If the upstream response
+// cannot be decoded the request fails since passing it on would hide the denied keys.
+func addDeniedToDeleteResult(ctx context.Context, denied []deleteError) func([]byte) ([]byte, error) {
+	return func(upstreamBody []byte) ([]byte, error) {
+		merged, err := mergeDeniedIntoDeleteResult(upstreamBody, denied)
+		if err != nil {
+			slog.ErrorContext(ctx, "Could not add denied keys to upstream DeleteResult", "error", err)
+			return nil, fmt.Errorf("could not add denied keys to upstream DeleteResult: %w", err)
+		}
+		return merged, nil
+	}
+}
@@ -0,0 +1,226 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/aws/service"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/testutils"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithymiddleware "github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// The keys that reached the backend during the last DeleteObjects request
+var keysReceivedByDeleteObjectsStub []string
+
+// A stub backend that deletes all the keys that it receives.
+var deleteObjectsStub = func(ctx context.Context, w http.ResponseWriter, r *http.Request, backendId string, bm interfaces.BackendManager, f requesterFunc, ch interfaces.CORSHandler) {
+	keysReceivedByDeleteObjectsStub = []string{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	deleteReq := deleteObjectsRequest{}
+	err = xml.Unmarshal(body, &deleteReq)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	result := newDeleteResult()
+	for _, object := range deleteReq.Objects {
+		keysReceivedByDeleteObjectsStub = append(keysReceivedByDeleteObjectsStub, object.Key)
+		result.Deleted = append(result.Deleted, deletedObject{Key: object.Key})
+	}
+	service.WriteSuccessResponseXML(ctx, w, service.EncodeResponse(ctx, result))
+}
+
+var testStubDeleteObjects interfaces.HandlerBuilderI = handlerBuilder{proxyFunc: deleteObjectsStub}
+
+// The request that reached the backend during the last DeleteObjects request
+var requestReceivedByDeleteObjectsStub *http.Request
+var bodyReceivedByDeleteObjectsStub []byte
+
+// A stub backend that answers with a gzip encoded body if the client accepts it
+var gzipDeleteObjectsStub = func(ctx context.Context, w http.ResponseWriter, r *http.Request, backendId string, bm interfaces.BackendManager, f requesterFunc, ch interfaces.CORSHandler) {
+	requestReceivedByDeleteObjectsStub = r
+	bodyReceivedByDeleteObjectsStub, _ = io.ReadAll(r.Body)
+	result, err := xml.Marshal(newDeleteResult())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		_, _ = gz.Write(result)
+		_ = gz.Close()
+		result = compressed.Bytes()
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(result)
+}
+
+var testPolicyAllowDeleteInPrefixARN = "arn:aws:iam::000000000000:role/AllowDeleteInPrefix"
+var testPolicyAllowDeleteInPrefix = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/allowed/*"
+		}
+	]
+}`, actionnames.IAMActionS3DeleteObject, testBucketARN)
+
+func runDeleteObjects(t *testing.T, s *S3Server, keys ...string) *s3.DeleteObjectsOutput {
+	output, err := tryDeleteObjects(t, s, nil, keys...)
+	if err != nil {
+		t.Fatalf("DeleteObjects should not fail as a whole, got %s", err)
+	}
+	return output
+}
+
+func tryDeleteObjects(t *testing.T, s *S3Server, optFns []func(*s3.Options), keys ...string) (*s3.DeleteObjectsOutput, error) {
+	cred := createTestCredentialsForPolicy(t, testPolicyAllowDeleteInPrefixARN, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "eu-west-1", cred, s)
+
+	objects := []types.ObjectIdentifier{}
+	for _, key := range keys {
+		objects = append(objects, types.ObjectIdentifier{Key: &key})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: &testBucketName,
+		Delete: &types.Delete{Objects: objects},
+	}, optFns...)
+}
+
+// Let the client accept gzip encoded responses which the SDK does not do by default
+func acceptGzip(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *smithymiddleware.Stack) error {
+		return stack.Finalize.Insert(smithymiddleware.FinalizeMiddlewareFunc("AcceptGzip", func(
+			ctx context.Context, in smithymiddleware.FinalizeInput, next smithymiddleware.FinalizeHandler,
+		) (smithymiddleware.FinalizeOutput, smithymiddleware.Metadata, error) {
+			if req, ok := in.Request.(*smithyhttp.Request); ok {
+				req.Header.Set("Accept-Encoding", "gzip")
+			}
+			return next.HandleFinalize(ctx, in)
+		}), "Signing", smithymiddleware.Before)
+	})
+}
+
+func getDeletedKeys(output *s3.DeleteObjectsOutput) (deleted []string, denied []string) {
+	for _, d := range output.Deleted {
+		deleted = append(deleted, *d.Key)
+	}
+	for _, e := range output.Errors {
+		if *e.Code == "AccessDenied" {
+			denied = append(denied, *e.Key)
+		}
+	}
+	return
+}
+
+func TestDeleteObjectsOnlyForwardsAllowedKeys(t *testing.T) {
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowDeleteInPrefixARN: testPolicyAllowDeleteInPrefix})
+	teardownSuite, s := setupSuiteProxyS3(t, testStubDeleteObjects, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When deleting keys of which only some are allowed
+	output := runDeleteObjects(t, s, "allowed/a", "denied/b", "allowed/c")
+
+	//Then only the allowed keys reach the backend
+	if !slices.Equal(keysReceivedByDeleteObjectsStub, []string{"allowed/a", "allowed/c"}) {
+		t.Errorf("Backend received unexpected keys: %v", keysReceivedByDeleteObjectsStub)
+	}
+	//And the denied keys are reported as per-key errors
+	deleted, denied := getDeletedKeys(output)
+	if !slices.Equal(deleted, []string{"allowed/a", "allowed/c"}) {
+		t.Errorf("Unexpected deleted keys: %v", deleted)
+	}
+	if !slices.Equal(denied, []string{"denied/b"}) {
+		t.Errorf("Unexpected denied keys: %v", denied)
+	}
+}
+
+func TestDeleteObjectsAllKeysDeniedDoesNotGoUpstream(t *testing.T) {
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowDeleteInPrefixARN: testPolicyAllowDeleteInPrefix})
+	teardownSuite, s := setupSuiteProxyS3(t, testStubDeleteObjects, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+	keysReceivedByDeleteObjectsStub = nil
+
+	//When deleting keys of which none are allowed
+	output := runDeleteObjects(t, s, "denied/a", "denied/b")
+
+	//Then the backend is not contacted
+	if keysReceivedByDeleteObjectsStub != nil {
+		t.Errorf("Backend should not have been called but received: %v", keysReceivedByDeleteObjectsStub)
+	}
+	//And all keys are reported as denied
+	deleted, denied := getDeletedKeys(output)
+	if len(deleted) != 0 {
+		t.Errorf("Unexpected deleted keys: %v", deleted)
+	}
+	if !slices.Equal(denied, []string{"denied/a", "denied/b"}) {
+		t.Errorf("Unexpected denied keys: %v", denied)
+	}
+}
+
+func TestDeleteObjectsRewrittenRequestIsNamespacedAndUncompressed(t *testing.T) {
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowDeleteInPrefixARN: testPolicyAllowDeleteInPrefix})
+	teardownSuite, s := setupSuiteProxyS3(t, handlerBuilder{proxyFunc: gzipDeleteObjectsStub}, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When deleting keys of which only some are allowed by a client that accepts gzip
+	output, err := tryDeleteObjects(t, s, []func(*s3.Options){acceptGzip}, "allowed/a", "denied/b")
+	if err != nil {
+		t.Fatalf("DeleteObjects should not fail as a whole, got %s", err)
+	}
+
+	//Then the rewritten body keeps the S3 namespace
+	if !strings.Contains(string(bodyReceivedByDeleteObjectsStub), fmt.Sprintf(`<Delete xmlns="%s">`, s3XMLNamespace)) {
+		t.Errorf("Rewritten body lost the S3 namespace: %s", bodyReceivedByDeleteObjectsStub)
+	}
+	//And the backend is not asked for a compressed response
+	if requestReceivedByDeleteObjectsStub.Header.Get("Accept-Encoding") != "" {
+		t.Errorf("Accept-Encoding must be removed, got %q", requestReceivedByDeleteObjectsStub.Header.Get("Accept-Encoding"))
+	}
+	//And the denied key is reported
+	if _, denied := getDeletedKeys(output); !slices.Equal(denied, []string{"denied/b"}) {
+		t.Errorf("Unexpected denied keys: %v", denied)
+	}
+}
+
+func TestDeleteObjectsFailsIfDeniedKeysCannotBeReported(t *testing.T) {
+	invalidResultStub := func(ctx context.Context, w http.ResponseWriter, r *http.Request, backendId string, bm interfaces.BackendManager, f requesterFunc, ch interfaces.CORSHandler) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("not a DeleteResult"))
+	}
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowDeleteInPrefixARN: testPolicyAllowDeleteInPrefix})
+	teardownSuite, s := setupSuiteProxyS3(t, handlerBuilder{proxyFunc: invalidResultStub}, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When the backend response cannot be decoded while some keys were denied
+	_, err := tryDeleteObjects(t, s, nil, "allowed/a", "denied/b")
+
+	//Then the request fails rather than hiding the denied keys
+	if err == nil || !strings.Contains(err.Error(), "InternalError") {
+		t.Errorf("Expected InternalError, got %v", err)
+	}
+}
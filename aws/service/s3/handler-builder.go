@@ -31,6 +31,26 @@ type handlerBuilder struct {
 
 var handlerBuilderToJustProxy interfaces.HandlerBuilderI = handlerBuilder{proxyFunc: justProxy, requester: defaultRequester}
 
+// Handler builders that expose how they perform upstream requests such that middleware can do the same
+type requesterProvider interface {
+	getRequester() requesterFunc
+}
+
+func (hb handlerBuilder) getRequester() requesterFunc {
+	if hb.requester == nil {
+		return defaultRequester
+	}
+	return hb.requester
+}
+
+// Get the function that performs upstream requests for a handler builder
+func getRequesterOfHandlerBuilder(hb interfaces.HandlerBuilderI) requesterFunc {
+	if provider, ok := hb.(requesterProvider); ok {
+		return provider.getRequester()
+	}
+	return defaultRequester
+}
+
 func getS3Action(r *http.Request) api.S3Operation {
 	action, actionOk := requestctx.GetOperation(r).(api.S3Operation)
 	if !actionOk {
@@ -63,6 +83,40 @@ func defaultRequester(r *http.Request) (*http.Response, error) {
 
 func justProxy(ctx context.Context, w http.ResponseWriter, r *http.Request, targetBackendId string, backendManager interfaces.BackendManager,
 	requester requesterFunc, corsHandler interfaces.CORSHandler) {
+	action := getS3Action(r)
+	if action == api.GetBucketLocation {
+		getBucketLocation(ctx, w, r, targetBackendId, backendManager, requester, corsHandler)
+		return
+	}
+	if action == api.ListBuckets && backendManager.AggregatesListBuckets() {
+		listBucketsAcrossBackends(ctx, w, r, targetBackendId, backendManager, requester, corsHandler)
+		return
+	}
+	if action == api.ListObjectsV2 {
+		if filter := getListObjectsFilter(r); filter != nil {
+			listObjectsV2Filtered(ctx, w, r, targetBackendId, backendManager, requester, corsHandler, filter)
+			return
+		}
+	}
+	if action == api.PostObject {
+		postObject(ctx, w, r, targetBackendId, backendManager, requester, corsHandler)
+		return
+	}
+	if action == api.CopyObject || action == api.UploadPartCopy {
+		sourceBackendId := r.Header.Get(constants.CopySourceRegion)
+		r.Header.Del(constants.CopySourceRegion)
+		if sourceBackendId != "" && sourceBackendId != targetBackendId {
+			if action == api.UploadPartCopy {
+				writeS3ErrorResponse(ctx, w, ErrS3NotImplemented, usererror.New(
+					errors.New("UploadPartCopy across backends"),
+					"UploadPartCopy is not supported across backends, use CopyObject instead",
+				))
+				return
+			}
+			copyObjectAcrossBackends(ctx, w, r, sourceBackendId, targetBackendId, backendManager, requester, corsHandler)
+			return
+		}
+	}
 	err := reTargetRequest(ctx, r, targetBackendId, backendManager)
 	if err == errInvalidBackendErr {
 		slog.WarnContext(ctx, "Invalid region was specified in the request", "error", err, "backendId", targetBackendId)
@@ -5,16 +5,25 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/VITObelgium/fakes3pp/aws/service/iam"
 	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
 	"github.com/VITObelgium/fakes3pp/aws/service/s3/api"
 	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/constants"
+	"github.com/VITObelgium/fakes3pp/presign"
 	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/usererror"
 	"github.com/micahhausler/aws-iam-policy/policy"
 )
 
+// Requests for operations that the proxy does not know are refused rather than proxied
+var errUnsupportedOperation = errors.New("cannot get IAM actions due to unsupported api action")
+
 func makeS3BucketArn(bucketName string) string {
 	return fmt.Sprintf("arn:aws:s3:::%s", bucketName)
 }
@@ -25,7 +34,7 @@ func makeS3ObjectArn(bucketName, objectKey string) string {
 
 func getS3ObjectFromRequest(req *http.Request, vhi interfaces.VirtualHosterIdentifier) (bucketName string, objectKey string, err error) {
 	if vhi.IsVirtualHostingRequest(req) {
-		return "", "", errors.New("virtual hosting not supported try path-style request")
+		return "", "", fmt.Errorf("host %s is not an FQDN of the proxy, configure a wildcard FQDN to allow virtual-hosted-style requests", req.Host)
 	} else {
 		//Path-style request
 		if !strings.HasPrefix(req.URL.Path, "/") {
@@ -49,6 +58,87 @@ func getS3BucketFromRequest(req *http.Request, vhi interfaces.VirtualHosterIdent
 	return bucketName, nil
 }
 
+// Get the source object of a copy operation as specified in the x-amz-copy-source header.
+// The header has the form bucket/key or /bucket/key with the key URL-encoded and
+// optionally a versionId query part (e.g. /bucket/key?versionId=123).
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CopyObject.html#API_CopyObject_RequestSyntax
+func getS3CopySourceFromRequest(req *http.Request) (bucketName, objectKey, versionId string, err error) {
+	copySource := req.Header.Get(constants.AmzCopySource)
+	if copySource == "" {
+		return "", "", "", errors.New("no copy source specified")
+	}
+	sourcePath, sourceQuery, _ := strings.Cut(copySource, "?")
+	if sourceQuery != "" {
+		queryValues, err := url.ParseQuery(sourceQuery)
+		if err != nil {
+			return "", "", "", fmt.Errorf("invalid query in copy source %s: %w", copySource, err)
+		}
+		versionId = queryValues.Get("versionId")
+	}
+	sourcePath, err = url.PathUnescape(sourcePath)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid copy source %s: %w", copySource, err)
+	}
+	bucketName, objectKey, found := strings.Cut(strings.TrimPrefix(sourcePath, "/"), "/")
+	if !found || bucketName == "" || objectKey == "" {
+		return "", "", "", usererror.New(
+			fmt.Errorf("copy source %s is not of form bucket/key", copySource),
+			"The copy source must be of form bucket/key",
+		)
+	}
+	return bucketName, objectKey, versionId, nil
+}
+
+// Get the condition keys of requests that list the content of a bucket.
+// s3:prefix is always set (empty if not specified) while s3:delimiter and s3:max-keys
+// are only set when they are part of the request.
+// https://docs.aws.amazon.com/service-authorization/latest/reference/list_amazons3.html#amazons3-policy-keys
+func getListBucketContext(req *http.Request) map[string]*policy.ConditionValue {
+	query := req.URL.Query()
+	context := map[string]*policy.ConditionValue{
+		actionnames.IAMConditionS3Prefix: policy.NewConditionValueString(true, query.Get("prefix")),
+	}
+	if query.Has("delimiter") {
+		context[actionnames.IAMConditionS3Delimiter] = policy.NewConditionValueString(true, query.Get("delimiter"))
+	}
+	if query.Has("max-keys") {
+		context[actionnames.IAMConditionS3MaxKeys] = policy.NewConditionValueString(true, query.Get("max-keys"))
+	}
+	return context
+}
+
+// Requests that target a specific version of an object require a version specific IAM action.
+// This allows policies to grant access to the current version without granting access to its history.
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/using-with-s3-policy-actions.html
+func selectVersionAction(versionId, action, versionAction string) string {
+	if versionId != "" {
+		return versionAction
+	}
+	return action
+}
+
+// The IAM actions to write an object with the given tags
+func newPutObjectIamActions(bucket, key string, tags map[string]string, session *iam.PolicySessionData) []iam.IAMAction {
+	tagContext := getObjectTagContext(actionnames.IAMConditionS3RequestObjectTagPrefix, tags)
+	actions := []iam.IAMAction{
+		iam.NewIamAction(
+			actionnames.IAMActionS3PutObject,
+			makeS3ObjectArn(bucket, key),
+			session,
+		).AddContext(tagContext),
+	}
+	if tags != nil {
+		// https://docs.aws.amazon.com/AmazonS3/latest/userguide/tagging-and-policies.html
+		// Adding tags while uploading also requires permission to tag the object
+		actions = append(actions, iam.NewIamAction(
+			actionnames.IAMActionS3PutObjectTagging,
+			makeS3ObjectArn(bucket, key),
+			session,
+		).AddContext(tagContext))
+	}
+	return actions
+}
+
 // Buid a new IAM action based out of an HTTP Request. The IAM action should resemble the required
 // Permissions. The api_action is passed in as a string argument
 func newIamActionsFromS3Request(api_action api.S3Operation, req *http.Request, session *iam.PolicySessionData, vhi interfaces.VirtualHosterIdentifier) (actions []iam.IAMAction, err error) {
@@ -58,53 +148,221 @@ func newIamActionsFromS3Request(api_action api.S3Operation, req *http.Request, s
 	switch api_action {
 	// https://docs.aws.amazon.com/AmazonS3/latest/userguide/mpuoverview.html
 	case api.PutObject, api.CreateMultipartUpload, api.CompleteMultipartUpload, api.UploadPart:
+		bucket, key, err := getS3ObjectFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		tags, err := getTagsFromTaggingHeader(req)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, newPutObjectIamActions(bucket, key, tags, session)...)
+	case api.PostObject:
+		// The key is a form field that was read and checked against the POST policy during authentication
+		bucket, err := getS3BucketFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		form, err := presign.GetPostPolicyForm(req)
+		if err != nil {
+			return nil, fmt.Errorf("could not get POST form: %w", err)
+		}
+		tags, err := getTagsFromPostForm(form)
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, newPutObjectIamActions(bucket, form.GetKey(), tags, session)...)
+	case api.GetObject, api.HeadObject:
 		bucket, key, err := getS3ObjectFromRequest(req, vhi)
 		if err != nil {
 			return nil, err
 		}
 		a := iam.NewIamAction(
-			actionnames.IAMActionS3PutObject,
+			selectVersionAction(req.URL.Query().Get("versionId"), actionnames.IAMActionS3GetObject, actionnames.IAMActionS3GetObjectVersion),
 			makeS3ObjectArn(bucket, key),
 			session,
 		)
 		actions = append(actions, a)
-	case api.GetObject, api.HeadObject:
+	case api.ListObjectsV2, api.ListObjects:
+		bucket, _, err := getS3ObjectFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		a := iam.NewIamAction(
+			actionnames.IAMActionS3ListBucket,
+			makeS3BucketArn(bucket),
+			session,
+		).AddContext(getListBucketContext(req))
+		actions = append(actions, a)
+	case api.ListObjectVersions:
+		bucket, _, err := getS3ObjectFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		a := iam.NewIamAction(
+			actionnames.IAMActionS3ListBucketVersions,
+			makeS3BucketArn(bucket),
+			session,
+		).AddContext(getListBucketContext(req))
+		actions = append(actions, a)
+	case api.AbortMultipartUpload:
 		bucket, key, err := getS3ObjectFromRequest(req, vhi)
 		if err != nil {
 			return nil, err
 		}
 		a := iam.NewIamAction(
-			actionnames.IAMActionS3GetObject,
+			actionnames.IAMActionS3AbortMultipartUpload,
 			makeS3ObjectArn(bucket, key),
 			session,
 		)
 		actions = append(actions, a)
-	case api.ListObjectsV2:
-		bucket, _, err := getS3ObjectFromRequest(req, vhi)
+	case api.CopyObject, api.UploadPartCopy:
+		// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CopyObject.html
+		// You must have read access to the source object and write access to the destination
+		srcBucket, srcKey, srcVersionId, err := getS3CopySourceFromRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		bucket, key, err := getS3ObjectFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		sourceAction := iam.NewIamAction(
+			selectVersionAction(srcVersionId, actionnames.IAMActionS3GetObject, actionnames.IAMActionS3GetObjectVersion),
+			makeS3ObjectArn(srcBucket, srcKey),
+			session,
+		)
+		//For a copy across backends the source object is read in the region of the source backend
+		if srcRegion := req.Header.Get(constants.CopySourceRegion); srcRegion != "" {
+			sourceAction = sourceAction.AddContext(map[string]*policy.ConditionValue{
+				"aws:RequestedRegion": policy.NewConditionValueString(true, srcRegion),
+			})
+		}
+		//A copied object gets the tags of the request with the REPLACE tagging directive. Otherwise it gets the tags
+		//of the source which are only known when the proxy performs the copy across backends.
+		var destinationTags map[string]string
+		if api_action == api.CopyObject && strings.EqualFold(req.Header.Get("X-Amz-Tagging-Directive"), "REPLACE") {
+			destinationTags, err = getTagsFromTaggingHeader(req)
+			if err != nil {
+				return nil, err
+			}
+		} else if sourceTags := getCopySourceTags(req); sourceTags != nil {
+			sourceAction = sourceAction.AddContext(getObjectTagContext(actionnames.IAMConditionS3ExistingObjectTagPrefix, sourceTags))
+			if len(sourceTags) > 0 {
+				destinationTags = sourceTags
+			}
+		}
+		actions = append(actions, sourceAction)
+		actions = append(actions, newPutObjectIamActions(bucket, key, destinationTags, session)...)
+	case api.DeleteObject:
+		bucket, key, err := getS3ObjectFromRequest(req, vhi)
 		if err != nil {
 			return nil, err
 		}
 		a := iam.NewIamAction(
-			actionnames.IAMActionS3ListBucket,
+			selectVersionAction(req.URL.Query().Get("versionId"), actionnames.IAMActionS3DeleteObject, actionnames.IAMActionS3DeleteObjectVersion),
+			makeS3ObjectArn(bucket, key),
+			session,
+		)
+		actions = append(actions, a)
+	case api.DeleteObjects:
+		// https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObjects.html
+		// Each key is authorized on its own such that denied keys can be reported per key.
+		bucket, err := getS3BucketFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		deleteReq, err := getDeleteObjectsRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range deleteReq.Objects {
+			a := iam.NewIamAction(
+				selectVersionAction(object.VersionId, actionnames.IAMActionS3DeleteObject, actionnames.IAMActionS3DeleteObjectVersion),
+				makeS3ObjectArn(bucket, object.Key),
+				session,
+			)
+			actions = append(actions, a)
+		}
+	case api.GetObjectTagging, api.DeleteObjectTagging:
+		bucket, key, err := getS3ObjectFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		versionId := req.URL.Query().Get("versionId")
+		iamAction := selectVersionAction(versionId, actionnames.IAMActionS3GetObjectTagging, actionnames.IAMActionS3GetObjectVersionTagging)
+		if api_action == api.DeleteObjectTagging {
+			iamAction = selectVersionAction(versionId, actionnames.IAMActionS3DeleteObjectTagging, actionnames.IAMActionS3DeleteObjectVersionTagging)
+		}
+		a := iam.NewIamAction(
+			iamAction,
+			makeS3ObjectArn(bucket, key),
+			session,
+		)
+		actions = append(actions, a)
+	case api.PutObjectTagging:
+		bucket, key, err := getS3ObjectFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		tags, err := getTagsFromPutObjectTaggingRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		a := iam.NewIamAction(
+			selectVersionAction(req.URL.Query().Get("versionId"), actionnames.IAMActionS3PutObjectTagging, actionnames.IAMActionS3PutObjectVersionTagging),
+			makeS3ObjectArn(bucket, key),
+			session,
+		).AddContext(getObjectTagContext(actionnames.IAMConditionS3RequestObjectTagPrefix, tags))
+		actions = append(actions, a)
+	case api.ListMultipartUploads:
+		bucket, err := getS3BucketFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		a := iam.NewIamAction(
+			actionnames.IAMActionS3ListBucketMultipartUploads,
 			makeS3BucketArn(bucket),
 			session,
-		).AddContext(
-			map[string]*policy.ConditionValue{
-				actionnames.IAMConditionS3Prefix: policy.NewConditionValueString(true, req.URL.Query().Get("prefix")),
-			},
 		)
 		actions = append(actions, a)
-	case api.AbortMultipartUpload:
+	case api.ListParts:
 		bucket, key, err := getS3ObjectFromRequest(req, vhi)
 		if err != nil {
 			return nil, err
 		}
 		a := iam.NewIamAction(
-			actionnames.IAMActionS3AbortMultipartUpload,
+			actionnames.IAMActionS3ListMultipartUploadParts,
 			makeS3ObjectArn(bucket, key),
 			session,
 		)
 		actions = append(actions, a)
+	case api.GetBucketLocation:
+		bucket, err := getS3BucketFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		a := iam.NewIamAction(
+			actionnames.IAMActionS3GetBucketLocation,
+			makeS3BucketArn(bucket),
+			session,
+		)
+		actions = append(actions, a)
+	case api.GetBucketVersioning, api.PutBucketVersioning:
+		bucket, err := getS3BucketFromRequest(req, vhi)
+		if err != nil {
+			return nil, err
+		}
+		iamAction := actionnames.IAMActionS3GetBucketVersioning
+		if api_action == api.PutBucketVersioning {
+			iamAction = actionnames.IAMActionS3PutBucketVersioning
+		}
+		a := iam.NewIamAction(
+			iamAction,
+			makeS3BucketArn(bucket),
+			session,
+		)
+		actions = append(actions, a)
 	case api.ListBuckets:
 		a := iam.NewIamAction(
 			actionnames.IAMActionS3ListAllMyBuckets,
@@ -113,7 +371,38 @@ func newIamActionsFromS3Request(api_action api.S3Operation, req *http.Request, s
 		)
 		actions = append(actions, a)
 	default:
-		return nil, errors.New("cannot get IAM actions due to unsupported api action")
+		return nil, errUnsupportedOperation
+	}
+	if signatureAgeContext := getSignatureAgeContext(req, time.Now().UTC()); signatureAgeContext != nil {
+		for _, a := range actions {
+			a.AddContext(signatureAgeContext)
+		}
 	}
 	return actions, nil
 }
+
+// Get the s3:signatureAge key which is the time in milliseconds since the request was signed. Requests that
+// are not signed with sigv4 (e.g. hmacv1 presigned URLs) do not have the key.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/bucket-policy-s3-sigv4-conditions.html
+func getSignatureAgeContext(req *http.Request, now time.Time) map[string]*policy.ConditionValue {
+	amzDate := req.Header.Get(constants.AmzDateKey)
+	if amzDate == "" {
+		amzDate = req.URL.Query().Get(constants.AmzDateKey)
+	}
+	if amzDate == "" {
+		if form, err := presign.GetPostPolicyForm(req); err == nil {
+			amzDate = form.Get(constants.AmzDateKey)
+		}
+	}
+	if amzDate == "" {
+		return nil
+	}
+	signingTime, err := presign.XAmzDateToTime(amzDate)
+	if err != nil {
+		return nil
+	}
+	signatureAge := max(now.Sub(signingTime).Milliseconds(), 0)
+	return map[string]*policy.ConditionValue{
+		actionnames.IAMConditionS3SignatureAge: policy.NewConditionValueString(true, strconv.FormatInt(signatureAge, 10)),
+	}
+}
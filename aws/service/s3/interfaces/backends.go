@@ -10,6 +10,9 @@ type BackendLocator interface {
 
 	//Get the ID of the fallback backend
 	GetDefaultBackend() string
+
+	//Get the IDs of all configured backends
+	GetBackendIds() []string
 }
 
 type BackendCredentialRetriever interface {
@@ -21,6 +24,8 @@ type BackendManager interface {
 	BackendLocator
 	BackendCredentialRetriever
 	HasCapability(backendId string, capability S3Capability) bool
+	//Whether ListBuckets should return the buckets of all backends rather than only those of the targeted backend
+	AggregatesListBuckets() bool
 }
 
 type Endpoint interface {
@@ -4,4 +4,8 @@ import "net/http"
 
 type VirtualHosterIdentifier interface {
 	IsVirtualHostingRequest(req *http.Request) bool
+
+	//Get the bucket of a virtual-hosted-style request (e.g. bucket1.s3.example.com) if the request is
+	//addressed to a subdomain of an FQDN that allows virtual hosting.
+	GetVirtualHostedBucket(req *http.Request) (bucket string, ok bool)
 }
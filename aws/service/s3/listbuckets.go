@@ -0,0 +1,213 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/VITObelgium/fakes3pp/aws/service"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	iaminterfaces "github.com/VITObelgium/fakes3pp/aws/service/iam/interfaces"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/api"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/middleware"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/utils"
+)
+
+// Actions that target a bucket itself, granting any of them on a bucket makes it show up in a filtered listing
+var bucketLevelActions = []string{
+	actionnames.IAMActionS3ListBucket,
+	actionnames.IAMActionS3ListBucketVersions,
+	actionnames.IAMActionS3ListBucketMultipartUploads,
+	actionnames.IAMActionS3GetBucketVersioning,
+	actionnames.IAMActionS3PutBucketVersioning,
+	actionnames.IAMActionS3GetBucketLocation,
+}
+
+// Actions that target objects, granting any of them on objects of a bucket makes it show up in a filtered listing
+var objectLevelActions = []string{
+	actionnames.IAMActionS3GetObject,
+	actionnames.IAMActionS3GetObjectVersion,
+	actionnames.IAMActionS3PutObject,
+	actionnames.IAMActionS3DeleteObject,
+	actionnames.IAMActionS3DeleteObjectVersion,
+	actionnames.IAMActionS3AbortMultipartUpload,
+	actionnames.IAMActionS3ListMultipartUploadParts,
+	actionnames.IAMActionS3GetObjectTagging,
+	actionnames.IAMActionS3PutObjectTagging,
+	actionnames.IAMActionS3DeleteObjectTagging,
+	actionnames.IAMActionS3GetObjectVersionTagging,
+	actionnames.IAMActionS3PutObjectVersionTagging,
+	actionnames.IAMActionS3DeleteObjectVersionTagging,
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListBuckets.html#API_ListBuckets_ResponseSyntax
+type listAllMyBucketsResult struct {
+	XMLName           xml.Name       `xml:"ListAllMyBucketsResult"`
+	Owner             *bucketOwner   `xml:"Owner,omitempty"`
+	Buckets           []listedBucket `xml:"Buckets>Bucket"`
+	ContinuationToken string         `xml:"ContinuationToken,omitempty"`
+	Prefix            string         `xml:"Prefix,omitempty"`
+}
+
+type bucketOwner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName,omitempty"`
+}
+
+type listedBucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+	BucketRegion string `xml:"BucketRegion,omitempty"`
+}
+
+// Whether the policy grants any action on the bucket or on objects in it
+func mayAccessBucket(pe *iam.PolicyEvaluator, session *iam.PolicySessionData, bucket string) bool {
+	return pe.CouldAllowAny(bucketLevelActions, makeS3BucketArn(bucket), session) ||
+		pe.CouldAllowAny(objectLevelActions, makeS3ObjectArn(bucket, "*"), session)
+}
+
+// Drop the buckets from a ListAllMyBucketsResult that the policy grants no access to
+func filterListAllMyBucketsResult(upstreamBody []byte, pe *iam.PolicyEvaluator, session *iam.PolicySessionData) ([]byte, error) {
+	result := listAllMyBucketsResult{}
+	err := xml.Unmarshal(upstreamBody, &result)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode ListAllMyBucketsResult: %w", err)
+	}
+	accessibleBuckets := []listedBucket{}
+	for _, bucket := range result.Buckets {
+		if mayAccessBucket(pe, session, bucket.Name) {
+			accessibleBuckets = append(accessibleBuckets, bucket)
+		}
+	}
+	result.Buckets = accessibleBuckets
+	result.XMLName = xml.Name{Space: s3XMLNamespace, Local: "ListAllMyBucketsResult"}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	err = xml.NewEncoder(&buf).Encode(result)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// A ListBuckets request returns all buckets that the credentials of the backend can see. When backends
+// are shared by tenants that leaks bucket names so for the configured roles the response only keeps the
+// buckets that their policy grants access to.
+func FilterListBuckets(roles roleArnSet, policyRetriever iaminterfaces.PolicyRetriever) middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if getS3Action(r) != api.ListBuckets {
+				next(w, r)
+				return
+			}
+			pe, session, err := getSessionPolicyForRoles(r, roles, policyRetriever)
+			if err != nil {
+				writeS3ErrorResponse(r.Context(), w, ErrS3InternalError, err)
+				return
+			}
+			if pe == nil {
+				next(w, r)
+				return
+			}
+			requestctx.AddAccessLogInfo(r, "s3", slog.Bool("FilteredListBuckets", true))
+			//The response must be decoded so it must not be compressed
+			r.Header.Del("Accept-Encoding")
+			bw := newBufferingResponseWriter(w)
+			next(bw, r)
+			bw.flush(r.Context(), func(body []byte) ([]byte, error) {
+				return filterListAllMyBucketsResult(body, pe, session)
+			})
+		}
+	}
+}
+
+// List the buckets of a single backend and annotate each of them with the region of that backend
+func (c backendClient) listBuckets(ctx context.Context, prefix string) (*listAllMyBucketsResult, error) {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/", query, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.Close(resp.Body, "ListBuckets response body", ctx)
+	result := listAllMyBucketsResult{}
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode ListAllMyBucketsResult: %w", err)
+	}
+	for i := range result.Buckets {
+		result.Buckets[i].BucketRegion = c.backendId
+	}
+	return &result, nil
+}
+
+// Answer a ListBuckets request with the buckets of all backends. The backends are queried concurrently and
+// a backend that fails is left out of the result. Only when all of them fail the request fails. Pagination
+// is not supported since continuation tokens are specific to a backend so every backend returns all its buckets.
+func listBucketsAcrossBackends(ctx context.Context, w http.ResponseWriter, r *http.Request, targetBackendId string,
+	backendManager interfaces.BackendManager, requester requesterFunc, corsHandler interfaces.CORSHandler) {
+	prefix := r.URL.Query().Get("prefix")
+	backendIds := getBucketLocationProbeOrder(targetBackendId, backendManager)
+	results := make([]*listAllMyBucketsResult, len(backendIds))
+	errs := make([]error, len(backendIds))
+	var wg sync.WaitGroup
+	for i, backendId := range backendIds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			backend := backendClient{backendId: backendId, bm: backendManager, requester: requester}
+			results[i], errs[i] = backend.listBuckets(ctx, prefix)
+		}()
+	}
+	wg.Wait()
+
+	merged := listAllMyBucketsResult{
+		XMLName: xml.Name{Space: s3XMLNamespace, Local: "ListAllMyBucketsResult"},
+		Buckets: []listedBucket{},
+		Prefix:  prefix,
+	}
+	var failedBackends []string
+	for i, backendId := range backendIds {
+		if errs[i] != nil {
+			slog.WarnContext(ctx, "Could not list buckets of backend", "error", errs[i], "backendId", backendId)
+			failedBackends = append(failedBackends, backendId)
+			continue
+		}
+		//The owner of the first backend in probe order is kept, preferring the targeted backend
+		if merged.Owner == nil {
+			merged.Owner = results[i].Owner
+		}
+		merged.Buckets = append(merged.Buckets, results[i].Buckets...)
+	}
+	if len(failedBackends) > 0 {
+		requestctx.AddAccessLogInfo(r, "s3", slog.Any("ListBucketsFailedBackends", failedBackends))
+	}
+	if len(backendIds) == 0 || len(failedBackends) == len(backendIds) {
+		requestctx.SetUpstreamHTTPStatus(r, -1)
+		var backendErr *backendErrorResponse
+		if len(errs) > 0 && errors.As(errs[0], &backendErr) {
+			writeBackendErrorResponse(ctx, w, backendErr)
+			return
+		}
+		writeS3ErrorResponse(ctx, w, ErrS3UpstreamError, errors.New("could not list buckets of any backend"))
+		return
+	}
+	requestctx.SetUpstreamHTTPStatus(r, http.StatusOK)
+	sort.SliceStable(merged.Buckets, func(i, j int) bool {
+		return merged.Buckets[i].Name < merged.Buckets[j].Name
+	})
+	corsHandler.SetHeaders(w, "", targetBackendId, backendManager)
+	service.WriteSuccessResponseXML(ctx, w, service.EncodeResponse(ctx, merged))
+}
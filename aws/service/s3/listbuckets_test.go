@@ -0,0 +1,268 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/server"
+	"github.com/VITObelgium/fakes3pp/testutils"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var testPolicyListBucketsAndReadBucket1ARN = "arn:aws:iam::000000000000:role/ListBucketsAndReadBucket1"
+var testPolicyListBucketsAndReadBucket1 = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "*"
+		},
+		{
+			"Effect": "Allow",
+			"Action": "s3:Get*",
+			"Resource": "%s/public/*"
+		},
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "arn:aws:s3:::shared-*"
+		}
+	]
+}`, actionnames.IAMActionS3ListAllMyBuckets, testBucketARN, actionnames.IAMActionS3ListBucket)
+
+const testListAllMyBucketsResult = `<?xml version="1.0" encoding="UTF-8"?>
+<ListAllMyBucketsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Owner><ID>owner</ID><DisplayName>owner</DisplayName></Owner><Buckets>` +
+	`<Bucket><Name>bucket1</Name><CreationDate>2024-01-01T00:00:00.000Z</CreationDate></Bucket>` +
+	`<Bucket><Name>bucket10</Name><CreationDate>2024-01-01T00:00:00.000Z</CreationDate></Bucket>` +
+	`<Bucket><Name>shared-data</Name><CreationDate>2024-01-01T00:00:00.000Z</CreationDate></Bucket>` +
+	`<Bucket><Name>other-tenant</Name><CreationDate>2024-01-01T00:00:00.000Z</CreationDate></Bucket>` +
+	`</Buckets></ListAllMyBucketsResult>`
+
+func TestRoleArnSetFromBytes(t *testing.T) {
+	roles, err := getRoleArnSetFromBytes([]byte("- arn:aws:iam::000000000000:role/a\n- ' '\n"))
+	if err != nil {
+		t.Fatalf("Could not parse role ARNs: %s", err)
+	}
+	if !roles.contains("arn:aws:iam::000000000000:role/a") {
+		t.Error("Expected role a to be configured")
+	}
+	if roles.contains("arn:aws:iam::000000000000:role/b") || len(roles) != 1 {
+		t.Errorf("Expected only role a to be configured, got %v", roles)
+	}
+}
+
+func TestFilterListAllMyBucketsResult(t *testing.T) {
+	pe, err := iam.NewPolicyEvaluatorFromStr(testPolicyListBucketsAndReadBucket1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := filterListAllMyBucketsResult([]byte(testListAllMyBucketsResult), pe, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := listAllMyBucketsResult{}
+	if err := xml.Unmarshal(filtered, &result); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, bucket := range result.Buckets {
+		names = append(names, bucket.Name)
+	}
+	if !slices.Equal(names, []string{"bucket1", "shared-data"}) {
+		t.Errorf("Unexpected buckets after filtering: %v", names)
+	}
+	if result.Owner == nil || result.Owner.ID != "owner" {
+		t.Errorf("Owner should be kept, got %v", result.Owner)
+	}
+}
+
+func newTestS3ServerFilteringListBuckets(t *testing.T, roles roleArnSet) (teardown func(), s *S3Server) {
+	requester := func(r *http.Request) (*http.Response, error) {
+		return fakeBackendResponse(http.StatusOK, http.Header{"Content-Type": {"application/xml"}}, []byte(testListAllMyBucketsResult)), nil
+	}
+	policies := map[string]string{
+		testPolicyListBucketsAndReadBucket1ARN: testPolicyListBucketsAndReadBucket1,
+		testPolicyAllowAllARN:                  testPolicyAllowAll,
+	}
+	return newTestS3ServerWithListingFilters(t, requester, policies, roles, nil)
+}
+
+func newTestS3ServerWithListingFilters(t *testing.T, requester requesterFunc, policies map[string]string,
+	listBucketsRoles, listObjectsRoles roleArnSet) (teardown func(), s *S3Server) {
+	pm := newTestPolicyManager(t, policies)
+	s, err := newS3Server(
+		fmt.Sprintf("%s/jwt_testing_rsa", testEtcPath),
+		testS3Port,
+		[]string{testS3Host},
+		fmt.Sprintf("%s/cert.pem", testEtcPath),
+		fmt.Sprintf("%s/key.pem", testEtcPath),
+		pm,
+		3600,
+		handlerBuilder{proxyFunc: justProxy, requester: requester},
+		getDefaultTestBackendConfig(),
+		nil,
+		listBucketsRoles,
+		listObjectsRoles,
+		nil,
+		nil,
+		nil,
+		0,
+		nil,
+		false,
+	)
+	if err != nil {
+		t.Fatalf("Problem creating test S3 server: %s", err)
+	}
+	done, srv, err := server.CreateAndStart(s, server.ServerOpts{})
+	if err != nil {
+		t.Fatalf("Could not spawn fake S3 server %s", err)
+	}
+	return func() {
+		if err := srv.Shutdown(context.Background()); err != nil {
+			panic(err)
+		}
+		done.Wait()
+	}, s
+}
+
+func runListBucketsWithPolicy(t *testing.T, s *S3Server, policyArn string) []string {
+	cred := createTestCredentialsForPolicy(t, policyArn, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "waw3-1", cred, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		t.Fatalf("ListBuckets failed: %s", err)
+	}
+	var names []string
+	for _, bucket := range output.Buckets {
+		names = append(names, *bucket.Name)
+	}
+	return names
+}
+
+func TestListBucketsIsFilteredForConfiguredRoles(t *testing.T) {
+	teardown, s := newTestS3ServerFilteringListBuckets(t, roleArnSet{testPolicyListBucketsAndReadBucket1ARN: {}})
+	defer teardown()
+
+	//When listing buckets with a role for which filtering is configured
+	names := runListBucketsWithPolicy(t, s, testPolicyListBucketsAndReadBucket1ARN)
+
+	//Then only the buckets that the policy grants access to are returned
+	if !slices.Equal(names, []string{"bucket1", "shared-data"}) {
+		t.Errorf("Unexpected buckets for filtered role: %v", names)
+	}
+
+	//When listing buckets with a role for which filtering is not configured
+	names = runListBucketsWithPolicy(t, s, testPolicyAllowAllARN)
+
+	//Then all buckets of the backend are returned
+	if len(names) != 4 {
+		t.Errorf("Expected all 4 buckets for unfiltered role, got %v", names)
+	}
+}
+
+func newTestListAllMyBucketsResult(bucketNames ...string) []byte {
+	var buckets strings.Builder
+	for _, name := range bucketNames {
+		fmt.Fprintf(&buckets, "<Bucket><Name>%s</Name><CreationDate>2024-01-01T00:00:00.000Z</CreationDate></Bucket>", name)
+	}
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<ListAllMyBucketsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Owner><ID>owner</ID></Owner><Buckets>` +
+		buckets.String() + `</Buckets></ListAllMyBucketsResult>`)
+}
+
+// A fake backend requester that lists different buckets per backend host. Hosts without listing fail.
+func newFakeListBucketsRequester(listings map[string][]byte) requesterFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		listing, ok := listings[r.Host]
+		if !ok || r.Method != http.MethodGet || r.URL.Path != "/" {
+			return fakeBackendResponse(http.StatusInternalServerError, nil, []byte("<Error><Code>InternalError</Code></Error>")), nil
+		}
+		return fakeBackendResponse(http.StatusOK, http.Header{"Content-Type": {"application/xml"}}, listing), nil
+	}
+}
+
+func getAggregatingTestBackendConfig() interfaces.BackendManager {
+	bm := getDefaultTestBackendConfig().(*backendsConfig)
+	bm.aggregateListBuckets = true
+	return bm
+}
+
+func runListBuckets(t *testing.T, s *S3Server) (*s3.ListBucketsOutput, error) {
+	cred := createTestCredentialsForPolicy(t, testPolicyAllowAllARN, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "waw3-1", cred, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return client.ListBuckets(ctx, &s3.ListBucketsInput{})
+}
+
+func TestListBucketsAggregatesAllBackends(t *testing.T) {
+	requester := newFakeListBucketsRequester(map[string][]byte{
+		testDestinationBackendHost: newTestListAllMyBucketsResult("waw-b", "waw-a"),
+		testSourceBackendHost:      newTestListAllMyBucketsResult("nl-a"),
+	})
+	hb := handlerBuilder{proxyFunc: justProxy, requester: requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, getAggregatingTestBackendConfig(), nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When listing buckets while aggregation is enabled
+	output, err := runListBuckets(t, s)
+
+	//Then the buckets of all backends are returned, sorted and annotated with their region
+	if err != nil {
+		t.Fatalf("ListBuckets failed: %s", err)
+	}
+	var got []string
+	for _, bucket := range output.Buckets {
+		got = append(got, fmt.Sprintf("%s@%s", *bucket.Name, *bucket.BucketRegion))
+	}
+	expected := []string{"nl-a@eu-nl", "waw-a@waw3-1", "waw-b@waw3-1"}
+	if !slices.Equal(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestListBucketsAggregationToleratesFailingBackend(t *testing.T) {
+	requester := newFakeListBucketsRequester(map[string][]byte{
+		testSourceBackendHost: newTestListAllMyBucketsResult("nl-a"),
+	})
+	hb := handlerBuilder{proxyFunc: justProxy, requester: requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, getAggregatingTestBackendConfig(), nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When listing buckets while the targeted backend fails
+	output, err := runListBuckets(t, s)
+
+	//Then the buckets of the other backends are still returned
+	if err != nil {
+		t.Fatalf("ListBuckets failed: %s", err)
+	}
+	if len(output.Buckets) != 1 || *output.Buckets[0].Name != "nl-a" {
+		t.Errorf("Expected only bucket nl-a, got %v", output.Buckets)
+	}
+}
+
+func TestListBucketsAggregationFailsWhenAllBackendsFail(t *testing.T) {
+	hb := handlerBuilder{proxyFunc: justProxy, requester: newFakeListBucketsRequester(nil)}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, getAggregatingTestBackendConfig(), nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When listing buckets while no backend can list its buckets
+	_, err := runListBuckets(t, s)
+
+	//Then the error of the targeted backend is returned
+	if err == nil || !strings.Contains(err.Error(), "InternalError") {
+		t.Errorf("Expected InternalError, got %v", err)
+	}
+}
@@ -0,0 +1,289 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/service"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	iaminterfaces "github.com/VITObelgium/fakes3pp/aws/service/iam/interfaces"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/api"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/middleware"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/usererror"
+	"github.com/VITObelgium/fakes3pp/utils"
+)
+
+const listObjectsFilterKey = "listObjectsFilter"
+
+// The default and maximum number of keys that S3 returns in a single ListObjectsV2 response
+const listObjectsDefaultMaxKeys = 1000
+
+// The maximum number of pages that are requested from the backend to fill a single filtered response.
+// Once reached the response is returned with fewer keys and a continuation token to resume.
+var listObjectsFilterMaxBackendPages = 10
+
+// Headers of a ListObjectsV2 request that are passed on to the backend when the response gets filtered
+var listObjectsForwardedHeaders = []string{
+	"X-Amz-Request-Payer",
+	"X-Amz-Expected-Bucket-Owner",
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html#API_ListObjectsV2_ResponseSyntax
+type listBucketV2Result struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	Contents              []listedObject `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+// An entry of the Contents of a listing. Only the key is needed for filtering, all other elements
+// (e.g. checksums or restore status) are passed on as returned by the backend.
+type listedObject struct {
+	Key   string `xml:"Key"`
+	Inner string `xml:",innerxml"`
+}
+
+func (o listedObject) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(struct {
+		Inner string `xml:",innerxml"`
+	}{o.Inner}, start)
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// The policy of a session for which ListObjectsV2 responses only keep the keys that it may read
+type listObjectsFilter struct {
+	pe      *iam.PolicyEvaluator
+	session *iam.PolicySessionData
+}
+
+// Whether the session may get the object
+func (f *listObjectsFilter) mayGetObject(bucket, key string) (bool, error) {
+	isAllowed, _, err := f.pe.Evaluate(iam.NewIamAction(actionnames.IAMActionS3GetObject, makeS3ObjectArn(bucket, key), f.session))
+	return isAllowed, err
+}
+
+// Whether the session may get some object under the prefix. Conditions are not taken into account
+// but a prefix under which every object is explicitly denied is not shown.
+func (f *listObjectsFilter) mayGetObjectWithPrefix(bucket, prefix string) bool {
+	getObject := []string{actionnames.IAMActionS3GetObject}
+	prefixArn := makeS3ObjectArn(bucket, prefix+"*")
+	return f.pe.CouldAllowAny(getObject, prefixArn, f.session) && !f.pe.DeniesAll(getObject, prefixArn, f.session)
+}
+
+// Drop the keys and common prefixes of a page that the session may not read. Returns the number of dropped entries.
+func (f *listObjectsFilter) filterPage(page *listBucketV2Result) (dropped int, err error) {
+	decode := func(s string) (string, error) { return s, nil }
+	if page.EncodingType == "url" {
+		decode = url.QueryUnescape
+	}
+	allowedObjects := []listedObject{}
+	for _, object := range page.Contents {
+		key, err := decode(object.Key)
+		if err != nil {
+			return 0, fmt.Errorf("could not decode key %q: %w", object.Key, err)
+		}
+		isAllowed, err := f.mayGetObject(page.Name, key)
+		if err != nil {
+			return 0, err
+		}
+		if isAllowed {
+			allowedObjects = append(allowedObjects, object)
+		}
+	}
+	allowedPrefixes := []commonPrefix{}
+	for _, p := range page.CommonPrefixes {
+		prefix, err := decode(p.Prefix)
+		if err != nil {
+			return 0, fmt.Errorf("could not decode prefix %q: %w", p.Prefix, err)
+		}
+		if f.mayGetObjectWithPrefix(page.Name, prefix) {
+			allowedPrefixes = append(allowedPrefixes, p)
+		}
+	}
+	dropped = len(page.Contents) - len(allowedObjects) + len(page.CommonPrefixes) - len(allowedPrefixes)
+	page.Contents = allowedObjects
+	page.CommonPrefixes = allowedPrefixes
+	return dropped, nil
+}
+
+// Get the filter of the session for a ListObjectsV2 request or nil if its response must not be filtered
+func getListObjectsFilter(r *http.Request) *listObjectsFilter {
+	rCtx, ok := requestctx.FromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	v, err := rCtx.GetData(listObjectsFilterKey)
+	if err != nil {
+		return nil
+	}
+	filter, ok := v.(*listObjectsFilter)
+	if !ok {
+		return nil
+	}
+	return filter
+}
+
+// Get a page of a ListObjectsV2 listing from the backend
+func (c backendClient) listObjectsV2(ctx context.Context, bucket string, query url.Values, header http.Header) (*listBucketV2Result, error) {
+	resp, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/%s", url.PathEscape(bucket)), query, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.Close(resp.Body, "ListObjectsV2 response body", ctx)
+	page := listBucketV2Result{}
+	err = xml.NewDecoder(resp.Body).Decode(&page)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode ListBucketResult: %w", err)
+	}
+	return &page, nil
+}
+
+// Answer a ListObjectsV2 request with only the keys that the session may read. When filtering thins out a page
+// the next pages are requested from the backend until max-keys entries are found or the listing ends. The
+// continuation token of the last page of the backend is returned such that a client resumes right after it.
+func listObjectsV2Filtered(ctx context.Context, w http.ResponseWriter, r *http.Request, targetBackendId string,
+	backendManager interfaces.BackendManager, requester requesterFunc, corsHandler interfaces.CORSHandler, filter *listObjectsFilter) {
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	maxKeys := listObjectsDefaultMaxKeys
+	if query.Has("max-keys") {
+		var err error
+		maxKeys, err = strconv.Atoi(query.Get("max-keys"))
+		if err != nil || maxKeys < 0 {
+			writeS3ErrorResponse(ctx, w, ErrS3InvalidArgument, usererror.New(
+				fmt.Errorf("invalid max-keys %q", query.Get("max-keys")),
+				"max-keys must be a non-negative integer",
+			))
+			return
+		}
+	}
+	header := http.Header{}
+	for _, headerName := range listObjectsForwardedHeaders {
+		if headerValue := r.Header.Get(headerName); headerValue != "" {
+			header.Set(headerName, headerValue)
+		}
+	}
+
+	backend := backendClient{backendId: targetBackendId, bm: backendManager, requester: requester}
+	var result *listBucketV2Result
+	dropped := 0
+	for pageNr := 0; pageNr < listObjectsFilterMaxBackendPages; pageNr++ {
+		remaining := maxKeys
+		if result != nil {
+			remaining = maxKeys - len(result.Contents) - len(result.CommonPrefixes)
+			query.Set("continuation-token", result.NextContinuationToken)
+			query.Del("start-after")
+		}
+		query.Set("max-keys", strconv.Itoa(remaining))
+		page, err := backend.listObjectsV2(ctx, bucket, query, header)
+		if err != nil && result == nil {
+			writeListObjectsError(ctx, w, r, err)
+			return
+		} else if err != nil {
+			//The entries found so far are returned and the client can resume from the last good page
+			slog.WarnContext(ctx, "Could not get next page of filtered listing", "error", err, "bucket", bucket)
+			break
+		}
+		pageDropped, err := filter.filterPage(page)
+		if err != nil {
+			writeS3ErrorResponse(ctx, w, ErrS3InternalError, err)
+			return
+		}
+		dropped += pageDropped
+		if result == nil {
+			result = page
+		} else {
+			result.Contents = append(result.Contents, page.Contents...)
+			result.CommonPrefixes = append(result.CommonPrefixes, page.CommonPrefixes...)
+			result.IsTruncated = page.IsTruncated
+			result.NextContinuationToken = page.NextContinuationToken
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" || len(result.Contents)+len(result.CommonPrefixes) >= maxKeys {
+			break
+		}
+	}
+	requestctx.SetUpstreamHTTPStatus(r, http.StatusOK)
+	requestctx.AddAccessLogInfo(r, "s3", slog.Int("FilteredKeys", dropped))
+	result.XMLName = xml.Name{Space: s3XMLNamespace, Local: "ListBucketResult"}
+	result.MaxKeys = maxKeys
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	if !result.IsTruncated {
+		result.NextContinuationToken = ""
+	}
+	corsHandler.SetHeaders(w, bucket, targetBackendId, backendManager)
+	service.WriteSuccessResponseXML(ctx, w, service.EncodeResponse(ctx, result))
+}
+
+func writeListObjectsError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	var backendErr *backendErrorResponse
+	switch {
+	case errors.Is(err, errInvalidBackendErr):
+		writeS3ErrorResponse(ctx, w, ErrS3InvalidRegion, err)
+	case errors.As(err, &backendErr):
+		requestctx.SetUpstreamHTTPStatus(r, backendErr.statusCode)
+		writeBackendErrorResponse(ctx, w, backendErr)
+	default:
+		requestctx.SetUpstreamHTTPStatus(r, -1)
+		writeS3ErrorResponse(ctx, w, ErrS3UpstreamError, err)
+	}
+}
+
+// Object listings return all keys of a bucket (or prefix) while a policy can restrict GetObject to part of them.
+// For the configured roles ListObjectsV2 responses only keep the keys that their policy allows to get. The other
+// listing operations cannot be filtered with correct pagination so they are refused for those roles.
+func FilterListObjects(roles roleArnSet, policyRetriever iaminterfaces.PolicyRetriever) middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			action := getS3Action(r)
+			if action != api.ListObjectsV2 && action != api.ListObjects && action != api.ListObjectVersions {
+				next(w, r)
+				return
+			}
+			pe, session, err := getSessionPolicyForRoles(r, roles, policyRetriever)
+			if err != nil {
+				writeS3ErrorResponse(r.Context(), w, ErrS3InternalError, err)
+				return
+			}
+			if pe == nil {
+				next(w, r)
+				return
+			}
+			if action != api.ListObjectsV2 {
+				writeS3ErrorResponse(r.Context(), w, ErrS3NotImplemented, usererror.New(
+					fmt.Errorf("%s for role with filtered listings", action),
+					"Listings of this role are filtered which is only supported for ListObjectsV2",
+				))
+				return
+			}
+			rCtx, ok := requestctx.FromContext(r.Context())
+			if !ok {
+				writeS3ErrorResponse(r.Context(), w, ErrS3InternalError, errors.New("could not keep track of listing filter without request context"))
+				return
+			}
+			rCtx.SetDataKey(listObjectsFilterKey, &listObjectsFilter{pe: pe, session: session})
+			next(w, r)
+		}
+	}
+}
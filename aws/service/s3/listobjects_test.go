@@ -0,0 +1,323 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/testutils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var testPolicyListBucket1AndReadHomeAliceARN = "arn:aws:iam::000000000000:role/ListBucket1AndReadHomeAlice"
+var testPolicyListBucket1AndReadHomeAlice = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s"
+		},
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/home/alice/*"
+		}
+	]
+}`, actionnames.IAMActionS3ListBucket, testBucketARN, actionnames.IAMActionS3GetObject, testBucketARN)
+
+var testListObjectsKeys = []string{
+	"home/alice/a.txt",
+	"home/alice/b.txt",
+	"home/alice/sub/c.txt",
+	"home/bob/a.txt",
+	"home/bob/b.txt",
+	"home/bob/c.txt",
+	"home/bob/d.txt",
+	"home/carol/a.txt",
+	"readme.txt",
+}
+
+// A fake backend requester that lists the given keys like ListObjectsV2 does. The continuation token is the
+// index of the next key to list.
+func newFakeListObjectsRequester(keys []string) requesterFunc {
+	sortedKeys := slices.Clone(keys)
+	sort.Strings(sortedKeys)
+	return func(r *http.Request) (*http.Response, error) {
+		query := r.URL.Query()
+		if r.Method != http.MethodGet || query.Get("list-type") != "2" {
+			return fakeBackendResponse(http.StatusBadRequest, nil, []byte("<Error><Code>InvalidRequest</Code></Error>")), nil
+		}
+		prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+		maxKeys := listObjectsDefaultMaxKeys
+		if query.Has("max-keys") {
+			maxKeys, _ = strconv.Atoi(query.Get("max-keys"))
+		}
+		start := 0
+		if query.Has("continuation-token") {
+			start, _ = strconv.Atoi(query.Get("continuation-token"))
+		}
+		var entries strings.Builder
+		count := 0
+		lastPrefix := ""
+		i := start
+		for ; i < len(sortedKeys) && count < maxKeys; i++ {
+			key := sortedKeys[i]
+			if !strings.HasPrefix(key, prefix) || key <= query.Get("start-after") {
+				continue
+			}
+			if delimiter != "" {
+				if idx := strings.Index(key[len(prefix):], delimiter); idx >= 0 {
+					commonPrefix := key[:len(prefix)+idx+len(delimiter)]
+					if commonPrefix != lastPrefix {
+						fmt.Fprintf(&entries, "<CommonPrefixes><Prefix>%s</Prefix></CommonPrefixes>", commonPrefix)
+						lastPrefix = commonPrefix
+						count++
+					}
+					continue
+				}
+			}
+			fmt.Fprintf(&entries, `<Contents><Key>%s</Key><ETag>"etag"</ETag><Size>3</Size><StorageClass>STANDARD</StorageClass></Contents>`, key)
+			count++
+		}
+		//Skip the remaining keys of the last common prefix
+		for lastPrefix != "" && i < len(sortedKeys) && strings.HasPrefix(sortedKeys[i], lastPrefix) {
+			i++
+		}
+		isTruncated := slices.ContainsFunc(sortedKeys[i:], func(key string) bool { return strings.HasPrefix(key, prefix) })
+		nextToken := ""
+		if isTruncated {
+			nextToken = fmt.Sprintf("<NextContinuationToken>%d</NextContinuationToken>", i)
+		}
+		body := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><Prefix>%s</Prefix><MaxKeys>%d</MaxKeys><KeyCount>%d</KeyCount><IsTruncated>%t</IsTruncated>%s%s</ListBucketResult>`,
+			testBucketName, prefix, maxKeys, count, isTruncated, nextToken, entries.String())
+		return fakeBackendResponse(http.StatusOK, http.Header{"Content-Type": {"application/xml"}}, []byte(body)), nil
+	}
+}
+
+func newTestS3ServerFilteringListObjects(t *testing.T) (teardown func(), s *S3Server) {
+	policies := map[string]string{
+		testPolicyListBucket1AndReadHomeAliceARN: testPolicyListBucket1AndReadHomeAlice,
+		testPolicyAllowAllARN:                    testPolicyAllowAll,
+	}
+	roles := roleArnSet{testPolicyListBucket1AndReadHomeAliceARN: {}}
+	return newTestS3ServerWithListingFilters(t, newFakeListObjectsRequester(testListObjectsKeys), policies, nil, roles)
+}
+
+// List all keys and common prefixes with the paginator of the SDK. Returns them together with the size of each page.
+func runListObjectsV2(t *testing.T, s *S3Server, policyArn string, input *s3.ListObjectsV2Input) (entries []string, pageSizes []int) {
+	cred := createTestCredentialsForPolicy(t, policyArn, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "waw3-1", cred, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	input.Bucket = aws.String(testBucketName)
+	paginator := s3.NewListObjectsV2Paginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			t.Fatalf("ListObjectsV2 failed: %s", err)
+		}
+		for _, object := range page.Contents {
+			entries = append(entries, *object.Key)
+		}
+		for _, commonPrefix := range page.CommonPrefixes {
+			entries = append(entries, *commonPrefix.Prefix)
+		}
+		if int(*page.KeyCount) != len(page.Contents)+len(page.CommonPrefixes) {
+			t.Errorf("KeyCount %d does not match the returned entries", *page.KeyCount)
+		}
+		pageSizes = append(pageSizes, int(*page.KeyCount))
+	}
+	return entries, pageSizes
+}
+
+func TestFilterListObjectsPage(t *testing.T) {
+	pe, err := iam.NewPolicyEvaluatorFromStr(testPolicyListBucket1AndReadHomeAlice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := &listObjectsFilter{pe: pe, session: &iam.PolicySessionData{}}
+	page := &listBucketV2Result{
+		Name:         testBucketName,
+		EncodingType: "url",
+		Contents: []listedObject{
+			{Key: "home/alice/with+space.txt"},
+			{Key: "home/bob/a.txt"},
+			{Key: "home%2Falice%2Fb.txt"},
+		},
+		CommonPrefixes: []commonPrefix{{Prefix: "home/alice/"}, {Prefix: "home/"}, {Prefix: "home/bob/"}, {Prefix: "other/"}},
+	}
+
+	dropped, err := filter.filterPage(page)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, object := range page.Contents {
+		keys = append(keys, object.Key)
+	}
+	if !slices.Equal(keys, []string{"home/alice/with+space.txt", "home%2Falice%2Fb.txt"}) {
+		t.Errorf("Unexpected keys after filtering: %v", keys)
+	}
+	var prefixes []string
+	for _, p := range page.CommonPrefixes {
+		prefixes = append(prefixes, p.Prefix)
+	}
+	//A prefix is kept when some object under it may be read
+	if !slices.Equal(prefixes, []string{"home/alice/", "home/"}) {
+		t.Errorf("Unexpected common prefixes after filtering: %v", prefixes)
+	}
+	if dropped != 3 {
+		t.Errorf("Expected 3 dropped entries, got %d", dropped)
+	}
+}
+
+var testPolicyReadAllButSecret = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "*",
+			"Resource": "*"
+		},
+		{
+			"Effect": "Deny",
+			"Action": "%s",
+			"Resource": "%s/secret/*"
+		}
+	]
+}`, actionnames.IAMActionS3GetObject, testBucketARN)
+
+func TestFilterListObjectsPageDropsDeniedPrefixes(t *testing.T) {
+	pe, err := iam.NewPolicyEvaluatorFromStr(testPolicyReadAllButSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := &listObjectsFilter{pe: pe, session: &iam.PolicySessionData{}}
+	page := &listBucketV2Result{
+		Name:           testBucketName,
+		Contents:       []listedObject{{Key: "readme.txt"}, {Key: "secret/a.txt"}},
+		CommonPrefixes: []commonPrefix{{Prefix: "public/"}, {Prefix: "secret/"}, {Prefix: "secret/sub/"}, {Prefix: "sec"}},
+	}
+
+	_, err = filter.filterPage(page)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prefixes []string
+	for _, p := range page.CommonPrefixes {
+		prefixes = append(prefixes, p.Prefix)
+	}
+	//A prefix is dropped when every object under it is denied, sec still has readable objects like sec.txt
+	if !slices.Equal(prefixes, []string{"public/", "sec"}) {
+		t.Errorf("Unexpected common prefixes after filtering: %v", prefixes)
+	}
+	if len(page.Contents) != 1 || page.Contents[0].Key != "readme.txt" {
+		t.Errorf("Unexpected keys after filtering: %v", page.Contents)
+	}
+}
+
+func TestListedObjectKeepsElementsOfBackend(t *testing.T) {
+	upstream := `<ListBucketResult><Name>b</Name><Contents><Key>k</Key><ETag>"e"</ETag><ChecksumAlgorithm>CRC32</ChecksumAlgorithm></Contents></ListBucketResult>`
+	page := listBucketV2Result{}
+	if err := xml.Unmarshal([]byte(upstream), &page); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := xml.Marshal(page.Contents[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<listedObject><Key>k</Key><ETag>"e"</ETag><ChecksumAlgorithm>CRC32</ChecksumAlgorithm></listedObject>`
+	if string(encoded) != expected {
+		t.Errorf("Expected %s, got %s", expected, encoded)
+	}
+}
+
+func TestListObjectsV2IsFilteredForConfiguredRoles(t *testing.T) {
+	teardown, s := newTestS3ServerFilteringListObjects(t)
+	defer teardown()
+
+	//When listing all keys in small pages with a role for which filtering is configured
+	entries, pageSizes := runListObjectsV2(t, s, testPolicyListBucket1AndReadHomeAliceARN, &s3.ListObjectsV2Input{MaxKeys: aws.Int32(2)})
+
+	//Then only the keys that the policy allows to get are returned
+	expected := []string{"home/alice/a.txt", "home/alice/b.txt", "home/alice/sub/c.txt"}
+	if !slices.Equal(entries, expected) {
+		t.Errorf("Expected %v, got %v", expected, entries)
+	}
+	//And pages are filled up to max-keys from next pages of the backend
+	if !slices.Equal(pageSizes, []int{2, 1}) {
+		t.Errorf("Unexpected page sizes %v", pageSizes)
+	}
+
+	//When listing with a delimiter
+	entries, _ = runListObjectsV2(t, s, testPolicyListBucket1AndReadHomeAliceARN, &s3.ListObjectsV2Input{
+		Prefix:    aws.String("home/"),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(1),
+	})
+
+	//Then only the common prefixes under which objects may be read are returned
+	if !slices.Equal(entries, []string{"home/alice/"}) {
+		t.Errorf("Expected only home/alice/, got %v", entries)
+	}
+
+	//When listing with a role for which filtering is not configured
+	entries, _ = runListObjectsV2(t, s, testPolicyAllowAllARN, &s3.ListObjectsV2Input{MaxKeys: aws.Int32(2)})
+
+	//Then all keys are returned
+	if !slices.Equal(entries, testListObjectsKeys) {
+		t.Errorf("Expected all keys for unfiltered role, got %v", entries)
+	}
+}
+
+func TestListObjectsV2FilteredStopsAfterMaxBackendPages(t *testing.T) {
+	teardown, s := newTestS3ServerFilteringListObjects(t)
+	defer teardown()
+	defer func(previous int) { listObjectsFilterMaxBackendPages = previous }(listObjectsFilterMaxBackendPages)
+	listObjectsFilterMaxBackendPages = 1
+
+	//When listing while every request may only perform a single backend request
+	entries, pageSizes := runListObjectsV2(t, s, testPolicyListBucket1AndReadHomeAliceARN, &s3.ListObjectsV2Input{
+		Prefix:  aws.String("home/b"),
+		MaxKeys: aws.Int32(1),
+	})
+
+	//Then empty pages are returned with a continuation token until the listing ends
+	if len(entries) != 0 {
+		t.Errorf("Expected no keys, got %v", entries)
+	}
+	if !slices.Equal(pageSizes, []int{0, 0, 0, 0}) {
+		t.Errorf("Unexpected page sizes %v", pageSizes)
+	}
+}
+
+func TestListObjectsV1IsRefusedForFilteredRoles(t *testing.T) {
+	teardown, s := newTestS3ServerFilteringListObjects(t)
+	defer teardown()
+	cred := createTestCredentialsForPolicy(t, testPolicyListBucket1AndReadHomeAliceARN, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "waw3-1", cred, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//When listing with ListObjects which cannot be filtered
+	_, err := client.ListObjects(ctx, &s3.ListObjectsInput{Bucket: aws.String(testBucketName)})
+
+	//Then the request is refused
+	if err == nil || !strings.Contains(err.Error(), "NotImplemented") {
+		t.Errorf("Expected NotImplemented, got %v", err)
+	}
+}
@@ -22,8 +22,10 @@ const L_BUCKET = "Bucket" // The Bucket used in the request
 
 // Authorization middleware is responsible for the following:
 // Make sure the action is authorized as per request context
+// If authzMessageKey is set denied requests get an encoded authorization message that is encrypted with it.
+// The requester is used for the backend requests that authorization needs e.g. to get the tags of an object.
 func AWSAuthZS3(keyStorage utils.JWTVerifier, backendManager interfaces.BackendManager, policyRetriever iaminterfaces.PolicyRetriever,
-	presignCutoff interfaces.CutoffDecider, vhi interfaces.VirtualHosterIdentifier) middleware.Middleware {
+	presignCutoff interfaces.CutoffDecider, vhi interfaces.VirtualHosterIdentifier, authzMessageKey utils.PrivateKeyKeeper, requester requesterFunc) middleware.Middleware {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			targetRegion, err := requestctx.GetTargetRegion(r)
@@ -42,7 +44,16 @@ func AWSAuthZS3(keyStorage utils.JWTVerifier, backendManager interfaces.BackendM
 				maxExpiryTime = presignCutoff.GetCutoffForPresignedUrl()
 			}
 
-			if authorizeS3Action(r.Context(), sessionToken, targetRegion, getS3Action(r), w, r, maxExpiryTime, keyStorage, policyRetriever, vhi) {
+			if authorizeS3Action(r.Context(), sessionToken, targetRegion, getS3Action(r), w, r, maxExpiryTime, keyStorage, policyRetriever, vhi, backendManager, authzMessageKey, requester) {
+				if denied := getDeniedDeleteObjects(r); len(denied) > 0 {
+					//Keys that were denied by the proxy must still be reported in the response which must therefore
+					//not be compressed
+					r.Header.Del("Accept-Encoding")
+					bw := newBufferingResponseWriter(w)
+					next(bw, r)
+					bw.flush(r.Context(), addDeniedToDeleteResult(r.Context(), denied))
+					return
+				}
 				next(w, r)
 			}
 		}
@@ -52,7 +63,8 @@ func AWSAuthZS3(keyStorage utils.JWTVerifier, backendManager interfaces.BackendM
 // Authorize an S3 action
 // maxExpiryTime is an upperbound for the expiry of the session token
 func authorizeS3Action(ctx context.Context, sessionToken, targetRegion string, action api.S3Operation, w http.ResponseWriter, r *http.Request,
-	maxExpiryTime time.Time, jwtVerifier utils.JWTVerifier, policyRetriever iaminterfaces.PolicyRetriever, vhi interfaces.VirtualHosterIdentifier) (allowed bool) {
+	maxExpiryTime time.Time, jwtVerifier utils.JWTVerifier, policyRetriever iaminterfaces.PolicyRetriever, vhi interfaces.VirtualHosterIdentifier,
+	backendManager interfaces.BackendManager, authzMessageKey utils.PrivateKeyKeeper, requester requesterFunc) (allowed bool) {
 	allowed = false
 	var jwtKeyFunc = jwtVerifier.GetJwtKeyFunc()
 	if action == api.GetObject || action == api.HeadObject {
@@ -69,37 +81,72 @@ func authorizeS3Action(ctx context.Context, sessionToken, targetRegion string, a
 	policySessionData := iam.GetPolicySessionDataFromClaims(sessionClaims)
 	requestctx.AddAccessLogInfo(r, "auth", slog.Any("sessionData", policySessionData.Tags))
 	policySessionData.RequestedRegion = targetRegion
-	policyStr, err := policyRetriever.GetPolicy(sessionClaims.RoleARN, policySessionData)
+	policySessionData.SourceIp = requestctx.GetSourceIP(r)
+	pe, err := policyRetriever.GetSessionPolicyEvaluator(sessionClaims, policySessionData)
 	if err != nil {
-		slog.ErrorContext(ctx, "Could not get policy for temporary credentials", "error", err, "role_arn", sessionClaims.RoleARN)
+		slog.ErrorContext(ctx, "Could not get policies for temporary credentials", "error", err, "role_arn", sessionClaims.RoleARN)
 		writeS3ErrorResponse(ctx, w, ErrS3InternalError, nil)
 		return
 	}
-	slog.DebugContext(ctx, "Policy retrieved", "policy", policyStr)
-	pe, err := iam.NewPolicyEvaluatorFromStr(policyStr)
-	if err != nil {
-		slog.ErrorContext(ctx, "Could not create policy generator", "error", err, "policy", sessionClaims.RoleARN)
-		writeS3ErrorResponse(ctx, w, ErrS3InternalError, nil)
-		return
+	slog.DebugContext(ctx, "Policies retrieved", "role_arn", sessionClaims.RoleARN, "sessionPolicies", sessionClaims.SessionPolicies)
+	if action == api.CopyObject || action == api.UploadPartCopy {
+		var sourceBackendId string
+		sourceBackendId, err = getCopySourceBackendId(r, backendManager)
+		if err != nil {
+			writeS3ErrorResponse(ctx, w, ErrS3InvalidArgument, err)
+			return
+		}
+		if copiesSourceTagsAcrossBackends(action, r, sourceBackendId, targetRegion) {
+			source := backendClient{backendId: sourceBackendId, bm: backendManager, requester: requester}
+			err = retrieveCopySourceTags(ctx, r, source)
+			if err != nil {
+				slog.ErrorContext(ctx, "Could not get tags of copy source", "error", err)
+				writeS3ErrorResponse(ctx, w, ErrS3UpstreamError, err)
+				return
+			}
+		}
 	}
 	iamActions, err := newIamActionsFromS3Request(action, r, policySessionData, vhi)
-	if err != nil {
+	if errors.Is(err, errMalformedXML) {
+		writeS3ErrorResponse(ctx, w, ErrS3MalformedXML, err)
+		return
+	} else if errors.Is(err, errPayloadHashMismatch) {
+		writeS3ErrorResponse(ctx, w, ErrS3XAmzContentSHA256Mismatch, err)
+		return
+	} else if errors.Is(err, errUnsupportedOperation) {
+		writeS3ErrorResponse(ctx, w, ErrS3NotImplemented, err)
+		return
+	} else if err != nil {
 		slog.ErrorContext(ctx, "Could not get IAM actions from request", "error", err, "policy", sessionClaims.RoleARN)
 		writeS3ErrorResponse(ctx, w, ErrS3InternalError, nil)
 		return
 	}
-	isAllowed, reason, err := pe.EvaluateAll(iamActions)
+	if requiresExistingObjectTags(action, pe, iamActions) {
+		existingObject := backendClient{backendId: targetRegion, bm: backendManager, requester: requester}
+		iamActions, err = addExistingObjectTagContext(ctx, r, iamActions, existingObject, vhi)
+		if err != nil {
+			slog.ErrorContext(ctx, "Could not get tags of existing object", "error", err)
+			writeS3ErrorResponse(ctx, w, ErrS3UpstreamError, err)
+			return
+		}
+	}
+	if action == api.DeleteObjects {
+		return authorizeDeleteObjects(ctx, pe, iamActions, w, r)
+	}
+	isAllowed, explanations, err := pe.ExplainAll(iamActions)
 	if err != nil {
 		slog.ErrorContext(ctx, "Could not evaluate policy", "error", err, "policy", sessionClaims.RoleARN)
 		writeS3ErrorResponse(ctx, w, ErrS3InternalError, nil)
 		return
 	}
+	requestctx.AddAccessLogInfo(r, "authz", slog.Any("decisions", explanations))
 
 	if isAllowed {
-		slog.DebugContext(ctx, "Allowed access", "reason", reason)
+		slog.DebugContext(ctx, "Allowed access")
 		return true
 	} else {
-		slog.DebugContext(ctx, "Denied access", "reason", reason, "actions", iamActions)
+		slog.DebugContext(ctx, "Denied access", "decision", explanations[len(explanations)-1])
+		setAuthorizationMessageHeader(ctx, w, authzMessageKey, sessionClaims.RoleARN, explanations)
 		writeS3ErrorResponse(ctx, w, ErrS3AccessDenied, nil)
 		return false
 	}
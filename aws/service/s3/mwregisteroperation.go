@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/VITObelgium/fakes3pp/aws/service/s3/api"
+	"github.com/VITObelgium/fakes3pp/constants"
 	"github.com/VITObelgium/fakes3pp/middleware"
 	"github.com/VITObelgium/fakes3pp/requestctx"
 	"github.com/VITObelgium/fakes3pp/server"
@@ -17,22 +18,84 @@ func registerOperation(operation fmt.Stringer) func(w http.ResponseWriter, r *ht
 	}
 }
 
+// Query parameters that select a subresource of a bucket or object e.g. GET /bucket?policy is GetBucketPolicy.
+// Requests for subresources that are not supported must not be mistaken for a listing or a download.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_Operations_Amazon_Simple_Storage_Service.html
+var s3Subresources = []string{
+	"accelerate", "acl", "analytics", "attributes", "cors", "delete", "encryption", "intelligent-tiering",
+	"inventory", "legal-hold", "lifecycle", "location", "logging", "metadataConfiguration", "metadataTable",
+	"metrics", "notification", "object-lock", "ownershipControls", "policy", "policyStatus", "publicAccessBlock",
+	"replication", "requestPayment", "restore", "retention", "select", "session", "tagging", "torrent", "uploadId",
+	"uploads", "versioning", "versions", "website",
+}
+
+func hasNoSubresource(r *http.Request, _ *mux.RouteMatch) bool {
+	query := r.URL.Query()
+	for _, subresource := range s3Subresources {
+		if query.Has(subresource) {
+			return false
+		}
+	}
+	return true
+}
+
 // Register an operation into the requestctx such that it can be retrieved by the context
 func RegisterOperation() middleware.Middleware {
 	router := mux.NewRouter().SkipClean(true).UseEncodedPath()
 	s3Router := router.NewRoute().PathPrefix(server.SlashSeparator).Subrouter()
-	s3Router.Methods(http.MethodGet).Queries("list-type", "2").HandlerFunc(registerOperation(api.ListObjectsV2))
+	// Path of requests that target an object rather than a bucket
+	objectPath := "/{bucket}/{object:.+}"
+	// Path of requests that target a bucket, with or without trailing slash
+	bucketPath := "/{bucket}{slash:/?}"
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetObjectTagging.html
+	s3Router.Methods(http.MethodGet).Path(objectPath).Queries("tagging", "").HandlerFunc(
+		registerOperation(api.GetObjectTagging))
+	s3Router.Methods(http.MethodGet).Path(bucketPath).Queries("list-type", "2").MatcherFunc(hasNoSubresource).HandlerFunc(
+		registerOperation(api.ListObjectsV2))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetBucketLocation.html
+	s3Router.Methods(http.MethodGet).Path(bucketPath).Queries("location", "").HandlerFunc(
+		registerOperation(api.GetBucketLocation))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetBucketVersioning.html
+	s3Router.Methods(http.MethodGet).Path(bucketPath).Queries("versioning", "").HandlerFunc(
+		registerOperation(api.GetBucketVersioning))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListMultipartUploads.html
+	s3Router.Methods(http.MethodGet).Path(bucketPath).Queries("uploads", "").HandlerFunc(
+		registerOperation(api.ListMultipartUploads))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListParts.html
+	s3Router.Methods(http.MethodGet).Queries("uploadId", "{id:.*}").HandlerFunc(
+		registerOperation(api.ListParts))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectVersions.html
+	s3Router.Methods(http.MethodGet).Path(bucketPath).Queries("versions", "").HandlerFunc(
+		registerOperation(api.ListObjectVersions))
 	s3Router.Methods(http.MethodGet).Path("/").HandlerFunc(
 		registerOperation(api.ListBuckets))
-	s3Router.Methods(http.MethodGet).HandlerFunc(
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjects.html
+	// A GET on a bucket without list-type or subresource is a ListObjects (v1) request
+	s3Router.Methods(http.MethodGet).Path("/{bucket}").MatcherFunc(hasNoSubresource).HandlerFunc(
+		registerOperation(api.ListObjects))
+	s3Router.Methods(http.MethodGet).Path("/{bucket}/").MatcherFunc(hasNoSubresource).HandlerFunc(
+		registerOperation(api.ListObjects))
+	s3Router.Methods(http.MethodGet).Path(objectPath).MatcherFunc(hasNoSubresource).HandlerFunc(
 		registerOperation(api.GetObject))
 	s3Router.Methods(http.MethodHead).Path("/").HandlerFunc(
 		registerOperation(api.HeadBucket))
 	s3Router.Methods(http.MethodHead).HandlerFunc(
 		registerOperation(api.HeadObject))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObjectTagging.html
+	s3Router.Methods(http.MethodPut).Path(objectPath).Queries("tagging", "").HandlerFunc(
+		registerOperation(api.PutObjectTagging))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutBucketVersioning.html
+	s3Router.Methods(http.MethodPut).Path(bucketPath).Queries("versioning", "").HandlerFunc(
+		registerOperation(api.PutBucketVersioning))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_UploadPartCopy.html
+	s3Router.Methods(http.MethodPut).Queries("partNumber", "{pn:.*}", "uploadId", "{ui:.*}").HeadersRegexp(constants.AmzCopySource, ".+").HandlerFunc(
+		registerOperation(api.UploadPartCopy))
 	s3Router.Methods(http.MethodPut).Queries("partNumber", "{pn:.*}", "uploadId", "{ui:.*}").HandlerFunc(
 		registerOperation(api.UploadPart))
-	s3Router.Methods(http.MethodPut).HandlerFunc(
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CopyObject.html
+	s3Router.Methods(http.MethodPut).HeadersRegexp(constants.AmzCopySource, ".+").MatcherFunc(hasNoSubresource).HandlerFunc(
+		registerOperation(api.CopyObject))
+	s3Router.Methods(http.MethodPut).MatcherFunc(hasNoSubresource).HandlerFunc(
 		registerOperation(api.PutObject))
 
 	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CreateMultipartUpload.html
@@ -40,9 +103,24 @@ func RegisterOperation() middleware.Middleware {
 		registerOperation(api.CreateMultipartUpload))
 	s3Router.Methods(http.MethodPost).Queries("uploadId", "{id:.*}").HandlerFunc(
 		registerOperation(api.CompleteMultipartUpload))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObjects.html
+	s3Router.Methods(http.MethodPost).Path(bucketPath).Queries("delete", "").HandlerFunc(
+		registerOperation(api.DeleteObjects))
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectPOST.html
+	// Browser-based uploads POST a form to the bucket
+	s3Router.Methods(http.MethodPost).Path("/{bucket}").HeadersRegexp("Content-Type", "multipart/form-data.*").HandlerFunc(
+		registerOperation(api.PostObject))
+	s3Router.Methods(http.MethodPost).Path("/{bucket}/").HeadersRegexp("Content-Type", "multipart/form-data.*").HandlerFunc(
+		registerOperation(api.PostObject))
 
+	// https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObjectTagging.html
+	s3Router.Methods(http.MethodDelete).Path(objectPath).Queries("tagging", "").HandlerFunc(
+		registerOperation(api.DeleteObjectTagging))
 	s3Router.Methods(http.MethodDelete).Queries("uploadId", "{id:.*}").HandlerFunc(
 		registerOperation(api.AbortMultipartUpload))
+	// DELETE on a bucket (DeleteBucket) is not supported so it must not be mistaken for a DeleteObject
+	s3Router.Methods(http.MethodDelete).Path(objectPath).MatcherFunc(hasNoSubresource).HandlerFunc(
+		registerOperation(api.DeleteObject))
 
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
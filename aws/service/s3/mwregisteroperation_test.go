@@ -0,0 +1,102 @@
+package s3
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/api"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+)
+
+// Route a request through the RegisterOperation middleware and return the request with its registered operation
+func routeTestRequest(method, target string, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req = req.WithContext(requestctx.NewContextFromHttpRequest(req))
+	RegisterOperation()(func(w http.ResponseWriter, r *http.Request) {})(httptest.NewRecorder(), req)
+	return req
+}
+
+type registerOperationTestCase struct {
+	Method            string
+	Target            string
+	Headers           map[string]string
+	ExpectedOperation api.S3Operation
+}
+
+func runRegisterOperationTestCases(t *testing.T, testCases []registerOperationTestCase) {
+	for _, tc := range testCases {
+		req := routeTestRequest(tc.Method, tc.Target, tc.Headers)
+		if got := getS3Action(req); got != tc.ExpectedOperation {
+			t.Errorf("%s %s: expected operation %s, got %s", tc.Method, tc.Target, tc.ExpectedOperation, got)
+		}
+	}
+}
+
+func TestRegisterOperationDelete(t *testing.T) {
+	runRegisterOperationTestCases(t, []registerOperationTestCase{
+		{http.MethodDelete, "/bucket/key", nil, api.DeleteObject},
+		{http.MethodDelete, "/bucket/dir/key", nil, api.DeleteObject},
+		{http.MethodDelete, "/bucket/key?uploadId=abc", nil, api.AbortMultipartUpload},
+		{http.MethodDelete, "/bucket/key?tagging", nil, api.DeleteObjectTagging},
+		{http.MethodDelete, "/bucket", nil, api.UnknownOperation},
+		{http.MethodDelete, "/bucket/", nil, api.UnknownOperation},
+	})
+}
+
+func TestDeleteBucketIsNotAuthorizedAsDeleteObject(t *testing.T) {
+	for _, target := range []string{"/bucket", "/bucket/"} {
+		req := routeTestRequest(http.MethodDelete, target, nil)
+		_, err := newIamActionsFromS3Request(getS3Action(req), req, nil, noVirtualHostRequests)
+		if !errors.Is(err, errUnsupportedOperation) {
+			t.Errorf("DELETE %s: expected unsupported operation, got %v", target, err)
+		}
+	}
+}
+
+func TestRegisterOperationGetSubresources(t *testing.T) {
+	runRegisterOperationTestCases(t, []registerOperationTestCase{
+		{http.MethodGet, "/bucket", nil, api.ListObjects},
+		{http.MethodGet, "/bucket/", nil, api.ListObjects},
+		{http.MethodGet, "/bucket?prefix=a%2F&delimiter=%2F&marker=a%2Fb&max-keys=10", nil, api.ListObjects},
+		{http.MethodGet, "/bucket?list-type=2&prefix=a%2F", nil, api.ListObjectsV2},
+		{http.MethodGet, "/bucket?versions", nil, api.ListObjectVersions},
+		{http.MethodGet, "/bucket/key", nil, api.GetObject},
+		{http.MethodGet, "/bucket/key?versionId=abc&partNumber=1", nil, api.GetObject},
+		{http.MethodGet, "/bucket?policy", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket/?acl", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket?lifecycle", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket?cors", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket?replication", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket?list-type=2&policy", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket/key?acl", nil, api.UnknownOperation},
+	})
+}
+
+func TestRegisterOperationBucketOperationsOnObjectPath(t *testing.T) {
+	runRegisterOperationTestCases(t, []registerOperationTestCase{
+		{http.MethodGet, "/bucket?versioning", nil, api.GetBucketVersioning},
+		{http.MethodGet, "/bucket/?versioning", nil, api.GetBucketVersioning},
+		{http.MethodPut, "/bucket?versioning", nil, api.PutBucketVersioning},
+		{http.MethodGet, "/bucket?uploads", nil, api.ListMultipartUploads},
+		{http.MethodGet, "/bucket?versions", nil, api.ListObjectVersions},
+		{http.MethodGet, "/bucket/?location", nil, api.GetBucketLocation},
+		{http.MethodPost, "/bucket?delete", nil, api.DeleteObjects},
+		{http.MethodGet, "/bucket/key?versioning", nil, api.UnknownOperation},
+		{http.MethodPut, "/bucket/key?versioning", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket/key?uploads", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket/key?versions", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket/key?location", nil, api.UnknownOperation},
+		{http.MethodPost, "/bucket/key?delete", nil, api.UnknownOperation},
+		{http.MethodGet, "/bucket/key?list-type=2", nil, api.GetObject},
+		{http.MethodPut, "/bucket/key?acl", nil, api.UnknownOperation},
+		{http.MethodDelete, "/bucket/key?versioning", nil, api.UnknownOperation},
+		{http.MethodPut, "/bucket/key", nil, api.PutObject},
+		{http.MethodPut, "/bucket/key", map[string]string{"X-Amz-Copy-Source": "/src/key"}, api.CopyObject},
+		{http.MethodPut, "/bucket/key?partNumber=1&uploadId=abc", nil, api.UploadPart},
+	})
+}
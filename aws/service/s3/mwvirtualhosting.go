@@ -0,0 +1,55 @@
+package s3
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/middleware"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/requestutils"
+)
+
+// Get the path-style equivalent of the escaped path of a virtual-hosted-style request.
+// Operations on the bucket itself use /bucket rather than /bucket/ such that they are routed as for path-style requests.
+func getPathStyleEscapedPath(bucket, escapedPath string) string {
+	if escapedPath == "" || escapedPath == "/" {
+		return "/" + bucket
+	}
+	return "/" + bucket + escapedPath
+}
+
+// Rewrite virtual-hosted-style requests (https://bucket.s3.example.com/key) to path-style requests
+// (https://s3.example.com/bucket/key). All further handling only has to deal with path-style requests
+// which also means that requests towards the backend are always path-style.
+// The addressing as received is kept in the requestctx because the signature of the request was
+// calculated over it.
+func RewriteVirtualHostedStyle(vhi interfaces.VirtualHosterIdentifier) middleware.Middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			bucket, ok := vhi.GetVirtualHostedBucket(r)
+			if !ok {
+				next(w, r)
+				return
+			}
+			escapedPath := r.URL.EscapedPath()
+			requestctx.SetVirtualHostedAddressing(r, r.Host, escapedPath)
+			pathStyleEscapedPath := getPathStyleEscapedPath(bucket, escapedPath)
+			err := requestutils.SetEscapedPath(r.URL, pathStyleEscapedPath)
+			if err != nil {
+				writeS3ErrorResponse(r.Context(), w, ErrS3InternalError, err)
+				return
+			}
+			if _, query, hasQuery := strings.Cut(r.RequestURI, "?"); hasQuery {
+				r.RequestURI = pathStyleEscapedPath + "?" + query
+			} else {
+				r.RequestURI = pathStyleEscapedPath
+			}
+			// The bucket is the first label of the host so what remains is the FQDN of the proxy (and the port)
+			r.Host = r.Host[len(bucket)+1:]
+			slog.DebugContext(r.Context(), "Rewrote virtual-hosted-style request", "bucket", bucket, "host", r.Host, "requestURI", r.RequestURI)
+			next(w, r)
+		}
+	}
+}
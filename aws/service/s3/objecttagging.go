@@ -0,0 +1,229 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/api"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/presign"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/usererror"
+	"github.com/VITObelgium/fakes3pp/utils"
+	"github.com/micahhausler/aws-iam-policy/policy"
+)
+
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/object-tagging.html
+// An object can have up to 10 tags so a tagging document is small
+const maxTaggingBodyBytes = 64 * 1024
+
+const amzTaggingHeader = "X-Amz-Tagging"
+
+const copySourceTagsKey = "copySourceTags"
+
+type objectTag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutObjectTagging.html#API_PutObjectTagging_RequestSyntax
+type tagging struct {
+	XMLName xml.Name    `xml:"Tagging"`
+	TagSet  []objectTag `xml:"TagSet>Tag"`
+}
+
+func (t tagging) toMap() map[string]string {
+	tags := map[string]string{}
+	for _, tag := range t.TagSet {
+		tags[tag.Key] = tag.Value
+	}
+	return tags
+}
+
+// The operations for which the s3:ExistingObjectTag/<key> condition keys are available.
+// https://docs.aws.amazon.com/service-authorization/latest/reference/list_amazons3.html
+var operationsWithExistingObjectTags = []api.S3Operation{
+	api.GetObject,
+	api.HeadObject,
+	api.GetObjectTagging,
+	api.PutObjectTagging,
+	api.DeleteObjectTagging,
+}
+
+// Get the tags of the x-amz-tagging header which are encoded as URL query parameters (e.g. Key1=Value1&Key2=Value2)
+func getTagsFromTaggingHeader(req *http.Request) (map[string]string, error) {
+	headerValue := req.Header.Get(amzTaggingHeader)
+	if headerValue == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(headerValue)
+	if err != nil {
+		return nil, usererror.New(
+			fmt.Errorf("invalid %s header %q: %w", amzTaggingHeader, headerValue, err),
+			"The x-amz-tagging header must be URL query encoded",
+		)
+	}
+	tags := map[string]string{}
+	for key, tagValues := range values {
+		tags[key] = tagValues[0]
+	}
+	return tags, nil
+}
+
+// Get the tags from the body of a PutObjectTagging request
+func getTagsFromPutObjectTaggingRequest(req *http.Request) (map[string]string, error) {
+	body, err := readSignedRequestBody(req, maxTaggingBodyBytes)
+	if err != nil {
+		return nil, err
+	}
+	t := tagging{}
+	err = xml.Unmarshal(body, &t)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errMalformedXML, err)
+	}
+	return t.toMap(), nil
+}
+
+// Get the tags from the tagging field of a POST form which holds a tagging document
+func getTagsFromPostForm(form *presign.PostPolicyForm) (map[string]string, error) {
+	taggingDocument := form.Get("tagging")
+	if taggingDocument == "" {
+		return nil, nil
+	}
+	t := tagging{}
+	err := xml.Unmarshal([]byte(taggingDocument), &t)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errMalformedXML, err)
+	}
+	return t.toMap(), nil
+}
+
+// Build the condition keys for tags using a prefix like s3:RequestObjectTag/
+func getObjectTagContext(prefix string, tags map[string]string) map[string]*policy.ConditionValue {
+	context := map[string]*policy.ConditionValue{}
+	for key, value := range tags {
+		context[prefix+key] = policy.NewConditionValueString(true, value)
+	}
+	return context
+}
+
+// Whether evaluating the policy could depend on the tags of an existing object. Retrieving the tags
+// requires an additional request to the backend so only do this when the policy refers to them.
+func requiresExistingObjectTags(action api.S3Operation, pe *iam.PolicyEvaluator, iamActions []iam.IAMAction) bool {
+	return slices.Contains(operationsWithExistingObjectTags, action) &&
+		pe.UsesConditionKeyPrefix(actionnames.IAMConditionS3ExistingObjectTagPrefix, iamActions)
+}
+
+// Retrieve the tags of an object from the backend. An object that does not exist has no tags.
+func getObjectTags(ctx context.Context, backend backendClient, bucket, key, versionId string) (map[string]string, error) {
+	query := url.Values{"tagging": {""}}
+	if versionId != "" {
+		query.Set("versionId", versionId)
+	}
+	resp, err := backend.do(ctx, http.MethodGet, makeS3ObjectPath(bucket, key), query, nil, nil, 0)
+	var backendErr *backendErrorResponse
+	if errors.As(err, &backendErr) && backendErr.statusCode == http.StatusNotFound {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer utils.Close(resp.Body, "GetObjectTagging response body", ctx)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTaggingBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	t := tagging{}
+	err = xml.Unmarshal(body, &t)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode tags of existing object: %w", err)
+	}
+	return t.toMap(), nil
+}
+
+// Retrieve the tags of the object targeted by the request from the backend
+func getExistingObjectTags(ctx context.Context, req *http.Request, backend backendClient, vhi interfaces.VirtualHosterIdentifier) (map[string]string, error) {
+	bucket, key, err := getS3ObjectFromRequest(req, vhi)
+	if err != nil {
+		return nil, err
+	}
+	return getObjectTags(ctx, backend, bucket, key, req.URL.Query().Get("versionId"))
+}
+
+// Add the s3:ExistingObjectTag/<key> condition keys to the IAM actions of a request
+func addExistingObjectTagContext(ctx context.Context, req *http.Request, actions []iam.IAMAction, backend backendClient,
+	vhi interfaces.VirtualHosterIdentifier) ([]iam.IAMAction, error) {
+	tags, err := getExistingObjectTags(ctx, req, backend, vhi)
+	if err != nil {
+		return nil, err
+	}
+	slog.DebugContext(ctx, "Retrieved tags of existing object", "tags", tags)
+	tagContext := getObjectTagContext(actionnames.IAMConditionS3ExistingObjectTagPrefix, tags)
+	for i := range actions {
+		actions[i] = actions[i].AddContext(tagContext)
+	}
+	return actions, nil
+}
+
+// Encode tags as the value of an x-amz-tagging header
+func encodeTaggingHeader(tags map[string]string) string {
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+// Whether the tags of the source object of a copy are written to the destination by the proxy. Within a backend
+// the backend copies them itself but across backends the proxy does when the tagging directive is COPY, the default.
+func copiesSourceTagsAcrossBackends(action api.S3Operation, r *http.Request, sourceBackendId, targetBackendId string) bool {
+	return action == api.CopyObject && sourceBackendId != "" && sourceBackendId != targetBackendId &&
+		!strings.EqualFold(r.Header.Get("X-Amz-Tagging-Directive"), "REPLACE")
+}
+
+// Retrieve the tags of the source object of a cross-backend copy. They are kept in the request context such that
+// the copy is authorized with them as existing tags of the source and requested tags of the destination and such
+// that they get written to the destination.
+func retrieveCopySourceTags(ctx context.Context, r *http.Request, source backendClient) error {
+	rCtx, ok := requestctx.FromContext(ctx)
+	if !ok {
+		return errors.New("could not keep track of copy source tags without request context")
+	}
+	bucket, key, versionId, err := getS3CopySourceFromRequest(r)
+	if err != nil {
+		return err
+	}
+	tags, err := getObjectTags(ctx, source, bucket, key, versionId)
+	if err != nil {
+		return err
+	}
+	slog.DebugContext(ctx, "Retrieved tags of copy source", "tags", tags)
+	rCtx.SetDataKey(copySourceTagsKey, tags)
+	return nil
+}
+
+// Get the tags of the source object of a copy, nil if they were not retrieved
+func getCopySourceTags(r *http.Request) map[string]string {
+	rCtx, ok := requestctx.FromContext(r.Context())
+	if !ok {
+		return nil
+	}
+	v, err := rCtx.GetData(copySourceTagsKey)
+	if err != nil {
+		return nil
+	}
+	tags, ok := v.(map[string]string)
+	if !ok {
+		return nil
+	}
+	return tags
+}
@@ -0,0 +1,115 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/testutils"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+var testPolicyAllowGetPublicClassificationARN = "arn:aws:iam::000000000000:role/AllowGetPublicClassification"
+var testPolicyAllowGetPublicClassification = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/*",
+			"Condition": {
+				"StringLike": {
+					"%sclassification": "public"
+				}
+			}
+		}
+	]
+}`, actionnames.IAMActionS3GetObject, testBucketARN, actionnames.IAMConditionS3ExistingObjectTagPrefix)
+
+// A fake backend that knows the tags of objects by their key
+func newFakeTaggingBackend(tagsByKey map[string]string) (requester requesterFunc, tagRequests *int) {
+	tagRequests = new(int)
+	requester = func(r *http.Request) (*http.Response, error) {
+		*tagRequests++
+		if !r.URL.Query().Has("tagging") {
+			return fakeBackendResponse(http.StatusBadRequest, nil, nil), nil
+		}
+		key := strings.TrimPrefix(r.URL.Path, fmt.Sprintf("/%s/", testBucketName))
+		classification, exists := tagsByKey[key]
+		if !exists {
+			return fakeBackendResponse(http.StatusNotFound, nil, []byte("<Error><Code>NoSuchKey</Code></Error>")), nil
+		}
+		body := fmt.Sprintf("<Tagging><TagSet><Tag><Key>classification</Key><Value>%s</Value></Tag></TagSet></Tagging>", classification)
+		return fakeBackendResponse(http.StatusOK, nil, []byte(body)), nil
+	}
+	return requester, tagRequests
+}
+
+func runGetObjectWithPolicy(t *testing.T, s *S3Server, policyArn, key string) error {
+	cred := createTestCredentialsForPolicy(t, policyArn, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3(t, "waw3-1", cred, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &testBucketName,
+		Key:    &key,
+	})
+	return err
+}
+
+func isAccessDenied(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "AccessDenied")
+}
+
+func TestExistingObjectTagConditionUsesTagsOfBackend(t *testing.T) {
+	requester, tagRequests := newFakeTaggingBackend(map[string]string{
+		"public-object":  "public",
+		"private-object": "confidential",
+	})
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowGetPublicClassificationARN: testPolicyAllowGetPublicClassification})
+	hb := handlerBuilder{proxyFunc: testProxyStub, requester: requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When getting an object that has the required tag
+	err := runGetObjectWithPolicy(t, s, testPolicyAllowGetPublicClassificationARN, "public-object")
+	//Then access is allowed
+	if err != nil {
+		t.Errorf("Object with matching tag should be allowed, got %s", err)
+	}
+
+	//When getting an object with another value for the tag or that does not exist
+	for _, key := range []string{"private-object", "missing-object"} {
+		err = runGetObjectWithPolicy(t, s, testPolicyAllowGetPublicClassificationARN, key)
+		//Then access is denied
+		if !isAccessDenied(err) {
+			t.Errorf("%s: expected AccessDenied, got %v", key, err)
+		}
+	}
+	if *tagRequests != 3 {
+		t.Errorf("Expected the tags to be retrieved 3 times, got %d", *tagRequests)
+	}
+}
+
+func TestExistingObjectTagsAreOnlyRetrievedWhenPolicyUsesThem(t *testing.T) {
+	requester, tagRequests := newFakeTaggingBackend(map[string]string{})
+	hb := handlerBuilder{proxyFunc: testProxyStub, requester: requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	//When getting an object with a policy that does not use existing object tags
+	err := runGetObjectWithPolicy(t, s, testPolicyAllowAllARN, "some-object")
+
+	//Then access is allowed without contacting the backend for tags
+	if err != nil {
+		t.Errorf("Expected access to be allowed, got %s", err)
+	}
+	if *tagRequests != 0 {
+		t.Errorf("Tags should not have been retrieved, got %d requests", *tagRequests)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"reflect"
 	"testing"
 	"time"
@@ -20,6 +21,7 @@ import (
 	"github.com/VITObelgium/fakes3pp/testutils"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/micahhausler/aws-iam-policy/policy"
 )
 
@@ -35,6 +37,10 @@ func (*noVirtualHostRequestsType) IsVirtualHostingRequest(req *http.Request) boo
 	return false
 }
 
+func (*noVirtualHostRequestsType) GetVirtualHostedBucket(req *http.Request) (string, bool) {
+	return "", false
+}
+
 var noVirtualHostRequests = &noVirtualHostRequestsType{}
 
 func (p *StubJustReturnIamAction) Build(backendManager interfaces.BackendManager, corsHandler interfaces.CORSHandler) http.HandlerFunc {
@@ -123,6 +129,41 @@ func runListObjectsV2WithPrefixAndReturnError(t *testing.T, s server.Serverable)
 	return err
 }
 
+var listobjects_test_delimiter string = "/"
+var listobjects_test_max_keys int32 = 10
+
+func runListObjectsAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.ListObjectsInput{
+		Bucket:    &testBucketName,
+		Prefix:    &listobjectv2_test_prefix,
+		Delimiter: &listobjects_test_delimiter,
+		MaxKeys:   &listobjects_test_max_keys,
+	}
+	defer cancel()
+	_, err := client.ListObjects(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runListObjectVersionsAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.ListObjectVersionsInput{
+		Bucket:    &testBucketName,
+		Delimiter: &listobjects_test_delimiter,
+	}
+	defer cancel()
+	_, err := client.ListObjectVersions(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
 func runListBucketsAndReturnError(t *testing.T, s server.Serverable) error {
 	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
 
@@ -251,6 +292,317 @@ func runUploadPartAndReturnError(t *testing.T, s server.Serverable) error {
 	return err
 }
 
+func runDeleteObjectAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.DeleteObjectInput{
+		Bucket: &testBucketName,
+		Key:    &putObjectTestKey,
+	}
+	defer cancel()
+	_, err := client.DeleteObject(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+var deleteObjectsTestKey2 string = "my/other/key"
+
+func runDeleteObjectsAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.DeleteObjectsInput{
+		Bucket: &testBucketName,
+		Delete: &types.Delete{
+			Objects: []types.ObjectIdentifier{
+				{Key: &putObjectTestKey},
+				{Key: &deleteObjectsTestKey2},
+			},
+		},
+	}
+	defer cancel()
+	_, err := client.DeleteObjects(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+var copySourceTestBucket string = "source-bucket"
+var copySourceTestKey string = "source/key with space"
+var copySourceTestObjectARN string = fmt.Sprintf("arn:aws:s3:::%s/%s", copySourceTestBucket, copySourceTestKey)
+var copySourceTestHeader string = fmt.Sprintf("%s/%s", copySourceTestBucket, "source/key%20with%20space")
+
+func runCopyObjectAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.CopyObjectInput{
+		Bucket:     &testBucketName,
+		Key:        &putObjectTestKey,
+		CopySource: &copySourceTestHeader,
+	}
+	defer cancel()
+	_, err := client.CopyObject(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runUploadPartCopyAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+	testId := "Thisisjustastringfortesting"
+	var partNumber int32 = 1
+
+	input := s3.UploadPartCopyInput{
+		Bucket:     &testBucketName,
+		Key:        &putObjectTestKey,
+		UploadId:   &testId,
+		PartNumber: &partNumber,
+		CopySource: &copySourceTestHeader,
+	}
+	defer cancel()
+	_, err := client.UploadPartCopy(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+var versionIdTestValue string = "3HL4kqtJlcpXroDTDmJ+rmSpXd3dIbrHY"
+
+func runGetObjectVersionAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.GetObjectInput{
+		Bucket:    &testBucketName,
+		Key:       &putObjectTestKey,
+		VersionId: &versionIdTestValue,
+	}
+	defer cancel()
+	_, err := client.GetObject(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runHeadObjectVersionAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.HeadObjectInput{
+		Bucket:    &testBucketName,
+		Key:       &putObjectTestKey,
+		VersionId: &versionIdTestValue,
+	}
+	defer cancel()
+	_, err := client.HeadObject(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runDeleteObjectVersionAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.DeleteObjectInput{
+		Bucket:    &testBucketName,
+		Key:       &putObjectTestKey,
+		VersionId: &versionIdTestValue,
+	}
+	defer cancel()
+	_, err := client.DeleteObject(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runDeleteObjectsWithVersionAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.DeleteObjectsInput{
+		Bucket: &testBucketName,
+		Delete: &types.Delete{
+			Objects: []types.ObjectIdentifier{
+				{Key: &putObjectTestKey, VersionId: &versionIdTestValue},
+				{Key: &deleteObjectsTestKey2},
+			},
+		},
+	}
+	defer cancel()
+	_, err := client.DeleteObjects(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runCopyObjectVersionAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+	copySource := fmt.Sprintf("%s?versionId=%s", copySourceTestHeader, url.QueryEscape(versionIdTestValue))
+
+	input := s3.CopyObjectInput{
+		Bucket:     &testBucketName,
+		Key:        &putObjectTestKey,
+		CopySource: &copySource,
+	}
+	defer cancel()
+	_, err := client.CopyObject(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runGetBucketVersioningAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.GetBucketVersioningInput{
+		Bucket: &testBucketName,
+	}
+	defer cancel()
+	_, err := client.GetBucketVersioning(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runPutBucketVersioningAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.PutBucketVersioningInput{
+		Bucket: &testBucketName,
+		VersioningConfiguration: &types.VersioningConfiguration{
+			Status: types.BucketVersioningStatusEnabled,
+		},
+	}
+	defer cancel()
+	_, err := client.PutBucketVersioning(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runListMultipartUploadsAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.ListMultipartUploadsInput{
+		Bucket: &testBucketName,
+	}
+	defer cancel()
+	_, err := client.ListMultipartUploads(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runListPartsAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+	testId := "Thisisjustastringfortesting"
+
+	input := s3.ListPartsInput{
+		Bucket:   &testBucketName,
+		Key:      &putObjectTestKey,
+		UploadId: &testId,
+	}
+	defer cancel()
+	_, err := client.ListParts(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+var taggingTestKey string = "classification"
+var taggingTestValue string = "public"
+
+func runGetObjectTaggingAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.GetObjectTaggingInput{
+		Bucket: &testBucketName,
+		Key:    &putObjectTestKey,
+	}
+	defer cancel()
+	_, err := client.GetObjectTagging(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runPutObjectTaggingAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.PutObjectTaggingInput{
+		Bucket: &testBucketName,
+		Key:    &putObjectTestKey,
+		Tagging: &types.Tagging{
+			TagSet: []types.Tag{{Key: &taggingTestKey, Value: &taggingTestValue}},
+		},
+	}
+	defer cancel()
+	_, err := client.PutObjectTagging(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runDeleteObjectTaggingAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.DeleteObjectTaggingInput{
+		Bucket:    &testBucketName,
+		Key:       &putObjectTestKey,
+		VersionId: &versionIdTestValue,
+	}
+	defer cancel()
+	_, err := client.DeleteObjectTagging(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runPutObjectWithTaggingAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+	tagging := fmt.Sprintf("%s=%s", taggingTestKey, taggingTestValue)
+
+	input := s3.PutObjectInput{
+		Bucket:  &testBucketName,
+		Key:     &putObjectTestKey,
+		Body:    bytes.NewReader([]byte("test")),
+		Tagging: &tagging,
+	}
+	defer cancel()
+	_, err := client.PutObject(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
+func runGetBucketLocationAndReturnError(t *testing.T, s server.Serverable) error {
+	client, max1Sec, cancel := getAnonymousS3TestClient(t, s)
+
+	input := s3.GetBucketLocationInput{
+		Bucket: &testBucketName,
+	}
+	defer cancel()
+	_, err := client.GetBucketLocation(max1Sec, &input)
+	if err == nil {
+		t.Error("Should have encountered error but did not")
+	}
+	return err
+}
+
 type s3CallTestFunc func(*testing.T, server.Serverable) error
 type contextType map[string]*policy.ConditionValue
 
@@ -292,6 +644,27 @@ func getApiAndIAMActionTestCases() []apiAndIAMActionTestCase {
 				}),
 			},
 		},
+		{
+			ApiAction: "ListObjects",
+			ApiCall:   runListObjectsAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3ListBucket, testBucketARN, nil).AddContext(contextType{
+					actionnames.IAMConditionS3Prefix:    policy.NewConditionValueString(true, listobjectv2_test_prefix),
+					actionnames.IAMConditionS3Delimiter: policy.NewConditionValueString(true, listobjects_test_delimiter),
+					actionnames.IAMConditionS3MaxKeys:   policy.NewConditionValueString(true, "10"),
+				}),
+			},
+		},
+		{
+			ApiAction: "ListObjectVersions",
+			ApiCall:   runListObjectVersionsAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3ListBucketVersions, testBucketARN, nil).AddContext(contextType{
+					actionnames.IAMConditionS3Prefix:    policy.NewConditionValueString(true, ""),
+					actionnames.IAMConditionS3Delimiter: policy.NewConditionValueString(true, listobjects_test_delimiter),
+				}),
+			},
+		},
 		{
 			ApiAction: "PutObject",
 			ApiCall:   runPutObjectAndReturnError,
@@ -356,6 +729,148 @@ func getApiAndIAMActionTestCases() []apiAndIAMActionTestCase {
 				iam.NewIamAction(actionnames.IAMActionS3PutObject, putObjectFullObjectARN, nil),
 			},
 		},
+		{
+			ApiAction: "DeleteObject",
+			ApiCall:   runDeleteObjectAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3DeleteObject, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "DeleteObjects",
+			ApiCall:   runDeleteObjectsAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				//https://docs.aws.amazon.com/AmazonS3/latest/API/API_DeleteObjects.html
+				//Each key requires the s3:DeleteObject permission on its own
+				iam.NewIamAction(actionnames.IAMActionS3DeleteObject, putObjectFullObjectARN, nil),
+				iam.NewIamAction(actionnames.IAMActionS3DeleteObject, fmt.Sprintf("%s/%s", testBucketARN, deleteObjectsTestKey2), nil),
+			},
+		},
+		{
+			ApiAction: "CopyObject",
+			ApiCall:   runCopyObjectAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				//https://docs.aws.amazon.com/AmazonS3/latest/API/API_CopyObject.html
+				//Requires read access on the source and write access on the destination
+				iam.NewIamAction(actionnames.IAMActionS3GetObject, copySourceTestObjectARN, nil),
+				iam.NewIamAction(actionnames.IAMActionS3PutObject, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "UploadPartCopy",
+			ApiCall:   runUploadPartCopyAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3GetObject, copySourceTestObjectARN, nil),
+				iam.NewIamAction(actionnames.IAMActionS3PutObject, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "GetObject",
+			ApiCall:   runGetObjectVersionAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3GetObjectVersion, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "HeadObject",
+			ApiCall:   runHeadObjectVersionAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3GetObjectVersion, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "DeleteObject",
+			ApiCall:   runDeleteObjectVersionAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3DeleteObjectVersion, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "DeleteObjects",
+			ApiCall:   runDeleteObjectsWithVersionAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3DeleteObjectVersion, putObjectFullObjectARN, nil),
+				iam.NewIamAction(actionnames.IAMActionS3DeleteObject, fmt.Sprintf("%s/%s", testBucketARN, deleteObjectsTestKey2), nil),
+			},
+		},
+		{
+			ApiAction: "CopyObject",
+			ApiCall:   runCopyObjectVersionAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3GetObjectVersion, copySourceTestObjectARN, nil),
+				iam.NewIamAction(actionnames.IAMActionS3PutObject, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "GetBucketVersioning",
+			ApiCall:   runGetBucketVersioningAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3GetBucketVersioning, testBucketARN, nil),
+			},
+		},
+		{
+			ApiAction: "PutBucketVersioning",
+			ApiCall:   runPutBucketVersioningAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3PutBucketVersioning, testBucketARN, nil),
+			},
+		},
+		{
+			ApiAction: "ListMultipartUploads",
+			ApiCall:   runListMultipartUploadsAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3ListBucketMultipartUploads, testBucketARN, nil),
+			},
+		},
+		{
+			ApiAction: "ListParts",
+			ApiCall:   runListPartsAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3ListMultipartUploadParts, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "GetObjectTagging",
+			ApiCall:   runGetObjectTaggingAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3GetObjectTagging, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "PutObjectTagging",
+			ApiCall:   runPutObjectTaggingAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3PutObjectTagging, putObjectFullObjectARN, nil).AddContext(contextType{
+					actionnames.IAMConditionS3RequestObjectTagPrefix + taggingTestKey: policy.NewConditionValueString(true, taggingTestValue),
+				}),
+			},
+		},
+		{
+			ApiAction: "DeleteObjectTagging",
+			ApiCall:   runDeleteObjectTaggingAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3DeleteObjectVersionTagging, putObjectFullObjectARN, nil),
+			},
+		},
+		{
+			ApiAction: "PutObject",
+			ApiCall:   runPutObjectWithTaggingAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3PutObject, putObjectFullObjectARN, nil).AddContext(contextType{
+					actionnames.IAMConditionS3RequestObjectTagPrefix + taggingTestKey: policy.NewConditionValueString(true, taggingTestValue),
+				}),
+				iam.NewIamAction(actionnames.IAMActionS3PutObjectTagging, putObjectFullObjectARN, nil).AddContext(contextType{
+					actionnames.IAMConditionS3RequestObjectTagPrefix + taggingTestKey: policy.NewConditionValueString(true, taggingTestValue),
+				}),
+			},
+		},
+		{
+			ApiAction: "GetBucketLocation",
+			ApiCall:   runGetBucketLocationAndReturnError,
+			ExpectedActions: []iam.IAMAction{
+				iam.NewIamAction(actionnames.IAMActionS3GetBucketLocation, testBucketARN, nil),
+			},
+		},
 	}
 	return iamActionTestCases
 }
@@ -367,6 +882,25 @@ func getApiAndIAMActionTestCases() []apiAndIAMActionTestCase {
 // Adding additional context should be OK
 // Removing/changing context values (e.g. if there are bugs) are breaking changes and should be
 // treated as such.
+// Copies of the actions without the keys about the time of the request which must be present in each of them
+func withoutTimeContext(t *testing.T, apiAction string, actions []iam.IAMAction) []iam.IAMAction {
+	result := make([]iam.IAMAction, len(actions))
+	for i, action := range actions {
+		for _, timeKey := range []string{"aws:CurrentTime", "aws:EpochTime"} {
+			if _, ok := action.Context[timeKey]; !ok {
+				t.Errorf("%s: %s is missing from the context of %s", apiAction, timeKey, action.Action)
+			}
+		}
+		result[i] = iam.IAMAction{Action: action.Action, Resource: action.Resource, Context: contextType{}}
+		for contextKey, contextValue := range action.Context {
+			if contextKey != "aws:CurrentTime" && contextKey != "aws:EpochTime" {
+				result[i].Context[contextKey] = contextValue
+			}
+		}
+	}
+	return result
+}
+
 func TestExpectedIamActionsAreReturned(t *testing.T) {
 	teardownSuite, s := setupSuiteProxyS3(t, newStubJustReturnIamAction(t), nil, nil, []middleware.Middleware{RegisterOperation()}, true, nil, nil)
 	defer teardownSuite(t)
@@ -378,10 +912,53 @@ func TestExpectedIamActionsAreReturned(t *testing.T) {
 			t.FailNow()
 		}
 
-		if !reflect.DeepEqual(latestIamActionInStubReturnIamAction, tc.ExpectedActions) {
-			printPointerAndJSONStringComparison(t, tc.ApiAction, tc.ExpectedActions, latestIamActionInStubReturnIamAction)
+		//The time of the request cannot be predicted so it is only checked to be present
+		gotActions := withoutTimeContext(t, tc.ApiAction, latestIamActionInStubReturnIamAction)
+		expectedActions := withoutTimeContext(t, tc.ApiAction, tc.ExpectedActions)
+		if !reflect.DeepEqual(gotActions, expectedActions) {
+			printPointerAndJSONStringComparison(t, tc.ApiAction, expectedActions, gotActions)
 			t.Errorf("unexpected actions got %v, expected %v", latestIamActionInStubReturnIamAction, tc.ExpectedActions)
 		}
 
 	}
 }
+
+func TestSignatureAgeContext(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	signed := "20240701T115500Z"
+
+	headerReq, _ := http.NewRequest(http.MethodGet, "https://localhost/bucket/key", nil)
+	headerReq.Header.Set("X-Amz-Date", signed)
+	queryReq, _ := http.NewRequest(http.MethodGet, "https://localhost/bucket/key?X-Amz-Date="+signed, nil)
+	futureReq, _ := http.NewRequest(http.MethodGet, "https://localhost/bucket/key?X-Amz-Date=20240701T120100Z", nil)
+	unsignedReq, _ := http.NewRequest(http.MethodGet, "https://localhost/bucket/key", nil)
+
+	var testCases = []struct {
+		Description string
+		Req         *http.Request
+		ExpectedAge string
+	}{
+		{"Signed with header", headerReq, "300000"},
+		{"Pre-signed url", queryReq, "300000"},
+		{"Clock skew does not give a negative age", futureReq, "0"},
+		{"Unsigned request", unsignedReq, ""},
+	}
+	for _, tc := range testCases {
+		context := getSignatureAgeContext(tc.Req, now)
+		value, ok := context[actionnames.IAMConditionS3SignatureAge]
+		if tc.ExpectedAge == "" {
+			if ok {
+				t.Errorf("%s: expected no signature age, got %v", tc.Description, value)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: signature age is missing", tc.Description)
+			continue
+		}
+		strValues, _, _ := value.Values()
+		if len(strValues) != 1 || strValues[0] != tc.ExpectedAge {
+			t.Errorf("%s: expected %s, got %v", tc.Description, tc.ExpectedAge, strValues)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/service"
+	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
+	"github.com/VITObelgium/fakes3pp/presign"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/usererror"
+)
+
+// Form fields of a POST request that are passed to the backend as the header with the same name
+var postObjectHeaderFields = []string{
+	"X-Amz-Server-Side-Encryption",
+	"X-Amz-Storage-Class",
+	"X-Amz-Website-Redirect-Location",
+}
+
+// Every POST upload buffers a part of the file in memory so parts are as small as S3 allows. With 10000 parts
+// this covers the 5 GB that S3 accepts for a POST upload.
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/qfacts.html
+var postObjectPartSize int64 = 5 * 1024 * 1024
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectPOST.html#RESTObjectPOST-responses-response-elements
+type postResponse struct {
+	XMLName  xml.Name `xml:"PostResponse"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// Get the headers of the object that gets written to the backend from the fields of the POST form
+func getPostObjectHeaders(form *presign.PostPolicyForm) (http.Header, error) {
+	header := http.Header{}
+	for fieldName, value := range form.Fields() {
+		if strings.HasPrefix(fieldName, strings.ToLower(amzMetaHeaderPrefix)) {
+			header.Set(fieldName, value)
+		}
+	}
+	for _, headerName := range slices.Concat(objectMetadataHeaders, postObjectHeaderFields) {
+		if value := form.Get(headerName); value != "" {
+			header.Set(headerName, value)
+		}
+	}
+	if acl := form.Get("acl"); acl != "" {
+		header.Set("X-Amz-Acl", acl)
+	}
+	// The tagging field holds a tagging document while a PUT takes the tags URL query encoded
+	tags, err := getTagsFromPostForm(form)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) > 0 {
+		tagValues := url.Values{}
+		for key, value := range tags {
+			tagValues.Set(key, value)
+		}
+		header.Set(amzTaggingHeader, tagValues.Encode())
+	}
+	return header, nil
+}
+
+// Respond to a successful upload as requested by the success_action_redirect or success_action_status field
+func writePostObjectResponse(ctx context.Context, w http.ResponseWriter, form *presign.PostPolicyForm, bucket, key, etag string) {
+	if redirect := form.Get("success_action_redirect"); redirect != "" {
+		redirectURL, err := url.Parse(redirect)
+		if err == nil && redirectURL.IsAbs() {
+			query := redirectURL.Query()
+			query.Set("bucket", bucket)
+			query.Set("key", key)
+			query.Set("etag", etag)
+			redirectURL.RawQuery = query.Encode()
+			w.Header().Set("Location", redirectURL.String())
+			w.WriteHeader(http.StatusSeeOther)
+			return
+		}
+		slog.InfoContext(ctx, "Ignoring invalid success_action_redirect", "redirect", redirect)
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Location", makeS3ObjectPath(bucket, key))
+	switch form.Get("success_action_status") {
+	case "200":
+		service.WriteResponse(ctx, w, http.StatusOK, nil, service.MimeNone)
+	case "201":
+		result := postResponse{
+			XMLName:  xml.Name{Space: s3XMLNamespace, Local: "PostResponse"},
+			Location: w.Header().Get("Location"),
+			Bucket:   bucket,
+			Key:      key,
+			ETag:     etag,
+		}
+		service.WriteResponse(ctx, w, http.StatusCreated, service.EncodeResponse(ctx, result), service.MimeXML)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Handle a browser-based upload. The POST form was read and its policy checked during authentication
+// up to the file field. The file gets streamed to the backend with a PUT (or a multipart upload for
+// large files) since its size is only known once it has been read completely.
+func postObject(ctx context.Context, w http.ResponseWriter, r *http.Request, targetBackendId string,
+	backendManager interfaces.BackendManager, requester requesterFunc, corsHandler interfaces.CORSHandler) {
+	form, err := presign.GetPostPolicyForm(r)
+	if err != nil {
+		writeS3ErrorResponse(ctx, w, ErrS3InternalError, fmt.Errorf("could not get POST form: %w", err))
+		return
+	}
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	key := form.GetKey()
+	header, err := getPostObjectHeaders(form)
+	if err != nil {
+		writeS3ErrorResponse(ctx, w, ErrS3MalformedPOSTRequest, usererror.New(err, "The tagging field must be a valid tagging document"))
+		return
+	}
+
+	var etag, versionId string
+	err = form.CheckContentLength(r.ContentLength)
+	if err == nil {
+		backend := backendClient{backendId: targetBackendId, bm: backendManager, requester: requester}
+		etag, versionId, err = backend.putObjectOfUnknownSize(ctx, makeS3ObjectPath(bucket, key), header, form.GetFile(), postObjectPartSize)
+	}
+	var backendErr *backendErrorResponse
+	switch {
+	case err == nil:
+	case errors.Is(err, presign.ErrEntityTooLarge):
+		writeS3ErrorResponse(ctx, w, ErrS3EntityTooLarge, err)
+		return
+	case errors.Is(err, presign.ErrEntityTooSmall):
+		writeS3ErrorResponse(ctx, w, ErrS3EntityTooSmall, err)
+		return
+	case errors.Is(err, errInvalidBackendErr):
+		writeS3ErrorResponse(ctx, w, ErrS3InvalidRegion, err)
+		return
+	case errors.As(err, &backendErr):
+		requestctx.SetUpstreamHTTPStatus(r, backendErr.statusCode)
+		slog.InfoContext(ctx, "Backend refused POST upload", "error", err)
+		writeBackendErrorResponse(ctx, w, backendErr)
+		return
+	default:
+		requestctx.SetUpstreamHTTPStatus(r, -1)
+		writeS3ErrorResponse(ctx, w, ErrS3UpstreamError, err)
+		return
+	}
+	requestctx.SetUpstreamHTTPStatus(r, http.StatusOK)
+
+	corsHandler.SetHeaders(w, bucket, targetBackendId, backendManager)
+	if versionId != "" {
+		w.Header().Set("X-Amz-Version-Id", versionId)
+	}
+	writePostObjectResponse(ctx, w, form, bucket, key, etag)
+}
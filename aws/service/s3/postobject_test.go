@@ -0,0 +1,311 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
+	"github.com/VITObelgium/fakes3pp/testutils"
+)
+
+var testPolicyAllowPutUploadsARN = "arn:aws:iam::000000000000:role/AllowPutUploads"
+var testPolicyAllowPutUploads = fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": "%s",
+			"Resource": "%s/uploads/*"
+		}
+	]
+}`, actionnames.IAMActionS3PutObject, testBucketARN)
+
+func hmacSHA256ForTest(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Build the body of a browser-based upload with a POST policy that gets signed with the credentials of the role
+func buildTestPostForm(t *testing.T, s *S3Server, roleArn string, conditions []any, expiration time.Time, fields map[string]string, file []byte) (body *bytes.Buffer, contentType string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	creds, err := createTestCredentialsForPolicy(t, roleArn, s.jwtKeyMaterial).Retrieve(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Now().UTC().Format("20060102")
+	credential := fmt.Sprintf("%s/%s/waw3-1/s3/aws4_request", creds.AccessKeyID, date)
+	signedFields := map[string]string{
+		"x-amz-algorithm":      "AWS4-HMAC-SHA256",
+		"x-amz-credential":     credential,
+		"x-amz-date":           date + "T000000Z",
+		"x-amz-security-token": creds.SessionToken,
+	}
+	for fieldName, value := range signedFields {
+		conditions = append(conditions, map[string]string{fieldName: value})
+	}
+	policyJSON, err := json.Marshal(map[string]any{
+		"expiration": expiration.UTC().Format(time.RFC3339),
+		"conditions": conditions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := base64.StdEncoding.EncodeToString(policyJSON)
+	signingKey := hmacSHA256ForTest([]byte("AWS4"+creds.SecretAccessKey), date)
+	for _, scopePart := range []string{"waw3-1", "s3", "aws4_request"} {
+		signingKey = hmacSHA256ForTest(signingKey, scopePart)
+	}
+	signedFields["policy"] = policy
+	signedFields["x-amz-signature"] = hex.EncodeToString(hmacSHA256ForTest(signingKey, policy))
+
+	body = &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, formFields := range []map[string]string{fields, signedFields} {
+		for fieldName, value := range formFields {
+			if err := writer.WriteField(fieldName, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	fileWriter, err := writer.CreateFormFile("file", "local name.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileWriter.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body, writer.FormDataContentType()
+}
+
+// POST the form once the server is listening
+func runPostObject(t *testing.T, s *S3Server, body *bytes.Buffer, contentType string) (statusCode int, responseBody string) {
+	client := testutils.BuildUnsafeHttpClientThatTrustsAnyCert(t)
+	var resp *http.Response
+	var err error
+	for range 50 {
+		resp, err = client.Post(testutils.GetTestServerUrl(s)+testBucketName, contentType, bytes.NewReader(body.Bytes()))
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(respBody)
+}
+
+func setupPostObjectTest(t *testing.T) (teardown func(t testing.TB), s *S3Server, backends *fakeBackends) {
+	backends = newFakeBackends()
+	hb := handlerBuilder{proxyFunc: justProxy, requester: backends.requester}
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowPutUploadsARN: testPolicyAllowPutUploads})
+	teardown, s = setupSuiteProxyS3(t, hb, pm, nil, nil, true, nil, nil)
+	return teardown, s, backends
+}
+
+var testPostObjectConditions = []any{
+	map[string]string{"bucket": testBucketName},
+	[]string{"starts-with", "$key", "uploads/"},
+	[]string{"starts-with", "$Content-Type", "text/"},
+	[]any{"content-length-range", 1, 20},
+	map[string]string{"success_action_status": "201"},
+}
+
+func TestPostObjectStreamsFileToBackend(t *testing.T) {
+	teardownSuite, s, backends := setupPostObjectTest(t)
+	defer teardownSuite(t)
+
+	//When uploading a file with a form that matches the POST policy
+	body, contentType := buildTestPostForm(t, s, testPolicyAllowPutUploadsARN, testPostObjectConditions, time.Now().Add(time.Hour), map[string]string{
+		"key":                   "uploads/${filename}",
+		"Content-Type":          "text/plain",
+		"success_action_status": "201",
+	}, []byte("the content"))
+	statusCode, responseBody := runPostObject(t, s, body, contentType)
+
+	//Then the upload succeeds
+	if statusCode != http.StatusCreated {
+		t.Fatalf("Expected 201 Created, got %d: %s", statusCode, responseBody)
+	}
+	if !strings.Contains(responseBody, "<Key>uploads/local name.txt</Key>") {
+		t.Errorf("Unexpected PostResponse %s", responseBody)
+	}
+	//And the file was written to the backend with its content type
+	objectId := fmt.Sprintf("%s/%s/uploads/local%%20name.txt", testDestinationBackendHost, testBucketName)
+	if string(backends.objects[objectId]) != "the content" {
+		t.Errorf("Unexpected object content %q, requests: %v", backends.objects[objectId], backends.requests)
+	}
+	if backends.objectHeaders[objectId].Get("Content-Type") != "text/plain" {
+		t.Errorf("Content-Type was not passed on, got %v", backends.objectHeaders[objectId])
+	}
+}
+
+func TestPostObjectUsesMultipartForLargeFiles(t *testing.T) {
+	defer func(partSize int64) { postObjectPartSize = partSize }(postObjectPartSize)
+	postObjectPartSize = 4
+	teardownSuite, s, backends := setupPostObjectTest(t)
+	defer teardownSuite(t)
+
+	//When uploading a file that is larger than a part
+	body, contentType := buildTestPostForm(t, s, testPolicyAllowPutUploadsARN, testPostObjectConditions, time.Now().Add(time.Hour), map[string]string{
+		"key":                   "uploads/large",
+		"Content-Type":          "text/plain",
+		"success_action_status": "201",
+	}, []byte("0123456789"))
+	statusCode, responseBody := runPostObject(t, s, body, contentType)
+
+	//Then the file is uploaded in parts
+	if statusCode != http.StatusCreated {
+		t.Fatalf("Expected 201 Created, got %d: %s", statusCode, responseBody)
+	}
+	objectId := fmt.Sprintf("%s/%s/uploads/large", testDestinationBackendHost, testBucketName)
+	if string(backends.objects[objectId]) != "0123456789" {
+		t.Errorf("Unexpected object content %q, requests: %v", backends.objects[objectId], backends.requests)
+	}
+	var parts int
+	for _, request := range backends.requests {
+		if strings.Contains(request, "partNumber=") {
+			parts++
+		}
+	}
+	if parts != 3 {
+		t.Errorf("Expected 3 parts, got %d: %v", parts, backends.requests)
+	}
+}
+
+func TestPostObjectEnforcesPolicy(t *testing.T) {
+	teardownSuite, s, backends := setupPostObjectTest(t)
+	defer teardownSuite(t)
+
+	var testCases = []struct {
+		description  string
+		expiration   time.Time
+		fields       map[string]string
+		file         string
+		expectedCode string
+	}{
+		{
+			"key outside of starts-with condition",
+			time.Now().Add(time.Hour),
+			map[string]string{"key": "other/file", "Content-Type": "text/plain", "success_action_status": "201"},
+			"content",
+			"AccessDenied",
+		},
+		{
+			"expired policy",
+			time.Now().Add(-time.Minute),
+			map[string]string{"key": "uploads/file", "Content-Type": "text/plain", "success_action_status": "201"},
+			"content",
+			"AccessDenied",
+		},
+		{
+			"field not covered by the policy",
+			time.Now().Add(time.Hour),
+			map[string]string{"key": "uploads/file", "Content-Type": "text/plain", "success_action_status": "201", "acl": "public-read"},
+			"content",
+			"AccessDenied",
+		},
+		{
+			"file larger than content-length-range",
+			time.Now().Add(time.Hour),
+			map[string]string{"key": "uploads/file", "Content-Type": "text/plain", "success_action_status": "201"},
+			"this content is too large for the policy",
+			"EntityTooLarge",
+		},
+		{
+			"empty file",
+			time.Now().Add(time.Hour),
+			map[string]string{"key": "uploads/file", "Content-Type": "text/plain", "success_action_status": "201"},
+			"",
+			"EntityTooSmall",
+		},
+	}
+	for _, tc := range testCases {
+		//When uploading with a form that violates the POST policy
+		body, contentType := buildTestPostForm(t, s, testPolicyAllowPutUploadsARN, testPostObjectConditions, tc.expiration, tc.fields, []byte(tc.file))
+		statusCode, responseBody := runPostObject(t, s, body, contentType)
+
+		//Then the upload fails
+		if statusCode < 400 || !strings.Contains(responseBody, tc.expectedCode) {
+			t.Errorf("%s: expected %s, got %d: %s", tc.description, tc.expectedCode, statusCode, responseBody)
+		}
+	}
+	//And nothing got written to the backend
+	if len(backends.objects) != 0 {
+		t.Errorf("No objects should have been written, got %v", backends.requests)
+	}
+}
+
+func TestPostObjectRequiresPutObjectPermission(t *testing.T) {
+	teardownSuite, s, backends := setupPostObjectTest(t)
+	defer teardownSuite(t)
+
+	//When uploading with a POST policy that allows any key but the role cannot write it
+	conditions := []any{
+		map[string]string{"bucket": testBucketName},
+		[]string{"starts-with", "$key", ""},
+	}
+	body, contentType := buildTestPostForm(t, s, testPolicyAllowPutUploadsARN, conditions, time.Now().Add(time.Hour), map[string]string{
+		"key": "private/file",
+	}, []byte("content"))
+	statusCode, responseBody := runPostObject(t, s, body, contentType)
+
+	//Then access is denied
+	if statusCode != http.StatusForbidden || !strings.Contains(responseBody, "AccessDenied") {
+		t.Errorf("Expected AccessDenied, got %d: %s", statusCode, responseBody)
+	}
+	if len(backends.requests) != 0 {
+		t.Errorf("Denied upload should not reach the backend, got %v", backends.requests)
+	}
+}
+
+func TestPostObjectWithInvalidSignatureIsRefused(t *testing.T) {
+	teardownSuite, s, backends := setupPostObjectTest(t)
+	defer teardownSuite(t)
+
+	//When the signature does not match the policy
+	body, contentType := buildTestPostForm(t, s, testPolicyAllowPutUploadsARN, testPostObjectConditions, time.Now().Add(time.Hour), map[string]string{
+		"key":                   "uploads/file",
+		"Content-Type":          "text/plain",
+		"success_action_status": "201",
+	}, []byte("content"))
+	form := body.String()
+	signatureMarker := "name=\"x-amz-signature\"\r\n\r\n"
+	signatureStart := strings.Index(form, signatureMarker) + len(signatureMarker)
+	replacement := "0"
+	if form[signatureStart] == '0' {
+		replacement = "1"
+	}
+	tampered := bytes.NewBufferString(form[:signatureStart] + replacement + form[signatureStart+1:])
+	statusCode, responseBody := runPostObject(t, s, tampered, contentType)
+
+	//Then the upload is refused
+	if !strings.Contains(responseBody, "InvalidSignature") {
+		t.Errorf("Expected InvalidSignature, got %d: %s", statusCode, responseBody)
+	}
+	if len(backends.requests) != 0 {
+		t.Errorf("Refused upload should not reach the backend, got %v", backends.requests)
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/VITObelgium/fakes3pp/aws/credentials"
 	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam/actionnames"
 	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
 	"github.com/VITObelgium/fakes3pp/aws/service/sts/session"
 	"github.com/VITObelgium/fakes3pp/constants"
@@ -106,7 +107,7 @@ func NewTestS3Server(t testing.TB, proxyHB interfaces.HandlerBuilderI, pm *iam.P
 	s, err := newS3Server(
 		jwtTestToken,
 		testS3Port,
-		[]string{testS3Host},
+		[]string{testS3Host, "*." + testS3Host},
 		tlsCert,
 		tlsKey,
 		pm,
@@ -114,11 +115,14 @@ func NewTestS3Server(t testing.TB, proxyHB interfaces.HandlerBuilderI, pm *iam.P
 		proxyHB,
 		bm,
 		nil,
+		nil,
+		nil,
 		mws,
 		removableQueryParamRegexes,
 		corsHandler,
 		0,
 		nil,
+		false,
 	)
 	if err != nil {
 		t.Error("Problem creating test STS server", "error", err)
@@ -144,8 +148,11 @@ func TestProxyAddsRequesterPaysHeaderForConfiguredBucket(t *testing.T) {
 		nil,
 		nil,
 		nil,
+		nil,
+		nil,
 		0,
 		nil,
+		false,
 	)
 	if err != nil {
 		t.Fatalf("Problem creating test S3 server: %s", err)
@@ -241,7 +248,7 @@ func newTestPolicyManager(_ testing.TB, extraPolicies map[string]string) *iam.Po
 }
 
 func createTestCredentialsForPolicy(t testing.TB, policyArn string, keyStorage utils.KeyPairKeeper) *credentials.AWSCredentials {
-	token := credentials.CreateRS256PolicyToken("stsissuer", "initialIssuer", "userid", policyArn, 20*time.Minute, session.AWSSessionTags{})
+	token := credentials.CreateRS256PolicyToken("stsissuer", "initialIssuer", "userid", policyArn, 20*time.Minute, session.AWSSessionTags{}, session.AWSSessionPolicies{})
 	cred, err := credentials.NewAWSCredentials(token, time.Hour, keyStorage)
 
 	if err != nil {
@@ -276,6 +283,74 @@ func TestWithValidCredsButNoAccess(t *testing.T) {
 	popLastRequestByTestProxy()
 }
 
+func TestSourceIpCondition(t *testing.T) {
+	policyForNetworks := func(networks string) string {
+		return fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Effect": "Allow",
+				"Action": "%s",
+				"Resource": "%s",
+				"Condition": {"IpAddress": {"aws:SourceIp": [%s]}}
+			}]
+		}`, actionnames.IAMActionS3ListBucket, testBucketARN, networks)
+	}
+	localOnlyARN := "arn:aws:iam::000000000000:role/LocalOnly"
+	campusOnlyARN := "arn:aws:iam::000000000000:role/CampusOnly"
+	pm := newTestPolicyManager(t, map[string]string{
+		localOnlyARN:  policyForNetworks(`"127.0.0.0/8", "::1"`),
+		campusOnlyARN: policyForNetworks(`"192.0.2.0/24"`),
+	})
+	teardownSuite, s := setupSuiteProxyS3(t, testStubJustProxy, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	var testCases = []struct {
+		Description    string
+		PolicyArn      string
+		ExpectedDenied bool
+	}{
+		{"Client in allowed network", localOnlyARN, false},
+		{"Client outside allowed network", campusOnlyARN, true},
+	}
+	for _, tc := range testCases {
+		cred := createTestCredentialsForPolicy(t, tc.PolicyArn, s.jwtKeyMaterial)
+		client := testutils.GetTestClientS3(t, "eu-west-1", cred, s)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: &testBucketName})
+		cancel()
+		denied := err != nil && strings.Contains(err.Error(), "AccessDenied")
+		if denied != tc.ExpectedDenied {
+			t.Errorf("%s: expected denied=%t, got error %v", tc.Description, tc.ExpectedDenied, err)
+		}
+		popLastRequestByTestProxy()
+	}
+}
+
+func TestSessionPolicyLimitsRole(t *testing.T) {
+	teardownSuite, s := setupSuiteProxyS3(t, testStubJustProxy, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+
+	sessionPolicies := session.AWSSessionPolicies{
+		Policy: fmt.Sprintf(`{
+			"Version": "2012-10-17",
+			"Statement": [{"Effect": "Allow", "Action": "%s", "Resource": "arn:aws:s3:::otherbucket"}]
+		}`, actionnames.IAMActionS3ListBucket),
+	}
+	token := credentials.CreateRS256PolicyToken("stsissuer", "initialIssuer", "userid", testPolicyAllowAllARN, 20*time.Minute, session.AWSSessionTags{}, sessionPolicies)
+	cred, err := credentials.NewAWSCredentials(token, time.Hour, s.jwtKeyMaterial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := testutils.GetTestClientS3(t, "eu-west-1", cred, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: &testBucketName})
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Session policy must deny what the role allows outside of it, got %v", err)
+	}
+	popLastRequestByTestProxy()
+}
+
 func TestWithValidCreds(t *testing.T) {
 	teardownSuite, s := setupSuiteProxyS3(t, testStubJustProxy, nil, nil, nil, true, nil, nil)
 	defer teardownSuite(t)
@@ -0,0 +1,59 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/VITObelgium/fakes3pp/utils"
+)
+
+// A response writer that holds back the upstream response such that the proxy can alter it
+// e.g. to add the keys it denied to a DeleteResult.
+type bufferingResponseWriter struct {
+	w      http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func newBufferingResponseWriter(w http.ResponseWriter) *bufferingResponseWriter {
+	return &bufferingResponseWriter{w: w}
+}
+
+func (m *bufferingResponseWriter) Header() http.Header {
+	return m.w.Header()
+}
+
+func (m *bufferingResponseWriter) WriteHeader(statusCode int) {
+	m.status = statusCode
+}
+
+func (m *bufferingResponseWriter) Write(b []byte) (int, error) {
+	if m.status == 0 {
+		m.status = http.StatusOK
+	}
+	return m.buf.Write(b)
+}
+
+// Write out the held back response. Only successful responses are altered by the transform. If the
+// transform fails an internal error is returned instead of the upstream response.
+func (m *bufferingResponseWriter) flush(ctx context.Context, transform func([]byte) ([]byte, error)) {
+	if m.status == 0 {
+		m.status = http.StatusOK
+	}
+	body := m.buf.Bytes()
+	if m.status == http.StatusOK {
+		transformed, err := transform(body)
+		if err != nil {
+			m.w.Header().Del("Content-Length")
+			m.w.Header().Del("Content-Encoding")
+			writeS3ErrorResponse(ctx, m.w, ErrS3InternalError, err)
+			return
+		}
+		body = transformed
+	}
+	m.w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	m.w.WriteHeader(m.status)
+	utils.WriteButLogOnError(ctx, m.w, body)
+}
@@ -0,0 +1,76 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/credentials"
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	iaminterfaces "github.com/VITObelgium/fakes3pp/aws/service/iam/interfaces"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"sigs.k8s.io/yaml"
+)
+
+// A set of role ARNs for which an optional behavior of the proxy is enabled
+type roleArnSet map[string]struct{}
+
+func getRoleArnSet(filename string) (roleArnSet, error) {
+	buf, err := os.ReadFile(filename) // #nosec G304 -- platform provided files
+	if err != nil {
+		return nil, err
+	}
+	return getRoleArnSetFromBytes(buf)
+}
+
+func getRoleArnSetFromBytes(inputBytes []byte) (roleArnSet, error) {
+	var roleArns []string
+	err := yaml.Unmarshal(inputBytes, &roleArns)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(roleArnSet, len(roleArns))
+	for _, roleArn := range roleArns {
+		trimmedRoleArn := strings.TrimSpace(roleArn)
+		if trimmedRoleArn == "" {
+			continue
+		}
+		result[trimmedRoleArn] = struct{}{}
+	}
+	return result, nil
+}
+
+func (s roleArnSet) contains(roleArn string) bool {
+	_, ok := s[roleArn]
+	return ok
+}
+
+// Get the policy evaluator and session data of the session if its role is in the set and nil otherwise.
+// Authorization has already verified the session token.
+func getSessionPolicyForRoles(r *http.Request, roles roleArnSet, policyRetriever iaminterfaces.PolicyRetriever) (*iam.PolicyEvaluator, *iam.PolicySessionData, error) {
+	sessionToken, err := requestctx.GetSessionToken(r)
+	if err != nil {
+		return nil, nil, errors.New("could not get session token from requestctx")
+	}
+	sessionClaims, err := credentials.ExtractTokenClaims(sessionToken, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get claims from session token: %w", err)
+	}
+	if !roles.contains(sessionClaims.RoleARN) {
+		return nil, nil, nil
+	}
+	policySessionData := iam.GetPolicySessionDataFromClaims(sessionClaims)
+	policySessionData.RequestedRegion, err = requestctx.GetTargetRegion(r)
+	if err != nil {
+		return nil, nil, errors.New("could not get target region from requestctx")
+	}
+	policySessionData.SourceIp = requestctx.GetSourceIP(r)
+	pe, err := policyRetriever.GetSessionPolicyEvaluator(sessionClaims, policySessionData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not get policies for role %s: %w", sessionClaims.RoleARN, err)
+	}
+	return pe, policySessionData, nil
+}
@@ -50,6 +50,7 @@ var awsToS3ErrorCode = map[service.AWSErrorCode]S3ErrorCode{
 	service.ErrAWSInvalidSignature:          ErrS3InvalidSignature,
 	service.ErrInvalidAccessKeyId:           ErrS3InvalidAccessKeyId,
 	service.ErrAuthorizationHeaderMalformed: ErrS3AuthorizationHeaderMalformed,
+	service.ErrMalformedPOSTRequest:         ErrS3MalformedPOSTRequest,
 }
 
 func toS3ErrorCode(ctx context.Context, awsE service.AWSErrorCode) (s3E S3ErrorCode) {
@@ -125,6 +126,15 @@ const (
 	ErrS3InvalidSecurity
 	ErrS3InvalidRegion
 	ErrS3AuthorizationHeaderMalformed
+	ErrS3MalformedXML
+	ErrS3XAmzContentSHA256Mismatch
+	ErrS3NotImplemented
+	ErrS3PreconditionFailed
+	ErrS3NoSuchBucket
+	ErrS3MalformedPOSTRequest
+	ErrS3EntityTooSmall
+	ErrS3EntityTooLarge
+	ErrS3InvalidArgument
 )
 
 type s3ErrorCodeMap map[S3ErrorCode]S3Error
@@ -179,4 +189,49 @@ var s3ErrCodes = s3ErrorCodeMap{
 		Description:    "The authorization header that you provided is not valid.",
 		HTTPStatusCode: http.StatusBadRequest,
 	},
+	ErrS3MalformedXML: {
+		Code:           "MalformedXML",
+		Description:    "The XML that you provided was not well formed or did not validate against our published schema.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrS3XAmzContentSHA256Mismatch: {
+		Code:           "XAmzContentSHA256Mismatch",
+		Description:    "The provided 'x-amz-content-sha256' header does not match what was computed.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrS3NotImplemented: {
+		Code:           "NotImplemented",
+		Description:    "A header you provided implies functionality that is not implemented.",
+		HTTPStatusCode: http.StatusNotImplemented,
+	},
+	ErrS3PreconditionFailed: {
+		Code:           "PreconditionFailed",
+		Description:    "At least one of the preconditions you specified did not hold.",
+		HTTPStatusCode: http.StatusPreconditionFailed,
+	},
+	ErrS3NoSuchBucket: {
+		Code:           "NoSuchBucket",
+		Description:    "The specified bucket does not exist.",
+		HTTPStatusCode: http.StatusNotFound,
+	},
+	ErrS3MalformedPOSTRequest: {
+		Code:           "MalformedPOSTRequest",
+		Description:    "The body of your POST request is not well-formed multipart/form-data.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrS3EntityTooSmall: {
+		Code:           "EntityTooSmall",
+		Description:    "Your proposed upload is smaller than the minimum allowed object size.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrS3EntityTooLarge: {
+		Code:           "EntityTooLarge",
+		Description:    "Your proposed upload exceeds the maximum allowed object size.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
+	ErrS3InvalidArgument: {
+		Code:           "InvalidArgument",
+		Description:    "Invalid Argument",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
 }
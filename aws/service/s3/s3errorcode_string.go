@@ -17,15 +17,25 @@ func _() {
 	_ = x[ErrS3InvalidSecurity-6]
 	_ = x[ErrS3InvalidRegion-7]
 	_ = x[ErrS3AuthorizationHeaderMalformed-8]
+	_ = x[ErrS3MalformedXML-9]
+	_ = x[ErrS3XAmzContentSHA256Mismatch-10]
+	_ = x[ErrS3NotImplemented-11]
+	_ = x[ErrS3PreconditionFailed-12]
+	_ = x[ErrS3NoSuchBucket-13]
+	_ = x[ErrS3MalformedPOSTRequest-14]
+	_ = x[ErrS3EntityTooSmall-15]
+	_ = x[ErrS3EntityTooLarge-16]
+	_ = x[ErrS3InvalidArgument-17]
 }
 
-const _S3ErrorCode_name = "S3NoneS3AccessDeniedS3InternalErrorS3UpstreamErrorS3InvalidAccessKeyIdS3InvalidSignatureS3InvalidSecurityS3InvalidRegionS3AuthorizationHeaderMalformed"
+const _S3ErrorCode_name = "S3NoneS3AccessDeniedS3InternalErrorS3UpstreamErrorS3InvalidAccessKeyIdS3InvalidSignatureS3InvalidSecurityS3InvalidRegionS3AuthorizationHeaderMalformedS3MalformedXMLS3XAmzContentSHA256MismatchS3NotImplementedS3PreconditionFailedS3NoSuchBucketS3MalformedPOSTRequestS3EntityTooSmallS3EntityTooLargeS3InvalidArgument"
 
-var _S3ErrorCode_index = [...]uint8{0, 6, 20, 35, 50, 70, 88, 105, 120, 150}
+var _S3ErrorCode_index = [...]uint16{0, 6, 20, 35, 50, 70, 88, 105, 120, 150, 164, 191, 207, 227, 241, 263, 279, 295, 312}
 
 func (i S3ErrorCode) String() string {
-	if i < 0 || i >= S3ErrorCode(len(_S3ErrorCode_index)-1) {
+	idx := int(i) - 0
+	if i < 0 || idx >= len(_S3ErrorCode_index)-1 {
 		return "S3ErrorCode(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _S3ErrorCode_name[_S3ErrorCode_index[i]:_S3ErrorCode_index[i+1]]
+	return _S3ErrorCode_name[_S3ErrorCode_index[idx]:_S3ErrorCode_index[idx+1]]
 }
@@ -22,6 +22,9 @@ type S3Server struct {
 
 	fqdns []string
 
+	//FQDNs for which subdomains address a bucket (virtual-hosted-style). These are configured as *.<fqdn>.
+	virtualHostedFQDNs []string
+
 	pm *iam.PolicyManager
 
 	signedUrlGracePeriod time.Duration
@@ -42,6 +45,10 @@ func (s *S3Server) GetListenHost() string {
 	return s.fqdns[0]
 }
 
+func (s *S3Server) GetPolicyManager() *iam.PolicyManager {
+	return s.pm
+}
+
 func NewS3Server(
 	jwtPrivateRSAKeyFilePath string,
 	serverPort int,
@@ -53,11 +60,14 @@ func NewS3Server(
 	proxyHB interfaces.HandlerBuilderI,
 	s3BackendConfigFilePath string,
 	forceRequesterPaysFor string,
+	filterListBucketsFor string,
+	filterListObjectsFor string,
 	backendLegacyBehaviorDefaultRegion bool,
 	removableQueryParamRegexes []*regexp.Regexp,
 	corsHandler interfaces.CORSHandler,
 	extraHTTPPort int,
 	loggedResponseHeaders []string,
+	authzMessageHeader bool,
 ) (s server.Serverable, err error) {
 	s3BackendCfg, err := getBackendsConfig(s3BackendConfigFilePath, backendLegacyBehaviorDefaultRegion)
 	if err != nil {
@@ -70,6 +80,20 @@ func NewS3Server(
 			return nil, err
 		}
 	}
+	var listBucketsFilterCfg roleArnSet
+	if filterListBucketsFor != "" {
+		listBucketsFilterCfg, err = getRoleArnSet(filterListBucketsFor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var listObjectsFilterCfg roleArnSet
+	if filterListObjectsFor != "" {
+		listObjectsFilterCfg, err = getRoleArnSet(filterListObjectsFor)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if proxyHB == nil {
 		proxyHB = handlerBuilderToJustProxy
 	}
@@ -84,11 +108,14 @@ func NewS3Server(
 		proxyHB,
 		s3BackendCfg,
 		requesterPaysCfg,
+		listBucketsFilterCfg,
+		listObjectsFilterCfg,
 		nil,
 		removableQueryParamRegexes,
 		corsHandler,
 		extraHTTPPort,
 		loggedResponseHeaders,
+		authzMessageHeader,
 	)
 }
 func newS3Server(
@@ -102,20 +129,24 @@ func newS3Server(
 	proxyHB interfaces.HandlerBuilderI,
 	s3BackendManager interfaces.BackendManager,
 	requesterPaysCfg requesterPaysBuckets,
+	listBucketsFilterCfg roleArnSet,
+	listObjectsFilterCfg roleArnSet,
 	mws []middleware.Middleware,
 	removableQueryParamRegexes []*regexp.Regexp,
 	corsHandler interfaces.CORSHandler,
 	extraHTTPPort int,
 	loggedResponseHeaders []string,
+	authzMessageHeader bool,
 ) (s *S3Server, err error) {
 	key, err := utils.NewKeyStorage(jwtPrivateRSAKeyFilePath)
 	if err != nil {
 		return nil, err
 	}
-	if len(fqdns) == 0 {
-		return nil, errors.New("must at least pass in 1 fqdn to create a server")
+	pathStyleFQDNs, virtualHostedFQDNs := splitVirtualHostedFQDNs(fqdns)
+	if len(pathStyleFQDNs) == 0 {
+		return nil, errors.New("must at least pass in 1 fqdn that is not a wildcard to create a server")
 	}
-	basicServer := server.NewBasicServer(serverPort, fqdns[0], tlsCertFilePath, tlsKeyFilePath, nil, extraHTTPPort)
+	basicServer := server.NewBasicServer(serverPort, pathStyleFQDNs[0], tlsCertFilePath, tlsKeyFilePath, nil, extraHTTPPort)
 	signedUrlGraceTimeDuration := time.Duration(signedUrlGraceTimeSeconds) * time.Second
 
 	if corsHandler == nil {
@@ -124,7 +155,8 @@ func newS3Server(
 	s = &S3Server{
 		BasicServer:          *basicServer,
 		jwtKeyMaterial:       key,
-		fqdns:                fqdns,
+		fqdns:                pathStyleFQDNs,
+		virtualHostedFQDNs:   virtualHostedFQDNs,
 		pm:                   pm,
 		signedUrlGracePeriod: signedUrlGraceTimeDuration,
 		proxyHB:              proxyHB,
@@ -134,14 +166,25 @@ func newS3Server(
 	}
 
 	if len(mws) == 0 {
+		var authzMessageKey utils.PrivateKeyKeeper
+		if authzMessageHeader {
+			authzMessageKey = key
+		}
 		presignAuthOptions := middleware.AuthenticationOptions{
 			Leeway:               signedUrlGraceTimeDuration,
 			RemovableQueryParams: removableQueryParamRegexes,
 		}
 		mws = []middleware.Middleware{
+			RewriteVirtualHostedStyle(s),
 			RegisterOperation(),
 			middleware.AWSAuthN(key, s3ErrorReporterInstance, s3BackendManager, &presignAuthOptions),
-			AWSAuthZS3(key, s3BackendManager, pm, s, s),
+			AWSAuthZS3(key, s3BackendManager, pm, s, s, authzMessageKey, getRequesterOfHandlerBuilder(proxyHB)),
+		}
+		if len(listBucketsFilterCfg) > 0 {
+			mws = append(mws, FilterListBuckets(listBucketsFilterCfg, pm))
+		}
+		if len(listObjectsFilterCfg) > 0 {
+			mws = append(mws, FilterListObjects(listObjectsFilterCfg, pm))
 		}
 		if len(requesterPaysCfg) > 0 {
 			mws = append(mws, ForceRequesterPays(requesterPaysCfg, s))
@@ -176,6 +219,34 @@ func (s *S3Server) IsVirtualHostingRequest(req *http.Request) bool {
 	return true
 }
 
+// A wildcard FQDN (e.g. *.s3.example.com) allows virtual-hosted-style requests for its subdomains
+func splitVirtualHostedFQDNs(fqdns []string) (pathStyleFQDNs, virtualHostedFQDNs []string) {
+	for _, fqdn := range fqdns {
+		if wildcardFQDN, isWildcard := strings.CutPrefix(fqdn, "*."); isWildcard {
+			virtualHostedFQDNs = append(virtualHostedFQDNs, strings.ToLower(wildcardFQDN))
+		} else {
+			pathStyleFQDNs = append(pathStyleFQDNs, fqdn)
+		}
+	}
+	return pathStyleFQDNs, virtualHostedFQDNs
+}
+
+func (s *S3Server) GetVirtualHostedBucket(req *http.Request) (string, bool) {
+	if !s.IsVirtualHostingRequest(req) {
+		return "", false
+	}
+	hostWithoutPort := strings.ToLower(strings.Split(req.Host, ":")[0])
+	var bucket string
+	for _, fqdn := range s.virtualHostedFQDNs {
+		candidate, isSubdomain := strings.CutSuffix(hostWithoutPort, "."+fqdn)
+		//With overlapping wildcards the most specific FQDN determines the bucket
+		if isSubdomain && candidate != "" && (bucket == "" || len(candidate) < len(bucket)) {
+			bucket = candidate
+		}
+	}
+	return bucket, bucket != ""
+}
+
 // Register routes to S3 router
 // For real cases the proxyHB HandlerBuilder should build a handler function
 // that sends the request upstream and passes back the response.
@@ -0,0 +1,193 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/presign"
+	"github.com/VITObelgium/fakes3pp/testutils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestGetVirtualHostedBucket(t *testing.T) {
+	s := &S3Server{fqdns: []string{"s3.example.com"}, virtualHostedFQDNs: []string{"example.com", "s3.example.com"}}
+	var testCases = []struct {
+		host           string
+		expectedBucket string
+		expectedOk     bool
+	}{
+		{"s3.example.com", "", false},
+		{"s3.example.com:8443", "", false},
+		{"bucket1.s3.example.com:8443", "bucket1", true},
+		{"Bucket1.S3.Example.com", "bucket1", true},
+		{"my.dotted.bucket.s3.example.com", "my.dotted.bucket", true},
+		{"bucket1.example.com", "bucket1", true},
+		{"bucket1.example.org", "", false},
+		{"example.com", "", false},
+	}
+	for _, tc := range testCases {
+		req, err := http.NewRequest(http.MethodGet, "https://localhost/key", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = tc.host
+		bucket, ok := s.GetVirtualHostedBucket(req)
+		if bucket != tc.expectedBucket || ok != tc.expectedOk {
+			t.Errorf("%s: expected (%q, %t), got (%q, %t)", tc.host, tc.expectedBucket, tc.expectedOk, bucket, ok)
+		}
+	}
+}
+
+func TestVirtualHostedStyleRequestsAreAuthorizedAndProxiedPathStyle(t *testing.T) {
+	pm := newTestPolicyManager(t, map[string]string{testPolicyAllowReadOnlyPublicWriteAllARN: testPolicyAllowReadOnlyPublicWriteAll})
+	teardownSuite, s := setupSuiteProxyS3(t, testStubJustProxy, pm, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+	testRequests = nil
+	cred := createTestCredentialsForPolicy(t, testPolicyAllowReadOnlyPublicWriteAllARN, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3VirtualHosted(t, "waw3-1", cred, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//When getting an object that the policy allows using a virtual-hosted-style request
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &testBucketName, Key: aws.String("public/a b")})
+
+	//Then the signature is valid and the request is proxied as a path-style request
+	if err != nil {
+		t.Fatalf("Virtual-hosted-style GetObject failed: %s", err)
+	}
+	proxied := popLastRequestByTestProxy()
+	if proxied == nil {
+		t.Fatal("Request should have been proxied")
+	}
+	expectedPath := fmt.Sprintf("/%s/public/a b", testBucketName)
+	if proxied.URL.Path != expectedPath || proxied.Host != fmt.Sprintf("%s:%d", testS3Host, testS3Port) {
+		t.Errorf("Expected path-style request for %s, got host %s and path %s", expectedPath, proxied.Host, proxied.URL.Path)
+	}
+
+	//When getting an object that the policy does not allow
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: &testBucketName, Key: aws.String("private/secret")})
+
+	//Then access is denied based on the bucket in the host
+	if !isAccessDenied(err) {
+		t.Errorf("Expected AccessDenied, got %v", err)
+	}
+}
+
+func TestVirtualHostedStyleBucketOperationIsRouted(t *testing.T) {
+	teardownSuite, s := setupSuiteProxyS3(t, testStubJustProxy, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+	testRequests = nil
+	cred := createTestCredentialsForPolicy(t, testPolicyAllowAllARN, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3VirtualHosted(t, "waw3-1", cred, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//When listing the objects of a bucket using a virtual-hosted-style request
+	_, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: &testBucketName, Prefix: aws.String("a/")})
+	if err != nil && !strings.Contains(err.Error(), "deserialization failed") {
+		t.Fatalf("Virtual-hosted-style ListObjectsV2 failed: %s", err)
+	}
+
+	//Then the request targets the bucket
+	proxied := popLastRequestByTestProxy()
+	if proxied == nil || proxied.URL.Path != "/"+testBucketName {
+		t.Fatalf("Expected request for bucket path, got %v", proxied)
+	}
+	if operation := getS3Action(proxied); operation.String() != "ListObjectsV2" {
+		t.Errorf("Expected ListObjectsV2, got %s", operation)
+	}
+}
+
+// Unlike the SDK a plain HTTP client does not retry when the test server is not yet listening
+func getWhenServerIsListening(t *testing.T, url string) (resp *http.Response, err error) {
+	client := testutils.BuildUnsafeHttpClientThatConnectsToLocalhost(t)
+	for range 50 {
+		resp, err = client.Get(url)
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			return resp, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return resp, err
+}
+
+func TestVirtualHostedStylePresignedUrls(t *testing.T) {
+	teardownSuite, s := setupSuiteProxyS3(t, testStubJustProxy, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+	testRequests = nil
+	cred := createTestCredentialsForPolicy(t, testPolicyAllowAllARN, s.jwtKeyMaterial)
+
+	//Given a sigv4 presigned url for a virtual-hosted-style request
+	presigner := Presigner{PresignClient: s3.NewPresignClient(testutils.GetTestClientS3VirtualHosted(t, "waw3-1", cred, s))}
+	sigv4Req, err := presigner.GetObject(context.Background(), testBucketName, "key", 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Given an hmacv1 presigned url which has the same canonical resource for both styles
+	creds, err := cred.Retrieve(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pathStyleReq, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s%s/key", testutils.GetTestServerUrl(s), testBucketName), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacv1Url, err := presign.CalculateS3PresignedHmacV1QueryUrl(pathStyleReq, creds, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacv1Url = strings.Replace(hmacv1Url, fmt.Sprintf("%s:%d/%s/", testS3Host, testS3Port, testBucketName), fmt.Sprintf("%s.%s:%d/", testBucketName, testS3Host, testS3Port), 1)
+
+	for name, presignedUrl := range map[string]string{"sigv4": sigv4Req.URL, "hmacv1": hmacv1Url} {
+		if !strings.Contains(presignedUrl, fmt.Sprintf("://%s.%s", testBucketName, testS3Host)) {
+			t.Errorf("%s: expected virtual-hosted-style url, got %s", name, presignedUrl)
+		}
+		//When using the presigned url
+		resp, err := getWhenServerIsListening(t, presignedUrl)
+
+		//Then the signature is valid
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: unexpected response %v", name, resp)
+		}
+		proxied := popLastRequestByTestProxy()
+		if proxied == nil || proxied.URL.Path != fmt.Sprintf("/%s/key", testBucketName) {
+			t.Errorf("%s: expected path-style request to be proxied, got %v", name, proxied)
+		}
+	}
+}
+
+func TestVirtualHostedStyleRequestIsSentPathStyleToBackend(t *testing.T) {
+	var upstreamUrls []string
+	requester := func(r *http.Request) (*http.Response, error) {
+		upstreamUrls = append(upstreamUrls, r.URL.String())
+		return fakeBackendResponse(http.StatusOK, nil, []byte("content")), nil
+	}
+	hb := handlerBuilder{proxyFunc: justProxy, requester: requester}
+	teardownSuite, s := setupSuiteProxyS3(t, hb, nil, nil, nil, true, nil, nil)
+	defer teardownSuite(t)
+	cred := createTestCredentialsForPolicy(t, testPolicyAllowAllARN, s.jwtKeyMaterial)
+	client := testutils.GetTestClientS3VirtualHosted(t, "waw3-1", cred, s)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//When getting an object using a virtual-hosted-style request
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: &testBucketName, Key: aws.String("dir/a b")})
+
+	//Then the backend receives a path-style request
+	if err != nil {
+		t.Fatalf("Virtual-hosted-style GetObject failed: %s", err)
+	}
+	expectedUrl := fmt.Sprintf("https://%s/%s/dir/a%%20b?x-id=GetObject", testDestinationBackendHost, testBucketName)
+	if len(upstreamUrls) != 1 || upstreamUrls[0] != expectedUrl {
+		t.Errorf("Expected upstream request %s, got %v", expectedUrl, upstreamUrls)
+	}
+}
@@ -15,6 +15,7 @@ const (
 	ErrAWSAccessDenied
 	ErrInvalidAccessKeyId
 	ErrAuthorizationHeaderMalformed
+	ErrMalformedPOSTRequest
 )
 
 type ErrorReporter interface {
@@ -25,6 +25,7 @@ const (
 	stsVersion          = "Version"
 	stsAction           = "Action"
 	stsPolicy           = "Policy"
+	stsPolicyArns       = "PolicyArns"
 	stsToken            = "Token"
 	stsRoleArn          = "RoleArn"
 	stsWebIdentityToken = "WebIdentityToken"
@@ -172,6 +172,8 @@ func parseForm(r *http.Request) error {
 // Call. That token will be exchanged for credentials.
 // Request parameters that we support:
 // - DurationSeconds
+// - Policy
+// - PolicyArns
 // - RoleArn
 // - RoleSessionName
 // - WebIdentityToken following the structure
@@ -232,13 +234,35 @@ func (s *STSServer) assumeRoleWithWebIdentity(ctx context.Context, w http.Respon
 	}
 
 	roleArn := r.Form.Get(stsRoleArn)
-	if !s.pm.DoesPolicyExist(roleArn) {
+	if !s.pm.DoesRoleExist(roleArn) {
 		slog.InfoContext(ctx, "Error retrieving policy", "role_arn", roleArn, "error", err)
 		writeSTSErrorResponse(ctx, w, ErrSTSInvalidParameterValue, fmt.Errorf("invalid value for %s: %s", stsRoleArn, roleArn))
 		return
 	}
+	webIdentityClaims, err := credentials.ExtractOIDCTokenMapClaims(token, s.oidcVerifier.GetKeyFunc())
+	if err != nil {
+		writeSTSErrorResponse(ctx, w, ErrSTSInternalError, err)
+		return
+	}
+	isTrusted, reason, err := s.pm.IsRoleTrusted(roleArn, webIdentityClaims)
+	if err != nil {
+		writeSTSErrorResponse(ctx, w, ErrSTSInternalError, err)
+		return
+	}
+	if !isTrusted {
+		slog.InfoContext(ctx, "Role does not trust web identity", "role_arn", roleArn, "subject", subFromToken, "reason", reason)
+		writeSTSErrorResponse(ctx, w, ErrSTSAccessDenied, fmt.Errorf("not authorized to assume role %s with this web identity, trust policy: %s", roleArn, reason))
+		return
+	}
 
-	newToken := s.newProxyIssuedToken(subject, issuer, roleArn, *duration, claimsMap.Tags)
+	sessionPolicies, errCode, err := s.getSessionPolicies(r)
+	if err != nil {
+		slog.InfoContext(ctx, "Invalid session policies", "error", err)
+		writeSTSErrorResponse(ctx, w, errCode, err)
+		return
+	}
+
+	newToken := s.newProxyIssuedToken(subject, issuer, roleArn, *duration, claimsMap.Tags, sessionPolicies)
 
 	cred, err := credentials.NewAWSCredentials(newToken, *duration, s.jwtKeyMaterial)
 
@@ -249,6 +273,7 @@ func (s *STSServer) assumeRoleWithWebIdentity(ctx context.Context, w http.Respon
 		slog.String("AKID", cred.AccessKey),
 		slog.String("subFromToken", subFromToken),
 		slog.Any("Tags", claimsMap.Tags),
+		slog.Any("sessionPolicyArns", sessionPolicies.PolicyArns),
 	)
 
 	if err != nil {
@@ -270,8 +295,41 @@ func (s *STSServer) assumeRoleWithWebIdentity(ctx context.Context, w http.Respon
 	service.WriteSuccessResponseXML(ctx, w, encodedSuccessResponse)
 }
 
-func (s *STSServer) newProxyIssuedToken(subject, issuer, roleARN string, expiry time.Duration, tags session.AWSSessionTags) (token *jwt.Token) {
-	return credentials.CreateRS256PolicyToken(s.GetIssuer(), issuer, subject, roleARN, expiry, tags)
+func (s *STSServer) newProxyIssuedToken(subject, issuer, roleARN string, expiry time.Duration, tags session.AWSSessionTags, sessionPolicies session.AWSSessionPolicies) (token *jwt.Token) {
+	return credentials.CreateRS256PolicyToken(s.GetIssuer(), issuer, subject, roleARN, expiry, tags, sessionPolicies)
+}
+
+// The limits of AWS for session policies, they end up in the session token so they also keep it small
+const maxSessionPolicyLength = 2048
+const maxSessionPolicyArns = 10
+
+// Get the session policies from the Policy and PolicyArns.member.N.arn parameters
+func (s *STSServer) getSessionPolicies(r *http.Request) (session.AWSSessionPolicies, STSErrorCode, error) {
+	sessionPolicies := session.AWSSessionPolicies{
+		Policy: r.Form.Get(stsPolicy),
+	}
+	if len(sessionPolicies.Policy) > maxSessionPolicyLength {
+		return sessionPolicies, ErrSTSPackedPolicyTooLarge, fmt.Errorf("%s exceeds %d characters", stsPolicy, maxSessionPolicyLength)
+	}
+	if sessionPolicies.Policy != "" {
+		if _, err := iam.NewPolicyEvaluatorFromStr(sessionPolicies.Policy); err != nil {
+			return sessionPolicies, ErrSTSMalformedPolicyDocument, fmt.Errorf("invalid %s: %w", stsPolicy, err)
+		}
+	}
+	for i := 1; ; i++ {
+		policyArn := r.Form.Get(fmt.Sprintf("%s.member.%d.arn", stsPolicyArns, i))
+		if policyArn == "" {
+			break
+		}
+		if i > maxSessionPolicyArns {
+			return sessionPolicies, ErrSTSInvalidParameterValue, fmt.Errorf("at most %d %s can be passed", maxSessionPolicyArns, stsPolicyArns)
+		}
+		if !iam.IsManagedPolicyArn(policyArn) || !s.pm.DoesPolicyExist(policyArn) {
+			return sessionPolicies, ErrSTSInvalidParameterValue, fmt.Errorf("invalid value for %s: %s", stsPolicyArns, policyArn)
+		}
+		sessionPolicies.PolicyArns = append(sessionPolicies.PolicyArns, policyArn)
+	}
+	return sessionPolicies, ErrSTSNone, nil
 }
 
 func (s *STSServer) calculateFinalDurationSeconds(apiProvidedDuration int, jwtExpiry *jwt.NumericDate) (*time.Duration, error) {
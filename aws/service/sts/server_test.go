@@ -2,9 +2,14 @@ package sts
 
 import (
 	"context"
+	"encoding/xml"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -246,3 +251,169 @@ func TestProxyStsViaSTSClient(t *testing.T) {
 		}()
 	}
 }
+
+var testManagedPolicyArn = "arn:aws:iam::000000000000:policy/ReadOnly"
+
+const testAllowAllPolicy = `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:*", "Resource": "*"}]}`
+
+func TestProxyStsAssumeRoleWithWebIdentitySessionPolicies(t *testing.T) {
+	pm := iam.NewTestPolicyManager(map[string]string{
+		testPolicyArnForTestPM: testAllowAllPolicy,
+		testManagedPolicyArn:   `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]}`,
+	})
+	s := NewTestSTSServer(t, pm, 3600, testOIDCConfigFakeTesting, true)
+	token := getWebIdentityTestingToken(t, s.jwtKeyMaterial, 10*time.Minute, nil)
+
+	var testCases = []struct {
+		Description             string
+		Params                  url.Values
+		ExpectedStatus          int
+		ExpectedSessionPolicies session.AWSSessionPolicies
+	}{
+		{
+			"No session policies",
+			url.Values{},
+			http.StatusOK,
+			session.AWSSessionPolicies{},
+		},
+		{
+			"Inline and managed session policies",
+			url.Values{"Policy": {testAllowAllPolicy}, "PolicyArns.member.1.arn": {testManagedPolicyArn}},
+			http.StatusOK,
+			session.AWSSessionPolicies{Policy: testAllowAllPolicy, PolicyArns: []string{testManagedPolicyArn}},
+		},
+		{
+			"Malformed inline policy",
+			url.Values{"Policy": {`{"Statement": [{"Effect": "Maybe"}]}`}},
+			http.StatusBadRequest,
+			session.AWSSessionPolicies{},
+		},
+		{
+			"Inline policy too large",
+			url.Values{"Policy": {strings.Repeat(" ", maxSessionPolicyLength) + testAllowAllPolicy}},
+			http.StatusBadRequest,
+			session.AWSSessionPolicies{},
+		},
+		{
+			"Unknown managed policy",
+			url.Values{"PolicyArns.member.1.arn": {"arn:aws:iam::000000000000:policy/Unknown"}},
+			http.StatusBadRequest,
+			session.AWSSessionPolicies{},
+		},
+		{
+			"Role is not a managed policy",
+			url.Values{"PolicyArns.member.1.arn": {testPolicyArnForTestPM}},
+			http.StatusBadRequest,
+			session.AWSSessionPolicies{},
+		},
+	}
+	for _, tc := range testCases {
+		reqUrl := buildAssumeRoleWithIdentityTokenUrl(901, "mysession", testPolicyArnForTestPM, token)
+		if len(tc.Params) > 0 {
+			reqUrl = fmt.Sprintf("%s&%s", reqUrl, tc.Params.Encode())
+		}
+		req, err := http.NewRequest("POST", reqUrl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.processSTSPost(rr, req)
+		if rr.Result().StatusCode != tc.ExpectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.Description, tc.ExpectedStatus, rr.Result().StatusCode, rr.Body.String())
+			continue
+		}
+		if tc.ExpectedStatus != http.StatusOK {
+			continue
+		}
+		var response AssumeRoleWithWebIdentityResponse
+		if err := xml.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		claims, err := credentials.ExtractTokenClaims(response.Result.Credentials.SessionToken, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := claims.SessionPolicies
+		if got.Policy != tc.ExpectedSessionPolicies.Policy || strings.Join(got.PolicyArns, ",") != strings.Join(tc.ExpectedSessionPolicies.PolicyArns, ",") {
+			t.Errorf("%s: expected session policies %v, got %v", tc.Description, tc.ExpectedSessionPolicies, got)
+		}
+	}
+}
+
+func TestProxyStsAssumeRoleWithWebIdentityTrustPolicy(t *testing.T) {
+	const testUntrustedRoleArn = "arn:aws:iam::000000000000:role/Untrusted"
+	pm := iam.NewTestPolicyManager(map[string]string{
+		testPolicyArnForTestPM: testAllowAllPolicy,
+		testUntrustedRoleArn:   testAllowAllPolicy,
+	})
+	trustPoliciesFile := filepath.Join(t.TempDir(), "trust-policies.yaml")
+	err := os.WriteFile(trustPoliciesFile, []byte(fmt.Sprintf(`%s:
+  Version: "2012-10-17"
+  Statement:
+    - Effect: Allow
+      Principal:
+        Federated: %s
+      Action: %s
+      Condition:
+        StringEquals:
+          claims:aud: fakes3pp
+        ForAnyValue:StringEquals:
+          claims:groups: climate
+`, testPolicyArnForTestPM, testFakeIssuer, iam.ActionAssumeRoleWithWebIdentity)), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustPolicies, err := iam.ReadRoleTrustPolicies(trustPoliciesFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pm.SetTrustPolicies(trustPolicies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTestSTSServer(t, pm, 3600, testOIDCConfigFakeTesting, true)
+
+	tokenWithAudienceAndGroups := func(audience []string, groups ...string) string {
+		claims := jwt.MapClaims{
+			"iss":    testFakeIssuer,
+			"sub":    "test-user",
+			"aud":    audience,
+			"exp":    time.Now().Add(10 * time.Minute).Unix(),
+			"groups": groups,
+		}
+		token, err := credentials.CreateSignedToken(jwt.NewWithClaims(jwt.SigningMethodRS256, claims), s.jwtKeyMaterial)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	tokenWithGroups := func(groups ...string) string {
+		return tokenWithAudienceAndGroups([]string{"fakes3pp"}, groups...)
+	}
+
+	var testCases = []struct {
+		Description    string
+		RoleArn        string
+		Token          string
+		ExpectedStatus int
+	}{
+		{"Member of trusted group", testPolicyArnForTestPM, tokenWithGroups("water", "climate"), http.StatusOK},
+		{"Not a member of trusted group", testPolicyArnForTestPM, tokenWithGroups("water"), http.StatusForbidden},
+		{"Role without trust policy", testUntrustedRoleArn, tokenWithGroups(), http.StatusOK},
+		{"Audience list with another audience", testPolicyArnForTestPM, tokenWithAudienceAndGroups([]string{"fakes3pp", "other"}, "climate"), http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		req, err := http.NewRequest("POST", buildAssumeRoleWithIdentityTokenUrl(901, "mysession", tc.RoleArn, tc.Token), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		s.processSTSPost(rr, req)
+		if rr.Result().StatusCode != tc.ExpectedStatus {
+			t.Errorf("%s: expected status %d, got %d: %s", tc.Description, tc.ExpectedStatus, rr.Result().StatusCode, rr.Body.String())
+		}
+		if tc.ExpectedStatus == http.StatusForbidden && !strings.Contains(rr.Body.String(), "AccessDenied") {
+			t.Errorf("%s: expected AccessDenied, got %s", tc.Description, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package session
+
+// The session policies passed when assuming a role. A session only gets the permissions that are allowed by both
+// its role and its session policies.
+type AWSSessionPolicies struct {
+	//An inline policy document
+	Policy string `json:"policy,omitempty"`
+	//The ARNs of managed policies
+	PolicyArns []string `json:"policy_arns,omitempty"`
+}
+
+func (p AWSSessionPolicies) IsEmpty() bool {
+	return p.Policy == "" && len(p.PolicyArns) == 0
+}
@@ -83,6 +83,7 @@ const (
 	ErrSTSClientGrantsExpiredToken
 	ErrSTSInvalidClientGrantsToken
 	ErrSTSMalformedPolicyDocument
+	ErrSTSPackedPolicyTooLarge
 	ErrSTSInsecureConnection
 	ErrSTSInvalidClientCertificate
 	ErrSTSNotInitialized
@@ -139,6 +140,11 @@ var stsErrCodes = stsErrorCodeMap{
 		Description:    "The request was rejected because the policy document was malformed.",
 		HTTPStatusCode: http.StatusBadRequest,
 	},
+	ErrSTSPackedPolicyTooLarge: {
+		Code:           "PackedPolicyTooLarge",
+		Description:    "The request was rejected because the total packed size of the session policies exceeds the limit.",
+		HTTPStatusCode: http.StatusBadRequest,
+	},
 	ErrSTSInsecureConnection: {
 		Code:           "InsecureConnection",
 		Description:    "The request was made over a plain HTTP connection. A TLS connection is required.",
@@ -1442,7 +1442,7 @@ func getLegacyCredentials(t testing.TB, roleArn string, tags session.AWSSessionT
 
 	expiry := time.Hour
 
-	token := credentials.CreateRS256PolicyToken("issuer", "iisuer", "subject", roleArn, expiry, tags)
+	token := credentials.CreateRS256PolicyToken("issuer", "iisuer", "subject", roleArn, expiry, tags, session.AWSSessionPolicies{})
 
 	creds, err := newLegacyAWSCredentialsForToken(token, expiry, pkKeeper)
 	if err != nil {
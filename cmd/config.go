@@ -50,16 +50,28 @@ const (
 	stsProxyTlsKeyFile                               = "stsProxyKeyFile"
 	stsMinimalDurationSeconds                        = "stsMinimalDurationSeconds"
 	rolePolicyPath                                   = "rolePolicyPath"
+	rolePolicyAttachments                            = "rolePolicyAttachments"
+	rolePermissionBoundaries                         = "rolePermissionBoundaries"
+	guardrailPolicyArn                               = "guardrailPolicyArn"
+	roleTrustPolicies                                = "roleTrustPolicies"
 	stsOIDCConfigFile                                = "stsOIDCConfigFile"
 	s3BackendConfigFile                              = "s3BackendConfigFile"
 	s3ForceRequesterPaysFor                          = "forceRequesterPaysFor"
+	s3FilterListBucketsFor                           = "filterListBucketsFor"
+	s3FilterListObjectsFor                           = "filterListObjectsFor"
+	s3BucketPolicyPath                               = "bucketPolicyPath"
 	stsMaxDurationSeconds                            = "stsMaxDurationSeconds"
 	signedUrlGraceTimeSeconds                        = "signedUrlGraceTimeSeconds"
 	enableLegacyBehaviorInvalidRegionToDefaultRegion = "enableLegacyBehaviorInvalidRegionToDefaultRegion"
 	logLevel                                         = "logLevel"
 	metricsPort                                      = "metricsPort"
+	enablePolicySimulator                            = "enablePolicySimulator"
+	policySimulatorToken                             = "policySimulatorToken"
+	trustedProxies                                   = "trustedProxies"
+	proxyProtocol                                    = "proxyProtocol"
 	s3CorsStrategy                                   = "corsStrategy"
 	s3LoggedResponseHeaders                          = "s3LoggedResponseHeaders"
+	s3AuthzMessageHeader                             = "s3AuthzMessageHeader"
 
 	//Environment variables are upper cased
 	//Unless they are wellknown environment variables they should be prefixed
@@ -74,7 +86,11 @@ const (
 	FAKES3PP_S3_CORS_STRATEGY                = "FAKES3PP_S3_CORS_STRATEGY"
 	FAKES3PP_S3_CORS_STATIC_ALLOWED_ORIGIN   = "FAKES3PP_S3_CORS_STATIC_ALLOWED_ORIGIN"
 	FAKES3PP_S3_FORCE_REQUESTER_PAYS_FOR     = "FAKES3PP_S3_FORCE_REQUESTER_PAYS_FOR"
+	FAKES3PP_S3_FILTER_LIST_BUCKETS_FOR      = "FAKES3PP_S3_FILTER_LIST_BUCKETS_FOR"
+	FAKES3PP_S3_FILTER_LIST_OBJECTS_FOR      = "FAKES3PP_S3_FILTER_LIST_OBJECTS_FOR"
+	FAKES3PP_S3_BUCKET_POLICY_PATH           = "FAKES3PP_S3_BUCKET_POLICY_PATH"
 	FAKES3PP_S3_LOGGED_RESPONSE_HEADERS      = "FAKES3PP_S3_LOGGED_RESPONSE_HEADERS"
+	FAKES3PP_S3_AUTHZ_MESSAGE_HEADER         = "FAKES3PP_S3_AUTHZ_MESSAGE_HEADER"
 
 	FAKES3PP_STS_PROXY_FQDN          = "FAKES3PP_STS_PROXY_FQDN"
 	FAKES3PP_STS_PROXY_TLS_PORT      = "FAKES3PP_STS_PROXY_TLS_PORT"
@@ -87,11 +103,19 @@ const (
 	FAKES3PP_STS_OIDC_CONFIG                                = "FAKES3PP_STS_OIDC_CONFIG"
 	FAKES3PP_S3_BACKEND_CONFIG                              = "FAKES3PP_S3_BACKEND_CONFIG"
 	FAKES3PP_ROLE_POLICY_PATH                               = "FAKES3PP_ROLE_POLICY_PATH"
+	FAKES3PP_ROLE_POLICY_ATTACHMENTS                        = "FAKES3PP_ROLE_POLICY_ATTACHMENTS"
+	FAKES3PP_ROLE_PERMISSION_BOUNDARIES                     = "FAKES3PP_ROLE_PERMISSION_BOUNDARIES"
+	FAKES3PP_GUARDRAIL_POLICY_ARN                           = "FAKES3PP_GUARDRAIL_POLICY_ARN"
+	FAKES3PP_ROLE_TRUST_POLICIES                            = "FAKES3PP_ROLE_TRUST_POLICIES"
 	FAKES3PP_STS_MAX_DURATION_SECONDS                       = "FAKES3PP_STS_MAX_DURATION_SECONDS"
 	FAKES3PP_SIGNEDURL_GRACE_TIME_SECONDS                   = "FAKES3PP_SIGNEDURL_GRACE_TIME_SECONDS"
 	ENABLE_LEGACY_BEHAVIOR_INVALID_REGION_TO_DEFAULT_REGION = "ENABLE_LEGACY_BEHAVIOR_INVALID_REGION_TO_DEFAULT_REGION"
 	LOG_LEVEL                                               = "LOG_LEVEL"
 	FAKES3PP_METRICS_PORT                                   = "FAKES3PP_METRICS_PORT"
+	FAKES3PP_ENABLE_POLICY_SIMULATOR                        = "FAKES3PP_ENABLE_POLICY_SIMULATOR"
+	FAKES3PP_POLICY_SIMULATOR_TOKEN                         = "FAKES3PP_POLICY_SIMULATOR_TOKEN"
+	FAKES3PP_TRUSTED_PROXIES                                = "FAKES3PP_TRUSTED_PROXIES"
+	FAKES3PP_PROXY_PROTOCOL                                 = "FAKES3PP_PROXY_PROTOCOL"
 
 	valueStatic  = "static"
 	valueDenyAll = "deny-all"
@@ -104,7 +128,8 @@ var envVarDefs = []envVarDef{
 		true,
 		`The fully qualified domain name(s) of this S3 proxy server (e.g. localhost).
 		You can specify multiple to allow access via multiple FQDNs but the first one will be used for generating pre-signed urls.
-		When specifying multiple they must be comma-separated.`,
+		When specifying multiple they must be comma-separated.
+		A wildcard FQDN (e.g. *.s3.example.com) allows virtual-hosted-style requests (e.g. https://bucket.s3.example.com/key).`,
 		[]string{proxys3},
 	},
 	{
@@ -140,7 +165,7 @@ var envVarDefs = []envVarDef{
 		FAKES3PP_PROXY_JWT_PRIVATE_RSA_KEY,
 		true,
 		"The key file used for signing JWT tokens",
-		[]string{proxys3, proxysts},
+		[]string{proxys3, proxysts, decodeAuthorizationMessage},
 	},
 	{
 		proxyJwtPublicRSAKey,
@@ -230,6 +255,27 @@ var envVarDefs = []envVarDef{
 		"Optional YAML file with bucket names that must get x-amz-request-payer: requester on upstream requests",
 		[]string{proxys3},
 	},
+	{
+		s3FilterListBucketsFor,
+		FAKES3PP_S3_FILTER_LIST_BUCKETS_FOR,
+		false,
+		"Optional YAML file with role ARNs for which ListBuckets only returns the buckets that their policy grants access to",
+		[]string{proxys3},
+	},
+	{
+		s3FilterListObjectsFor,
+		FAKES3PP_S3_FILTER_LIST_OBJECTS_FOR,
+		false,
+		"Optional YAML file with role ARNs for which ListObjectsV2 only returns the keys that their policy allows to get",
+		[]string{proxys3},
+	},
+	{
+		s3BucketPolicyPath,
+		FAKES3PP_S3_BUCKET_POLICY_PATH,
+		false,
+		"Optional path with bucket policies in files named <bucket>.json which can grant access to sessions next to the policies of their role",
+		[]string{proxys3, simulate},
+	},
 	{
 		s3LoggedResponseHeaders,
 		FAKES3PP_S3_LOGGED_RESPONSE_HEADERS,
@@ -237,12 +283,47 @@ var envVarDefs = []envVarDef{
 		"Comma-separated list of upstream response header names to include in the S3 access log under the s3 group (e.g. x-ratelimit-remaining,x-ratelimit-limit)",
 		[]string{proxys3},
 	},
+	{
+		s3AuthzMessageHeader,
+		FAKES3PP_S3_AUTHZ_MESSAGE_HEADER,
+		false,
+		"Whether to explain denied requests in an x-fakes3pp-authz-message header that is encrypted with the JWT key of the proxy. Administrators can decode it with the decode-authorization-message command",
+		[]string{proxys3},
+	},
 	{
 		rolePolicyPath,
 		FAKES3PP_ROLE_POLICY_PATH,
 		true,
 		"The path in which there are files with names corresponsing to the base32 encoded role name and content the policy",
-		[]string{proxysts, proxys3},
+		[]string{proxysts, proxys3, simulate},
+	},
+	{
+		rolePolicyAttachments,
+		FAKES3PP_ROLE_POLICY_ATTACHMENTS,
+		false,
+		"Optional YAML file that maps role ARNs to lists of managed policy ARNs that are attached to them. Managed policies are stored like role policies in the role policy path",
+		[]string{proxysts, proxys3, simulate},
+	},
+	{
+		rolePermissionBoundaries,
+		FAKES3PP_ROLE_PERMISSION_BOUNDARIES,
+		false,
+		"Optional YAML file that maps role ARNs to the managed policy ARN that is their permission boundary. Sessions of a role only get what both its policies and its boundary allow",
+		[]string{proxysts, proxys3, simulate},
+	},
+	{
+		guardrailPolicyArn,
+		FAKES3PP_GUARDRAIL_POLICY_ARN,
+		false,
+		"Optional ARN of a managed policy that limits the permissions of every role. Grants must be allowed by both the role and the guardrail policy",
+		[]string{proxysts, proxys3, simulate},
+	},
+	{
+		roleTrustPolicies,
+		FAKES3PP_ROLE_TRUST_POLICIES,
+		false,
+		"Optional YAML file that maps role ARNs to trust policies which control which web identities can assume the role. Roles without trust policy can be assumed by any valid web identity",
+		[]string{proxysts},
 	},
 	{
 		stsMaxDurationSeconds,
@@ -279,6 +360,36 @@ var envVarDefs = []envVarDef{
 		"The port on which to run the /metrics endpoint",
 		[]string{proxys3, proxysts},
 	},
+	{
+		enablePolicySimulator,
+		FAKES3PP_ENABLE_POLICY_SIMULATOR,
+		false,
+		"Whether the S3 proxy serves the policy simulator on the metrics port under /simulate. It reveals the policies so the metrics port must only be reachable by administrators",
+		[]string{proxys3},
+	},
+	{
+		policySimulatorToken,
+		FAKES3PP_POLICY_SIMULATOR_TOKEN,
+		false,
+		"The admin token that requests to the policy simulator must pass as bearer token. Required when the policy simulator is enabled",
+		[]string{proxys3},
+	},
+	{
+		trustedProxies,
+		FAKES3PP_TRUSTED_PROXIES,
+		false,
+		`A comma separated list of CIDR blocks or IP addresses of load balancers in front of the proxy (e.g. 10.0.0.0/8).
+		For requests from these peers the client address (e.g. for aws:SourceIp conditions) is taken from the
+		X-Forwarded-For header or, if FAKES3PP_PROXY_PROTOCOL is true, from the PROXY protocol header.`,
+		[]string{proxys3, proxysts},
+	},
+	{
+		proxyProtocol,
+		FAKES3PP_PROXY_PROTOCOL,
+		false,
+		"If set to true the trusted proxies must start their connections with a PROXY protocol (v1 or v2) header",
+		[]string{proxys3, proxysts},
+	},
 	{
 		stsMinimalDurationSeconds,
 		FAKES3PP_STS_MINIMAL_DURATION_SECONDS,
@@ -373,16 +484,18 @@ func getS3ProxyLCFQDNs() ([]string, error) {
 	return s3ProxyFQDNs, nil
 }
 
-// get the main FQDN associated with the S3 proxy
+// get the main FQDN associated with the S3 proxy which is the first one that is not a wildcard
 func getMainS3ProxyFQDN() (string, error) {
 	fqdns, err := getS3ProxyLCFQDNs()
 	if err != nil {
 		return "", err
 	}
-	if len(fqdns) == 0 {
-		return "", errors.New("no S3ProxyFQDN available")
+	for _, fqdn := range fqdns {
+		if !strings.HasPrefix(fqdn, "*.") {
+			return fqdn, nil
+		}
 	}
-	return fqdns[0], nil
+	return "", errors.New("no S3ProxyFQDN available")
 }
 
 // Bind the environment variables for a command
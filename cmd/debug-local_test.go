@@ -25,6 +25,7 @@ func TestRunLocalDebugEndpoint(t *testing.T) {
 
 	logging.InitializeLogging(logging.EnvironmentLvl, nil, nil)
 
-	server.CreateAndStartSync(buildS3Server(), getServerOptsFromViper())
+	s := buildS3Server()
+	server.CreateAndStartSync(s, getServerOptsFromViper(s))
 
 }
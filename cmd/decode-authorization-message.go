@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const decodeAuthorizationMessage = "decode-authorization-message"
+
+// decodeAuthorizationMessageCmd represents the decode-authorization-message command
+var decodeAuthorizationMessageCmd = &cobra.Command{
+	Use:   decodeAuthorizationMessage + " <encoded message>",
+	Short: "Decode the authorization message of a denied S3 request",
+	Long: `Decode the x-fakes3pp-authz-message header that the S3 proxy returns for denied requests when
+FAKES3PP_S3_AUTHZ_MESSAGE_HEADER is enabled. It tells which statement denied each action or which conditions were
+not met. Only the private JWT key of the proxy can decode it, like DecodeAuthorizationMessage of AWS STS.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		BindEnvVariables(decodeAuthorizationMessage)
+		msg, err := decodeAuthorizationMessageWithProxyKey(args[0])
+		if err != nil {
+			fmt.Printf("Could not decode authorization message: %s\n", err)
+			os.Exit(1)
+		}
+		out, err := json.MarshalIndent(msg, "", "  ")
+		if err != nil {
+			fmt.Printf("Could not encode authorization message: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	},
+}
+
+func decodeAuthorizationMessageWithProxyKey(encodedMsg string) (*iam.AuthorizationMessage, error) {
+	key, err := utils.PrivateKeyFromPemFile(viper.GetString(proxyJwtPrivateRSAKey))
+	if err != nil {
+		return nil, err
+	}
+	return iam.DecodeAuthorizationMessage(key, encodedMsg)
+}
+
+func init() {
+	rootCmd.AddCommand(decodeAuthorizationMessageCmd)
+}
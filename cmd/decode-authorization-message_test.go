@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/utils"
+	"github.com/spf13/viper"
+)
+
+func TestDecodeAuthorizationMessageWithProxyKey(t *testing.T) {
+	BindEnvVariables(decodeAuthorizationMessage)
+	key, err := utils.PrivateKeyFromPemFile(viper.GetString(proxyJwtPrivateRSAKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encodedMsg, err := iam.EncodeAuthorizationMessage(key, iam.AuthorizationMessage{RoleArn: "arn:aws:iam::000000000000:role/S3Access"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := decodeAuthorizationMessageWithProxyKey(encodedMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.RoleArn != "arn:aws:iam::000000000000:role/S3Access" {
+		t.Errorf("Unexpected decoded message %+v", msg)
+	}
+	if _, err := decodeAuthorizationMessageWithProxyKey("not-a-message"); err == nil {
+		t.Error("Decoding garbage must fail")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
 	"github.com/VITObelgium/fakes3pp/aws/service/s3"
 	"github.com/VITObelgium/fakes3pp/aws/service/s3/interfaces"
 	"github.com/VITObelgium/fakes3pp/server"
@@ -23,6 +24,11 @@ func buildS3Server() server.Serverable {
 		slog.Error("Could not initialize PolicyManager", "error", err)
 		panic(fmt.Sprintf("Clould not initialize PolicyManager %s", err))
 	}
+	err = initializeBucketPolicies(pm)
+	if err != nil {
+		slog.Error("Could not load bucket policies", "error", err)
+		panic(fmt.Sprintf("Could not load bucket policies: %s", err))
+	}
 
 	fqdns, err := getS3ProxyFQDNs()
 	if err != nil {
@@ -47,11 +53,14 @@ func buildS3Server() server.Serverable {
 		nil,
 		viper.GetString(s3BackendConfigFile),
 		viper.GetString(s3ForceRequesterPaysFor),
+		viper.GetString(s3FilterListBucketsFor),
+		viper.GetString(s3FilterListObjectsFor),
 		viper.GetBool(enableLegacyBehaviorInvalidRegionToDefaultRegion),
 		removableQueryParams,
 		getS3CORSHandler(),
 		getS3ProxyHTTPPort(),
 		viper.GetStringSlice(s3LoggedResponseHeaders),
+		viper.GetBool(s3AuthzMessageHeader),
 	)
 	if err != nil {
 		slog.Error("Could not create S3 server", "error", err)
@@ -60,6 +69,17 @@ func buildS3Server() server.Serverable {
 	return s
 }
 
+func initializeBucketPolicies(pm *iam.PolicyManager) error {
+	if bucketPolicyPath := viper.GetString(s3BucketPolicyPath); bucketPolicyPath != "" {
+		bucketPolicies, err := iam.NewLocalBucketPolicies(bucketPolicyPath)
+		if err != nil {
+			return err
+		}
+		pm.SetBucketPolicies(bucketPolicies)
+	}
+	return nil
+}
+
 // proxys3Cmd represents the proxyS3 command
 var proxys3Cmd = &cobra.Command{
 	Use:   proxys3,
@@ -67,7 +87,8 @@ var proxys3Cmd = &cobra.Command{
 	Long: `Spawn a server process that listens for requests and takes API calls
 	that follow the S3 API.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		server.CreateAndStartSync(buildS3Server(), getServerOptsFromViper())
+		s := buildS3Server()
+		server.CreateAndStartSync(s, getServerOptsFromViper(s))
 	},
 }
 
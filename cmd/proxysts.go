@@ -24,6 +24,7 @@ package cmd
 import (
 	"fmt"
 	"log/slog"
+	"net/http"
 
 	"github.com/VITObelgium/fakes3pp/aws/service/iam"
 	"github.com/VITObelgium/fakes3pp/aws/service/sts"
@@ -35,7 +36,47 @@ import (
 const proxysts = "proxysts"
 
 func initializePolicyManager() (pm *iam.PolicyManager, err error) {
-	return iam.NewPolicyManagerForLocalPolicies(viper.GetString(rolePolicyPath))
+	pm, err = iam.NewPolicyManagerForLocalPolicies(viper.GetString(rolePolicyPath))
+	if err != nil {
+		return nil, err
+	}
+	if attachmentsFile := viper.GetString(rolePolicyAttachments); attachmentsFile != "" {
+		attachments, err := iam.ReadRolePolicyAttachments(attachmentsFile)
+		if err != nil {
+			return nil, err
+		}
+		err = pm.AttachPolicies(attachments)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if boundariesFile := viper.GetString(rolePermissionBoundaries); boundariesFile != "" {
+		boundaries, err := iam.ReadRolePermissionBoundaries(boundariesFile)
+		if err != nil {
+			return nil, err
+		}
+		err = pm.SetPermissionBoundaries(boundaries)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if guardrailArn := viper.GetString(guardrailPolicyArn); guardrailArn != "" {
+		err = pm.SetGuardrailPolicy(guardrailArn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if trustPoliciesFile := viper.GetString(roleTrustPolicies); trustPoliciesFile != "" {
+		trustPolicies, err := iam.ReadRoleTrustPolicies(trustPoliciesFile)
+		if err != nil {
+			return nil, err
+		}
+		err = pm.SetTrustPolicies(trustPolicies)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pm, nil
 }
 
 func buildSTSServer() server.Serverable {
@@ -71,10 +112,35 @@ func buildSTSServer() server.Serverable {
 	return s
 }
 
-func getServerOptsFromViper() server.ServerOpts {
-	return server.ServerOpts{
-		MetricsPort: viper.GetInt(metricsPort),
+// A server that evaluates policies with a PolicyManager. Only the S3 proxy is one since the answers of the
+// policy simulator must take the bucket policies into account like the S3 proxy does.
+type policyManagerHolder interface {
+	GetPolicyManager() *iam.PolicyManager
+}
+
+func getServerOptsFromViper(s server.Serverable) server.ServerOpts {
+	proxies, err := server.ParseTrustedProxies(viper.GetString(trustedProxies))
+	if err != nil {
+		slog.Error("Could not get trusted proxies", "error", err)
+		panic(fmt.Sprintf("Could not get trusted proxies: %s", err))
+	}
+	opts := server.ServerOpts{
+		MetricsPort:    viper.GetInt(metricsPort),
+		TrustedProxies: proxies,
+		ProxyProtocol:  viper.GetBool(proxyProtocol),
+	}
+	if holder, ok := s.(policyManagerHolder); ok && viper.GetBool(enablePolicySimulator) {
+		token := viper.GetString(policySimulatorToken)
+		if token == "" {
+			slog.Error("The policy simulator requires an admin token", "env", FAKES3PP_POLICY_SIMULATOR_TOKEN)
+			panic(fmt.Sprintf("The policy simulator is enabled but %s is not set", FAKES3PP_POLICY_SIMULATOR_TOKEN))
+		}
+		slog.Warn("Serving the policy simulator, it reveals the policies to anyone with the admin token", "path", policySimulatorPath, "port", opts.MetricsPort)
+		opts.AdminHandlers = map[string]http.Handler{
+			policySimulatorPath: iam.NewPolicySimulatorHandler(holder.GetPolicyManager(), token),
+		}
 	}
+	return opts
 }
 
 // proxystsCmd represents the proxysts command
@@ -84,7 +150,8 @@ var proxystsCmd = &cobra.Command{
 	Long: `Spawn a server process that listens for requests and takes API calls
 	that follow the STS API. There are only few supporte`,
 	Run: func(cmd *cobra.Command, args []string) {
-		server.CreateAndStartSync(buildSTSServer(), getServerOptsFromViper())
+		s := buildSTSServer()
+		server.CreateAndStartSync(s, getServerOptsFromViper(s))
 	},
 }
 
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/spf13/cobra"
+)
+
+const simulate = "simulate"
+
+// The path of the policy simulator on the metrics port
+const policySimulatorPath = "/simulate"
+
+var simulateRequest iam.SimulationRequest
+var simulateTags []string
+var simulateContext []string
+
+// simulateCmd represents the simulate command
+var simulateCmd = &cobra.Command{
+	Use:   simulate,
+	Short: "Explain whether a session of a role is allowed to perform an action",
+	Long: `Simulate a request with the policies of the proxy and print the decision, the statement that decided it and
+the reason. The role policies are rendered for the given claims and tags and combined with the attached policies,
+bucket policies, guardrail, permission boundary and session policies like the proxy does. For example:
+
+fakes3pp simulate --role-arn arn:aws:iam::000000000000:role/S3Access --subject alice --tag team=climate \
+  --action s3:GetObject --resource arn:aws:s3:::bucket/climate/data.csv`,
+	Run: func(cmd *cobra.Command, args []string) {
+		BindEnvVariables(simulate)
+		result, err := runSimulation()
+		if err != nil {
+			fmt.Printf("Could not simulate request: %s\n", err)
+			os.Exit(1)
+		}
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			fmt.Printf("Could not encode simulation result: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	},
+}
+
+func runSimulation() (*iam.SimulationResult, error) {
+	pm, err := initializePolicyManager()
+	if err != nil {
+		return nil, err
+	}
+	err = initializeBucketPolicies(pm)
+	if err != nil {
+		return nil, err
+	}
+	simulateRequest.Tags.PrincipalTags, err = parseKeyValues(simulateTags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --tag: %w", err)
+	}
+	simulateRequest.Context, err = parseKeyValues(simulateContext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --context: %w", err)
+	}
+	return pm.Simulate(simulateRequest)
+}
+
+// Parse key=value pairs, a key that is passed multiple times gets multiple values
+func parseKeyValues(pairs []string) (map[string][]string, error) {
+	values := map[string][]string{}
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("%q is not of the form key=value", pair)
+		}
+		values[key] = append(values[key], value)
+	}
+	return values, nil
+}
+
+func init() {
+	rootCmd.AddCommand(simulateCmd)
+
+	simulateCmd.Flags().StringVar(&simulateRequest.RoleArn, "role-arn", "", "The ARN of the role of the session.")
+	simulateCmd.Flags().StringVar(&simulateRequest.Issuer, "issuer", "", "The issuer of the web identity token of the session.")
+	simulateCmd.Flags().StringVar(&simulateRequest.Subject, "subject", "", "The subject of the web identity token of the session.")
+	simulateCmd.Flags().StringArrayVar(&simulateTags, "tag", nil, "A principal tag of the session as key=value, can be repeated.")
+	simulateCmd.Flags().StringVar(&simulateRequest.SessionPolicies.Policy, "session-policy", "", "An inline session policy.")
+	simulateCmd.Flags().StringArrayVar(&simulateRequest.SessionPolicies.PolicyArns, "session-policy-arn", nil, "The ARN of a managed session policy, can be repeated.")
+	simulateCmd.Flags().StringVar(&simulateRequest.Action, "action", "", "The IAM action e.g. s3:GetObject.")
+	simulateCmd.Flags().StringVar(&simulateRequest.Resource, "resource", "", "The ARN of the resource e.g. arn:aws:s3:::bucket/key.")
+	simulateCmd.Flags().StringArrayVar(&simulateContext, "context", nil, "A context key of the request as key=value e.g. s3:prefix=home/, can be repeated.")
+	simulateCmd.Flags().StringVar(&simulateRequest.SourceIp, "source-ip", "", "The IP address of the client.")
+	simulateCmd.Flags().StringVar(&simulateRequest.RequestedRegion, "region", "", "The region of the request.")
+	for _, flag := range []string{"role-arn", "action", "resource"} {
+		err := simulateCmd.MarkFlagRequired(flag)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/VITObelgium/fakes3pp/aws/service/iam"
+	"github.com/VITObelgium/fakes3pp/aws/service/sts"
+	"github.com/spf13/viper"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	values, err := parseKeyValues([]string{"team=climate", "groups=a", "groups=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values["team"]) != 1 || len(values["groups"]) != 2 || len(values["empty"]) != 1 {
+		t.Errorf("Unexpected values %v", values)
+	}
+	if _, err := parseKeyValues([]string{"team"}); err == nil {
+		t.Error("Pair without = must be refused")
+	}
+}
+
+func TestSimulateCommand(t *testing.T) {
+	BindEnvVariables(simulate)
+	simulateRequest = iam.SimulationRequest{
+		RoleArn:  "arn:aws:iam::000000000000:role/S3Access",
+		Action:   "s3:GetObject",
+		Resource: "arn:aws:s3:::bucket/key",
+	}
+	simulateTags = []string{"team=climate"}
+	defer func() {
+		simulateRequest = iam.SimulationRequest{}
+		simulateTags = nil
+	}()
+
+	result, err := runSimulation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Decision != iam.DecisionAllowed || result.Statement == nil || result.Statement.Sid != "VisualEditor0" {
+		t.Errorf("Unexpected simulation result %+v", result)
+	}
+}
+
+func TestPolicySimulatorRequiresAdminToken(t *testing.T) {
+	BindEnvVariables(proxys3)
+	s := buildS3Server()
+	viper.Set(enablePolicySimulator, true)
+	defer func() {
+		viper.Set(enablePolicySimulator, false)
+		viper.Set(policySimulatorToken, "")
+	}()
+
+	//Without an admin token the simulator must not be served
+	viper.Set(policySimulatorToken, "")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Enabling the policy simulator without admin token must fail")
+			}
+		}()
+		getServerOptsFromViper(s)
+	}()
+
+	//With an admin token it is served on the metrics port
+	viper.Set(policySimulatorToken, "admin-token")
+	opts := getServerOptsFromViper(s)
+	if opts.AdminHandlers[policySimulatorPath] == nil {
+		t.Errorf("Expected the policy simulator to be served, got %v", opts.AdminHandlers)
+	}
+}
+
+func TestPolicySimulatorTakesBucketPoliciesIntoAccount(t *testing.T) {
+	//Given a bucket policy that denies what the policy of the role allows
+	bucketPolicyDir := t.TempDir()
+	bucketPolicy := `{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/key"}]}`
+	err := os.WriteFile(filepath.Join(bucketPolicyDir, "bucket.json"), []byte(bucketPolicy), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(FAKES3PP_S3_BUCKET_POLICY_PATH, bucketPolicyDir)
+	BindEnvVariables(proxys3)
+	s := buildS3Server()
+	viper.Set(enablePolicySimulator, true)
+	viper.Set(policySimulatorToken, "admin-token")
+	defer func() {
+		viper.Set(enablePolicySimulator, false)
+		viper.Set(policySimulatorToken, "")
+	}()
+
+	//When simulating a request with the simulator of the S3 proxy
+	opts := getServerOptsFromViper(s)
+	body := `{"role_arn": "arn:aws:iam::000000000000:role/S3Access", "action": "s3:GetObject", "resource": "arn:aws:s3:::bucket/key"}`
+	req := httptest.NewRequest(http.MethodPost, policySimulatorPath, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rec := httptest.NewRecorder()
+	opts.AdminHandlers[policySimulatorPath].ServeHTTP(rec, req)
+
+	//Then the request is denied by the bucket policy like the S3 proxy would
+	var result iam.SimulationResult
+	err = json.NewDecoder(rec.Body).Decode(&result)
+	if err != nil {
+		t.Fatalf("Could not decode simulation result (status %d): %s", rec.Code, err)
+	}
+	if result.Decision != iam.DecisionExplicitDeny {
+		t.Errorf("Expected the bucket policy to deny the request, got %+v", result)
+	}
+	//And the STS proxy, which does not load bucket policies and would allow it, does not serve the simulator
+	opts = getServerOptsFromViper(&sts.STSServer{})
+	if opts.AdminHandlers[policySimulatorPath] != nil {
+		t.Error("The STS proxy must not serve the policy simulator")
+	}
+}
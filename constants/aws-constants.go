@@ -11,6 +11,9 @@ const (
 	// AmzRequestPayerRequesterValue is the value used to charge the requester.
 	AmzRequestPayerRequesterValue = "requester"
 
+	// AmzCopySource indicates the source object for copy operations (CopyObject and UploadPartCopy)
+	AmzCopySource = "X-Amz-Copy-Source"
+
 	// Source: https://pkg.go.dev/github.com/aws/aws-sdk-go-v2/internal/v4a/internal/v4
 
 	// EmptyStringSHA256 is the hex encoded sha256 value of an empty string
@@ -6,4 +6,9 @@ const (
 	// IAM permissions so why not allow both type of HTTP requests. Note This must be passed
 	// as a query parameter BEFORE signing because it is expected to be signed.
 	HeadAsGet = "X-Proxy-Head-As-Get"
+
+	// The region of the backend that holds the source of a CopyObject request. Copy sources do not
+	// carry a region so without this header the source is assumed to be on the destination backend.
+	// When it resolves to another backend the proxy streams the object from one backend to the other.
+	CopySourceRegion = "X-Proxy-Copy-Source-Region"
 )
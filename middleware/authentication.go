@@ -38,6 +38,8 @@ func AWSAuthN(keyStorage utils.KeyPairKeeper, e service.ErrorReporter, backendMa
 			var shouldContinue bool
 			if IsPresignedAWSRequest(r) {
 				shouldContinue = handleAuthNPresigned(w, r, keyStorage, e, backendManager, presignOptions)
+			} else if presign.IsPostPolicyRequest(r) {
+				shouldContinue = handleAuthNPostPolicy(w, r, keyStorage, e, backendManager)
 			} else {
 				shouldContinue = handleAuthNNormal(w, r, keyStorage, e, backendManager)
 			}
@@ -199,6 +201,12 @@ func handleAuthNNormal(w http.ResponseWriter, r *http.Request, keyStorage utils.
 		r.ContentLength = -1
 	}
 	clonedReq := r.Clone(r.Context())
+	err = presign.RestoreVirtualHostedAddressing(clonedReq)
+	if err != nil {
+		err := fmt.Errorf("could not restore virtual-hosted-style addressing: %w", err)
+		e.WriteErrorResponse(r.Context(), w, service.ErrAWSInternalError, err)
+		return false
+	}
 	creds := aws.Credentials{
 		AccessKeyID:     accessKeyId,
 		SecretAccessKey: secretAccessKey,
@@ -230,6 +238,77 @@ func handleAuthNNormal(w http.ResponseWriter, r *http.Request, keyStorage utils.
 	return true
 }
 
+// Authenticate a browser-based upload (POST with a multipart form) see responsibilities AWSAuthN
+// The credentials and signature are form fields and the signature covers the POST policy. The policy
+// restricts the other form fields so it gets enforced here as well. The file is not read such that it
+// can be streamed by the handler which gets the form from the request context.
+func handleAuthNPostPolicy(w http.ResponseWriter, r *http.Request, keyStorage utils.KeyPairKeeper, e service.ErrorReporter, backendManager interfaces.BackendManager) bool {
+	requestctx.SetAuthType(r, authtypes.AuthTypePostPolicy)
+	form, err := presign.ReadPostPolicyForm(r)
+	if err != nil {
+		e.WriteErrorResponse(r.Context(), w, service.ErrMalformedPOSTRequest, err)
+		return false
+	}
+	accessKeyId, sessionToken, err := form.GetCredentials()
+	if err != nil {
+		err := fmt.Errorf("could not get credentials from POST form: %w", err)
+		e.WriteErrorResponse(r.Context(), w, service.ErrAuthorizationHeaderMalformed, err)
+		return false
+	}
+	requestctx.AddAccessLogInfo(r, "s3", slog.String(L_AKID, accessKeyId))
+	requestctx.SetSessionToken(r, sessionToken)
+
+	err = makeSureSessionTokenIsForAccessKey(sessionToken, accessKeyId, keyStorage.GetJwtKeyFunc(), nil)
+	if err != nil {
+		err := fmt.Errorf("error when making sure session token corresponds to used credential pair: %w", err)
+		e.WriteErrorResponse(r.Context(), w, service.ErrAuthorizationHeaderMalformed, err)
+		return false
+	}
+
+	region, err := form.GetRegion()
+	if err != nil {
+		err := fmt.Errorf("could not get region from POST form: %w", err)
+		e.WriteErrorResponse(r.Context(), w, service.ErrAuthorizationHeaderMalformed, err)
+		return false
+	}
+	requestctx.SetTargetRegion(r, region)
+	requestctx.AddAccessLogInfo(r, "s3", slog.String("TargetRegion", region))
+
+	secretAccessKey, err := credentials.CalculateSecretKey(accessKeyId, keyStorage)
+	if err != nil {
+		err := fmt.Errorf("could not calculate secret key: %w", err)
+		e.WriteErrorResponse(r.Context(), w, service.ErrAWSInternalError, err)
+		return false
+	}
+	isValid, err := form.HasValidSignature(secretAccessKey)
+	if err != nil {
+		e.WriteErrorResponse(r.Context(), w, service.ErrMalformedPOSTRequest, err)
+		return false
+	}
+	if !isValid {
+		e.WriteErrorResponse(r.Context(), w, service.ErrAWSInvalidSignature, errors.New("failed authentication of POST policy signature"))
+		return false
+	}
+
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	err = form.CheckPolicy(bucket, time.Now().UTC())
+	if errors.Is(err, presign.ErrPostPolicyViolated) {
+		e.WriteErrorResponse(r.Context(), w, service.ErrAWSAccessDenied, err)
+		return false
+	} else if err != nil {
+		e.WriteErrorResponse(r.Context(), w, service.ErrMalformedPOSTRequest, err)
+		return false
+	}
+
+	rCtx, ok := requestctx.FromContext(r.Context())
+	if !ok {
+		e.WriteErrorResponse(r.Context(), w, service.ErrAWSInternalError, errors.New("no request context to keep POST form"))
+		return false
+	}
+	rCtx.SetDataKey(presign.PostPolicyFormDataKey, form)
+	return true
+}
+
 // Make sure the provided session token matches the used credentials
 // If not return an error
 func makeSureSessionTokenIsForAccessKey(sessionToken, accessKeyId string, keyFunc jwt.Keyfunc, authOptions *AuthenticationOptions) (invalidToken error) {
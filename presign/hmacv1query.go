@@ -288,6 +288,8 @@ var queryStringArgumentsOfInterest = map[string]bool{
 	"object-lock":                  true,
 }
 
+// The canonical resource is /bucket/key. Virtual-hosted-style requests are rewritten to path-style by
+// the proxy before their signature is checked so the path of the request can be used for both styles.
 func getCanonicalResource(req *http.Request) (string, error) {
 	if len(req.URL.Query()) > 0 {
 		for queryName := range req.URL.Query() {
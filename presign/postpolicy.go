@@ -0,0 +1,372 @@
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-UsingHTTPPOST.html
+// Browser-based uploads send an HTML form (multipart/form-data) that contains a base64 encoded POST policy
+// and its signature. The policy restricts the values that the other form fields can have.
+package presign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/VITObelgium/fakes3pp/constants"
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/requestutils"
+	"github.com/VITObelgium/fakes3pp/usererror"
+)
+
+// The key under which the POST form is kept in the request context such that the file can be streamed by the handler
+const PostPolicyFormDataKey = "PostPolicyForm"
+
+// The form fields that precede the file are small so limit how much of them gets read into memory
+var maxPostPolicyFormFieldsBytes int64 = 64 * 1024
+
+const postFileFieldName = "file"
+
+const postPolicyAlgorithm = "AWS4-HMAC-SHA256"
+
+// Placeholder in the key field that gets replaced by the name of the uploaded file
+const postKeyFileNamePlaceholder = "${filename}"
+
+var ErrMalformedPostRequest = errors.New("malformed POST request")
+var ErrPostPolicyViolated = errors.New("invalid according to policy")
+var ErrEntityTooSmall = errors.New("file is smaller than the minimum allowed by the policy")
+var ErrEntityTooLarge = errors.New("file is larger than the maximum allowed by the policy")
+
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-HTTPPOSTConstructPolicy.html
+type postPolicyDocument struct {
+	Expiration string            `json:"expiration"`
+	Conditions []json.RawMessage `json:"conditions"`
+}
+
+type postPolicyCondition struct {
+	operator string
+	//Lower case name of the form field without the $ prefix
+	field string
+	value string
+}
+
+const (
+	postPolicyOperatorEq                 = "eq"
+	postPolicyOperatorStartsWith         = "starts-with"
+	postPolicyOperatorContentLengthRange = "content-length-range"
+)
+
+// A POST form of which the fields up to the file have been read. The file can then be streamed.
+type PostPolicyForm struct {
+	//Form field values by lower case name since field names are case insensitive
+	fields map[string]string
+
+	//The name of the uploaded file as set by the browser
+	fileName string
+
+	//The content of the uploaded file
+	file io.Reader
+
+	expiration time.Time
+	conditions []postPolicyCondition
+
+	//Limits of the file size, a negative maximum means there is no limit
+	minFileSize int64
+	maxFileSize int64
+}
+
+func newMalformedPostRequestError(err error, userMsg string) error {
+	return usererror.New(fmt.Errorf("%w: %w", ErrMalformedPostRequest, err), userMsg)
+}
+
+func newPostPolicyViolatedError(userMsg string) error {
+	return usererror.New(fmt.Errorf("%w: %s", ErrPostPolicyViolated, userMsg), fmt.Sprintf("Invalid according to Policy: %s", userMsg))
+}
+
+// A browser-based upload is a POST with a multipart form that is not signed via an Authorization header
+func IsPostPolicyRequest(r *http.Request) bool {
+	if r.Method != http.MethodPost || r.Header.Get("Authorization") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// Read the form fields of a POST request up to the file field. Fields after the file are ignored just like S3 does.
+func ReadPostPolicyForm(r *http.Request) (*PostPolicyForm, error) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, newMalformedPostRequestError(err, "The Content-Type must be multipart/form-data")
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	form := &PostPolicyForm{fields: map[string]string{}, maxFileSize: -1}
+	remainingBytes := maxPostPolicyFormFieldsBytes
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, newMalformedPostRequestError(errors.New("no file field"), "The body of your POST request is not well-formed multipart/form-data, the file field is missing")
+		} else if err != nil {
+			return nil, newMalformedPostRequestError(err, "The body of your POST request is not well-formed multipart/form-data")
+		}
+		fieldName := strings.ToLower(part.FormName())
+		if fieldName == postFileFieldName {
+			form.fileName = part.FileName()
+			form.file = part
+			break
+		}
+		value, err := io.ReadAll(io.LimitReader(part, remainingBytes+1))
+		if err != nil {
+			return nil, newMalformedPostRequestError(err, "The body of your POST request is not well-formed multipart/form-data")
+		}
+		remainingBytes -= int64(len(value))
+		if remainingBytes < 0 {
+			return nil, newMalformedPostRequestError(errors.New("form fields too large"), "The form fields of your POST request are too large")
+		}
+		form.fields[fieldName] = string(value)
+	}
+	err = form.decodePolicy()
+	if err != nil {
+		return nil, err
+	}
+	return form, nil
+}
+
+func (f *PostPolicyForm) decodePolicy() error {
+	policyJSON, err := base64.StdEncoding.DecodeString(f.fields["policy"])
+	if err != nil || len(policyJSON) == 0 {
+		return newMalformedPostRequestError(fmt.Errorf("invalid policy: %w", err), "The policy field must be a base64 encoded policy document")
+	}
+	document := postPolicyDocument{}
+	err = json.Unmarshal(policyJSON, &document)
+	if err != nil {
+		return newMalformedPostRequestError(err, "The policy document is not valid JSON")
+	}
+	f.expiration, err = time.Parse(time.RFC3339, document.Expiration)
+	if err != nil {
+		return newMalformedPostRequestError(err, "The expiration of the policy must be an ISO8601 GMT date")
+	}
+	for _, rawCondition := range document.Conditions {
+		err = f.addCondition(rawCondition)
+		if err != nil {
+			return newMalformedPostRequestError(err, fmt.Sprintf("Invalid policy condition %s", string(rawCondition)))
+		}
+	}
+	return nil
+}
+
+// Conditions are either an exact match {"field": "value"} or a list [operator, "$field", value]
+// except for content-length-range which is [operator, min, max].
+func (f *PostPolicyForm) addCondition(rawCondition json.RawMessage) error {
+	exactMatch := map[string]string{}
+	if json.Unmarshal(rawCondition, &exactMatch) == nil {
+		for field, value := range exactMatch {
+			f.conditions = append(f.conditions, postPolicyCondition{
+				operator: postPolicyOperatorEq,
+				field:    strings.ToLower(strings.TrimPrefix(field, "$")),
+				value:    value,
+			})
+		}
+		return nil
+	}
+	elements := []json.RawMessage{}
+	err := json.Unmarshal(rawCondition, &elements)
+	if err != nil {
+		return err
+	}
+	if len(elements) != 3 {
+		return errors.New("a condition list must have 3 elements")
+	}
+	var operator string
+	err = json.Unmarshal(elements[0], &operator)
+	if err != nil {
+		return err
+	}
+	operator = strings.ToLower(operator)
+	if operator == postPolicyOperatorContentLengthRange {
+		f.minFileSize, err = strconv.ParseInt(strings.Trim(string(elements[1]), `"`), 10, 64)
+		if err != nil {
+			return err
+		}
+		f.maxFileSize, err = strconv.ParseInt(strings.Trim(string(elements[2]), `"`), 10, 64)
+		return err
+	}
+	if operator != postPolicyOperatorEq && operator != postPolicyOperatorStartsWith {
+		return fmt.Errorf("unsupported operator %s", operator)
+	}
+	condition := postPolicyCondition{operator: operator}
+	err = json.Unmarshal(elements[1], &condition.field)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(condition.field, "$") {
+		return fmt.Errorf("field %s must start with $", condition.field)
+	}
+	condition.field = strings.ToLower(strings.TrimPrefix(condition.field, "$"))
+	err = json.Unmarshal(elements[2], &condition.value)
+	if err != nil {
+		return err
+	}
+	f.conditions = append(f.conditions, condition)
+	return nil
+}
+
+// Get the value of a form field, field names are case insensitive
+func (f *PostPolicyForm) Get(fieldName string) string {
+	return f.fields[strings.ToLower(fieldName)]
+}
+
+// Get all form fields by their lower case name
+func (f *PostPolicyForm) Fields() map[string]string {
+	return f.fields
+}
+
+// Get the key of the object which can refer to the name of the uploaded file
+func (f *PostPolicyForm) GetKey() string {
+	return strings.ReplaceAll(f.Get("key"), postKeyFileNamePlaceholder, f.fileName)
+}
+
+// Get the access key id and session token of the credentials that signed the policy
+func (f *PostPolicyForm) GetCredentials() (accessKeyId, sessionToken string, err error) {
+	accessKeyId, err = requestutils.GetCredentialPart(f.Get(constants.AmzCredentialKey), requestutils.CredentialPartAccessKeyId)
+	if err != nil {
+		return "", "", err
+	}
+	return accessKeyId, f.Get(constants.AmzSecurityTokenKey), nil
+}
+
+// Get the region for which the policy was signed
+func (f *PostPolicyForm) GetRegion() (string, error) {
+	return requestutils.GetCredentialPart(f.Get(constants.AmzCredentialKey), requestutils.CredentialPartRegionName)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// The policy is the string to sign and the signature is calculated with the sigv4 signing key
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-authentication-HTTPPOST.html
+func (f *PostPolicyForm) HasValidSignature(secretAccessKey string) (bool, error) {
+	if algorithm := f.Get(constants.AmzAlgorithmKey); algorithm != postPolicyAlgorithm {
+		return false, newMalformedPostRequestError(fmt.Errorf("unsupported algorithm %s", algorithm), "Only AWS4-HMAC-SHA256 is supported for POST requests")
+	}
+	credential := f.Get(constants.AmzCredentialKey)
+	date, err := requestutils.GetCredentialPart(credential, requestutils.CredentialPartDate)
+	if err != nil {
+		return false, err
+	}
+	region, err := f.GetRegion()
+	if err != nil {
+		return false, err
+	}
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	calculatedSignature := hex.EncodeToString(hmacSHA256(signingKey, f.Get("policy")))
+	return hmac.Equal([]byte(calculatedSignature), []byte(f.Get(constants.AmzSignatureKey))), nil
+}
+
+// Fields that do not need to be covered by a condition of the policy
+func isPostFieldExemptFromPolicy(fieldName string) bool {
+	return fieldName == "policy" || fieldName == strings.ToLower(constants.AmzSignatureKey) || strings.HasPrefix(fieldName, "x-ignore-")
+}
+
+// Check that the policy has not expired and that the form matches all its conditions. Every form field must be
+// covered by a condition. The bucket is not necessarily a form field so it must be passed in.
+func (f *PostPolicyForm) CheckPolicy(bucket string, now time.Time) error {
+	if now.After(f.expiration) {
+		return newPostPolicyViolatedError("Policy expired.")
+	}
+	if f.Get("key") == "" {
+		return newMalformedPostRequestError(errors.New("no key field"), "The key field is required")
+	}
+	values := map[string]string{}
+	for fieldName, value := range f.fields {
+		values[fieldName] = value
+	}
+	values["bucket"] = bucket
+	values["key"] = f.GetKey()
+	covered := map[string]bool{}
+	for _, condition := range f.conditions {
+		value := values[condition.field]
+		switch condition.operator {
+		case postPolicyOperatorEq:
+			if value != condition.value {
+				return newPostPolicyViolatedError(fmt.Sprintf(`Policy Condition failed: ["eq", "$%s", "%s"]`, condition.field, condition.value))
+			}
+		case postPolicyOperatorStartsWith:
+			if !strings.HasPrefix(value, condition.value) {
+				return newPostPolicyViolatedError(fmt.Sprintf(`Policy Condition failed: ["starts-with", "$%s", "%s"]`, condition.field, condition.value))
+			}
+		}
+		covered[condition.field] = true
+	}
+	for fieldName := range f.fields {
+		if !covered[fieldName] && !isPostFieldExemptFromPolicy(fieldName) {
+			return newPostPolicyViolatedError(fmt.Sprintf("Extra input fields: %s", fieldName))
+		}
+	}
+	return nil
+}
+
+// Enforces the content-length-range of a policy while the file is being read
+type contentLengthRangeReader struct {
+	r        io.Reader
+	n        int64
+	min, max int64
+}
+
+func (c *contentLengthRangeReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.max >= 0 && c.n > c.max {
+		return n, usererror.New(ErrEntityTooLarge, fmt.Sprintf("Your proposed upload exceeds the maximum allowed size of %d bytes", c.max))
+	}
+	if err == io.EOF && c.n < c.min {
+		return n, usererror.New(ErrEntityTooSmall, fmt.Sprintf("Your proposed upload is smaller than the minimum allowed size of %d bytes", c.min))
+	}
+	return n, err
+}
+
+// Check the content-length-range of the policy against the Content-Length of the request before the file gets read.
+// The file is only part of the body so this refuses a body that is too small to hold a file of the minimum size. A
+// file that is too large is refused while it is being read, as soon as it exceeds the maximum.
+func (f *PostPolicyForm) CheckContentLength(contentLength int64) error {
+	if contentLength >= 0 && contentLength < f.minFileSize {
+		return usererror.New(
+			fmt.Errorf("%w: request of %d bytes cannot hold a file of %d bytes", ErrEntityTooSmall, contentLength, f.minFileSize),
+			fmt.Sprintf("Your proposed upload is smaller than the minimum allowed size of %d bytes", f.minFileSize),
+		)
+	}
+	return nil
+}
+
+// Get the content of the uploaded file. Reading fails when the file size is not within the content-length-range of the policy.
+func (f *PostPolicyForm) GetFile() io.Reader {
+	return &contentLengthRangeReader{r: f.file, min: f.minFileSize, max: f.maxFileSize}
+}
+
+// Get the POST form that was read during authentication
+func GetPostPolicyForm(r *http.Request) (*PostPolicyForm, error) {
+	rCtx, ok := requestctx.FromContext(r.Context())
+	if !ok {
+		return nil, errors.New("no request context")
+	}
+	v, err := rCtx.GetData(PostPolicyFormDataKey)
+	if err != nil {
+		return nil, err
+	}
+	form, ok := v.(*PostPolicyForm)
+	if !ok {
+		return nil, requestctx.ErrInvalidType
+	}
+	return form, nil
+}
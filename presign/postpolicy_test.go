@@ -0,0 +1,148 @@
+package presign
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func buildTestPostPolicyRequest(t *testing.T, policyJSON string, fields map[string]string, file string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	fields["policy"] = base64.StdEncoding.EncodeToString([]byte(policyJSON))
+	for fieldName, value := range fields {
+		if err := writer.WriteField(fieldName, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fileWriter, err := writer.CreateFormFile("file", "photo.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fileWriter.Write([]byte(file)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest(http.MethodPost, "http://localhost/bucket", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	return r
+}
+
+var testPostPolicy = `{
+	"expiration": "2030-01-01T00:00:00Z",
+	"conditions": [
+		{"bucket": "bucket"},
+		["starts-with", "$key", "user/alice/"],
+		["starts-with", "$Content-Type", "image/"],
+		["content-length-range", 2, 5]
+	]
+}`
+
+func TestCheckPostPolicy(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	var testCases = []struct {
+		description string
+		bucket      string
+		now         time.Time
+		fields      map[string]string
+		expectedErr error
+	}{
+		{"matching form", "bucket", now, map[string]string{"key": "user/alice/${filename}", "content-type": "image/jpeg"}, nil},
+		{"field names are case insensitive", "bucket", now, map[string]string{"Key": "user/alice/a", "Content-Type": "image/png"}, nil},
+		{"other bucket", "other", now, map[string]string{"key": "user/alice/a", "content-type": "image/png"}, ErrPostPolicyViolated},
+		{"key with other prefix", "bucket", now, map[string]string{"key": "user/bob/a", "content-type": "image/png"}, ErrPostPolicyViolated},
+		{"field without condition", "bucket", now, map[string]string{"key": "user/alice/a", "content-type": "image/png", "acl": "public-read"}, ErrPostPolicyViolated},
+		{"ignored field", "bucket", now, map[string]string{"key": "user/alice/a", "content-type": "image/png", "x-ignore-me": "1"}, nil},
+		{"expired policy", "bucket", time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC), map[string]string{"key": "user/alice/a", "content-type": "image/png"}, ErrPostPolicyViolated},
+		{"missing key", "bucket", now, map[string]string{"content-type": "image/png"}, ErrMalformedPostRequest},
+	}
+	for _, tc := range testCases {
+		form, err := ReadPostPolicyForm(buildTestPostPolicyRequest(t, testPostPolicy, tc.fields, "abc"))
+		if err != nil {
+			t.Fatalf("%s: could not read form: %s", tc.description, err)
+		}
+		err = form.CheckPolicy(tc.bucket, tc.now)
+		if !errors.Is(err, tc.expectedErr) {
+			t.Errorf("%s: expected error %v, got %v", tc.description, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestPostPolicyKeyUsesFileName(t *testing.T) {
+	form, err := ReadPostPolicyForm(buildTestPostPolicyRequest(t, testPostPolicy, map[string]string{"key": "user/alice/${filename}"}, "abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := form.GetKey(); key != "user/alice/photo.jpg" {
+		t.Errorf("Expected key user/alice/photo.jpg, got %s", key)
+	}
+}
+
+func TestPostPolicyContentLengthRange(t *testing.T) {
+	var testCases = []struct {
+		file        string
+		expectedErr error
+	}{
+		{"a", ErrEntityTooSmall},
+		{"ab", nil},
+		{"abcde", nil},
+		{"abcdef", ErrEntityTooLarge},
+	}
+	for _, tc := range testCases {
+		form, err := ReadPostPolicyForm(buildTestPostPolicyRequest(t, testPostPolicy, map[string]string{"key": "user/alice/a"}, tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadAll(form.GetFile())
+		if !errors.Is(err, tc.expectedErr) {
+			t.Errorf("%q: expected error %v, got %v", tc.file, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestPostPolicyContentLengthRangeIsCheckedBeforeReadingFile(t *testing.T) {
+	r := buildTestPostPolicyRequest(t, testPostPolicy, map[string]string{"key": "user/alice/a"}, "abc")
+	form, err := ReadPostPolicyForm(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var testCases = []struct {
+		contentLength int64
+		expectedErr   error
+	}{
+		{1, ErrEntityTooSmall},
+		{2, nil},
+		{1000, nil},
+		{-1, nil}, //Unknown length
+	}
+	for _, tc := range testCases {
+		err := form.CheckContentLength(tc.contentLength)
+		if !errors.Is(err, tc.expectedErr) {
+			t.Errorf("Content-Length %d: expected error %v, got %v", tc.contentLength, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestReadPostPolicyFormWithInvalidPolicy(t *testing.T) {
+	for _, policyJSON := range []string{
+		`not json`,
+		`{"expiration": "tomorrow", "conditions": []}`,
+		`{"expiration": "2030-01-01T00:00:00Z", "conditions": [["regex", "$key", ".*"]]}`,
+		`{"expiration": "2030-01-01T00:00:00Z", "conditions": [["eq", "key", "a"]]}`,
+	} {
+		_, err := ReadPostPolicyForm(buildTestPostPolicyRequest(t, policyJSON, map[string]string{"key": "a"}, "abc"))
+		if !errors.Is(err, ErrMalformedPostRequest) {
+			t.Errorf("%s: expected malformed POST request, got %v", policyJSON, err)
+		}
+	}
+}
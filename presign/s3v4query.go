@@ -76,6 +76,10 @@ func (u presignedUrlS3V4Query) GetPresignedUrlDetails(ctx context.Context, deriv
 	expires = signDate.Add(time.Duration(expirySeconds) * time.Second)
 	originalSignature := u.Request.URL.Query().Get(constants.AmzSignatureKey)
 	c := u.Clone(ctx)
+	err = RestoreVirtualHostedAddressing(c)
+	if err != nil {
+		return
+	}
 	if c.Header.Get("Host") == "" {
 		c.Header.Add("Host", c.Host)
 	}
@@ -0,0 +1,23 @@
+package presign
+
+import (
+	"net/http"
+
+	"github.com/VITObelgium/fakes3pp/requestctx"
+	"github.com/VITObelgium/fakes3pp/requestutils"
+)
+
+// Virtual-hosted-style requests (e.g. https://bucket.s3.example.com/key) are rewritten to path-style by the proxy.
+// A sigv4 signature covers the Host header and the path as they were sent by the client so these must be
+// restored before the signature of the request can be recalculated. Only use this on a clone of the request.
+//
+// Hmacv1 does not need this because its canonical resource is /bucket/key for both styles which is the
+// path of the rewritten request.
+func RestoreVirtualHostedAddressing(r *http.Request) error {
+	host, escapedPath, ok := requestctx.GetVirtualHostedAddressing(r)
+	if !ok {
+		return nil
+	}
+	r.Host = host
+	return requestutils.SetEscapedPath(r.URL, escapedPath)
+}
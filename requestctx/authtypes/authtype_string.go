@@ -12,15 +12,17 @@ func _() {
 	_ = x[AuthTypeNone-1]
 	_ = x[AuthTypeQueryString-2]
 	_ = x[AuthTypeAuthHeader-3]
+	_ = x[AuthTypePostPolicy-4]
 }
 
-const _AuthType_name = "UnknownNoneQueryStringAuthHeader"
+const _AuthType_name = "UnknownNoneQueryStringAuthHeaderPostPolicy"
 
-var _AuthType_index = [...]uint8{0, 7, 11, 22, 32}
+var _AuthType_index = [...]uint8{0, 7, 11, 22, 32, 42}
 
 func (i AuthType) String() string {
-	if i < 0 || i >= AuthType(len(_AuthType_index)-1) {
+	idx := int(i) - 0
+	if i < 0 || idx >= len(_AuthType_index)-1 {
 		return "AuthType(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _AuthType_name[_AuthType_index[i]:_AuthType_index[i+1]]
+	return _AuthType_name[_AuthType_index[idx]:_AuthType_index[idx+1]]
 }
@@ -11,4 +11,5 @@ const (
 	AuthTypeNone
 	AuthTypeQueryString
 	AuthTypeAuthHeader
+	AuthTypePostPolicy
 )
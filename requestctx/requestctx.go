@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"reflect"
 	"strings"
 	"time"
@@ -88,6 +89,12 @@ type RequestCtx struct {
 
 	//SignedHeaders
 	SignedHeaders []string
+
+	//The Host and escaped path of a virtual-hosted-style request as it was received. These are empty for
+	//path-style requests. Virtual-hosted-style requests get rewritten to path-style but their signature
+	//is calculated over the original addressing.
+	VirtualHostedHost        string
+	VirtualHostedEscapedPath string
 }
 
 func (c *RequestCtx) AddAccessLogInfo(groupName string, attrs ...slog.Attr) {
@@ -198,6 +205,44 @@ func SetTargetRegion(r *http.Request, region string) {
 	)
 }
 
+func SetVirtualHostedAddressing(r *http.Request, host, escapedPath string) {
+	if rCtx := get(r); rCtx != nil {
+		rCtx.VirtualHostedHost = host
+		rCtx.VirtualHostedEscapedPath = escapedPath
+		return
+	}
+	slog.Error( // #nosec G706 -- structured logging of diagnostic request metadata
+		"Attempting to set virtual-hosted addressing without existing request context",
+		"request", r,
+		"host", host,
+		"escapedPath", escapedPath,
+	)
+}
+
+// Get the addressing of a request that was received virtual-hosted-style, ok is false for path-style requests
+func GetVirtualHostedAddressing(r *http.Request) (host, escapedPath string, ok bool) {
+	if rCtx := get(r); rCtx != nil && rCtx.VirtualHostedHost != "" {
+		return rCtx.VirtualHostedHost, rCtx.VirtualHostedEscapedPath, true
+	}
+	return "", "", false
+}
+
+// Get the IP address of the client without port. RemoteIP holds the address as it was received (e.g. "192.0.2.1:54321")
+// or a bare address when it was taken from a trusted proxy. An empty string is returned when it is not an IP address.
+func GetSourceIP(r *http.Request) string {
+	rCtx := get(r)
+	if rCtx == nil {
+		return ""
+	}
+	if addrPort, err := netip.ParseAddrPort(rCtx.RemoteIP); err == nil {
+		return addrPort.Addr().Unmap().String()
+	}
+	if addr, err := netip.ParseAddr(rCtx.RemoteIP); err == nil {
+		return addr.Unmap().String()
+	}
+	return ""
+}
+
 func GetTargetRegion(r *http.Request) (string, error) {
 	if rCtx := get(r); rCtx != nil {
 		return rCtx.TargetRegion, nil
@@ -309,6 +354,14 @@ func (c *RequestCtx) SetDataKey(key string, value any) {
 var ErrNoSuchKey = errors.New("no such key")
 var ErrInvalidType = errors.New("invalid type for key")
 
+func (c *RequestCtx) GetData(key string) (any, error) {
+	v, ok := c.data[key]
+	if !ok {
+		return nil, ErrNoSuchKey
+	}
+	return v, nil
+}
+
 func (c *RequestCtx) GetStringData(key string) (string, error) {
 	v, ok := c.data[key]
 	if !ok {
@@ -66,3 +66,28 @@ func TestGetAccessLogStringInfoWhenSet(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestGetSourceIP(t *testing.T) {
+	var testCases = []struct {
+		Description string
+		RemoteAddr  string
+		ExpectedIP  string
+	}{
+		{"IPv4 with port", "192.0.2.1:54321", "192.0.2.1"},
+		{"IPv6 with port", "[2001:db8::1]:54321", "2001:db8::1"},
+		{"Bare address from trusted proxy", "203.0.113.5", "203.0.113.5"},
+		{"IPv4 mapped IPv6 address", "[::ffff:192.0.2.1]:54321", "192.0.2.1"},
+		{"Not an address", "pipe", ""},
+	}
+	for _, tc := range testCases {
+		r, err := http.NewRequest(http.MethodGet, "http://localhost/bucket", nil)
+		if err != nil {
+			t.Fatalf("Could not create test request: %s", err)
+		}
+		r.RemoteAddr = tc.RemoteAddr
+		r = r.WithContext(requestctx.NewContextFromHttpRequest(r))
+		if got := requestctx.GetSourceIP(r); got != tc.ExpectedIP {
+			t.Errorf("%s: expected %q, got %q", tc.Description, tc.ExpectedIP, got)
+		}
+	}
+}
@@ -13,3 +13,14 @@ func GetQueryParamsFromUrl(inputUrl string) (url.Values, error) {
 	}
 	return q, nil
 }
+
+// Set the path of an URL from its escaped form such that the escaping is preserved when the URL is used again.
+func SetEscapedPath(u *url.URL, escapedPath string) error {
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return err
+	}
+	u.Path = path
+	u.RawPath = escapedPath
+	return nil
+}
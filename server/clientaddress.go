@@ -0,0 +1,254 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Parse a comma separated list of CIDR blocks or single IP addresses of trusted proxies
+func ParseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var trustedProxies []netip.Prefix
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+	return trustedProxies, nil
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the IP address of a "host:port" or bare address
+func parseRemoteAddr(remoteAddr string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(remoteAddr); err == nil {
+		return addrPort.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(remoteAddr)
+	return addr.Unmap(), err
+}
+
+// Get the address of the client from the X-Forwarded-For header of a request that was sent by a trusted proxy.
+// Every proxy appends the address of its peer so the header is read from right to left and the first address
+// that is not a trusted proxy is the client. Addresses left of it could have been set by the client itself.
+func getClientAddrFromXForwardedFor(header http.Header, trustedProxies []netip.Prefix) (netip.Addr, bool) {
+	var forwardedFor []string
+	for _, value := range header.Values("X-Forwarded-For") {
+		forwardedFor = append(forwardedFor, strings.Split(value, ",")...)
+	}
+	var clientAddr netip.Addr
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwardedFor[i]))
+		if err != nil {
+			//Do not trust anything left of an invalid entry
+			break
+		}
+		clientAddr = addr.Unmap()
+		if !isTrustedProxy(clientAddr, trustedProxies) {
+			break
+		}
+	}
+	return clientAddr, clientAddr.IsValid()
+}
+
+// Middleware that replaces the RemoteAddr of requests that come from a trusted proxy by the client address in
+// their X-Forwarded-For header. It must run before the request context is created as that records RemoteAddr.
+func clientAddrFromXForwardedForHandler(trustedProxies []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peerAddr, err := parseRemoteAddr(r.RemoteAddr)
+		if err == nil && isTrustedProxy(peerAddr, trustedProxies) {
+			if clientAddr, ok := getClientAddrFromXForwardedFor(r.Header, trustedProxies); ok {
+				r.RemoteAddr = clientAddr.String()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The time a trusted proxy gets to send the PROXY protocol header
+const proxyProtocolHeaderTimeout = 3 * time.Second
+
+var proxyProtocolV1Prefix = []byte("PROXY ")
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// The longest header of version 1 of the PROXY protocol including CRLF
+const proxyProtocolV1MaxLength = 107
+
+// A listener that takes the client address from the PROXY protocol header that trusted proxies send at the start
+// of a connection. Connections from other peers are used as they are.
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+type proxyProtocolListener struct {
+	net.Listener
+	trustedProxies []netip.Prefix
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	peerAddr, err := parseRemoteAddr(conn.RemoteAddr().String())
+	if err != nil || !isTrustedProxy(peerAddr, l.trustedProxies) {
+		return conn, nil
+	}
+	return &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// A connection of a trusted proxy. The header is read on first use rather than in Accept so that a slow proxy does
+// not block accepting other connections.
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	headerOnce sync.Once
+	headerErr  error
+	clientAddr net.Addr
+}
+
+func (c *proxyProtocolConn) readHeaderOnce() error {
+	c.headerOnce.Do(func() {
+		err := c.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+		if err == nil {
+			c.clientAddr, err = readProxyProtocolHeader(c.reader)
+		}
+		if err == nil {
+			err = c.SetReadDeadline(time.Time{})
+		}
+		if err != nil {
+			slog.Warn("Closing connection of trusted proxy without valid PROXY protocol header", "peer", c.Conn.RemoteAddr(), "error", err)
+			c.headerErr = err
+			_ = c.Close()
+		}
+	})
+	return c.headerErr
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	if err := c.readHeaderOnce(); err != nil {
+		return 0, err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	if err := c.readHeaderOnce(); err != nil || c.clientAddr == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.clientAddr
+}
+
+// Read a version 1 or 2 PROXY protocol header. The returned address is nil if the header does not carry the
+// address of a client (e.g. health checks of the proxy itself).
+func readProxyProtocolHeader(r *bufio.Reader) (net.Addr, error) {
+	start, err := r.Peek(len(proxyProtocolV1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(start, proxyProtocolV1Prefix) {
+		return readProxyProtocolV1Header(r)
+	}
+	start, err = r.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(start, proxyProtocolV2Signature) {
+		return readProxyProtocolV2Header(r)
+	}
+	return nil, errors.New("missing PROXY protocol header")
+}
+
+// e.g. "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+func readProxyProtocolV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyProtocolV1MaxLength {
+			return nil, errors.New("PROXY protocol v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header %q", line)
+	}
+	addrPort, err := netip.ParseAddrPort(net.JoinHostPort(fields[2], fields[4]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source in PROXY protocol v1 header: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(addrPort), nil
+}
+
+func readProxyProtocolV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyProtocolV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	versionCommand, family := header[12], header[13]
+	addresses := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, addresses); err != nil {
+		return nil, err
+	}
+	if versionCommand>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", versionCommand>>4)
+	}
+	const commandLocal, commandProxy = 0x0, 0x1
+	switch versionCommand & 0x0f {
+	case commandLocal:
+		return nil, nil
+	case commandProxy:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol command %d", versionCommand&0x0f)
+	}
+	const tcpOverIPv4, tcpOverIPv6 = 0x11, 0x21
+	switch family {
+	case tcpOverIPv4:
+		if len(addresses) < 12 {
+			return nil, errors.New("PROXY protocol v2 header too short for IPv4")
+		}
+		addr := netip.AddrFrom4([4]byte(addresses[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(addresses[8:10]))), nil
+	case tcpOverIPv6:
+		if len(addresses) < 36 {
+			return nil, errors.New("PROXY protocol v2 header too short for IPv6")
+		}
+		addr := netip.AddrFrom16([16]byte(addresses[0:16]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(addresses[32:34]))), nil
+	default:
+		//Other transports (e.g. UDP or unix sockets) do not give a client IP
+		return nil, nil
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies(" 10.0.0.0/8, 192.0.2.7,2001:db8::/32,")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.0.2.7/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	if len(trustedProxies) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, trustedProxies)
+	}
+	for i := range expected {
+		if trustedProxies[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], trustedProxies[i])
+		}
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/8,campus"); err == nil {
+		t.Error("Expected an error for an invalid entry")
+	}
+}
+
+func TestClientAddrFromXForwardedFor(t *testing.T) {
+	trustedProxies := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	var testCases = []struct {
+		Description        string
+		RemoteAddr         string
+		XForwardedFor      []string
+		ExpectedRemoteAddr string
+	}{
+		{"Request from trusted proxy", "10.0.0.1:1234", []string{"203.0.113.5"}, "203.0.113.5"},
+		{"Request from untrusted peer keeps its address", "198.51.100.1:1234", []string{"203.0.113.5"}, "198.51.100.1:1234"},
+		{"Trusted proxy without header", "10.0.0.1:1234", nil, "10.0.0.1:1234"},
+		{"Chain of trusted proxies", "10.0.0.1:1234", []string{"203.0.113.5, 10.0.0.2"}, "203.0.113.5"},
+		{"Address set by the client is ignored", "10.0.0.1:1234", []string{"192.0.2.1, 203.0.113.5"}, "203.0.113.5"},
+		{"Multiple headers", "10.0.0.1:1234", []string{"192.0.2.1", "203.0.113.5"}, "203.0.113.5"},
+		{"Invalid entry stops the search", "10.0.0.1:1234", []string{"192.0.2.1, garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"Only invalid entries", "10.0.0.1:1234", []string{"garbage"}, "10.0.0.1:1234"},
+		{"IPv4 mapped IPv6 address", "10.0.0.1:1234", []string{"::ffff:203.0.113.5"}, "203.0.113.5"},
+	}
+	for _, tc := range testCases {
+		var gotRemoteAddr string
+		handler := clientAddrFromXForwardedForHandler(trustedProxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotRemoteAddr = r.RemoteAddr
+		}))
+		r := httptest.NewRequest(http.MethodGet, "http://localhost/bucket", nil)
+		r.RemoteAddr = tc.RemoteAddr
+		for _, value := range tc.XForwardedFor {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		if gotRemoteAddr != tc.ExpectedRemoteAddr {
+			t.Errorf("%s: expected %s, got %s", tc.Description, tc.ExpectedRemoteAddr, gotRemoteAddr)
+		}
+	}
+}
+
+func buildProxyProtocolV2Header(command, family byte, addresses []byte) []byte {
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	header = append(header, 0x20|command, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	ipv4Addresses := []byte{203, 0, 113, 5, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6Addresses := make([]byte, 36)
+	copy(ipv6Addresses, netip.MustParseAddr("2001:db8::5").AsSlice())
+	binary.BigEndian.PutUint16(ipv6Addresses[32:], 56324)
+
+	var testCases = []struct {
+		Description  string
+		Header       []byte
+		ExpectedAddr string
+		ExpectError  bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 203.0.113.5 10.0.0.1 56324 443\r\n"), "203.0.113.5:56324", false},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::5 2001:db8::1 56324 443\r\n"), "[2001:db8::5]:56324", false},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 invalid address", []byte("PROXY TCP4 campus 10.0.0.1 56324 443\r\n"), "", true},
+		{"v1 without CRLF", []byte("PROXY TCP4 203.0.113.5 10.0.0.1 56324 443" + strings.Repeat(" ", 100)), "", true},
+		{"v2 IPv4", buildProxyProtocolV2Header(0x1, 0x11, ipv4Addresses), "203.0.113.5:56324", false},
+		{"v2 IPv6", buildProxyProtocolV2Header(0x1, 0x21, ipv6Addresses), "[2001:db8::5]:56324", false},
+		{"v2 LOCAL", buildProxyProtocolV2Header(0x0, 0x00, nil), "", false},
+		{"v2 address too short", buildProxyProtocolV2Header(0x1, 0x11, ipv4Addresses[:4]), "", true},
+		{"No header", []byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"), "", true},
+	}
+	for _, tc := range testCases {
+		r := bufio.NewReader(io.MultiReader(bytes.NewReader(tc.Header), strings.NewReader("GET")))
+		addr, err := readProxyProtocolHeader(r)
+		if tc.ExpectError {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tc.Description, addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.Description, err)
+			continue
+		}
+		gotAddr := ""
+		if addr != nil {
+			gotAddr = addr.String()
+		}
+		if gotAddr != tc.ExpectedAddr {
+			t.Errorf("%s: expected %q, got %q", tc.Description, tc.ExpectedAddr, gotAddr)
+		}
+		//The data after the header must be left for the application
+		rest, _ := io.ReadAll(r)
+		if string(rest) != "GET" {
+			t.Errorf("%s: expected data after the header to be kept, got %q", tc.Description, rest)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := &proxyProtocolListener{Listener: ln, trustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	go func() {
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			_ = client.Close()
+		}()
+		_, _ = client.Write([]byte("PROXY TCP4 203.0.113.5 127.0.0.1 56324 443\r\nhello"))
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if got := conn.RemoteAddr().String(); got != "203.0.113.5:56324" {
+		t.Errorf("Expected client address from PROXY header, got %s", got)
+	}
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("Expected data after the header, got %q", data)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/netip"
 	"reflect"
 	"sync"
 	"time"
@@ -26,10 +28,24 @@ type ServerOpts struct {
 
 	//The healthchecker used
 	healthchecker middleware.HealthChecker
+
+	//Load balancers in front of the server that pass on the address of the client. By default they do so with
+	//the X-Forwarded-For header. Requests from other peers keep the address of the peer.
+	TrustedProxies []netip.Prefix
+
+	//Whether trusted proxies pass on the client address with the PROXY protocol instead of X-Forwarded-For
+	ProxyProtocol bool
+
+	//Handlers of administrative endpoints keyed by path. They are served on the metrics port which must not be
+	//reachable by clients.
+	AdminHandlers map[string]http.Handler
 }
 
-func StartPrometheusMetricsServer(port int) (func(), prometheus.Registerer) {
+func StartPrometheusMetricsServer(port int, adminHandlers map[string]http.Handler) (func(), prometheus.Registerer) {
 	if port == 0 {
+		if len(adminHandlers) > 0 {
+			slog.Warn("Admin endpoints are only served on the metrics port which is not set")
+		}
 		return nil, nil
 	}
 	// Create non-global registry.
@@ -44,6 +60,9 @@ func StartPrometheusMetricsServer(port int) (func(), prometheus.Registerer) {
 	mux := http.NewServeMux()
 	// Expose /metrics HTTP endpoint using the created custom registry.
 	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg}))
+	for path, handler := range adminHandlers {
+		mux.Handle(path, handler)
+	}
 	var addr = fmt.Sprintf(":%d", port)
 
 	metricsSrvDone := &sync.WaitGroup{}
@@ -75,7 +94,7 @@ func StartPrometheusMetricsServer(port int) (func(), prometheus.Registerer) {
 
 // Start a server in the background but return a waitGroup.
 func CreateAndStart(s Serverable, opts ServerOpts) (*sync.WaitGroup, *http.Server, error) {
-	shutdownMetricsServerSync, reg := StartPrometheusMetricsServer(opts.MetricsPort)
+	shutdownMetricsServerSync, reg := StartPrometheusMetricsServer(opts.MetricsPort, opts.AdminHandlers)
 
 	serverDone := &sync.WaitGroup{}
 
@@ -100,6 +119,9 @@ func CreateAndStart(s Serverable, opts ServerOpts) (*sync.WaitGroup, *http.Serve
 			s,
 			middleware.LogMiddleware(opts.RequestLogLvl, healthchecker, reg),
 		)
+		if len(opts.TrustedProxies) > 0 && !opts.ProxyProtocol {
+			srv.Handler = clientAddrFromXForwardedForHandler(opts.TrustedProxies, srv.Handler)
+		}
 
 		// For TLS listeners we use a tlsCertificateReloader so that the cert/key
 		// pair can be updated on disk (e.g. Kubernetes secret rotation) without
@@ -118,6 +140,19 @@ func CreateAndStart(s Serverable, opts ServerOpts) (*sync.WaitGroup, *http.Serve
 			srv.RegisterOnShutdown(reloader.Close)
 		}
 
+		var listener net.Listener
+		if len(opts.TrustedProxies) > 0 && opts.ProxyProtocol {
+			ln, err := net.Listen("tcp", listenAddress)
+			if err != nil {
+				if reloader != nil {
+					reloader.Close()
+				}
+				serverDone.Done() // undo the Add(1) above
+				return nil, fmt.Errorf("failed to listen on %s: %w", listenAddress, err)
+			}
+			listener = &proxyProtocolListener{Listener: ln, trustedProxies: opts.TrustedProxies}
+		}
+
 		// Start proxy in the background but manage waitgroup
 		go func() {
 			defer serverDone.Done()
@@ -127,10 +162,18 @@ func CreateAndStart(s Serverable, opts ServerOpts) (*sync.WaitGroup, *http.Serve
 				slog.Info("Starting ListenAndServeTLS", "secure", tlsEnabled, "type", iType)
 				// cert/key are supplied via TLSConfig.GetCertificate; pass empty
 				// strings so net/http does not attempt a second LoadX509KeyPair.
-				err = srv.ListenAndServeTLS("", "")
+				if listener != nil {
+					err = srv.ServeTLS(listener, "", "")
+				} else {
+					err = srv.ListenAndServeTLS("", "")
+				}
 			} else {
 				slog.Info("Starting ListenAndServe", "secure", tlsEnabled, "type", iType)
-				err = srv.ListenAndServe()
+				if listener != nil {
+					err = srv.Serve(listener)
+				} else {
+					err = srv.ListenAndServe()
+				}
 			}
 
 			if err != http.ErrServerClosed {
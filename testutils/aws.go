@@ -46,6 +46,23 @@ func GetTestClientS3(t testing.TB, region string, creds aws.CredentialsProvider,
 	return client
 }
 
+// Get an S3 client that uses virtual-hosted-style requests (e.g. https://bucket.localhost:8443/key)
+func GetTestClientS3VirtualHosted(t testing.TB, region string, creds aws.CredentialsProvider, s3Server server.Serverable) *s3.Client {
+	cfg := getTestAwsConfig(t)
+	cfg.HTTPClient = BuildUnsafeHttpClientThatConnectsToLocalhost(t)
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(GetTestServerUrl(s3Server))
+		o.Credentials = creds
+		o.Region = region
+		o.UsePathStyle = false
+		o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+		o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+	})
+
+	return client
+}
+
 func AssumeRoleWithWebIdentityAgainstTestStsProxy(t testing.TB, token, roleSessionName, roleArn string, stsServer server.Serverable, durationSecs *int32) (*sts.AssumeRoleWithWebIdentityOutput, error) {
 	client := GetTestClientSts(t, stsServer)
 
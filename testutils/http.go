@@ -1,7 +1,9 @@
 package testutils
 
 import (
+	"context"
 	"crypto/tls"
+	"net"
 	"net/http"
 	"testing"
 )
@@ -15,3 +17,18 @@ func BuildUnsafeHttpClientThatTrustsAnyCert(t testing.TB) *http.Client {
 	}
 	return &http.Client{Transport: tr}
 }
+
+// Build an HTTP client like BuildUnsafeHttpClientThatTrustsAnyCert that connects to localhost for any host.
+// This allows virtual-hosted-style requests (e.g. bucket.localhost) without DNS entries for the subdomains.
+func BuildUnsafeHttpClientThatConnectsToLocalhost(t testing.TB) *http.Client {
+	client := BuildUnsafeHttpClientThatTrustsAnyCert(t)
+	dialer := &net.Dialer{}
+	client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, net.JoinHostPort("localhost", port))
+	}
+	return client
+}